@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dev-perry/go-server/internal/auth"
+	"github.com/dev-perry/go-server/internal/database"
+)
+
+// Scopes a personal API key or an OAuth client can be granted. Requests made
+// with either only reach routes registered with requireScope or optionalScope
+// for one of their scopes.
+const (
+	scopeChirpsRead   = "chirps:read"
+	scopeChirpsWrite  = "chirps:write"
+	scopeProfileWrite = "profile:write"
+)
+
+var grantableScopes = []string{scopeChirpsRead, scopeChirpsWrite, scopeProfileWrite}
+
+const maxAPIKeyNameLength = 100
+
+var (
+	errInvalidAPIKey = errors.New("invalid API key")
+	errExpiredAPIKey = errors.New("API key has expired")
+)
+
+type APIKey struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	// Key is only returned when the key is created; the server keeps a hash.
+	Key string `json:"key,omitempty"`
+}
+
+func newAPIKey(k database.ApiKey) APIKey {
+	key := APIKey{
+		ID:        k.ID,
+		Name:      k.Name,
+		Scopes:    k.Scopes,
+		CreatedAt: k.CreatedAt,
+	}
+	if k.ExpiresAt.Valid {
+		key.ExpiresAt = &k.ExpiresAt.Time
+	}
+	if k.LastUsedAt.Valid {
+		key.LastUsedAt = &k.LastUsedAt.Time
+	}
+	return key
+}
+
+// authenticateAPIKey verifies a personal API key. Keys outlive password
+// changes and session revocation, but not their owner's account being locked.
+func (cfg *apiConfig) authenticateAPIKey(ctx context.Context, raw string) (principal, error) {
+	presented, parseErr := auth.ParseAPIKey(raw)
+	if parseErr != nil {
+		return principal{}, errInvalidAPIKey
+	}
+	stored, dbErr := cfg.db.GetAPIKey(ctx, presented.ID)
+	if errors.Is(dbErr, sql.ErrNoRows) {
+		return principal{}, errInvalidAPIKey
+	}
+	if dbErr != nil {
+		return principal{}, dbErr
+	}
+	rehash := func(ctx context.Context, newHash string) error {
+		return cfg.db.RehashAPIKey(ctx, database.RehashAPIKeyParams{ID: stored.ID, NewHash: newHash, OldHash: stored.KeyHash})
+	}
+	if !cfg.checkOpaqueTokenHash(ctx, presented.Secret, auth.PurposeAPIKey, stored.KeyHash, rehash) || stored.RevokedAt.Valid {
+		return principal{}, errInvalidAPIKey
+	}
+	if stored.ExpiresAt.Valid && !time.Now().Before(stored.ExpiresAt.Time) {
+		return principal{}, errExpiredAPIKey
+	}
+
+	state, dbErr := cfg.db.GetUserAuthState(ctx, stored.UserID)
+	if errors.Is(dbErr, sql.ErrNoRows) {
+		return principal{}, errInvalidAPIKey
+	}
+	if dbErr != nil {
+		return principal{}, dbErr
+	}
+	if state.LockedAt.Valid {
+		return principal{}, errInvalidAPIKey
+	}
+	if err := cfg.db.TouchAPIKey(ctx, stored.ID); err != nil {
+		log.Printf("Error recording use of API key %s: %s", stored.ID, err)
+	}
+
+	p := newPrincipal(stored.UserID, state)
+	p.APIKeyID = stored.ID
+	// A non-nil slice, even if empty, marks the principal as restricted.
+	p.Scopes = append([]string{}, stored.Scopes...)
+	return p, nil
+}
+
+func (cfg *apiConfig) createAPIKey(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+	var req struct {
+		Name      string     `json:"name"`
+		Scopes    []string   `json:"scopes"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, r, 400, errCodeBadRequest, "Unable to decode request body")
+		return
+	}
+
+	var problems []fieldError
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		problems = append(problems, fieldError{Field: "name", Message: "is required"})
+	} else if utf8.RuneCountInString(name) > maxAPIKeyNameLength {
+		problems = append(problems, fieldError{Field: "name", Message: "is too long"})
+	}
+	var scopes []string
+	for _, scope := range req.Scopes {
+		if !slices.Contains(grantableScopes, scope) {
+			problems = append(problems, fieldError{
+				Field:   "scopes",
+				Message: "must be some of " + strings.Join(grantableScopes, ", "),
+			})
+			break
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	if len(req.Scopes) == 0 {
+		problems = append(problems, fieldError{Field: "scopes", Message: "is required"})
+	}
+	var expiresAt sql.NullTime
+	if req.ExpiresAt != nil {
+		if !req.ExpiresAt.After(time.Now()) {
+			problems = append(problems, fieldError{Field: "expires_at", Message: "must be in the future"})
+		}
+		expiresAt = sql.NullTime{Time: req.ExpiresAt.UTC(), Valid: true}
+	}
+	if len(problems) > 0 {
+		respondWithError(w, r, 400, errCodeValidation, "Invalid API key request", problems...)
+		return
+	}
+	slices.Sort(scopes)
+
+	key, err := auth.MakeAPIKey()
+	if err != nil {
+		log.Printf("Error generating API key: %s", err)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to create API key")
+		return
+	}
+	stored, dbErr := cfg.db.CreateAPIKey(r.Context(), database.CreateAPIKeyParams{
+		ID:        key.ID,
+		UserID:    caller.UserID,
+		Name:      name,
+		KeyHash:   auth.HashOpaqueToken(key.Secret, cfg.refreshPepper, auth.PurposeAPIKey),
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+	})
+	if dbErr != nil {
+		log.Printf("Error storing API key for user %s: %s", caller.UserID, dbErr)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to create API key")
+		return
+	}
+	created := newAPIKey(stored)
+	created.Key = auth.FormatAPIKey(key)
+	respondWithJSON(w, 201, created)
+}
+
+func (cfg *apiConfig) listAPIKeys(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+	rows, dbErr := cfg.db.ListAPIKeys(r.Context(), caller.UserID)
+	if dbErr != nil {
+		log.Printf("Error listing API keys: %s", dbErr)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to list API keys")
+		return
+	}
+	keys := make([]APIKey, 0, len(rows))
+	for _, row := range rows {
+		keys = append(keys, newAPIKey(row))
+	}
+	respondWithJSON(w, 200, keys)
+}
+
+func (cfg *apiConfig) revokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+	keyID := r.PathValue("keyID")
+
+	revoked, dbErr := cfg.db.RevokeAPIKey(r.Context(), database.RevokeAPIKeyParams{
+		ID:     keyID,
+		UserID: caller.UserID,
+	})
+	if dbErr != nil {
+		log.Printf("Error revoking API key %s: %s", keyID, dbErr)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to revoke API key")
+		return
+	}
+	if revoked == 0 {
+		respondWithError(w, r, 404, errCodeNotFound, "API key not found")
+		return
+	}
+	w.WriteHeader(204)
+}
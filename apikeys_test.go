@@ -0,0 +1,201 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dev-perry/go-server/internal/auth"
+	"github.com/dev-perry/go-server/internal/database"
+)
+
+type createAPIKeyRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+func apiKeyAuth(key string) string {
+	return "ApiKey " + key
+}
+
+func (ts *testServer) createAPIKey(t *testing.T, token string, scopes ...string) APIKey {
+	t.Helper()
+	res, body := ts.do(t, "POST", "/api/keys", bearer(token), createAPIKeyRequest{Name: "bot", Scopes: scopes})
+	expectStatus(t, res, body, http.StatusCreated)
+	return decode[APIKey](t, body)
+}
+
+func TestAPIKeyScopes(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser(t, "walt@example.com", "heisenberg")
+	login := ts.login(t, "walt@example.com", "heisenberg")
+
+	key := ts.createAPIKey(t, login.Token, scopeChirpsWrite, scopeChirpsRead, scopeChirpsWrite)
+	if !strings.HasPrefix(key.Key, "chirpy_") {
+		t.Errorf("expected a prefixed key, got %q", key.Key)
+	}
+	if len(key.Scopes) != 2 || key.Scopes[0] != scopeChirpsRead || key.Scopes[1] != scopeChirpsWrite {
+		t.Errorf("expected sorted, deduplicated scopes, got %v", key.Scopes)
+	}
+
+	res, body := ts.do(t, "POST", "/api/chirps", apiKeyAuth(key.Key), createChirpRequest{Body: "Say my name"})
+	expectStatus(t, res, body, http.StatusCreated)
+	res, body = ts.do(t, "GET", "/api/chirps", apiKeyAuth(key.Key), nil)
+	expectStatus(t, res, body, http.StatusOK)
+
+	// Not granted, and never grantable.
+	res, body = ts.do(t, "PUT", "/api/users", apiKeyAuth(key.Key), credsRequest{Email: "walt@example.com", Password: "blue"})
+	expectError(t, res, body, http.StatusForbidden, errCodeForbidden)
+	if got := res.Header.Get("WWW-Authenticate"); !strings.Contains(got, `error="insufficient_scope"`) {
+		t.Errorf("expected an insufficient_scope challenge, got %q", got)
+	}
+	res, body = ts.do(t, "POST", "/api/keys", apiKeyAuth(key.Key), createAPIKeyRequest{Name: "escalate", Scopes: []string{scopeProfileWrite}})
+	expectError(t, res, body, http.StatusForbidden, errCodeForbidden)
+	res, body = ts.do(t, "GET", "/api/sessions", apiKeyAuth(key.Key), nil)
+	expectError(t, res, body, http.StatusForbidden, errCodeForbidden)
+
+	readOnly := ts.createAPIKey(t, login.Token, scopeChirpsRead)
+	res, body = ts.do(t, "POST", "/api/chirps", apiKeyAuth(readOnly.Key), createChirpRequest{Body: "Say my name"})
+	expectError(t, res, body, http.StatusForbidden, errCodeForbidden)
+}
+
+func TestAPIKeyProfileWrite(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser(t, "walt@example.com", "heisenberg")
+	login := ts.login(t, "walt@example.com", "heisenberg")
+	key := ts.createAPIKey(t, login.Token, scopeProfileWrite)
+
+	res, body := ts.do(t, "PUT", "/api/users", apiKeyAuth(key.Key), credsRequest{Email: "walt@example.com", Password: "blue"})
+	expectStatus(t, res, body, http.StatusOK)
+	if updated := decode[UpdatedUserRes](t, body); updated.Token != "" || updated.RefreshToken != "" {
+		t.Errorf("expected no session tokens for an API key, got %s", body)
+	}
+	// The password change revoked the session but not the key.
+	res, body = ts.do(t, "GET", "/api/sessions", bearer(login.Token), nil)
+	expectError(t, res, body, http.StatusUnauthorized, errCodeUnauthorized)
+	res, body = ts.do(t, "PUT", "/api/users", apiKeyAuth(key.Key), credsRequest{Email: "walt@example.com", Password: "heisenberg"})
+	expectStatus(t, res, body, http.StatusOK)
+}
+
+func TestAPIKeyLifecycle(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser(t, "walt@example.com", "heisenberg")
+	ts.createUser(t, "jesse@example.com", "yeahscience")
+	walt := ts.login(t, "walt@example.com", "heisenberg")
+	jesse := ts.login(t, "jesse@example.com", "yeahscience")
+	key := ts.createAPIKey(t, walt.Token, scopeChirpsWrite)
+
+	ts.createChirp(t, walt.Token, "Say my name")
+	res, body := ts.do(t, "GET", "/api/keys", bearer(walt.Token), nil)
+	expectStatus(t, res, body, http.StatusOK)
+	keys := decode[[]APIKey](t, body)
+	if len(keys) != 1 || keys[0].ID != key.ID || keys[0].Key != "" {
+		t.Fatalf("expected the key without its secret, got %s", body)
+	}
+
+	res, body = ts.do(t, "POST", "/api/chirps", apiKeyAuth(key.Key), createChirpRequest{Body: "I am the one who knocks"})
+	expectStatus(t, res, body, http.StatusCreated)
+	res, body = ts.do(t, "GET", "/api/keys", bearer(walt.Token), nil)
+	if keys := decode[[]APIKey](t, body); keys[0].LastUsedAt == nil {
+		t.Errorf("expected last_used_at to be set, got %s", body)
+	}
+
+	res, body = ts.do(t, "DELETE", "/api/keys/"+key.ID, bearer(jesse.Token), nil)
+	expectError(t, res, body, http.StatusNotFound, errCodeNotFound)
+	res, body = ts.do(t, "DELETE", "/api/keys/"+key.ID, bearer(walt.Token), nil)
+	expectStatus(t, res, body, http.StatusNoContent)
+	res, body = ts.do(t, "POST", "/api/chirps", apiKeyAuth(key.Key), createChirpRequest{Body: "Tread lightly"})
+	expectError(t, res, body, http.StatusUnauthorized, errCodeUnauthorized)
+	res, body = ts.do(t, "GET", "/api/keys", bearer(walt.Token), nil)
+	if keys := decode[[]APIKey](t, body); len(keys) != 0 {
+		t.Errorf("expected revoked keys to be hidden, got %s", body)
+	}
+
+	tampered := key.Key[:len(key.Key)-1] + "0"
+	if tampered == key.Key {
+		tampered = key.Key[:len(key.Key)-1] + "1"
+	}
+	for _, credential := range []string{tampered, "chirpy_nope", "not-a-key"} {
+		res, body = ts.do(t, "GET", "/api/chirps", apiKeyAuth(credential), nil)
+		expectError(t, res, body, http.StatusUnauthorized, errCodeUnauthorized)
+	}
+}
+
+func TestAPIKeyExpiry(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser(t, "walt@example.com", "heisenberg")
+	login := ts.login(t, "walt@example.com", "heisenberg")
+
+	past := time.Now().Add(-time.Minute)
+	res, body := ts.do(t, "POST", "/api/keys", bearer(login.Token), createAPIKeyRequest{Name: "bot", Scopes: []string{scopeChirpsRead}, ExpiresAt: &past})
+	apiErr := expectError(t, res, body, http.StatusBadRequest, errCodeValidation)
+	if len(apiErr.Details) != 1 || apiErr.Details[0].Field != "expires_at" {
+		t.Errorf("expected an expires_at detail, got %v", apiErr.Details)
+	}
+	res, body = ts.do(t, "POST", "/api/keys", bearer(login.Token), createAPIKeyRequest{Name: " ", Scopes: []string{"admin"}})
+	apiErr = expectError(t, res, body, http.StatusBadRequest, errCodeValidation)
+	if len(apiErr.Details) != 2 {
+		t.Errorf("expected name and scopes details, got %v", apiErr.Details)
+	}
+
+	soon := time.Now().Add(time.Hour)
+	res, body = ts.do(t, "POST", "/api/keys", bearer(login.Token), createAPIKeyRequest{Name: "bot", Scopes: []string{scopeChirpsRead}, ExpiresAt: &soon})
+	expectStatus(t, res, body, http.StatusCreated)
+	key := decode[APIKey](t, body)
+	res, body = ts.do(t, "GET", "/api/chirps", apiKeyAuth(key.Key), nil)
+	expectStatus(t, res, body, http.StatusOK)
+
+	// The API refuses to create keys that have already expired, so store one
+	// directly.
+	expired, err := auth.MakeAPIKey()
+	if err != nil {
+		t.Fatalf("MakeAPIKey: %v", err)
+	}
+	_, err = ts.cfg.db.CreateAPIKey(t.Context(), database.CreateAPIKeyParams{
+		ID:        expired.ID,
+		UserID:    login.User.ID,
+		Name:      "old bot",
+		KeyHash:   auth.HashOpaqueToken(expired.Secret, testPepper, auth.PurposeAPIKey),
+		Scopes:    []string{scopeChirpsRead},
+		ExpiresAt: sql.NullTime{Time: time.Now().Add(-time.Second), Valid: true},
+	})
+	if err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+	res, body = ts.do(t, "GET", "/api/chirps", apiKeyAuth(auth.FormatAPIKey(expired)), nil)
+	expectError(t, res, body, http.StatusUnauthorized, errCodeTokenExpired)
+}
+
+func TestLegacyAPIKeyIsRehashed(t *testing.T) {
+	ts := newTestServer(t)
+	login := ts.loginAs(t, roleUser)
+	key, err := auth.MakeAPIKey()
+	if err != nil {
+		t.Fatalf("MakeAPIKey: %v", err)
+	}
+	_, err = ts.cfg.db.CreateAPIKey(t.Context(), database.CreateAPIKeyParams{
+		ID:      key.ID,
+		UserID:  login.User.ID,
+		Name:    "old bot",
+		KeyHash: auth.LegacyOpaqueTokenHash(key.Secret, testPepper),
+		Scopes:  []string{scopeChirpsRead},
+	})
+	if err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+
+	res, body := ts.do(t, "GET", "/api/chirps", apiKeyAuth(auth.FormatAPIKey(key)), nil)
+	expectStatus(t, res, body, http.StatusOK)
+	stored, err := ts.cfg.db.GetAPIKey(t.Context(), key.ID)
+	if err != nil {
+		t.Fatalf("GetAPIKey: %v", err)
+	}
+	if !auth.CheckOpaqueTokenHash(key.Secret, testPepper, auth.PurposeAPIKey, stored.KeyHash) {
+		t.Error("expected the key to be rehashed for its purpose")
+	}
+	res, body = ts.do(t, "GET", "/api/chirps", apiKeyAuth(auth.FormatAPIKey(key)), nil)
+	expectStatus(t, res, body, http.StatusOK)
+}
@@ -1,14 +1,16 @@
 package main
 
 import (
+	"database/sql"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
-	"sort"
 	"strings"
 	"time"
 
-	"github.com/dev-perry/go-server/internal/auth"
 	"github.com/dev-perry/go-server/internal/database"
 	"github.com/google/uuid"
 )
@@ -25,6 +27,50 @@ type Chirp struct {
 	UserID    uuid.UUID `json:"user_id"`
 }
 
+type chirpPage struct {
+	Chirps     []Chirp `json:"chirps"`
+	NextCursor *string `json:"next_cursor"`
+}
+
+const (
+	defaultChirpPageSize = 50
+	maxChirpPageSize     = 100
+)
+
+// chirpCursor is the keyset position of the last chirp on a page. Clients
+// treat its encoded form as opaque.
+type chirpCursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+	Valid     bool
+}
+
+func (c chirpCursor) encode() string {
+	raw := c.CreatedAt.Format(time.RFC3339Nano) + "," + c.ID.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeChirpCursor(encoded string) (chirpCursor, error) {
+	if encoded == "" {
+		return chirpCursor{}, nil
+	}
+	invalid := &paramError{Param: "cursor", Message: "is not a valid cursor"}
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return chirpCursor{}, invalid
+	}
+	ts, id, found := strings.Cut(string(raw), ",")
+	if !found {
+		return chirpCursor{}, invalid
+	}
+	createdAt, tsErr := time.Parse(time.RFC3339Nano, ts)
+	chirpID, idErr := uuid.Parse(id)
+	if tsErr != nil || idErr != nil {
+		return chirpCursor{}, invalid
+	}
+	return chirpCursor{CreatedAt: createdAt, ID: chirpID, Valid: true}, nil
+}
+
 var theProfane = [3]string{"kerfuffle", "sharbert", "fornax"}
 
 func censorWord(w string) string {
@@ -47,39 +93,25 @@ func filterProfanity(input string) string {
 }
 
 func (cfg *apiConfig) createChirp(w http.ResponseWriter, r *http.Request) {
-	token, tokenErr := auth.GetBearerToken(r.Header)
-	if tokenErr != nil {
-		w.WriteHeader(403)
-		w.Write([]byte("Unathorized"))
+	caller, _ := principalFromContext(r.Context())
+	uid := caller.UserID
+	if cfg.requireVerifiedEmail && !caller.EmailVerified {
+		respondWithError(w, r, 403, errCodeForbidden, "Verify your email address before posting chirps")
 		return
 	}
 	decoder := json.NewDecoder(r.Body)
 	req := createChirpRequest{}
 	err := decoder.Decode(&req)
-	defaultErr := fail{
-		Error: "Something went wrong",
-	}
-
-	uid, authErr := auth.ValidateJWT(token, cfg.tokenSecret)
-	if authErr != nil {
-		w.WriteHeader(401)
-		w.Write([]byte("Unauthorized"))
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-
 	if err != nil {
-		errMessage, _ := json.Marshal(defaultErr)
-		w.WriteHeader(500)
-		w.Write(errMessage)
+		respondWithError(w, r, 400, errCodeBadRequest, "Unable to decode request body")
 		return
 	}
 
-	if len(req.Body) > 140 {
-		message := "Chirp is too long"
-		w.WriteHeader(400)
-		w.Write([]byte(message))
+	if len(req.Body) > cfg.chirpMaxLength {
+		respondWithError(w, r, 400, errCodeValidation, "Chirp is too long", fieldError{
+			Field:   "body",
+			Message: fmt.Sprintf("must be %d characters or fewer", cfg.chirpMaxLength),
+		})
 		return
 	} else {
 		req.Body = filterProfanity(req.Body)
@@ -90,7 +122,7 @@ func (cfg *apiConfig) createChirp(w http.ResponseWriter, r *http.Request) {
 		newChirp, dbErr := cfg.db.CreateChirp(r.Context(), insertChirp)
 		if dbErr != nil {
 			log.Printf("Datbase error %v", dbErr)
-			w.WriteHeader(500)
+			respondWithError(w, r, 500, errCodeInternal, "Unable to create chirp")
 			return
 		}
 		chirp := Chirp{
@@ -100,69 +132,82 @@ func (cfg *apiConfig) createChirp(w http.ResponseWriter, r *http.Request) {
 			Body:      newChirp.Body,
 			UserID:    newChirp.UserID,
 		}
-		chirpResponse, _ := json.Marshal(chirp)
-		w.WriteHeader(201)
-		w.Write(chirpResponse)
+		respondWithJSON(w, 201, chirp)
 	}
 }
 
 func (cfg *apiConfig) getChirps(w http.ResponseWriter, r *http.Request) {
-	authorId := r.URL.Query().Get("author_id")
-	sortBy := r.URL.Query().Get("sort")
+	authorId, paramErr := queryUUID(r, "author_id")
+	if paramErr != nil {
+		respondWithParamError(w, r, paramErr)
+		return
+	}
+	sortBy, paramErr := queryOneOf(r, "sort", "asc", "desc")
+	if paramErr != nil {
+		respondWithParamError(w, r, paramErr)
+		return
+	}
+	limit, paramErr := queryInt(r, "limit", defaultChirpPageSize, 1, maxChirpPageSize)
+	if paramErr != nil {
+		respondWithParamError(w, r, paramErr)
+		return
+	}
+	cursor, paramErr := decodeChirpCursor(r.URL.Query().Get("cursor"))
+	if paramErr != nil {
+		respondWithParamError(w, r, paramErr)
+		return
+	}
 
+	// Fetch one extra row to learn whether there is another page.
+	listParams := database.ListChirpsAscParams{
+		AuthorID:       authorId,
+		AfterCreatedAt: sql.NullTime{Time: cursor.CreatedAt, Valid: cursor.Valid},
+		AfterID:        uuid.NullUUID{UUID: cursor.ID, Valid: cursor.Valid},
+		PageSize:       int32(limit + 1),
+	}
 	var dbChirps []database.Chirp
-
-	if authorId == "" {
-		chirps, dbErr := cfg.db.GetAllChirps(r.Context())
-		if dbErr != nil {
-			w.WriteHeader(500)
-			w.Write([]byte("Something went wrong"))
-			return
-		}
-
-		dbChirps = chirps
+	var dbErr error
+	if sortBy == "desc" {
+		dbChirps, dbErr = cfg.db.ListChirpsDesc(r.Context(), database.ListChirpsDescParams(listParams))
 	} else {
-		chirps, dbErr := cfg.db.GetAllChirpsByAuthor(r.Context(), uuid.MustParse(authorId))
-		if dbErr != nil {
-			w.WriteHeader(500)
-			w.Write([]byte("Something went wrong"))
-			return
-		}
-
-		dbChirps = chirps
+		dbChirps, dbErr = cfg.db.ListChirpsAsc(r.Context(), listParams)
+	}
+	if dbErr != nil {
+		log.Printf("Error fetching chirps: %s", dbErr)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to fetch chirps")
+		return
 	}
 
-	chirpResponse := make([]Chirp, len(dbChirps))
-	for i, c := range dbChirps {
-		chirpResponse[i] = Chirp{
+	page := chirpPage{
+		Chirps: []Chirp{},
+	}
+	if len(dbChirps) > limit {
+		dbChirps = dbChirps[:limit]
+		last := dbChirps[limit-1]
+		next := chirpCursor{CreatedAt: last.CreatedAt, ID: last.ID, Valid: true}.encode()
+		page.NextCursor = &next
+	}
+	for _, c := range dbChirps {
+		page.Chirps = append(page.Chirps, Chirp{
 			ID:        c.ID,
 			CreatedAt: c.CreatedAt,
 			UpdatedAt: c.UpdatedAt,
 			Body:      c.Body,
 			UserID:    c.UserID,
-		}
+		})
 	}
-	switch sortBy {
-	case "asc":
-		sort.Slice(chirpResponse, func(i, j int) bool { return chirpResponse[i].CreatedAt.Before(chirpResponse[j].CreatedAt) })
-	case "desc":
-		sort.Slice(chirpResponse, func(i, j int) bool { return chirpResponse[i].CreatedAt.After(chirpResponse[j].CreatedAt) })
-
-	}
-	response, _ := json.Marshal(chirpResponse)
-	w.Write(response)
+	respondWithJSON(w, 200, page)
 }
 
 func (cfg *apiConfig) getChirp(w http.ResponseWriter, r *http.Request) {
-	chirpID := r.PathValue("chirpID")
-	if chirpID == "" {
-		w.WriteHeader(404)
-		w.Write([]byte("Chirp ID required"))
+	chirpID, paramErr := pathUUID(r, "chirpID")
+	if paramErr != nil {
+		respondWithParamError(w, r, paramErr)
 		return
 	}
-	c, dbErr := cfg.db.GetChirp(r.Context(), uuid.MustParse(chirpID))
+	c, dbErr := cfg.db.GetChirp(r.Context(), chirpID)
 	if dbErr != nil {
-		w.WriteHeader(404)
+		respondWithError(w, r, 404, errCodeNotFound, "Chirp not found")
 		return
 	}
 	responseChirp := Chirp{
@@ -173,53 +218,45 @@ func (cfg *apiConfig) getChirp(w http.ResponseWriter, r *http.Request) {
 		UserID:    c.UserID,
 	}
 
-	response, _ := json.Marshal(responseChirp)
-
-	w.Write(response)
+	respondWithJSON(w, 200, responseChirp)
 }
 
 func (cfg *apiConfig) deleteChirp(w http.ResponseWriter, r *http.Request) {
-	chirpID := r.PathValue("chirpID")
-	if chirpID == "" {
-		w.WriteHeader(404)
-		w.Write([]byte("Chirp ID required"))
-		return
-	}
-	token, tokenErr := auth.GetBearerToken(r.Header)
-	if tokenErr != nil {
-		w.WriteHeader(401)
-		w.Write([]byte("Unathorized"))
-		return
-	}
-
-	uid, authErr := auth.ValidateJWT(token, cfg.tokenSecret)
-	if authErr != nil {
-		w.WriteHeader(401)
-		w.Write([]byte("Unauthorized"))
+	chirpID, paramErr := pathUUID(r, "chirpID")
+	if paramErr != nil {
+		respondWithParamError(w, r, paramErr)
 		return
 	}
+	caller, _ := principalFromContext(r.Context())
+	uid := caller.UserID
 
 	authorCheck := database.IsChirpAuthorParams{
 		UserID: uid,
-		ID:     uuid.MustParse(chirpID),
+		ID:     chirpID,
 	}
 	authorResult, dbErr := cfg.db.IsChirpAuthor(r.Context(), authorCheck)
 
+	if errors.Is(dbErr, sql.ErrNoRows) {
+		respondWithError(w, r, 404, errCodeNotFound, "Chirp not found")
+		return
+	}
 	if dbErr != nil {
-		w.WriteHeader(500)
+		log.Printf("Error checking chirp author: %s", dbErr)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to delete chirp")
 		return
 	}
 	if !authorResult.IsAuthor {
-		w.WriteHeader(403)
+		respondWithError(w, r, 403, errCodeForbidden, "You can only delete your own chirps")
 		return
 	}
 	deleteParams := database.DeleteChirpParams{
 		UserID: uid,
-		ID:     uuid.MustParse(chirpID),
+		ID:     chirpID,
 	}
 	chirpErr := cfg.db.DeleteChirp(r.Context(), deleteParams)
 	if chirpErr != nil {
-		w.WriteHeader(500)
+		log.Printf("Error deleting chirp: %s", chirpErr)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to delete chirp")
 		return
 	}
 	w.WriteHeader(204)
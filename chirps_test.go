@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func (ts *testServer) createChirp(t *testing.T, token, body string) Chirp {
+	t.Helper()
+	res, resBody := ts.do(t, "POST", "/api/chirps", bearer(token), createChirpRequest{Body: body})
+	expectStatus(t, res, resBody, http.StatusCreated)
+	return decode[Chirp](t, resBody)
+}
+
+func TestCreateChirp(t *testing.T) {
+	ts := newTestServer(t)
+	user := ts.createUser(t, "walt@example.com", "heisenberg")
+	login := ts.login(t, "walt@example.com", "heisenberg")
+
+	chirp := ts.createChirp(t, login.Token, "I am the one who Kerfuffle knocks")
+	if chirp.Body != "I am the one who **** knocks" {
+		t.Errorf("expected profanity to be censored, got %q", chirp.Body)
+	}
+	if chirp.UserID != user.ID {
+		t.Errorf("expected chirp author %s, got %s", user.ID, chirp.UserID)
+	}
+
+	res, body := ts.do(t, "POST", "/api/chirps", bearer(login.Token), createChirpRequest{Body: strings.Repeat("a", 141)})
+	apiErr := expectError(t, res, body, http.StatusBadRequest, errCodeValidation)
+	if len(apiErr.Details) != 1 || apiErr.Details[0].Field != "body" {
+		t.Errorf("expected body field detail, got %v", apiErr.Details)
+	}
+
+	res, body = ts.do(t, "POST", "/api/chirps", bearer("garbage"), createChirpRequest{Body: "hi"})
+	expectStatus(t, res, body, http.StatusUnauthorized)
+}
+
+func TestListChirps(t *testing.T) {
+	ts := newTestServer(t)
+	walt := ts.createUser(t, "walt@example.com", "heisenberg")
+	ts.createUser(t, "jesse@example.com", "pinkman")
+	waltLogin := ts.login(t, "walt@example.com", "heisenberg")
+	jesseLogin := ts.login(t, "jesse@example.com", "pinkman")
+
+	first := ts.createChirp(t, waltLogin.Token, "first")
+	ts.createChirp(t, jesseLogin.Token, "second")
+	last := ts.createChirp(t, waltLogin.Token, "third")
+
+	res, body := ts.do(t, "GET", "/api/chirps", "", nil)
+	expectStatus(t, res, body, http.StatusOK)
+	page := decode[chirpPage](t, body)
+	if len(page.Chirps) != 3 || page.NextCursor != nil {
+		t.Fatalf("expected 3 chirps on a single page, got %d (next %v)", len(page.Chirps), page.NextCursor)
+	}
+	if page.Chirps[0].ID != first.ID {
+		t.Errorf("expected chirps oldest first by default, got %v", page.Chirps)
+	}
+
+	res, body = ts.do(t, "GET", "/api/chirps?author_id="+walt.ID.String(), "", nil)
+	expectStatus(t, res, body, http.StatusOK)
+	chirps := decode[chirpPage](t, body).Chirps
+	if len(chirps) != 2 {
+		t.Fatalf("expected 2 chirps by author, got %d", len(chirps))
+	}
+	for _, c := range chirps {
+		if c.UserID != walt.ID {
+			t.Errorf("expected only chirps by %s, got one by %s", walt.ID, c.UserID)
+		}
+	}
+
+	res, body = ts.do(t, "GET", "/api/chirps?sort=desc", "", nil)
+	expectStatus(t, res, body, http.StatusOK)
+	chirps = decode[chirpPage](t, body).Chirps
+	if chirps[0].ID != last.ID || chirps[len(chirps)-1].ID != first.ID {
+		t.Errorf("expected chirps newest first, got %v", chirps)
+	}
+}
+
+func TestListChirpsPagination(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser(t, "walt@example.com", "heisenberg")
+	login := ts.login(t, "walt@example.com", "heisenberg")
+
+	var created []Chirp
+	for i := range 5 {
+		created = append(created, ts.createChirp(t, login.Token, fmt.Sprintf("chirp %d", i)))
+	}
+
+	for _, order := range []string{"asc", "desc"} {
+		t.Run(order, func(t *testing.T) {
+			var seen []Chirp
+			path := "/api/chirps?limit=2&sort=" + order
+			for pages := 0; ; pages++ {
+				if pages > 3 {
+					t.Fatal("pagination did not terminate")
+				}
+				res, body := ts.do(t, "GET", path, "", nil)
+				expectStatus(t, res, body, http.StatusOK)
+				page := decode[chirpPage](t, body)
+				seen = append(seen, page.Chirps...)
+				if page.NextCursor == nil {
+					break
+				}
+				path = "/api/chirps?limit=2&sort=" + order + "&cursor=" + *page.NextCursor
+			}
+
+			if len(seen) != len(created) {
+				t.Fatalf("expected %d chirps across pages, got %d", len(created), len(seen))
+			}
+			for i := range created {
+				want := created[i]
+				if order == "desc" {
+					want = created[len(created)-1-i]
+				}
+				if seen[i].ID != want.ID {
+					t.Errorf("position %d: expected %q, got %q", i, want.Body, seen[i].Body)
+				}
+			}
+		})
+	}
+
+	res, body := ts.do(t, "GET", "/api/chirps?cursor=bogus", "", nil)
+	expectError(t, res, body, http.StatusBadRequest, errCodeInvalidParameter)
+
+	res, body = ts.do(t, "GET", "/api/chirps?limit=0", "", nil)
+	expectError(t, res, body, http.StatusBadRequest, errCodeInvalidParameter)
+}
+
+func TestGetChirp(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser(t, "walt@example.com", "heisenberg")
+	login := ts.login(t, "walt@example.com", "heisenberg")
+	chirp := ts.createChirp(t, login.Token, "say my name")
+
+	res, body := ts.do(t, "GET", "/api/chirps/"+chirp.ID.String(), "", nil)
+	expectStatus(t, res, body, http.StatusOK)
+	if got := decode[Chirp](t, body); got.ID != chirp.ID || got.Body != chirp.Body {
+		t.Errorf("expected %v, got %v", chirp, got)
+	}
+
+	res, body = ts.do(t, "GET", "/api/chirps/00000000-0000-0000-0000-000000000000", "", nil)
+	expectError(t, res, body, http.StatusNotFound, errCodeNotFound)
+}
+
+func TestDeleteChirp(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser(t, "walt@example.com", "heisenberg")
+	ts.createUser(t, "jesse@example.com", "pinkman")
+	waltLogin := ts.login(t, "walt@example.com", "heisenberg")
+	jesseLogin := ts.login(t, "jesse@example.com", "pinkman")
+	chirp := ts.createChirp(t, waltLogin.Token, "say my name")
+	path := "/api/chirps/" + chirp.ID.String()
+
+	res, body := ts.do(t, "DELETE", path, "", nil)
+	expectStatus(t, res, body, http.StatusUnauthorized)
+
+	res, body = ts.do(t, "DELETE", path, bearer(jesseLogin.Token), nil)
+	expectError(t, res, body, http.StatusForbidden, errCodeForbidden)
+
+	res, body = ts.do(t, "DELETE", path, bearer(waltLogin.Token), nil)
+	expectStatus(t, res, body, http.StatusNoContent)
+
+	res, body = ts.do(t, "GET", path, "", nil)
+	expectStatus(t, res, body, http.StatusNotFound)
+}
+
+func TestMalformedIDsAreRejected(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser(t, "walt@example.com", "heisenberg")
+	login := ts.login(t, "walt@example.com", "heisenberg")
+
+	tests := []struct {
+		method string
+		path   string
+		auth   string
+		field  string
+	}{
+		{"GET", "/api/chirps/not-a-uuid", "", "chirpID"},
+		{"DELETE", "/api/chirps/not-a-uuid", bearer(login.Token), "chirpID"},
+		{"GET", "/api/chirps?author_id=not-a-uuid", "", "author_id"},
+		{"GET", "/api/chirps?sort=sideways", "", "sort"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			res, body := ts.do(t, tt.method, tt.path, tt.auth, nil)
+			apiErr := expectError(t, res, body, http.StatusBadRequest, errCodeInvalidParameter)
+			if len(apiErr.Details) != 1 || apiErr.Details[0].Field != tt.field {
+				t.Errorf("expected detail for %s, got %v", tt.field, apiErr.Details)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/dev-perry/go-server/internal/config"
+	"github.com/dev-perry/go-server/internal/database"
+)
+
+const usage = "usage: chirpy [set-role <email> <role>]"
+
+// runCommand runs a maintenance command against the configured database
+// instead of starting the server.
+func runCommand(ctx context.Context, args []string, out io.Writer) error {
+	switch args[0] {
+	case "set-role":
+		if len(args) != 3 {
+			return errors.New(usage)
+		}
+		conf, err := config.Load()
+		if err != nil {
+			return fmt.Errorf("invalid configuration: %w", err)
+		}
+		if conf.DBURL == "" {
+			return errors.New("set-role needs DB_URL; the in-memory store only lives as long as the server")
+		}
+		db, err := openDB(conf)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		return setRoleByEmail(ctx, database.NewStore(db, nil), args[1], args[2], out)
+	default:
+		return fmt.Errorf("unknown command %q\n%s", args[0], usage)
+	}
+}
+
+// setRoleByEmail is how the first admin is made, since only admins can
+// change roles through the API.
+func setRoleByEmail(ctx context.Context, store database.Store, email, role string, out io.Writer) error {
+	if !slices.Contains(roles, role) {
+		return fmt.Errorf("unknown role %q, expected one of %s", role, strings.Join(roles, ", "))
+	}
+	user, err := store.GetUserCredsByEmail(ctx, email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("no user has the email %s", email)
+	}
+	if err != nil {
+		return fmt.Errorf("looking up user: %w", err)
+	}
+	err = store.ExecTx(ctx, func(q database.Querier) error {
+		if _, err := q.SetUserRole(ctx, database.SetUserRoleParams{ID: user.ID, Role: role}); err != nil {
+			return err
+		}
+		return q.CreateSecurityEvent(ctx, database.CreateSecurityEventParams{
+			UserID: user.ID,
+			Kind:   securityEventRoleChanged,
+			Detail: fmt.Sprintf("role set to %s from the command line", role),
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("setting role: %w", err)
+	}
+	fmt.Fprintf(out, "%s (%s) is now %s\n", user.Email, user.ID, role)
+	return nil
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"math"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dev-perry/go-server/internal/auth"
+	"github.com/dev-perry/go-server/internal/database"
+	"github.com/dev-perry/go-server/internal/mailer"
+	"github.com/google/uuid"
+)
+
+const (
+	verificationEmailLimit  = 3
+	verificationEmailWindow = time.Hour
+	// maxEmailLength is the longest address SMTP can deliver to (RFC 5321).
+	maxEmailLength = 254
+)
+
+var (
+	errInvalidEmail             = errors.New("is not a valid email address")
+	errInvalidVerificationToken = errors.New("email verification token is invalid")
+)
+
+// normalizeEmail trims raw and lower-cases its domain, which is not case
+// sensitive. The local part is kept as typed, but the users table compares
+// addresses case-insensitively, so Walt@ and walt@ are the same account.
+func normalizeEmail(raw string) (string, error) {
+	trimmed := strings.TrimSpace(raw)
+	if len(trimmed) > maxEmailLength {
+		return "", errInvalidEmail
+	}
+	// Rejects display names and comments: only a bare address parses back to
+	// itself.
+	addr, err := mail.ParseAddress(trimmed)
+	if err != nil || addr.Address != trimmed {
+		return "", errInvalidEmail
+	}
+	at := strings.LastIndex(trimmed, "@")
+	local, domain := trimmed[:at], strings.ToLower(trimmed[at+1:])
+	if !strings.Contains(domain, ".") || strings.HasSuffix(domain, ".") {
+		return "", errInvalidEmail
+	}
+	return local + "@" + domain, nil
+}
+
+func respondWithInvalidEmail(w http.ResponseWriter, r *http.Request) {
+	respondWithError(w, r, 400, errCodeValidation, "Email address is invalid", fieldError{
+		Field:   "email",
+		Message: errInvalidEmail.Error(),
+	})
+}
+
+// sendEmailVerification mails userID a link proving they own email, which is
+// either their current address or the one they asked to change to. Earlier
+// links stop working.
+func (cfg *apiConfig) sendEmailVerification(ctx context.Context, userID uuid.UUID, email string) error {
+	token, err := auth.MakeOpaqueToken()
+	if err != nil {
+		return err
+	}
+	if err := cfg.db.InvalidateEmailVerificationTokens(ctx, userID); err != nil {
+		return err
+	}
+	err = cfg.db.CreateEmailVerificationToken(ctx, database.CreateEmailVerificationTokenParams{
+		ID:        token.ID,
+		UserID:    userID,
+		Email:     email,
+		TokenHash: auth.HashOpaqueToken(token.Secret, cfg.refreshPepper, auth.PurposeEmailVerification),
+		ExpiresAt: time.Now().Add(cfg.emailVerificationTTL),
+	})
+	if err != nil {
+		return err
+	}
+
+	link := cfg.publicURL + "/api/verify-email?token=" + url.QueryEscape(token.String())
+	return cfg.mailer.Send(ctx, mailer.Message{
+		To:      email,
+		Subject: "Confirm your email address for Chirpy",
+		Body: fmt.Sprintf("To confirm this is your email address, open this link within %s:\n\n%s\n\n"+
+			"If you didn't sign up for Chirpy or change your address, you can ignore this email.\n",
+			cfg.emailVerificationTTL, link),
+	})
+}
+
+// verifyEmailPage is where the link in the email leads. Mail scanners open
+// links before people do, so the page only confirms when its button is
+// pressed, by posting the token to verifyEmail.
+var verifyEmailPage = template.Must(template.New("verify-email").Parse(`<!DOCTYPE html>
+<html>
+    <head>
+        <meta charset="utf-8">
+        <title>Verify your email address - Chirpy</title>
+    </head>
+    <body>
+        <h1>Verify your email address</h1>
+        <button id="confirm">Confirm this address</button>
+        <p id="message"></p>
+        <script nonce="{{.Nonce}}">
+            const token = new URLSearchParams(location.search).get("token");
+            const button = document.getElementById("confirm");
+            const message = document.getElementById("message");
+            button.addEventListener("click", async () => {
+                button.disabled = true;
+                const res = await fetch("/api/verify-email", {
+                    method: "POST",
+                    headers: {"Content-Type": "application/json"},
+                    body: JSON.stringify({token}),
+                });
+                const body = await res.json();
+                if (res.ok) {
+                    button.hidden = true;
+                    message.textContent = body.email + " is verified.";
+                } else {
+                    message.textContent = body.error.message;
+                }
+            });
+        </script>
+    </body>
+</html>
+`))
+
+// showVerifyEmailPage serves verifyEmailPage. It changes nothing, so it is
+// safe for anything to fetch.
+func showVerifyEmailPage(w http.ResponseWriter, r *http.Request) {
+	nonce, err := scriptNonce()
+	if err != nil {
+		log.Printf("Error generating script nonce: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	setPageHeaders(w.Header(), nonce)
+	w.WriteHeader(200)
+	if err := verifyEmailPage.Execute(w, struct{ Nonce string }{nonce}); err != nil {
+		log.Printf("Error rendering email verification page: %s", err)
+	}
+}
+
+// verifyEmail confirms an address with a token from sendEmailVerification,
+// posted by showVerifyEmailPage or by a front-end that handles the link
+// itself.
+func (cfg *apiConfig) verifyEmail(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, r, 400, errCodeBadRequest, "Unable to decode request body")
+		return
+	}
+	rawToken := req.Token
+
+	stored, lookupErr := cfg.lookupVerificationToken(r.Context(), rawToken)
+	if errors.Is(lookupErr, errInvalidVerificationToken) {
+		respondWithInvalidVerificationToken(w, r)
+		return
+	}
+	if lookupErr != nil {
+		log.Printf("Error looking up email verification token: %s", lookupErr)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to verify email address")
+		return
+	}
+
+	txErr := cfg.db.ExecTx(r.Context(), func(q database.Querier) error {
+		used, err := q.UseEmailVerificationToken(r.Context(), stored.ID)
+		if err != nil {
+			return err
+		}
+		if used == 0 {
+			return errInvalidVerificationToken
+		}
+		verified, err := q.VerifyUserEmail(r.Context(), database.VerifyUserEmailParams{ID: stored.UserID, Email: stored.Email})
+		if err != nil || verified == 1 {
+			return err
+		}
+		// Not the current address, so it should be a pending change. If that
+		// has since been replaced or confirmed, the link is stale.
+		confirmed, err := q.ConfirmPendingEmail(r.Context(), database.ConfirmPendingEmailParams{ID: stored.UserID, Email: stored.Email})
+		if err != nil {
+			return err
+		}
+		if confirmed == 0 {
+			return errInvalidVerificationToken
+		}
+		return nil
+	})
+	if errors.Is(txErr, errInvalidVerificationToken) {
+		respondWithInvalidVerificationToken(w, r)
+		return
+	}
+	if isUniqueViolation(txErr) {
+		// Someone else verified the address while the change was pending.
+		respondWithError(w, r, 409, errCodeConflict, "A user with that email already exists", fieldError{
+			Field:   "email",
+			Message: "is already taken",
+		})
+		return
+	}
+	if txErr != nil {
+		log.Printf("Error verifying email for user %s: %s", stored.UserID, txErr)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to verify email address")
+		return
+	}
+
+	user, dbErr := cfg.db.GetUser(r.Context(), stored.UserID)
+	if dbErr != nil {
+		log.Printf("Error loading user %s: %s", stored.UserID, dbErr)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to verify email address")
+		return
+	}
+	respondWithJSON(w, 200, User{
+		ID:            user.ID,
+		CreatedAt:     user.CreatedAt,
+		UpdatedAt:     user.UpdatedAt,
+		Email:         user.Email,
+		IsChirpyRed:   user.IsChirpyRed.Bool,
+		EmailVerified: user.EmailVerifiedAt.Valid,
+		Role:          user.Role,
+		PendingEmail:  user.PendingEmail.String,
+	})
+}
+
+// resendEmailVerification sends a new link for the caller's pending address,
+// or for their current one if it has not been verified yet.
+func (cfg *apiConfig) resendEmailVerification(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+	user, dbErr := cfg.db.GetUser(r.Context(), caller.UserID)
+	if dbErr != nil {
+		log.Printf("Error loading user %s: %s", caller.UserID, dbErr)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to send verification email")
+		return
+	}
+
+	email := user.PendingEmail.String
+	if !user.PendingEmail.Valid {
+		if user.EmailVerifiedAt.Valid {
+			respondWithError(w, r, 409, errCodeConflict, "Email address is already verified")
+			return
+		}
+		email = user.Email
+	}
+
+	allowed, retryAfter := cfg.verifyLimiter.allow(user.ID.String(), time.Now())
+	if !allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		respondWithError(w, r, 429, errCodeRateLimited, "Too many verification emails, try again later")
+		return
+	}
+	if err := cfg.sendEmailVerification(r.Context(), user.ID, email); err != nil {
+		log.Printf("Error sending verification email to user %s: %s", user.ID, err)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to send verification email")
+		return
+	}
+	w.WriteHeader(202)
+}
+
+// lookupVerificationToken finds the stored row for a presented verification
+// token and verifies its secret, returning errInvalidVerificationToken if it
+// is unknown, wrong, spent or expired.
+func (cfg *apiConfig) lookupVerificationToken(ctx context.Context, raw string) (database.EmailVerificationToken, error) {
+	presented, parseErr := auth.ParseOpaqueToken(raw)
+	if parseErr != nil {
+		return database.EmailVerificationToken{}, errInvalidVerificationToken
+	}
+	stored, dbErr := cfg.db.GetEmailVerificationToken(ctx, presented.ID)
+	if errors.Is(dbErr, sql.ErrNoRows) {
+		return database.EmailVerificationToken{}, errInvalidVerificationToken
+	}
+	if dbErr != nil {
+		return database.EmailVerificationToken{}, dbErr
+	}
+	if !auth.CheckOpaqueTokenHash(presented.Secret, cfg.refreshPepper, auth.PurposeEmailVerification, stored.TokenHash) {
+		return database.EmailVerificationToken{}, errInvalidVerificationToken
+	}
+	if stored.UsedAt.Valid || !time.Now().Before(stored.ExpiresAt) {
+		return database.EmailVerificationToken{}, errInvalidVerificationToken
+	}
+	return stored, nil
+}
+
+func respondWithInvalidVerificationToken(w http.ResponseWriter, r *http.Request) {
+	respondWithError(w, r, 400, errCodeValidation, "Verification link is invalid or has expired", fieldError{
+		Field:   "token",
+		Message: "is invalid or has expired",
+	})
+}
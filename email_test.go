@@ -0,0 +1,180 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+type tokenRequest struct {
+	Token string `json:"token"`
+}
+
+// verificationToken returns the token from the last verification email sent
+// to email.
+func (ts *testServer) verificationToken(t *testing.T, email string) string {
+	t.Helper()
+	sent := ts.mail.messages()
+	for i := len(sent) - 1; i >= 0; i-- {
+		if sent[i].To == email {
+			return linkToken(t, sent[i], "http://chirpy.test/api/verify-email")
+		}
+	}
+	t.Fatalf("expected a verification email to %s, got %v", email, sent)
+	return ""
+}
+
+func TestNormalizeEmail(t *testing.T) {
+	valid := map[string]string{
+		"walt@example.com":        "walt@example.com",
+		"  Walt@Example.COM ":     "Walt@example.com",
+		"walt+chirpy@example.com": "walt+chirpy@example.com",
+	}
+	for in, want := range valid {
+		got, err := normalizeEmail(in)
+		if err != nil || got != want {
+			t.Errorf("normalizeEmail(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "walt", "walt@", "@example.com", "Walt <walt@example.com>", "walt@localhost", "walt@example.com."} {
+		if got, err := normalizeEmail(in); err == nil {
+			t.Errorf("normalizeEmail(%q) = %q, expected an error", in, got)
+		}
+	}
+}
+
+func TestSignupVerification(t *testing.T) {
+	ts := newTestServer(t)
+	user := ts.createUser(t, "walt@example.com", "heisenberg")
+	if user.EmailVerified {
+		t.Error("expected a new account to be unverified")
+	}
+
+	token := ts.verificationToken(t, "walt@example.com")
+	// Opening the link, as mail scanners do, only shows a page to confirm on.
+	res, body := ts.do(t, "GET", "/api/verify-email?token="+url.QueryEscape(token), "", nil)
+	expectStatus(t, res, body, http.StatusOK)
+	if !strings.Contains(string(body), `fetch("/api/verify-email"`) {
+		t.Errorf("expected a confirmation page, got %s", body)
+	}
+	if login := ts.login(t, "walt@example.com", "heisenberg"); login.EmailVerified {
+		t.Error("expected opening the link not to verify the address")
+	}
+
+	res, body = ts.do(t, "POST", "/api/verify-email", "", tokenRequest{Token: token})
+	expectStatus(t, res, body, http.StatusOK)
+	if got := decode[User](t, body); !got.EmailVerified {
+		t.Errorf("expected the address to be verified, got %+v", got)
+	}
+	if login := ts.login(t, "walt@example.com", "heisenberg"); !login.EmailVerified {
+		t.Error("expected login to report the address as verified")
+	}
+
+	res, body = ts.do(t, "POST", "/api/verify-email", "", tokenRequest{Token: token})
+	expectError(t, res, body, http.StatusBadRequest, errCodeValidation)
+}
+
+func TestEmailChangeRequiresVerification(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser(t, "walt@example.com", "heisenberg")
+	login := ts.login(t, "walt@example.com", "heisenberg")
+
+	res, body := ts.do(t, "PUT", "/api/users", bearer(login.Token), credsRequest{Email: "Heisenberg@Example.com", Password: "blue"})
+	expectStatus(t, res, body, http.StatusOK)
+	res, body = ts.do(t, "POST", "/api/login", "", credsRequest{Email: "heisenberg@example.com", Password: "blue"})
+	expectError(t, res, body, http.StatusUnauthorized, errCodeUnauthorized)
+
+	token := ts.verificationToken(t, "Heisenberg@example.com")
+	res, body = ts.do(t, "POST", "/api/verify-email", "", tokenRequest{Token: token})
+	expectStatus(t, res, body, http.StatusOK)
+	verified := decode[User](t, body)
+	if verified.Email != "Heisenberg@example.com" || verified.PendingEmail != "" || !verified.EmailVerified {
+		t.Errorf("expected the change to be applied, got %+v", verified)
+	}
+
+	ts.login(t, "heisenberg@example.com", "blue")
+}
+
+func TestEmailChangeToTakenAddress(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser(t, "walt@example.com", "heisenberg")
+	ts.createUser(t, "jesse@example.com", "yeahscience")
+	walt := ts.login(t, "walt@example.com", "heisenberg")
+	jesse := ts.login(t, "jesse@example.com", "yeahscience")
+
+	res, body := ts.do(t, "PUT", "/api/users", bearer(walt.Token), credsRequest{Email: "JESSE@example.com", Password: "blue"})
+	expectError(t, res, body, http.StatusConflict, errCodeConflict)
+
+	// Both can ask for the same new address; whoever verifies first gets it.
+	res, body = ts.do(t, "PUT", "/api/users", bearer(walt.Token), credsRequest{Email: "heisenberg@example.com", Password: "blue"})
+	expectStatus(t, res, body, http.StatusOK)
+	waltToken := ts.verificationToken(t, "heisenberg@example.com")
+	res, body = ts.do(t, "PUT", "/api/users", bearer(jesse.Token), credsRequest{Email: "heisenberg@example.com", Password: "blue"})
+	expectStatus(t, res, body, http.StatusOK)
+	jesseToken := ts.verificationToken(t, "heisenberg@example.com")
+
+	res, body = ts.do(t, "POST", "/api/verify-email", "", tokenRequest{Token: jesseToken})
+	expectStatus(t, res, body, http.StatusOK)
+	res, body = ts.do(t, "POST", "/api/verify-email", "", tokenRequest{Token: waltToken})
+	expectError(t, res, body, http.StatusConflict, errCodeConflict)
+}
+
+func TestCreateUserNormalizesEmail(t *testing.T) {
+	ts := newTestServer(t)
+
+	user := ts.createUser(t, " Walt@EXAMPLE.com", "heisenberg")
+	if user.Email != "Walt@example.com" {
+		t.Errorf("expected a normalized email, got %q", user.Email)
+	}
+	ts.login(t, "walt@example.com", "heisenberg")
+
+	res, body := ts.do(t, "POST", "/api/users", "", credsRequest{Email: "WALT@example.com", Password: "again"})
+	expectError(t, res, body, http.StatusConflict, errCodeConflict)
+
+	res, body = ts.do(t, "POST", "/api/users", "", credsRequest{Email: "not an email", Password: "again"})
+	apiErr := expectError(t, res, body, http.StatusBadRequest, errCodeValidation)
+	if len(apiErr.Details) != 1 || apiErr.Details[0].Field != "email" {
+		t.Errorf("expected email field detail, got %v", apiErr.Details)
+	}
+}
+
+func TestRequireVerifiedEmail(t *testing.T) {
+	ts := newTestServer(t)
+	ts.cfg.requireVerifiedEmail = true
+	ts.createUser(t, "walt@example.com", "heisenberg")
+	login := ts.login(t, "walt@example.com", "heisenberg")
+
+	res, body := ts.do(t, "POST", "/api/chirps", bearer(login.Token), createChirpRequest{Body: "Say my name"})
+	expectError(t, res, body, http.StatusForbidden, errCodeForbidden)
+
+	res, body = ts.do(t, "POST", "/api/verify-email/resend", bearer(login.Token), nil)
+	expectStatus(t, res, body, http.StatusAccepted)
+	token := ts.verificationToken(t, "walt@example.com")
+	res, body = ts.do(t, "POST", "/api/verify-email", "", tokenRequest{Token: token})
+	expectStatus(t, res, body, http.StatusOK)
+
+	res, body = ts.do(t, "POST", "/api/chirps", bearer(login.Token), createChirpRequest{Body: "Say my name"})
+	expectStatus(t, res, body, http.StatusCreated)
+
+	res, body = ts.do(t, "POST", "/api/verify-email/resend", bearer(login.Token), nil)
+	expectError(t, res, body, http.StatusConflict, errCodeConflict)
+}
+
+func TestResendVerificationRateLimit(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser(t, "walt@example.com", "heisenberg")
+	login := ts.login(t, "walt@example.com", "heisenberg")
+	first := ts.verificationToken(t, "walt@example.com")
+
+	for range verificationEmailLimit {
+		res, body := ts.do(t, "POST", "/api/verify-email/resend", bearer(login.Token), nil)
+		expectStatus(t, res, body, http.StatusAccepted)
+	}
+	res, body := ts.do(t, "POST", "/api/verify-email/resend", bearer(login.Token), nil)
+	expectError(t, res, body, http.StatusTooManyRequests, errCodeRateLimited)
+
+	// Only the latest link works.
+	res, body = ts.do(t, "POST", "/api/verify-email", "", tokenRequest{Token: first})
+	expectError(t, res, body, http.StatusBadRequest, errCodeValidation)
+}
@@ -0,0 +1,26 @@
+package auth
+
+import "strings"
+
+// APIKeyPrefix starts every personal API key, so they are recognisable in
+// config files and to secret scanners.
+const APIKeyPrefix = "chirpy_"
+
+// MakeAPIKey returns a new personal API key.
+func MakeAPIKey() (OpaqueToken, error) {
+	return MakeOpaqueToken()
+}
+
+// FormatAPIKey renders key as it is shown to its owner.
+func FormatAPIKey(key OpaqueToken) string {
+	return APIKeyPrefix + key.String()
+}
+
+// ParseAPIKey splits a presented personal API key into its parts.
+func ParseAPIKey(raw string) (OpaqueToken, error) {
+	rest, found := strings.CutPrefix(raw, APIKeyPrefix)
+	if !found {
+		return OpaqueToken{}, ErrInvalidToken
+	}
+	return ParseOpaqueToken(rest)
+}
@@ -0,0 +1,28 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseAPIKey(t *testing.T) {
+	key, err := MakeAPIKey()
+	if err != nil {
+		t.Fatalf("MakeAPIKey failed: %v", err)
+	}
+	formatted := FormatAPIKey(key)
+	if !strings.HasPrefix(formatted, APIKeyPrefix) {
+		t.Errorf("expected %q to start with %s", formatted, APIKeyPrefix)
+	}
+	parsed, err := ParseAPIKey(formatted)
+	if err != nil || parsed != key {
+		t.Errorf("expected %v, got %v (%v)", key, parsed, err)
+	}
+
+	for _, raw := range []string{"", key.String(), APIKeyPrefix, APIKeyPrefix + "id.", APIKeyPrefix + strings.Repeat("ab", 32)} {
+		if _, err := ParseAPIKey(raw); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("ParseAPIKey(%q): expected ErrInvalidToken, got %v", raw, err)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+)
+
+// sealedPrefix versions the format of values from Seal, so the scheme can
+// change later without guessing how old rows were written.
+const sealedPrefix = "v1:"
+
+// Cipher encrypts small secrets, such as TOTP keys, for storage with
+// AES-256-GCM under a key derived from a configured secret.
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+// NewCipher derives the encryption key from secret with HKDF-SHA256. purpose
+// separates keys derived from the same secret for different uses.
+func NewCipher(secret, purpose string) (*Cipher, error) {
+	key, err := hkdf.Key(sha256.New, []byte(secret), nil, "chirpy "+purpose, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Cipher{aead: aead}, nil
+}
+
+// Seal encrypts plaintext. additionalData is authenticated but not stored;
+// passing the owning row's ID stops a value from being copied to another row.
+func (c *Cipher) Seal(plaintext, additionalData []byte) (string, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := c.aead.Seal(nonce, nonce, plaintext, additionalData)
+	return sealedPrefix + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Open reverses Seal, failing with ErrDecrypt if the value was tampered
+// with, sealed under another key or with different additionalData.
+func (c *Cipher) Open(sealed string, additionalData []byte) ([]byte, error) {
+	encoded, found := strings.CutPrefix(sealed, sealedPrefix)
+	if !found {
+		return nil, ErrDecrypt
+	}
+	raw, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil || len(raw) < c.aead.NonceSize() {
+		return nil, ErrDecrypt
+	}
+	nonce, ciphertext := raw[:c.aead.NonceSize()], raw[c.aead.NonceSize():]
+	plaintext, err := c.aead.Open(nil, nonce, ciphertext, additionalData)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
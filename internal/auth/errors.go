@@ -0,0 +1,14 @@
+package auth
+
+import "errors"
+
+var (
+	ErrMissingToken           = errors.New("no credentials provided")
+	ErrMalformedAuthorization = errors.New("authorization header is not in the correct format")
+	ErrInvalidToken           = errors.New("invalid token")
+	ErrExpiredToken           = errors.New("token has expired")
+	ErrRevokedToken           = errors.New("token has been revoked")
+	ErrWrongSigningMethod     = errors.New("unexpected signing method")
+	ErrMalformedHash          = errors.New("password hash is malformed")
+	ErrDecrypt                = errors.New("unable to decrypt value")
+)
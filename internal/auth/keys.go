@@ -0,0 +1,274 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// MinRSAKeyBits is the smallest RSA modulus accepted for RS256.
+const MinRSAKeyBits = 2048
+
+// Key is a JWT signing or verification key. Asymmetric keys are identified
+// by their RFC 7638 thumbprint, which is what goes in the token's kid header.
+type Key struct {
+	ID     string
+	Method jwt.SigningMethod
+	// private is nil for keys that can only verify.
+	private any
+	public  any
+}
+
+// NewHMACKey wraps a shared secret for HS256. It has no kid, so tokens
+// issued before asymmetric keys were configured still verify against it.
+func NewHMACKey(secret string) *Key {
+	return &Key{Method: jwt.SigningMethodHS256, private: []byte(secret), public: []byte(secret)}
+}
+
+// ParseKeyPEM reads an Ed25519, RSA or P-256 ECDSA key. A private key can be
+// used to sign; a public key only to verify. The algorithm follows from the
+// key type: EdDSA, RS256 or ES256 respectively.
+func ParseKeyPEM(data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	var parsed any
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		parsed, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key := &Key{}
+	if signer, ok := parsed.(crypto.Signer); ok {
+		key.private = parsed
+		key.public = signer.Public()
+	} else {
+		key.public = parsed
+	}
+
+	if key.Method, err = signingMethod(key.public); err != nil {
+		return nil, err
+	}
+
+	thumbprint, err := json.Marshal(key.jwk().thumbprintMembers())
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(thumbprint)
+	key.ID = base64.RawURLEncoding.EncodeToString(sum[:])
+	return key, nil
+}
+
+// signingMethod picks the algorithm for a public key, rejecting keys too
+// weak to trust.
+func signingMethod(public any) (jwt.SigningMethod, error) {
+	switch pub := public.(type) {
+	case ed25519.PublicKey:
+		return jwt.SigningMethodEdDSA, nil
+	case *rsa.PublicKey:
+		if pub.N.BitLen() < MinRSAKeyBits {
+			return nil, fmt.Errorf("RSA key is %d bits, need at least %d", pub.N.BitLen(), MinRSAKeyBits)
+		}
+		return jwt.SigningMethodRS256, nil
+	case *ecdsa.PublicKey:
+		if pub.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("ECDSA key must use P-256, got %s", pub.Curve.Params().Name)
+		}
+		return jwt.SigningMethodES256, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", public)
+	}
+}
+
+func LoadKeyFile(path string) (*Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ParseKeyPEM(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return key, nil
+}
+
+// KeySet signs access tokens with one key and accepts any of its
+// verification keys, so a new key can be rolled out while tokens signed by
+// the previous one are still in circulation.
+type KeySet struct {
+	signing *Key
+	verify  map[string]*Key
+}
+
+// NewKeySet signs with signing and verifies with it plus any extra keys.
+func NewKeySet(signing *Key, verification ...*Key) (*KeySet, error) {
+	if signing == nil || signing.private == nil {
+		return nil, errors.New("signing key must be a private key")
+	}
+	ks := &KeySet{signing: signing, verify: map[string]*Key{}}
+	for _, key := range append([]*Key{signing}, verification...) {
+		ks.verify[key.ID] = key
+	}
+	return ks, nil
+}
+
+// NewHMACKeySet signs and verifies with a single HS256 secret.
+func NewHMACKeySet(secret string) *KeySet {
+	ks, _ := NewKeySet(NewHMACKey(secret))
+	return ks
+}
+
+// lookup returns the key a token claims to be signed with. Tokens without a
+// kid can only match the HS256 key.
+func (ks *KeySet) lookup(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := ks.verify[kid]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidToken, kid)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("%w: %v", ErrWrongSigningMethod, token.Header["alg"])
+	}
+	return key.public, nil
+}
+
+// JWK is a public key in RFC 7517 form.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS lists the public verification keys. HS256 secrets are never published.
+func (ks *KeySet) JWKS() JWKS {
+	set := JWKS{Keys: []JWK{}}
+	for _, key := range ks.verify {
+		if key.ID == "" {
+			continue
+		}
+		jwk := key.jwk()
+		jwk.Kid = key.ID
+		jwk.Use = "sig"
+		jwk.Alg = key.Method.Alg()
+		set.Keys = append(set.Keys, jwk)
+	}
+	slices.SortFunc(set.Keys, func(a, b JWK) int { return strings.Compare(a.Kid, b.Kid) })
+	return set
+}
+
+func (k *Key) jwk() JWK {
+	b64 := base64.RawURLEncoding.EncodeToString
+	switch pub := k.public.(type) {
+	case ed25519.PublicKey:
+		return JWK{Kty: "OKP", Crv: "Ed25519", X: b64(pub)}
+	case *rsa.PublicKey:
+		return JWK{Kty: "RSA", N: b64(pub.N.Bytes()), E: b64(big.NewInt(int64(pub.E)).Bytes())}
+	case *ecdsa.PublicKey:
+		raw, _ := pub.Bytes()
+		// Uncompressed point: 0x04 || X || Y, each 32 bytes for P-256.
+		return JWK{Kty: "EC", Crv: "P-256", X: b64(raw[1:33]), Y: b64(raw[33:])}
+	}
+	return JWK{}
+}
+
+// thumbprintMembers is the JWK reduced to the required members; marshalling
+// a map sorts the keys, which gives the canonical form RFC 7638 hashes.
+func (j JWK) thumbprintMembers() map[string]string {
+	switch j.Kty {
+	case "OKP":
+		return map[string]string{"crv": j.Crv, "kty": j.Kty, "x": j.X}
+	case "RSA":
+		return map[string]string{"e": j.E, "kty": j.Kty, "n": j.N}
+	default:
+		return map[string]string{"crv": j.Crv, "kty": j.Kty, "x": j.X, "y": j.Y}
+	}
+}
+
+// ParseJWK reads a public key published by someone else, such as an OpenID
+// provider. The key keeps the publisher's kid, and can only verify.
+func ParseJWK(j JWK) (*Key, error) {
+	b64 := base64.RawURLEncoding.DecodeString
+	var public any
+	switch {
+	case j.Kty == "OKP" && j.Crv == "Ed25519":
+		x, err := b64(j.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("malformed Ed25519 key")
+		}
+		public = ed25519.PublicKey(x)
+	case j.Kty == "RSA":
+		n, nErr := b64(j.N)
+		e, eErr := b64(j.E)
+		if nErr != nil || eErr != nil || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("malformed RSA key")
+		}
+		public = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	case j.Kty == "EC" && j.Crv == "P-256":
+		x, xErr := b64(j.X)
+		y, yErr := b64(j.Y)
+		if xErr != nil || yErr != nil || len(x) != 32 || len(y) != 32 {
+			return nil, errors.New("malformed P-256 key")
+		}
+		pub, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), append(append([]byte{4}, x...), y...))
+		if err != nil {
+			return nil, fmt.Errorf("malformed P-256 key: %w", err)
+		}
+		public = pub
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", j.Kty)
+	}
+	method, err := signingMethod(public)
+	if err != nil {
+		return nil, err
+	}
+	if j.Alg != "" && j.Alg != method.Alg() {
+		return nil, fmt.Errorf("key is for %s, not %s", j.Alg, method.Alg())
+	}
+	return &Key{ID: j.Kid, Method: method, public: public}, nil
+}
+
+// Public returns the key tokens signed with k are verified against.
+func (k *Key) Public() any {
+	return k.public
+}
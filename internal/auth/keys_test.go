@@ -0,0 +1,245 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+func generateKey(t *testing.T, alg string) crypto.Signer {
+	t.Helper()
+	var key crypto.Signer
+	var err error
+	switch alg {
+	case "EdDSA":
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	case "RS256":
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	case "ES256":
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}
+	if err != nil {
+		t.Fatalf("generating %s key: %v", alg, err)
+	}
+	return key
+}
+
+func privatePEM(t *testing.T, key crypto.Signer) []byte {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal private key: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func publicPEM(t *testing.T, key crypto.PublicKey) []byte {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatalf("marshal public key: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+func TestAsymmetricJWT(t *testing.T) {
+	for _, alg := range []string{"EdDSA", "RS256", "ES256"} {
+		t.Run(alg, func(t *testing.T) {
+			signer := generateKey(t, alg)
+			private, err := ParseKeyPEM(privatePEM(t, signer))
+			if err != nil {
+				t.Fatalf("ParseKeyPEM failed: %v", err)
+			}
+			if private.Method.Alg() != alg {
+				t.Errorf("expected %s, got %s", alg, private.Method.Alg())
+			}
+			public, err := ParseKeyPEM(publicPEM(t, signer.Public()))
+			if err != nil {
+				t.Fatalf("ParseKeyPEM failed: %v", err)
+			}
+			if public.ID != private.ID {
+				t.Errorf("expected the same kid for both halves, got %s and %s", private.ID, public.ID)
+			}
+			if _, err := NewKeySet(public); err == nil {
+				t.Error("expected a public key to be refused for signing")
+			}
+
+			keys, err := NewKeySet(private)
+			if err != nil {
+				t.Fatalf("NewKeySet failed: %v", err)
+			}
+			userID := uuid.New()
+			token, err := MakeJWT(userID, uuid.New(), 0, "user", keys, time.Hour)
+			if err != nil {
+				t.Fatalf("MakeJWT failed: %v", err)
+			}
+			parsed, _, err := jwt.NewParser().ParseUnverified(token, &ChirpyClaims{})
+			if err != nil {
+				t.Fatalf("ParseUnverified failed: %v", err)
+			}
+			if parsed.Header["kid"] != private.ID || parsed.Header["alg"] != alg {
+				t.Errorf("unexpected header %v", parsed.Header)
+			}
+
+			got, err := ValidateJWT(token, keys)
+			if err != nil {
+				t.Fatalf("ValidateJWT failed: %v", err)
+			}
+			if got.UserID != userID {
+				t.Errorf("expected %s, got %s", userID, got.UserID)
+			}
+		})
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	oldKey, _ := ParseKeyPEM(privatePEM(t, generateKey(t, "EdDSA")))
+	newKey, _ := ParseKeyPEM(privatePEM(t, generateKey(t, "ES256")))
+	oldPublic, _ := ParseKeyPEM(publicPEM(t, oldKey.public))
+
+	before, _ := NewKeySet(oldKey)
+	token, err := MakeJWT(uuid.New(), uuid.New(), 0, "user", before, time.Hour)
+	if err != nil {
+		t.Fatalf("MakeJWT failed: %v", err)
+	}
+
+	during, _ := NewKeySet(newKey, oldPublic)
+	if _, err := ValidateJWT(token, during); err != nil {
+		t.Errorf("expected a token from the previous key to verify, got %v", err)
+	}
+	if len(during.JWKS().Keys) != 2 {
+		t.Errorf("expected both keys to be published, got %+v", during.JWKS())
+	}
+
+	after, _ := NewKeySet(newKey)
+	if _, err := ValidateJWT(token, after); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected the retired key to be rejected, got %v", err)
+	}
+}
+
+func TestValidateJWTRejectsAlgorithmConfusion(t *testing.T) {
+	signer := generateKey(t, "RS256")
+	key, _ := ParseKeyPEM(privatePEM(t, signer))
+	keys, _ := NewKeySet(key)
+
+	// An HS256 token keyed with the published public key must not verify.
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, ChirpyClaims{
+		RegisteredClaims: jwt.RegisteredClaims{Subject: uuid.NewString(), ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))},
+	})
+	forged.Header["kid"] = key.ID
+	signed, _ := forged.SignedString(publicPEM(t, signer.Public()))
+	if _, err := ValidateJWT(signed, keys); !errors.Is(err, ErrWrongSigningMethod) {
+		t.Errorf("expected %v, got %v", ErrWrongSigningMethod, err)
+	}
+}
+
+func TestHMACKeyVerifiesAlongsideSigningKey(t *testing.T) {
+	legacy, _ := MakeJWT(uuid.New(), uuid.New(), 0, "user", NewHMACKeySet("secret"), time.Hour)
+	signing, _ := ParseKeyPEM(privatePEM(t, generateKey(t, "EdDSA")))
+
+	keys, _ := NewKeySet(signing, NewHMACKey("secret"))
+	if _, err := ValidateJWT(legacy, keys); err != nil {
+		t.Errorf("expected an HS256 token to verify during the switch, got %v", err)
+	}
+	for _, jwk := range keys.JWKS().Keys {
+		if jwk.Kty == "oct" || jwk.Kid == "" {
+			t.Errorf("expected the HMAC secret not to be published, got %+v", jwk)
+		}
+	}
+
+	withoutSecret, _ := NewKeySet(signing)
+	if _, err := ValidateJWT(legacy, withoutSecret); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected %v, got %v", ErrInvalidToken, err)
+	}
+}
+
+func TestKeyIDIsJWKThumbprint(t *testing.T) {
+	// The example key from RFC 7638 section 3.1.
+	n, _ := base64.RawURLEncoding.DecodeString("0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw")
+	pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: 65537}
+
+	key, err := ParseKeyPEM(publicPEM(t, pub))
+	if err != nil {
+		t.Fatalf("ParseKeyPEM failed: %v", err)
+	}
+	if want := "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"; key.ID != want {
+		t.Errorf("expected kid %s, got %s", want, key.ID)
+	}
+}
+
+func TestParseKeyPEMErrors(t *testing.T) {
+	small, _ := rsa.GenerateKey(rand.Reader, 1024)
+	p384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+
+	tests := map[string][]byte{
+		"not PEM":       []byte("hello"),
+		"certificate":   pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte{1}}),
+		"small RSA key": privatePEM(t, small),
+		"P-384 key":     privatePEM(t, p384),
+		"corrupt PKCS8": pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte{1, 2, 3}}),
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ParseKeyPEM(data); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestParseJWK(t *testing.T) {
+	for _, alg := range []string{"EdDSA", "RS256", "ES256"} {
+		t.Run(alg, func(t *testing.T) {
+			signer := generateKey(t, alg)
+			key, _ := ParseKeyPEM(privatePEM(t, signer))
+			keys, _ := NewKeySet(key)
+			published := keys.JWKS().Keys[0]
+
+			parsed, err := ParseJWK(published)
+			if err != nil {
+				t.Fatalf("ParseJWK failed: %v", err)
+			}
+			if parsed.ID != key.ID || parsed.Method.Alg() != alg {
+				t.Errorf("expected kid %s and %s, got %s and %s", key.ID, alg, parsed.ID, parsed.Method.Alg())
+			}
+			token, _ := MakeJWT(uuid.New(), uuid.New(), 0, "user", keys, time.Hour)
+			_, err = jwt.Parse(token, func(*jwt.Token) (any, error) { return parsed.Public(), nil })
+			if err != nil {
+				t.Errorf("expected the token to verify with the parsed key, got %v", err)
+			}
+		})
+	}
+
+	small, _ := rsa.GenerateKey(rand.Reader, 1024)
+	smallKey := &Key{public: &small.PublicKey}
+	ed, _ := ParseKeyPEM(privatePEM(t, generateKey(t, "EdDSA")))
+	wrongAlg := ed.jwk()
+	wrongAlg.Alg = "RS256"
+
+	tests := map[string]JWK{
+		"small RSA key":   smallKey.jwk(),
+		"wrong algorithm": wrongAlg,
+		"symmetric key":   {Kty: "oct"},
+		"bad encoding":    {Kty: "OKP", Crv: "Ed25519", X: "not base64!"},
+	}
+	for name, jwk := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ParseJWK(jwk); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// OpaqueToken is a random credential of the form "<id>.<secret>", such as a
+// refresh token or a password reset link. Only the ID is stored in clear;
+// the secret is kept as a keyed hash.
+type OpaqueToken struct {
+	ID     string
+	Secret string
+}
+
+func (t OpaqueToken) String() string {
+	return t.ID + "." + t.Secret
+}
+
+func MakeOpaqueToken() (OpaqueToken, error) {
+	id := make([]byte, 16)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return OpaqueToken{}, err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return OpaqueToken{}, err
+	}
+
+	return OpaqueToken{
+		ID:     hex.EncodeToString(id),
+		Secret: hex.EncodeToString(secret),
+	}, nil
+}
+
+// ParseOpaqueToken splits a presented token into its parts.
+func ParseOpaqueToken(raw string) (OpaqueToken, error) {
+	id, secret, found := strings.Cut(raw, ".")
+	if !found || id == "" || secret == "" {
+		return OpaqueToken{}, ErrInvalidToken
+	}
+	return OpaqueToken{ID: id, Secret: secret}, nil
+}
+
+// ParseRefreshToken is ParseOpaqueToken that also accepts refresh tokens
+// issued before hashing, which were 64 hex characters with no separator;
+// their first half serves as the ID.
+func ParseRefreshToken(raw string) (OpaqueToken, error) {
+	if strings.Contains(raw, ".") {
+		return ParseOpaqueToken(raw)
+	}
+	if _, err := hex.DecodeString(raw); err != nil || len(raw) != 64 {
+		return OpaqueToken{}, ErrInvalidToken
+	}
+	return OpaqueToken{ID: raw[:32], Secret: raw[32:]}, nil
+}
+
+// TokenPurpose says what an opaque secret is for. Each purpose hashes with
+// its own key, so a hash stored for one can't verify a secret presented as
+// another.
+type TokenPurpose string
+
+const (
+	PurposeRefreshToken      TokenPurpose = "refresh token"
+	PurposePasswordReset     TokenPurpose = "password reset"
+	PurposeEmailVerification TokenPurpose = "email verification"
+	PurposeMFAChallenge      TokenPurpose = "mfa challenge"
+	PurposeRecoveryCode      TokenPurpose = "recovery code"
+	PurposeAPIKey            TokenPurpose = "api key"
+	PurposeOAuthClientSecret TokenPurpose = "oauth client secret"
+	PurposeOAuthCode         TokenPurpose = "oauth code"
+	PurposeOIDCState         TokenPurpose = "oidc state"
+)
+
+// HashOpaqueToken returns the HMAC-SHA256 of secret under a key derived
+// from pepper for purpose with HKDF-SHA256.
+func HashOpaqueToken(secret, pepper string, purpose TokenPurpose) string {
+	key, err := hkdf.Key(sha256.New, []byte(pepper), nil, "chirpy token "+string(purpose), sha256.Size)
+	if err != nil {
+		// Only possible for a key length HKDF can't produce.
+		panic(err)
+	}
+	return hmacHex(key, secret)
+}
+
+// CheckOpaqueTokenHash compares in constant time.
+func CheckOpaqueTokenHash(secret, pepper string, purpose TokenPurpose, hash string) bool {
+	return hmac.Equal([]byte(HashOpaqueToken(secret, pepper, purpose)), []byte(hash))
+}
+
+// LegacyOpaqueTokenHash is how every secret was hashed before each purpose
+// had its own key: under pepper itself. Long-lived credentials may still be
+// stored this way.
+func LegacyOpaqueTokenHash(secret, pepper string) string {
+	return hmacHex([]byte(pepper), secret)
+}
+
+// CheckLegacyOpaqueTokenHash compares in constant time.
+func CheckLegacyOpaqueTokenHash(secret, pepper, hash string) bool {
+	return hmac.Equal([]byte(LegacyOpaqueTokenHash(secret, pepper)), []byte(hash))
+}
+
+func hmacHex(key []byte, data string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return hex.EncodeToString(mac.Sum(nil))
+}
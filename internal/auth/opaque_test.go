@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestOpaqueTokenRoundTrip(t *testing.T) {
+	token, err := MakeOpaqueToken()
+	if err != nil {
+		t.Fatalf("MakeOpaqueToken failed: %v", err)
+	}
+
+	parsed, err := ParseOpaqueToken(token.String())
+	if err != nil || parsed != token {
+		t.Fatalf("expected %v, got %v (%v)", token, parsed, err)
+	}
+
+	hash := HashOpaqueToken(token.Secret, "pepper", PurposePasswordReset)
+	if !CheckOpaqueTokenHash(parsed.Secret, "pepper", PurposePasswordReset, hash) {
+		t.Error("expected hash to verify")
+	}
+	if CheckOpaqueTokenHash(parsed.Secret, "other-pepper", PurposePasswordReset, hash) {
+		t.Error("expected hash with a different pepper to fail")
+	}
+	if CheckOpaqueTokenHash("wrong", "pepper", PurposePasswordReset, hash) {
+		t.Error("expected hash of a different secret to fail")
+	}
+	if CheckOpaqueTokenHash(parsed.Secret, "pepper", PurposeEmailVerification, hash) {
+		t.Error("expected hash for a different purpose to fail")
+	}
+	if CheckLegacyOpaqueTokenHash(parsed.Secret, "pepper", hash) {
+		t.Error("expected a purpose hash not to pass as a legacy one")
+	}
+	if !CheckLegacyOpaqueTokenHash(parsed.Secret, "pepper", LegacyOpaqueTokenHash(parsed.Secret, "pepper")) {
+		t.Error("expected legacy hash to verify")
+	}
+}
+
+func TestParseRefreshToken(t *testing.T) {
+	legacy := strings.Repeat("ab", 32)
+	parsed, err := ParseRefreshToken(legacy)
+	if err != nil || parsed.ID != legacy[:32] || parsed.Secret != legacy[32:] {
+		t.Errorf("expected legacy token to split in half, got %v (%v)", parsed, err)
+	}
+
+	for _, raw := range []string{"", ".", "id.", ".secret", "not-hex", strings.Repeat("zz", 32)} {
+		if _, err := ParseRefreshToken(raw); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("ParseRefreshToken(%q): expected ErrInvalidToken, got %v", raw, err)
+		}
+	}
+	if _, err := ParseOpaqueToken(legacy); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected only refresh tokens to accept the legacy form, got %v", err)
+	}
+}
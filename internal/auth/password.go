@@ -1,31 +1,85 @@
 package auth
 
 import (
-	"log"
+	"fmt"
 
 	"github.com/alexedwards/argon2id"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
 type ChirpyClaims struct {
 	jwt.RegisteredClaims
+	// TokenVersion must match the user's current token version; bumping it
+	// invalidates every access token issued before.
+	TokenVersion int32 `json:"ver"`
+	// Role is the user's role when the token was issued. It is only as
+	// current as the token, so servers should check it is still the user's.
+	Role string `json:"role,omitempty"`
+	// ClientID and Scope are set on tokens issued to third-party OAuth
+	// clients, which may only do what the space-separated scopes allow.
+	ClientID string `json:"client_id,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+	// SessionID is the refresh token family the token was issued with.
+	// Revoking that session revokes the token too.
+	SessionID string `json:"sid,omitempty"`
+	// UserID is the parsed subject, filled in by ValidateJWT.
+	UserID uuid.UUID `json:"-"`
 }
 
-func HashPassword(password string) (string, error) {
-	hash, err := argon2id.CreateHash(password, argon2id.DefaultParams)
+// PasswordParams are the argon2id costs for new password hashes.
+type PasswordParams struct {
+	// Memory is in KiB.
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+}
+
+// DefaultPasswordParams matches argon2id.DefaultParams on a two-core machine.
+var DefaultPasswordParams = PasswordParams{Memory: 64 * 1024, Iterations: 1, Parallelism: 2}
+
+func (p PasswordParams) argon2id() *argon2id.Params {
+	return &argon2id.Params{
+		Memory:      p.Memory,
+		Iterations:  p.Iterations,
+		Parallelism: p.Parallelism,
+		SaltLength:  argon2id.DefaultParams.SaltLength,
+		KeyLength:   argon2id.DefaultParams.KeyLength,
+	}
+}
+
+func HashPassword(password string, params PasswordParams) (string, error) {
+	hash, err := argon2id.CreateHash(password, params.argon2id())
 	if err != nil {
-		log.Fatal(err)
 		return "", err
 	}
 
 	return hash, nil
 }
 
+// CheckPasswordHash reports whether password matches hash, using the
+// parameters stored in the hash. A hash that cannot be parsed yields
+// ErrMalformedHash rather than a mismatch.
 func CheckPasswordHash(password, hash string) (bool, error) {
 	match, err := argon2id.ComparePasswordAndHash(password, hash)
 	if err != nil {
-		log.Fatal(err)
-		return match, err
+		return false, fmt.Errorf("%w: %v", ErrMalformedHash, err)
 	}
 	return match, nil
 }
+
+// NeedsRehash reports whether hash was made with any cost lower than params,
+// so it should be replaced once the password is known again. Stronger hashes
+// are left alone.
+func NeedsRehash(hash string, params PasswordParams) bool {
+	stored, _, _, err := argon2id.DecodeHash(hash)
+	if err != nil {
+		return true
+	}
+	want := params.argon2id()
+	return stored.Memory < want.Memory ||
+		stored.Iterations < want.Iterations ||
+		stored.Parallelism < want.Parallelism ||
+		stored.SaltLength < want.SaltLength ||
+		stored.KeyLength < want.KeyLength
+}
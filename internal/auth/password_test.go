@@ -1,32 +1,50 @@
 package auth
 
 import (
+	"errors"
 	"testing"
-	"time"
-
-	"github.com/google/uuid"
 )
 
-func TestMakeJWTAndValidateJWT(t *testing.T) {
-	// Setup
-	tokenSecret := "test-secret-key"
-	duration := time.Hour
-	expectedUUID := uuid.New()
-
-	// Create a JWT token
-	token, err := MakeJWT(expectedUUID, tokenSecret, duration)
+func TestCheckPasswordHash(t *testing.T) {
+	hash, err := HashPassword("heisenberg", DefaultPasswordParams)
 	if err != nil {
-		t.Fatalf("MakeJWT failed: %v", err)
+		t.Fatalf("HashPassword failed: %v", err)
+	}
+
+	if match, err := CheckPasswordHash("heisenberg", hash); err != nil || !match {
+		t.Errorf("expected password to match, got %v (%v)", match, err)
+	}
+	if match, err := CheckPasswordHash("pinkman", hash); err != nil || match {
+		t.Errorf("expected password not to match, got %v (%v)", match, err)
 	}
+	if _, err := CheckPasswordHash("heisenberg", "not-a-hash"); !errors.Is(err, ErrMalformedHash) {
+		t.Errorf("expected ErrMalformedHash, got %v", err)
+	}
+}
 
-	// Validate the token and extract the UUID
-	extractedUUID, err := ValidateJWT(token, tokenSecret)
+func TestNeedsRehash(t *testing.T) {
+	weak := PasswordParams{Memory: 8 * 1024, Iterations: 1, Parallelism: 1}
+	hash, err := HashPassword("heisenberg", weak)
 	if err != nil {
-		t.Fatalf("ValidateJWT failed: %v", err)
+		t.Fatalf("HashPassword failed: %v", err)
 	}
 
-	// Check that the extracted UUID matches the original
-	if extractedUUID != expectedUUID {
-		t.Errorf("UUID mismatch: expected %s, got %s", expectedUUID, extractedUUID)
+	if NeedsRehash(hash, weak) {
+		t.Error("expected a hash made with the current params to be kept")
+	}
+	if NeedsRehash(hash, PasswordParams{Memory: 4 * 1024, Iterations: 1, Parallelism: 1}) {
+		t.Error("expected a stronger hash to be kept")
+	}
+	for _, stronger := range []PasswordParams{
+		{Memory: 16 * 1024, Iterations: 1, Parallelism: 1},
+		{Memory: 8 * 1024, Iterations: 2, Parallelism: 1},
+		{Memory: 8 * 1024, Iterations: 1, Parallelism: 2},
+	} {
+		if !NeedsRehash(hash, stronger) {
+			t.Errorf("expected a rehash for %+v", stronger)
+		}
+	}
+	if !NeedsRehash("not-a-hash", weak) {
+		t.Error("expected a malformed hash to need replacing")
 	}
 }
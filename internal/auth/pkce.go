@@ -0,0 +1,26 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"regexp"
+)
+
+// validCodeVerifier is the PKCE code_verifier syntax from RFC 7636.
+var validCodeVerifier = regexp.MustCompile(`^[A-Za-z0-9._~-]{43,128}$`)
+
+// PKCEChallenge derives the S256 code_challenge for verifier.
+func PKCEChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// CheckPKCE reports whether verifier is well-formed and hashes to the S256
+// challenge, comparing in constant time.
+func CheckPKCE(verifier, challenge string) bool {
+	if !validCodeVerifier.MatchString(verifier) {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(PKCEChallenge(verifier)), []byte(challenge)) == 1
+}
@@ -0,0 +1,24 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckPKCE(t *testing.T) {
+	// The example from RFC 7636 appendix B.
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+	if got := PKCEChallenge(verifier); got != challenge {
+		t.Errorf("PKCEChallenge = %q, want %q", got, challenge)
+	}
+	if !CheckPKCE(verifier, challenge) {
+		t.Error("expected the verifier to match")
+	}
+	if CheckPKCE(verifier[:42], PKCEChallenge(verifier[:42])) {
+		t.Error("expected a verifier shorter than 43 characters to be refused")
+	}
+	if CheckPKCE(strings.Repeat("a", 43), challenge) {
+		t.Error("expected a different verifier not to match")
+	}
+}
@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// minEmailPartLength is the shortest email local part that passwords are
+// checked for; shorter ones turn up inside ordinary words too often.
+const minEmailPartLength = 4
+
+// PasswordPolicy decides which passwords users may choose.
+type PasswordPolicy struct {
+	MinLength int
+	// breached holds lowercased known-leaked passwords.
+	breached map[string]struct{}
+}
+
+// LoadBreachedPasswords reads a list of leaked passwords, one per line, into
+// the policy. Blank lines and lines starting with # are skipped. Matching
+// ignores case.
+func (p *PasswordPolicy) LoadBreachedPasswords(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	breached := map[string]struct{}{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		breached[strings.ToLower(line)] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading %s: %w", path, err)
+	}
+	p.breached = breached
+	return nil
+}
+
+// Check returns the reasons password is unacceptable for the account with
+// the given email, or nil if it may be used.
+func (p *PasswordPolicy) Check(password, email string) []string {
+	var problems []string
+	if utf8.RuneCountInString(password) < p.MinLength {
+		problems = append(problems, fmt.Sprintf("must be at least %d characters", p.MinLength))
+	}
+	lower := strings.ToLower(password)
+	if _, found := p.breached[lower]; found {
+		problems = append(problems, "has appeared in a data breach, choose another")
+	}
+	if containsEmail(lower, strings.ToLower(email)) {
+		problems = append(problems, "must not contain your email address")
+	}
+	return problems
+}
+
+func containsEmail(password, email string) bool {
+	if email == "" {
+		return false
+	}
+	if strings.Contains(password, email) {
+		return true
+	}
+	local, _, _ := strings.Cut(email, "@")
+	return len(local) >= minEmailPartLength && strings.Contains(password, local)
+}
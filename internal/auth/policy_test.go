@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPasswordPolicy(t *testing.T) {
+	list := filepath.Join(t.TempDir(), "breached.txt")
+	if err := os.WriteFile(list, []byte("# top passwords\npassword123\r\n\nletmein!!\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	policy := &PasswordPolicy{MinLength: 8}
+	if err := policy.LoadBreachedPasswords(list); err != nil {
+		t.Fatalf("LoadBreachedPasswords: %v", err)
+	}
+
+	cases := map[string]int{
+		"correct horse":   0,
+		"ünïcödé":         1,
+		"short":           1,
+		"Password123":     1,
+		"LETMEIN!!":       1,
+		"heisenberg-2008": 1,
+		"# top passwords": 0,
+		"hey-walt-2008":   0,
+	}
+	for password, want := range cases {
+		if got := policy.Check(password, "Heisenberg@example.com"); len(got) != want {
+			t.Errorf("Check(%q) = %v, want %d problems", password, got, want)
+		}
+	}
+	if got := policy.Check("walt@example.com", "walt@example.com"); len(got) != 1 {
+		t.Errorf("expected the full address to be refused, got %v", got)
+	}
+
+	if err := policy.LoadBreachedPasswords(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Error("expected an error for a missing list")
+	}
+}
@@ -1,11 +1,8 @@
 package auth
 
 import (
-	"crypto/rand"
-	"encoding/hex"
 	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"strings"
 	"time"
@@ -14,59 +11,77 @@ import (
 	"github.com/google/uuid"
 )
 
-func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
-	claims := ChirpyClaims{
-		jwt.RegisteredClaims{
+func MakeJWT(userID, sessionID uuid.UUID, tokenVersion int32, role string, keys *KeySet, expiresIn time.Duration) (string, error) {
+	return signJWT(newClaims(userID, sessionID, tokenVersion, role, expiresIn), keys)
+}
+
+// MakeClientJWT issues an access token to a third-party OAuth client that
+// only grants scopes.
+func MakeClientJWT(userID, sessionID uuid.UUID, tokenVersion int32, role, clientID string, scopes []string, keys *KeySet, expiresIn time.Duration) (string, error) {
+	claims := newClaims(userID, sessionID, tokenVersion, role, expiresIn)
+	claims.ClientID = clientID
+	claims.Scope = strings.Join(scopes, " ")
+	return signJWT(claims, keys)
+}
+
+func newClaims(userID, sessionID uuid.UUID, tokenVersion int32, role string, expiresIn time.Duration) ChirpyClaims {
+	return ChirpyClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
 			Issuer:    "chirpy",
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiresIn)),
 			Subject:   userID.String(),
-		}}
-
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	ss, err := token.SignedString([]byte(tokenSecret))
-
-	return ss, err
+			ID:        uuid.NewString(),
+		},
+		TokenVersion: tokenVersion,
+		Role:         role,
+		SessionID:    sessionID.String(),
+	}
 }
 
-func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &ChirpyClaims{}, func(token *jwt.Token) (interface{}, error) {
-
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
+func signJWT(claims ChirpyClaims, keys *KeySet) (string, error) {
+	token := jwt.NewWithClaims(keys.signing.Method, claims)
+	if keys.signing.ID != "" {
+		token.Header["kid"] = keys.signing.ID
+	}
+	return token.SignedString(keys.signing.private)
+}
 
-		return []byte(tokenSecret), nil
-	})
+// ValidateJWT checks the signature and expiry of an access token. Whether
+// its TokenVersion is still current is up to the caller.
+func ValidateJWT(tokenString string, keys *KeySet) (*ChirpyClaims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &ChirpyClaims{}, keys.lookup)
 
-	if err != nil {
-		return uuid.UUID{}, err
+	switch {
+	case errors.Is(err, ErrWrongSigningMethod):
+		return nil, ErrWrongSigningMethod
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return nil, ErrExpiredToken
+	case err != nil:
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
 	}
 
 	if claims, ok := token.Claims.(*ChirpyClaims); ok && token.Valid {
-		userUUID := uuid.MustParse(claims.Subject)
-		return userUUID, nil
+		userUUID, parseErr := uuid.Parse(claims.Subject)
+		if parseErr != nil {
+			return nil, fmt.Errorf("%w: subject is not a valid user ID", ErrInvalidToken)
+		}
+		claims.UserID = userUUID
+		return claims, nil
 	} else {
-		log.Fatal("Invalid token")
-		return uuid.UUID{}, errors.New("Invalid token")
+		return nil, ErrInvalidToken
 	}
 }
 
 func GetBearerToken(headers http.Header) (string, error) {
 	authorization := headers.Get("Authorization")
+	if authorization == "" {
+		return "", ErrMissingToken
+	}
 	tokenString, prefixFound := strings.CutPrefix(authorization, "Bearer ")
 	if !prefixFound {
-		return "", errors.New("Authorization Header is not in the correct format")
+		return "", ErrMalformedAuthorization
 	}
 
 	return tokenString, nil
 }
-
-func MakeRefreshToken() (string, error) {
-	key := make([]byte, 32)
-	_, err := rand.Read(key)
-	encoded := hex.EncodeToString(key)
-
-	return encoded, err
-
-}
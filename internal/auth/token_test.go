@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+func TestMakeJWTAndValidateJWT(t *testing.T) {
+	// Setup
+	keys := NewHMACKeySet("test-secret-key")
+	duration := time.Hour
+	expectedUUID := uuid.New()
+	sessionID := uuid.New()
+
+	// Create a JWT token
+	token, err := MakeJWT(expectedUUID, sessionID, 3, "admin", keys, duration)
+	if err != nil {
+		t.Fatalf("MakeJWT failed: %v", err)
+	}
+
+	// Validate the token and extract the claims
+	claims, err := ValidateJWT(token, keys)
+	if err != nil {
+		t.Fatalf("ValidateJWT failed: %v", err)
+	}
+
+	// Check that the extracted UUID matches the original
+	if claims.UserID != expectedUUID {
+		t.Errorf("UUID mismatch: expected %s, got %s", expectedUUID, claims.UserID)
+	}
+	if claims.TokenVersion != 3 {
+		t.Errorf("expected token version 3, got %d", claims.TokenVersion)
+	}
+	if claims.Role != "admin" {
+		t.Errorf("expected role admin, got %q", claims.Role)
+	}
+	if claims.SessionID != sessionID.String() {
+		t.Errorf("expected session %s, got %q", sessionID, claims.SessionID)
+	}
+}
+
+func TestMakeClientJWT(t *testing.T) {
+	keys := NewHMACKeySet("test-secret-key")
+	token, err := MakeClientJWT(uuid.New(), uuid.New(), 0, "user", "client-1", []string{"chirps:read", "chirps:write"}, keys, time.Hour)
+	if err != nil {
+		t.Fatalf("MakeClientJWT failed: %v", err)
+	}
+	claims, err := ValidateJWT(token, keys)
+	if err != nil {
+		t.Fatalf("ValidateJWT failed: %v", err)
+	}
+	if claims.ClientID != "client-1" || claims.Scope != "chirps:read chirps:write" {
+		t.Errorf("expected client and scope claims, got %q and %q", claims.ClientID, claims.Scope)
+	}
+}
+
+func TestValidateJWTErrors(t *testing.T) {
+	userID := uuid.New()
+
+	keys := NewHMACKeySet("secret")
+	expired, _ := MakeJWT(userID, uuid.New(), 0, "user", keys, -time.Minute)
+	valid, _ := MakeJWT(userID, uuid.New(), 0, "user", keys, time.Hour)
+	unsigned, _ := jwt.NewWithClaims(jwt.SigningMethodNone, ChirpyClaims{
+		RegisteredClaims: jwt.RegisteredClaims{Subject: userID.String()},
+	}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+
+	badSubject, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, ChirpyClaims{
+		RegisteredClaims: jwt.RegisteredClaims{Subject: "not-a-uuid"},
+	}).SignedString([]byte("secret"))
+
+	tests := []struct {
+		name  string
+		token string
+		keys  *KeySet
+		want  error
+	}{
+		{"expired", expired, keys, ErrExpiredToken},
+		{"wrong secret", valid, NewHMACKeySet("other-secret"), ErrInvalidToken},
+		{"garbage", "not.a.token", keys, ErrInvalidToken},
+		{"none algorithm", unsigned, keys, ErrWrongSigningMethod},
+		{"malformed subject", badSubject, keys, ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ValidateJWT(tt.token, tt.keys)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
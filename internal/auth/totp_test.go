@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 key from RFC 6238 appendix B, base32 encoded.
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCodeMatchesRFC6238(t *testing.T) {
+	// The RFC lists eight-digit codes; six-digit codes are their last six.
+	vectors := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for unix, want := range vectors {
+		got, err := TOTPCode(rfc6238Secret, time.Unix(unix, 0))
+		if err != nil || got != want {
+			t.Errorf("at %d: expected %s, got %s (%v)", unix, want, got, err)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	code, _ := TOTPCode(rfc6238Secret, now)
+
+	step, ok := ValidateTOTP(rfc6238Secret, code, now.Add(25*time.Second))
+	if !ok || step != now.Unix()/30 {
+		t.Errorf("expected the code to be accepted in the next step, got %d %v", step, ok)
+	}
+	if _, ok := ValidateTOTP(rfc6238Secret, code, now.Add(2*time.Minute)); ok {
+		t.Error("expected an old code to be rejected")
+	}
+	if _, ok := ValidateTOTP(rfc6238Secret, "12345", now); ok {
+		t.Error("expected a short code to be rejected")
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("GenerateTOTPSecret failed: %v", err)
+	}
+	uri, err := url.Parse(TOTPURI("Chirpy", "walt@example.com", secret))
+	if err != nil {
+		t.Fatalf("parse URI: %v", err)
+	}
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/Chirpy:walt@example.com" {
+		t.Errorf("unexpected URI %s", uri)
+	}
+	if uri.Query().Get("secret") != secret || uri.Query().Get("issuer") != "Chirpy" {
+		t.Errorf("unexpected parameters %v", uri.Query())
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	if err != nil {
+		t.Fatalf("GenerateRecoveryCodes failed: %v", err)
+	}
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if len(code) != 11 || code[5] != '-' || seen[code] {
+			t.Errorf("unexpected code %q", code)
+		}
+		seen[code] = true
+		if typed := strings.ToUpper(strings.ReplaceAll(code, "-", " ")); NormalizeRecoveryCode(typed) != code {
+			t.Errorf("expected %q to normalize to %q", typed, code)
+		}
+	}
+}
+
+func TestCipher(t *testing.T) {
+	c, err := NewCipher("an encryption secret of some length", "totp")
+	if err != nil {
+		t.Fatalf("NewCipher failed: %v", err)
+	}
+	sealed, err := c.Seal([]byte("hello"), []byte("user-1"))
+	if err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+	if strings.Contains(sealed, "hello") {
+		t.Errorf("expected ciphertext, got %s", sealed)
+	}
+	opened, err := c.Open(sealed, []byte("user-1"))
+	if err != nil || string(opened) != "hello" {
+		t.Errorf("expected hello, got %q (%v)", opened, err)
+	}
+
+	if _, err := c.Open(sealed, []byte("user-2")); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("expected a value bound to another user to fail, got %v", err)
+	}
+	other, _ := NewCipher("an encryption secret of some length", "something else")
+	if _, err := other.Open(sealed, []byte("user-1")); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("expected a key for another purpose to fail, got %v", err)
+	}
+}
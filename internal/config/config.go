@@ -0,0 +1,425 @@
+// Package config loads Chirpy's settings from defaults, an optional YAML file
+// and the environment (including .env), in increasing order of precedence.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	MinTokenSecretLength = 32
+	redacted             = "[redacted]"
+)
+
+type Config struct {
+	ListenAddr              string        `yaml:"listen_addr"`
+	Platform                string        `yaml:"platform"`
+	StaticDir               string        `yaml:"static_dir"`
+	DBURL                   string        `yaml:"db_url"`
+	TokenSecret             string        `yaml:"token_secret"`
+	RefreshPepper           string        `yaml:"refresh_token_pepper"`
+	PolkaKey                string        `yaml:"polka_key"`
+	MetricsToken            string        `yaml:"metrics_token"`
+	MFAEncryptionKey        string        `yaml:"mfa_encryption_key"`
+	AccessTokenTTL          time.Duration `yaml:"access_token_ttl"`
+	RefreshTokenTTL         time.Duration `yaml:"refresh_token_ttl"`
+	ChirpMaxLength          int           `yaml:"chirp_max_length"`
+	JWTSigningKeyFile       string        `yaml:"jwt_signing_key_file"`
+	JWTVerificationKeyFiles []string      `yaml:"jwt_verification_key_files"`
+	PublicURL               string        `yaml:"public_url"`
+	PasswordResetTTL        time.Duration `yaml:"password_reset_ttl"`
+	EmailVerificationTTL    time.Duration `yaml:"email_verification_ttl"`
+	RequireVerifiedEmail    bool          `yaml:"require_verified_email"`
+	DB                      DB            `yaml:"db"`
+	Server                  Server        `yaml:"server"`
+	Mail                    Mail          `yaml:"mail"`
+	Password                Password      `yaml:"password"`
+	OIDC                    OIDC          `yaml:"oidc"`
+}
+
+type DB struct {
+	MaxOpenConns    int           `yaml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+}
+
+// Mail selects how outgoing email is delivered. The stdout and file drivers
+// write messages out instead of sending them, for development.
+type Mail struct {
+	Driver       string `yaml:"driver"`
+	From         string `yaml:"from"`
+	File         string `yaml:"file"`
+	SMTPAddr     string `yaml:"smtp_addr"`
+	SMTPUsername string `yaml:"smtp_username"`
+	SMTPPassword string `yaml:"smtp_password"`
+}
+
+// Password sets which passwords are accepted and the argon2id cost of new
+// hashes. Raising a cost rehashes each password at its owner's next login.
+type Password struct {
+	MinLength        int    `yaml:"min_length"`
+	BreachedListFile string `yaml:"breached_list_file"`
+	// Argon2Memory is in KiB.
+	Argon2Memory      int `yaml:"argon2_memory"`
+	Argon2Iterations  int `yaml:"argon2_iterations"`
+	Argon2Parallelism int `yaml:"argon2_parallelism"`
+}
+
+// OIDC lets users sign in with an external OpenID Connect provider. It is
+// off unless IssuerURL is set.
+type OIDC struct {
+	IssuerURL    string `yaml:"issuer_url"`
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret"`
+	// RedirectURL defaults to the callback endpoint under PublicURL. A
+	// front-end page that passes the query string on to it works too.
+	RedirectURL string `yaml:"redirect_url"`
+}
+
+type Server struct {
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	ReadTimeout       time.Duration `yaml:"read_timeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout"`
+	ShutdownTimeout   time.Duration `yaml:"shutdown_timeout"`
+}
+
+func Default() Config {
+	return Config{
+		ListenAddr:           ":8080",
+		Platform:             "prod",
+		AccessTokenTTL:       time.Hour,
+		RefreshTokenTTL:      60 * 24 * time.Hour,
+		ChirpMaxLength:       140,
+		PublicURL:            "http://localhost:8080",
+		PasswordResetTTL:     time.Hour,
+		EmailVerificationTTL: 24 * time.Hour,
+		DB: DB{
+			MaxOpenConns:    25,
+			MaxIdleConns:    25,
+			ConnMaxLifetime: 5 * time.Minute,
+		},
+		Server: Server{
+			ReadHeaderTimeout: 5 * time.Second,
+			ReadTimeout:       15 * time.Second,
+			WriteTimeout:      15 * time.Second,
+			IdleTimeout:       60 * time.Second,
+			ShutdownTimeout:   10 * time.Second,
+		},
+		Mail: Mail{
+			Driver: "stdout",
+			From:   "Chirpy <no-reply@chirpy.local>",
+		},
+		Password: Password{
+			MinLength:         8,
+			Argon2Memory:      64 * 1024,
+			Argon2Iterations:  1,
+			Argon2Parallelism: 2,
+		},
+	}
+}
+
+// Load reads .env into the environment (without overriding variables that
+// are already set), applies the YAML file named by CONFIG_FILE if any, then
+// the environment, and validates the result.
+func Load() (Config, error) {
+	if err := godotenv.Load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return Config{}, fmt.Errorf("loading .env: %w", err)
+	}
+
+	cfg := Default()
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return Config{}, err
+		}
+	}
+	if err := cfg.loadEnv(); err != nil {
+		return Config{}, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+	if err := yaml.Unmarshal(raw, c); err != nil {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+	return nil
+}
+
+func (c *Config) loadEnv() error {
+	var errs []error
+	envString(&c.ListenAddr, "LISTEN_ADDR")
+	envString(&c.Platform, "PLATFORM")
+	envString(&c.StaticDir, "STATIC_DIR")
+	envString(&c.DBURL, "DB_URL")
+	envString(&c.TokenSecret, "TOKEN_SECRET")
+	envString(&c.RefreshPepper, "REFRESH_TOKEN_PEPPER")
+	envString(&c.PolkaKey, "POLKA_KEY")
+	envString(&c.MetricsToken, "METRICS_TOKEN")
+	envString(&c.MFAEncryptionKey, "MFA_ENCRYPTION_KEY")
+	envString(&c.JWTSigningKeyFile, "JWT_SIGNING_KEY_FILE")
+	envList(&c.JWTVerificationKeyFiles, "JWT_VERIFICATION_KEY_FILES")
+	envString(&c.PublicURL, "PUBLIC_URL")
+	envString(&c.Mail.Driver, "MAIL_DRIVER")
+	envString(&c.Mail.From, "MAIL_FROM")
+	envString(&c.Mail.File, "MAIL_FILE")
+	envString(&c.Mail.SMTPAddr, "SMTP_ADDR")
+	envString(&c.Mail.SMTPUsername, "SMTP_USERNAME")
+	envString(&c.Mail.SMTPPassword, "SMTP_PASSWORD")
+	envString(&c.Password.BreachedListFile, "BREACHED_PASSWORDS_FILE")
+	envString(&c.OIDC.IssuerURL, "OIDC_ISSUER_URL")
+	envString(&c.OIDC.ClientID, "OIDC_CLIENT_ID")
+	envString(&c.OIDC.ClientSecret, "OIDC_CLIENT_SECRET")
+	envString(&c.OIDC.RedirectURL, "OIDC_REDIRECT_URL")
+	errs = append(errs,
+		envDuration(&c.AccessTokenTTL, "ACCESS_TOKEN_TTL"),
+		envDuration(&c.RefreshTokenTTL, "REFRESH_TOKEN_TTL"),
+		envDuration(&c.PasswordResetTTL, "PASSWORD_RESET_TTL"),
+		envDuration(&c.EmailVerificationTTL, "EMAIL_VERIFICATION_TTL"),
+		envBool(&c.RequireVerifiedEmail, "REQUIRE_VERIFIED_EMAIL"),
+		envInt(&c.ChirpMaxLength, "CHIRP_MAX_LENGTH"),
+		envInt(&c.Password.MinLength, "PASSWORD_MIN_LENGTH"),
+		envInt(&c.Password.Argon2Memory, "ARGON2_MEMORY"),
+		envInt(&c.Password.Argon2Iterations, "ARGON2_ITERATIONS"),
+		envInt(&c.Password.Argon2Parallelism, "ARGON2_PARALLELISM"),
+		envInt(&c.DB.MaxOpenConns, "DB_MAX_OPEN_CONNS"),
+		envInt(&c.DB.MaxIdleConns, "DB_MAX_IDLE_CONNS"),
+		envDuration(&c.DB.ConnMaxLifetime, "DB_CONN_MAX_LIFETIME"),
+		envDuration(&c.Server.ReadHeaderTimeout, "READ_HEADER_TIMEOUT"),
+		envDuration(&c.Server.ReadTimeout, "READ_TIMEOUT"),
+		envDuration(&c.Server.WriteTimeout, "WRITE_TIMEOUT"),
+		envDuration(&c.Server.IdleTimeout, "IDLE_TIMEOUT"),
+		envDuration(&c.Server.ShutdownTimeout, "SHUTDOWN_TIMEOUT"),
+	)
+	return errors.Join(errs...)
+}
+
+func envString(dst *string, name string) {
+	if v := os.Getenv(name); v != "" {
+		*dst = v
+	}
+}
+
+// envList reads a comma-separated list, ignoring empty entries.
+func envList(dst *[]string, name string) {
+	v := os.Getenv(name)
+	if v == "" {
+		return
+	}
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	*dst = items
+}
+
+func envInt(dst *int, name string) error {
+	v := os.Getenv(name)
+	if v == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return fmt.Errorf("%s must be an integer: %q", name, v)
+	}
+	*dst = n
+	return nil
+}
+
+func envBool(dst *bool, name string) error {
+	v := os.Getenv(name)
+	if v == "" {
+		return nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return fmt.Errorf("%s must be true or false: %q", name, v)
+	}
+	*dst = b
+	return nil
+}
+
+func envDuration(dst *time.Duration, name string) error {
+	v := os.Getenv(name)
+	if v == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return fmt.Errorf("%s must be a duration such as 15s: %q", name, v)
+	}
+	*dst = d
+	return nil
+}
+
+func (c Config) Validate() error {
+	var errs []error
+	if c.ListenAddr == "" {
+		errs = append(errs, errors.New("listen address is required"))
+	}
+	// The in-memory store loses everything on restart, so a deploy that
+	// forgets DB_URL must not come up looking healthy.
+	if c.DBURL == "" && c.Platform != "dev" {
+		errs = append(errs, errors.New("DB_URL is required unless PLATFORM is dev"))
+	}
+	if c.StaticDir != "" && c.Platform != "dev" {
+		errs = append(errs, errors.New("STATIC_DIR is only allowed when PLATFORM is dev"))
+	}
+	if c.TokenSecret == "" && c.JWTSigningKeyFile == "" {
+		errs = append(errs, errors.New("TOKEN_SECRET is required unless JWT_SIGNING_KEY_FILE is set"))
+	} else if c.TokenSecret != "" && len(c.TokenSecret) < MinTokenSecretLength {
+		errs = append(errs, fmt.Errorf("TOKEN_SECRET must be at least %d characters", MinTokenSecretLength))
+	}
+	if len(c.JWTVerificationKeyFiles) > 0 && c.JWTSigningKeyFile == "" {
+		errs = append(errs, errors.New("JWT_VERIFICATION_KEY_FILES requires JWT_SIGNING_KEY_FILE"))
+	}
+	if c.RefreshPepper == "" {
+		errs = append(errs, errors.New("REFRESH_TOKEN_PEPPER is required"))
+	} else if len(c.RefreshPepper) < MinTokenSecretLength {
+		errs = append(errs, fmt.Errorf("REFRESH_TOKEN_PEPPER must be at least %d characters", MinTokenSecretLength))
+	}
+	if c.PolkaKey == "" {
+		errs = append(errs, errors.New("POLKA_KEY is required"))
+	}
+	if c.MetricsToken != "" && len(c.MetricsToken) < MinTokenSecretLength {
+		errs = append(errs, fmt.Errorf("METRICS_TOKEN must be at least %d characters", MinTokenSecretLength))
+	}
+	if c.MFAEncryptionKey != "" && len(c.MFAEncryptionKey) < MinTokenSecretLength {
+		errs = append(errs, fmt.Errorf("MFA_ENCRYPTION_KEY must be at least %d characters", MinTokenSecretLength))
+	}
+	if c.AccessTokenTTL <= 0 {
+		errs = append(errs, errors.New("access token TTL must be positive"))
+	}
+	if c.RefreshTokenTTL <= 0 {
+		errs = append(errs, errors.New("refresh token TTL must be positive"))
+	}
+	if c.PasswordResetTTL <= 0 {
+		errs = append(errs, errors.New("password reset TTL must be positive"))
+	}
+	if c.EmailVerificationTTL <= 0 {
+		errs = append(errs, errors.New("email verification TTL must be positive"))
+	}
+	if u, err := url.Parse(c.PublicURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, fmt.Errorf("PUBLIC_URL must be an absolute http(s) URL: %q", c.PublicURL))
+	}
+	switch c.Mail.Driver {
+	case "stdout":
+	case "file":
+		if c.Mail.File == "" {
+			errs = append(errs, errors.New("MAIL_FILE is required when MAIL_DRIVER is file"))
+		}
+	case "smtp":
+		if c.Mail.SMTPAddr == "" {
+			errs = append(errs, errors.New("SMTP_ADDR is required when MAIL_DRIVER is smtp"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("MAIL_DRIVER must be stdout, file or smtp: %q", c.Mail.Driver))
+	}
+	if _, err := mail.ParseAddress(c.Mail.From); err != nil {
+		errs = append(errs, fmt.Errorf("MAIL_FROM is not a valid address: %q", c.Mail.From))
+	}
+	if c.ChirpMaxLength <= 0 {
+		errs = append(errs, errors.New("chirp max length must be positive"))
+	}
+	if c.Password.MinLength < 1 {
+		errs = append(errs, errors.New("PASSWORD_MIN_LENGTH must be at least 1"))
+	}
+	if c.Password.Argon2Iterations < 1 || c.Password.Argon2Iterations > math.MaxUint32 {
+		errs = append(errs, errors.New("ARGON2_ITERATIONS must be a positive 32-bit number"))
+	}
+	if c.Password.Argon2Parallelism < 1 || c.Password.Argon2Parallelism > math.MaxUint8 {
+		errs = append(errs, errors.New("ARGON2_PARALLELISM must be between 1 and 255"))
+	}
+	// argon2 needs at least 8 KiB per lane.
+	if c.Password.Argon2Memory < 8*max(c.Password.Argon2Parallelism, 1) || c.Password.Argon2Memory > math.MaxUint32 {
+		errs = append(errs, errors.New("ARGON2_MEMORY must be at least 8 KiB per ARGON2_PARALLELISM and fit in 32 bits"))
+	}
+	if c.OIDC.IssuerURL != "" {
+		// The provider's keys and metadata are only as trustworthy as the
+		// connection they are fetched over.
+		if u, err := url.Parse(c.OIDC.IssuerURL); err != nil || u.Host == "" ||
+			(u.Scheme != "https" && !(u.Scheme == "http" && isLoopback(u.Hostname()))) {
+			errs = append(errs, fmt.Errorf("OIDC_ISSUER_URL must be an https URL: %q", c.OIDC.IssuerURL))
+		}
+		if c.OIDC.ClientID == "" {
+			errs = append(errs, errors.New("OIDC_CLIENT_ID is required when OIDC_ISSUER_URL is set"))
+		}
+		if c.OIDC.RedirectURL != "" {
+			if u, err := url.Parse(c.OIDC.RedirectURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				errs = append(errs, fmt.Errorf("OIDC_REDIRECT_URL must be an absolute http(s) URL: %q", c.OIDC.RedirectURL))
+			}
+		}
+	} else if c.OIDC.ClientID != "" {
+		errs = append(errs, errors.New("OIDC_CLIENT_ID requires OIDC_ISSUER_URL"))
+	}
+	if c.DB.MaxOpenConns < 0 || c.DB.MaxIdleConns < 0 {
+		errs = append(errs, errors.New("database pool sizes cannot be negative"))
+	}
+	return errors.Join(errs...)
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Redacted returns the effective configuration as YAML with secrets masked,
+// suitable for logging at startup.
+func (c Config) Redacted() string {
+	if c.TokenSecret != "" {
+		c.TokenSecret = redacted
+	}
+	if c.RefreshPepper != "" {
+		c.RefreshPepper = redacted
+	}
+	if c.PolkaKey != "" {
+		c.PolkaKey = redacted
+	}
+	if c.MetricsToken != "" {
+		c.MetricsToken = redacted
+	}
+	if c.MFAEncryptionKey != "" {
+		c.MFAEncryptionKey = redacted
+	}
+	if c.Mail.SMTPPassword != "" {
+		c.Mail.SMTPPassword = redacted
+	}
+	if c.OIDC.ClientSecret != "" {
+		c.OIDC.ClientSecret = redacted
+	}
+	if u, err := url.Parse(c.DBURL); err == nil && u.Scheme != "" {
+		c.DBURL = u.Redacted()
+	} else if c.DBURL != "" {
+		// key=value connection strings can't be partially masked.
+		c.DBURL = redacted
+	}
+	out, err := yaml.Marshal(c)
+	if err != nil {
+		return err.Error()
+	}
+	return string(out)
+}
@@ -7,6 +7,7 @@ package database
 
 import (
 	"context"
+	"database/sql"
 
 	"github.com/google/uuid"
 )
@@ -59,12 +60,71 @@ func (q *Queries) DeleteChirp(ctx context.Context, arg DeleteChirpParams) error
 	return err
 }
 
-const getAllChirps = `-- name: GetAllChirps :many
+const getChirp = `-- name: GetChirp :one
+SELECT id, created_at, updated_at, body, user_id FROM chirps WHERE id = $1
+`
+
+func (q *Queries) GetChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, getChirp, id)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+	)
+	return i, err
+}
+
+const isChirpAuthor = `-- name: IsChirpAuthor :one
+SELECT id, CASE
+    WHEN user_id=$1 THEN true
+    ELSE false
+END AS is_author
+FROM chirps
+WHERE id=$2
+`
+
+type IsChirpAuthorParams struct {
+	UserID uuid.UUID
+	ID     uuid.UUID
+}
+
+type IsChirpAuthorRow struct {
+	ID       uuid.UUID
+	IsAuthor bool
+}
+
+func (q *Queries) IsChirpAuthor(ctx context.Context, arg IsChirpAuthorParams) (IsChirpAuthorRow, error) {
+	row := q.db.QueryRowContext(ctx, isChirpAuthor, arg.UserID, arg.ID)
+	var i IsChirpAuthorRow
+	err := row.Scan(&i.ID, &i.IsAuthor)
+	return i, err
+}
+
+const listChirpsAsc = `-- name: ListChirpsAsc :many
 SELECT id, created_at, updated_at, body, user_id FROM chirps
+WHERE ($1::uuid IS NULL OR user_id = $1::uuid)
+AND ($2::timestamp IS NULL OR (created_at, id) > ($2::timestamp, $3::uuid))
+ORDER BY created_at ASC, id ASC
+LIMIT $4
 `
 
-func (q *Queries) GetAllChirps(ctx context.Context) ([]Chirp, error) {
-	rows, err := q.db.QueryContext(ctx, getAllChirps)
+type ListChirpsAscParams struct {
+	AuthorID       uuid.NullUUID
+	AfterCreatedAt sql.NullTime
+	AfterID        uuid.NullUUID
+	PageSize       int32
+}
+
+func (q *Queries) ListChirpsAsc(ctx context.Context, arg ListChirpsAscParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, listChirpsAsc,
+		arg.AuthorID,
+		arg.AfterCreatedAt,
+		arg.AfterID,
+		arg.PageSize,
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -92,12 +152,28 @@ func (q *Queries) GetAllChirps(ctx context.Context) ([]Chirp, error) {
 	return items, nil
 }
 
-const getAllChirpsByAuthor = `-- name: GetAllChirpsByAuthor :many
-SELECT id, created_at, updated_at, body, user_id FROM chirps WHERE user_id=$1
+const listChirpsDesc = `-- name: ListChirpsDesc :many
+SELECT id, created_at, updated_at, body, user_id FROM chirps
+WHERE ($1::uuid IS NULL OR user_id = $1::uuid)
+AND ($2::timestamp IS NULL OR (created_at, id) < ($2::timestamp, $3::uuid))
+ORDER BY created_at DESC, id DESC
+LIMIT $4
 `
 
-func (q *Queries) GetAllChirpsByAuthor(ctx context.Context, userID uuid.UUID) ([]Chirp, error) {
-	rows, err := q.db.QueryContext(ctx, getAllChirpsByAuthor, userID)
+type ListChirpsDescParams struct {
+	AuthorID       uuid.NullUUID
+	AfterCreatedAt sql.NullTime
+	AfterID        uuid.NullUUID
+	PageSize       int32
+}
+
+func (q *Queries) ListChirpsDesc(ctx context.Context, arg ListChirpsDescParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, listChirpsDesc,
+		arg.AuthorID,
+		arg.AfterCreatedAt,
+		arg.AfterID,
+		arg.PageSize,
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -125,45 +201,13 @@ func (q *Queries) GetAllChirpsByAuthor(ctx context.Context, userID uuid.UUID) ([
 	return items, nil
 }
 
-const getChirp = `-- name: GetChirp :one
-SELECT id, created_at, updated_at, body, user_id FROM chirps WHERE id = $1
-`
-
-func (q *Queries) GetChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
-	row := q.db.QueryRowContext(ctx, getChirp, id)
-	var i Chirp
-	err := row.Scan(
-		&i.ID,
-		&i.CreatedAt,
-		&i.UpdatedAt,
-		&i.Body,
-		&i.UserID,
-	)
-	return i, err
-}
-
-const isChirpAuthor = `-- name: IsChirpAuthor :one
-SELECT id, CASE
-    WHEN user_id=$1 THEN true
-    ELSE false
-END AS is_author
-FROM chirps
-WHERE id=$2
+const removeChirp = `-- name: RemoveChirp :one
+DELETE FROM chirps WHERE id=$1 RETURNING user_id
 `
 
-type IsChirpAuthorParams struct {
-	UserID uuid.UUID
-	ID     uuid.UUID
-}
-
-type IsChirpAuthorRow struct {
-	ID       uuid.UUID
-	IsAuthor bool
-}
-
-func (q *Queries) IsChirpAuthor(ctx context.Context, arg IsChirpAuthorParams) (IsChirpAuthorRow, error) {
-	row := q.db.QueryRowContext(ctx, isChirpAuthor, arg.UserID, arg.ID)
-	var i IsChirpAuthorRow
-	err := row.Scan(&i.ID, &i.IsAuthor)
-	return i, err
+func (q *Queries) RemoveChirp(ctx context.Context, id uuid.UUID) (uuid.UUID, error) {
+	row := q.db.QueryRowContext(ctx, removeChirp, id)
+	var user_id uuid.UUID
+	err := row.Scan(&user_id)
+	return user_id, err
 }
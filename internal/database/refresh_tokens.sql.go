@@ -7,25 +7,43 @@ package database
 
 import (
 	"context"
+	"database/sql"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 const createRefreshToken = `-- name: CreateRefreshToken :one
 INSERT INTO refresh_tokens(
-    created_at, updated_at,  token, user_id, expires_at
-) VALUES (now(), now(), $1, $2, $3 ) RETURNING token, created_at, updated_at, user_id, expires_at, revoked_at
+    created_at, updated_at, last_used_at, token, user_id, expires_at, family_id, token_hash, user_agent, ip_address, client_id, scopes
+) VALUES (now(), now(), now(), $1, $2, $3, $4, $5, $6, $7, $8, $9 ) RETURNING token, created_at, updated_at, user_id, expires_at, revoked_at, family_id, rotated_at, token_hash, last_used_at, user_agent, ip_address, client_id, scopes
 `
 
 type CreateRefreshTokenParams struct {
 	Token     string
 	UserID    uuid.UUID
 	ExpiresAt time.Time
+	FamilyID  uuid.UUID
+	TokenHash sql.NullString
+	UserAgent string
+	IpAddress string
+	ClientID  sql.NullString
+	Scopes    []string
 }
 
 func (q *Queries) CreateRefreshToken(ctx context.Context, arg CreateRefreshTokenParams) (RefreshToken, error) {
-	row := q.db.QueryRowContext(ctx, createRefreshToken, arg.Token, arg.UserID, arg.ExpiresAt)
+	row := q.db.QueryRowContext(ctx, createRefreshToken,
+		arg.Token,
+		arg.UserID,
+		arg.ExpiresAt,
+		arg.FamilyID,
+		arg.TokenHash,
+		arg.UserAgent,
+		arg.IpAddress,
+		arg.ClientID,
+		pq.Array(arg.Scopes),
+	)
 	var i RefreshToken
 	err := row.Scan(
 		&i.Token,
@@ -34,32 +52,164 @@ func (q *Queries) CreateRefreshToken(ctx context.Context, arg CreateRefreshToken
 		&i.UserID,
 		&i.ExpiresAt,
 		&i.RevokedAt,
+		&i.FamilyID,
+		&i.RotatedAt,
+		&i.TokenHash,
+		&i.LastUsedAt,
+		&i.UserAgent,
+		&i.IpAddress,
+		&i.ClientID,
+		pq.Array(&i.Scopes),
 	)
 	return i, err
 }
 
 const getRefreshToken = `-- name: GetRefreshToken :one
-
-SELECT token from refresh_tokens where expires is null and token=$1
+SELECT token, created_at, updated_at, user_id, expires_at, revoked_at, family_id, rotated_at, token_hash, last_used_at, user_agent, ip_address, client_id, scopes from refresh_tokens where token=$1
 `
 
-func (q *Queries) GetRefreshToken(ctx context.Context, token string) (string, error) {
+func (q *Queries) GetRefreshToken(ctx context.Context, token string) (RefreshToken, error) {
 	row := q.db.QueryRowContext(ctx, getRefreshToken, token)
-	err := row.Scan(&token)
-	return token, err
+	var i RefreshToken
+	err := row.Scan(
+		&i.Token,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.UserID,
+		&i.ExpiresAt,
+		&i.RevokedAt,
+		&i.FamilyID,
+		&i.RotatedAt,
+		&i.TokenHash,
+		&i.LastUsedAt,
+		&i.UserAgent,
+		&i.IpAddress,
+		&i.ClientID,
+		pq.Array(&i.Scopes),
+	)
+	return i, err
 }
 
-const getUserFromRefreshToken = `-- name: GetUserFromRefreshToken :one
-SELECT user_id from refresh_tokens 
-where token=$1
+const hashLegacyRefreshToken = `-- name: HashLegacyRefreshToken :exec
+UPDATE refresh_tokens SET token=$1, token_hash=$2, updated_at=now()
+where token=$3
+and token_hash is null
+`
+
+type HashLegacyRefreshTokenParams struct {
+	TokenID     string
+	TokenHash   sql.NullString
+	LegacyToken string
+}
+
+func (q *Queries) HashLegacyRefreshToken(ctx context.Context, arg HashLegacyRefreshTokenParams) error {
+	_, err := q.db.ExecContext(ctx, hashLegacyRefreshToken, arg.TokenID, arg.TokenHash, arg.LegacyToken)
+	return err
+}
+
+const isSessionActive = `-- name: IsSessionActive :one
+SELECT EXISTS(
+    SELECT 1 FROM refresh_tokens
+    where family_id=$1
+    and revoked_at is null
+    and expires_at > now()
+)
+`
+
+func (q *Queries) IsSessionActive(ctx context.Context, familyID uuid.UUID) (bool, error) {
+	row := q.db.QueryRowContext(ctx, isSessionActive, familyID)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
+const listActiveSessions = `-- name: ListActiveSessions :many
+SELECT t.family_id, t.last_used_at, t.user_agent, t.ip_address, t.expires_at, t.client_id,
+    (SELECT min(f.created_at) FROM refresh_tokens f WHERE f.family_id = t.family_id)::timestamp AS created_at
+FROM refresh_tokens t
+where t.user_id=$1
+and t.revoked_at is null
+and t.expires_at > now()
+ORDER BY t.last_used_at DESC
+`
+
+type ListActiveSessionsRow struct {
+	FamilyID   uuid.UUID
+	LastUsedAt time.Time
+	UserAgent  string
+	IpAddress  string
+	ExpiresAt  time.Time
+	ClientID   sql.NullString
+	CreatedAt  time.Time
+}
+
+func (q *Queries) ListActiveSessions(ctx context.Context, userID uuid.UUID) ([]ListActiveSessionsRow, error) {
+	rows, err := q.db.QueryContext(ctx, listActiveSessions, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListActiveSessionsRow
+	for rows.Next() {
+		var i ListActiveSessionsRow
+		if err := rows.Scan(
+			&i.FamilyID,
+			&i.LastUsedAt,
+			&i.UserAgent,
+			&i.IpAddress,
+			&i.ExpiresAt,
+			&i.ClientID,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listLegacyRefreshTokens = `-- name: ListLegacyRefreshTokens :many
+SELECT token from refresh_tokens where token_hash is null
+`
+
+func (q *Queries) ListLegacyRefreshTokens(ctx context.Context) ([]string, error) {
+	rows, err := q.db.QueryContext(ctx, listLegacyRefreshTokens)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var token string
+		if err := rows.Scan(&token); err != nil {
+			return nil, err
+		}
+		items = append(items, token)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const revokeAllUserRefreshTokens = `-- name: RevokeAllUserRefreshTokens :exec
+UPDATE refresh_tokens SET revoked_at=now(), updated_at=now()
+where user_id=$1
 and revoked_at is null
 `
 
-func (q *Queries) GetUserFromRefreshToken(ctx context.Context, token string) (uuid.UUID, error) {
-	row := q.db.QueryRowContext(ctx, getUserFromRefreshToken, token)
-	var user_id uuid.UUID
-	err := row.Scan(&user_id)
-	return user_id, err
+func (q *Queries) RevokeAllUserRefreshTokens(ctx context.Context, userID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, revokeAllUserRefreshTokens, userID)
+	return err
 }
 
 const revokeRefreshToken = `-- name: RevokeRefreshToken :exec
@@ -70,3 +220,63 @@ func (q *Queries) RevokeRefreshToken(ctx context.Context, token string) error {
 	_, err := q.db.ExecContext(ctx, revokeRefreshToken, token)
 	return err
 }
+
+const revokeRefreshTokenFamily = `-- name: RevokeRefreshTokenFamily :exec
+UPDATE refresh_tokens SET revoked_at=now(), updated_at=now()
+where family_id=$1
+and revoked_at is null
+`
+
+func (q *Queries) RevokeRefreshTokenFamily(ctx context.Context, familyID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, revokeRefreshTokenFamily, familyID)
+	return err
+}
+
+const revokeSession = `-- name: RevokeSession :execrows
+UPDATE refresh_tokens SET revoked_at=now(), updated_at=now()
+where family_id=$1
+and user_id=$2
+and revoked_at is null
+`
+
+type RevokeSessionParams struct {
+	FamilyID uuid.UUID
+	UserID   uuid.UUID
+}
+
+func (q *Queries) RevokeSession(ctx context.Context, arg RevokeSessionParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, revokeSession, arg.FamilyID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const rotateRefreshToken = `-- name: RotateRefreshToken :one
+UPDATE refresh_tokens SET revoked_at=now(), rotated_at=now(), updated_at=now()
+where token=$1
+and revoked_at is null
+RETURNING token, created_at, updated_at, user_id, expires_at, revoked_at, family_id, rotated_at, token_hash, last_used_at, user_agent, ip_address, client_id, scopes
+`
+
+func (q *Queries) RotateRefreshToken(ctx context.Context, token string) (RefreshToken, error) {
+	row := q.db.QueryRowContext(ctx, rotateRefreshToken, token)
+	var i RefreshToken
+	err := row.Scan(
+		&i.Token,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.UserID,
+		&i.ExpiresAt,
+		&i.RevokedAt,
+		&i.FamilyID,
+		&i.RotatedAt,
+		&i.TokenHash,
+		&i.LastUsedAt,
+		&i.UserAgent,
+		&i.IpAddress,
+		&i.ClientID,
+		pq.Array(&i.Scopes),
+	)
+	return i, err
+}
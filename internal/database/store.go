@@ -0,0 +1,48 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// Store is a Querier that can also run several queries atomically. This
+// file is not generated by sqlc.
+type Store interface {
+	Querier
+	// ExecTx runs fn in a transaction, committing if it returns nil and
+	// rolling back otherwise.
+	ExecTx(ctx context.Context, fn func(Querier) error) error
+}
+
+// SQLStore is a Store backed by a *sql.DB.
+type SQLStore struct {
+	*Queries
+	db   *sql.DB
+	wrap func(DBTX) DBTX
+}
+
+var _ Store = (*SQLStore)(nil)
+
+// NewStore returns a Store for db. wrap, if not nil, decorates both db and
+// every transaction, e.g. to instrument queries.
+func NewStore(db *sql.DB, wrap func(DBTX) DBTX) *SQLStore {
+	if wrap == nil {
+		wrap = func(d DBTX) DBTX { return d }
+	}
+	return &SQLStore{Queries: New(wrap(db)), db: db, wrap: wrap}
+}
+
+func (s *SQLStore) ExecTx(ctx context.Context, fn func(Querier) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(New(s.wrap(tx))); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return fmt.Errorf("%w (rollback failed: %v)", err, rbErr)
+		}
+		return err
+	}
+	return tx.Commit()
+}
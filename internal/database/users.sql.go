@@ -13,11 +13,41 @@ import (
 	"github.com/google/uuid"
 )
 
+const bumpTokenVersion = `-- name: BumpTokenVersion :one
+UPDATE users SET token_version=token_version+1, updated_at=now() WHERE id=$1 RETURNING token_version
+`
+
+func (q *Queries) BumpTokenVersion(ctx context.Context, id uuid.UUID) (int32, error) {
+	row := q.db.QueryRowContext(ctx, bumpTokenVersion, id)
+	var token_version int32
+	err := row.Scan(&token_version)
+	return token_version, err
+}
+
+const confirmPendingEmail = `-- name: ConfirmPendingEmail :execrows
+UPDATE users SET email=pending_email, pending_email=NULL, email_verified_at=now(), updated_at=now()
+WHERE id=$1
+and pending_email=$2::text
+`
+
+type ConfirmPendingEmailParams struct {
+	ID    uuid.UUID
+	Email string
+}
+
+func (q *Queries) ConfirmPendingEmail(ctx context.Context, arg ConfirmPendingEmailParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, confirmPendingEmail, arg.ID, arg.Email)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const createUser = `-- name: CreateUser :one
 INSERT INTO
     users (id, created_at, updated_at, email, hashed_password)
 VALUES
-    (gen_random_uuid(), now(), now(), $1, $2) RETURNING id, created_at, updated_at, email, is_chirpy_red
+    (gen_random_uuid(), now(), now(), $1, $2) RETURNING id, created_at, updated_at, email, is_chirpy_red, email_verified_at, role
 `
 
 type CreateUserParams struct {
@@ -26,11 +56,13 @@ type CreateUserParams struct {
 }
 
 type CreateUserRow struct {
-	ID          uuid.UUID
-	CreatedAt   time.Time
-	UpdatedAt   time.Time
-	Email       string
-	IsChirpyRed sql.NullBool
+	ID              uuid.UUID
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+	Email           string
+	IsChirpyRed     sql.NullBool
+	EmailVerifiedAt sql.NullTime
+	Role            string
 }
 
 func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (CreateUserRow, error) {
@@ -42,6 +74,8 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (CreateU
 		&i.UpdatedAt,
 		&i.Email,
 		&i.IsChirpyRed,
+		&i.EmailVerifiedAt,
+		&i.Role,
 	)
 	return i, err
 }
@@ -55,17 +89,78 @@ func (q *Queries) DeleteAllUsers(ctx context.Context) error {
 	return err
 }
 
+const getUser = `-- name: GetUser :one
+SELECT id, created_at, updated_at, email, is_chirpy_red, email_verified_at, pending_email, role FROM users WHERE id=$1
+`
+
+type GetUserRow struct {
+	ID              uuid.UUID
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+	Email           string
+	IsChirpyRed     sql.NullBool
+	EmailVerifiedAt sql.NullTime
+	PendingEmail    sql.NullString
+	Role            string
+}
+
+func (q *Queries) GetUser(ctx context.Context, id uuid.UUID) (GetUserRow, error) {
+	row := q.db.QueryRowContext(ctx, getUser, id)
+	var i GetUserRow
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.IsChirpyRed,
+		&i.EmailVerifiedAt,
+		&i.PendingEmail,
+		&i.Role,
+	)
+	return i, err
+}
+
+const getUserAuthState = `-- name: GetUserAuthState :one
+SELECT token_version, locked_at, is_chirpy_red, email_verified_at, role FROM users WHERE id=$1
+`
+
+type GetUserAuthStateRow struct {
+	TokenVersion    int32
+	LockedAt        sql.NullTime
+	IsChirpyRed     sql.NullBool
+	EmailVerifiedAt sql.NullTime
+	Role            string
+}
+
+func (q *Queries) GetUserAuthState(ctx context.Context, id uuid.UUID) (GetUserAuthStateRow, error) {
+	row := q.db.QueryRowContext(ctx, getUserAuthState, id)
+	var i GetUserAuthStateRow
+	err := row.Scan(
+		&i.TokenVersion,
+		&i.LockedAt,
+		&i.IsChirpyRed,
+		&i.EmailVerifiedAt,
+		&i.Role,
+	)
+	return i, err
+}
+
 const getUserCredsByEmail = `-- name: GetUserCredsByEmail :one
-SELECT id, email, created_at, updated_at, hashed_password, is_chirpy_red FROM users where email=$1
+SELECT id, email, created_at, updated_at, hashed_password, is_chirpy_red, token_version, locked_at, email_verified_at, totp_enabled_at, role FROM users where lower(email)=lower($1)
 `
 
 type GetUserCredsByEmailRow struct {
-	ID             uuid.UUID
-	Email          string
-	CreatedAt      time.Time
-	UpdatedAt      time.Time
-	HashedPassword string
-	IsChirpyRed    sql.NullBool
+	ID              uuid.UUID
+	Email           string
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+	HashedPassword  string
+	IsChirpyRed     sql.NullBool
+	TokenVersion    int32
+	LockedAt        sql.NullTime
+	EmailVerifiedAt sql.NullTime
+	TotpEnabledAt   sql.NullTime
+	Role            string
 }
 
 func (q *Queries) GetUserCredsByEmail(ctx context.Context, email string) (GetUserCredsByEmailRow, error) {
@@ -78,39 +173,124 @@ func (q *Queries) GetUserCredsByEmail(ctx context.Context, email string) (GetUse
 		&i.UpdatedAt,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.TokenVersion,
+		&i.LockedAt,
+		&i.EmailVerifiedAt,
+		&i.TotpEnabledAt,
+		&i.Role,
 	)
 	return i, err
 }
 
+const lockUser = `-- name: LockUser :execrows
+UPDATE users SET locked_at=now(), token_version=token_version+1, updated_at=now() WHERE id=$1
+`
+
+func (q *Queries) LockUser(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, lockUser, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const rehashUserPassword = `-- name: RehashUserPassword :exec
+UPDATE users SET hashed_password=$2 WHERE id=$1 and hashed_password=$3
+`
+
+type RehashUserPasswordParams struct {
+	ID      uuid.UUID
+	NewHash string
+	OldHash string
+}
+
+// Only replaces the hash it was computed from, so a concurrent password
+// change wins.
+func (q *Queries) RehashUserPassword(ctx context.Context, arg RehashUserPasswordParams) error {
+	_, err := q.db.ExecContext(ctx, rehashUserPassword, arg.ID, arg.NewHash, arg.OldHash)
+	return err
+}
+
+const setUserRole = `-- name: SetUserRole :execrows
+UPDATE users SET role=$2, updated_at=now() WHERE id=$1
+`
+
+type SetUserRoleParams struct {
+	ID   uuid.UUID
+	Role string
+}
+
+func (q *Queries) SetUserRole(ctx context.Context, arg SetUserRoleParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, setUserRole, arg.ID, arg.Role)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const unlockUser = `-- name: UnlockUser :execrows
+UPDATE users SET locked_at=NULL, updated_at=now() WHERE id=$1
+`
+
+func (q *Queries) UnlockUser(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, unlockUser, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const updateUserCredentials = `-- name: UpdateUserCredentials :one
-UPDATE users SET hashed_password=$1, email=$2, updated_at=now() WHERE id=$3 RETURNING id, updated_at, email, is_chirpy_red
+UPDATE users SET hashed_password=$1, pending_email=$2, token_version=token_version+1, updated_at=now() WHERE id=$3 RETURNING id, updated_at, email, pending_email, email_verified_at, is_chirpy_red, token_version, role
 `
 
 type UpdateUserCredentialsParams struct {
 	HashedPassword string
-	Email          string
+	PendingEmail   sql.NullString
 	ID             uuid.UUID
 }
 
 type UpdateUserCredentialsRow struct {
-	ID          uuid.UUID
-	UpdatedAt   time.Time
-	Email       string
-	IsChirpyRed sql.NullBool
+	ID              uuid.UUID
+	UpdatedAt       time.Time
+	Email           string
+	PendingEmail    sql.NullString
+	EmailVerifiedAt sql.NullTime
+	IsChirpyRed     sql.NullBool
+	TokenVersion    int32
+	Role            string
 }
 
 func (q *Queries) UpdateUserCredentials(ctx context.Context, arg UpdateUserCredentialsParams) (UpdateUserCredentialsRow, error) {
-	row := q.db.QueryRowContext(ctx, updateUserCredentials, arg.HashedPassword, arg.Email, arg.ID)
+	row := q.db.QueryRowContext(ctx, updateUserCredentials, arg.HashedPassword, arg.PendingEmail, arg.ID)
 	var i UpdateUserCredentialsRow
 	err := row.Scan(
 		&i.ID,
 		&i.UpdatedAt,
 		&i.Email,
+		&i.PendingEmail,
+		&i.EmailVerifiedAt,
 		&i.IsChirpyRed,
+		&i.TokenVersion,
+		&i.Role,
 	)
 	return i, err
 }
 
+const updateUserPassword = `-- name: UpdateUserPassword :exec
+UPDATE users SET hashed_password=$1, token_version=token_version+1, updated_at=now() WHERE id=$2
+`
+
+type UpdateUserPasswordParams struct {
+	HashedPassword string
+	ID             uuid.UUID
+}
+
+func (q *Queries) UpdateUserPassword(ctx context.Context, arg UpdateUserPasswordParams) error {
+	_, err := q.db.ExecContext(ctx, updateUserPassword, arg.HashedPassword, arg.ID)
+	return err
+}
+
 const upgradeUser = `-- name: UpgradeUser :exec
 UPDATE users SET is_chirpy_red=true WHERE id=$1 RETURNING id, is_chirpy_red
 `
@@ -119,3 +299,22 @@ func (q *Queries) UpgradeUser(ctx context.Context, id uuid.UUID) error {
 	_, err := q.db.ExecContext(ctx, upgradeUser, id)
 	return err
 }
+
+const verifyUserEmail = `-- name: VerifyUserEmail :execrows
+UPDATE users SET email_verified_at=now(), updated_at=now()
+WHERE id=$1
+and lower(email)=lower($2)
+`
+
+type VerifyUserEmailParams struct {
+	ID    uuid.UUID
+	Email string
+}
+
+func (q *Queries) VerifyUserEmail(ctx context.Context, arg VerifyUserEmailParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, verifyUserEmail, arg.ID, arg.Email)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
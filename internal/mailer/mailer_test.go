@@ -0,0 +1,190 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// received is one message accepted by fakeSMTP.
+type received struct {
+	auth string
+	from string
+	to   []string
+	data string
+}
+
+// fakeSMTP is just enough of an SMTP server for net/smtp: no TLS, optional
+// AUTH PLAIN, one message per connection.
+type fakeSMTP struct {
+	addr     string
+	password string
+	messages chan received
+}
+
+func startFakeSMTP(t *testing.T, password string) *fakeSMTP {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	srv := &fakeSMTP{addr: ln.Addr().String(), password: password, messages: make(chan received, 10)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go srv.serve(conn)
+		}
+	}()
+	return srv
+}
+
+func (s *fakeSMTP) serve(conn net.Conn) {
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+	var msg received
+	tp.PrintfLine("220 fake ESMTP")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			if s.password != "" {
+				tp.PrintfLine("250-fake")
+				tp.PrintfLine("250 AUTH PLAIN")
+			} else {
+				tp.PrintfLine("250 fake")
+			}
+		case "AUTH":
+			_, initial, _ := strings.Cut(arg, " ")
+			creds, _ := base64.StdEncoding.DecodeString(initial)
+			parts := strings.Split(string(creds), "\x00")
+			if len(parts) != 3 || parts[2] != s.password {
+				tp.PrintfLine("535 authentication failed")
+				continue
+			}
+			msg.auth = parts[1]
+			tp.PrintfLine("235 ok")
+		case "MAIL":
+			msg.from = strings.Trim(strings.TrimPrefix(arg, "FROM:"), "<>")
+			tp.PrintfLine("250 ok")
+		case "RCPT":
+			msg.to = append(msg.to, strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>"))
+			tp.PrintfLine("250 ok")
+		case "DATA":
+			tp.PrintfLine("354 go ahead")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			msg.data = string(data)
+			s.messages <- msg
+			tp.PrintfLine("250 queued")
+		case "QUIT":
+			tp.PrintfLine("221 bye")
+			return
+		default:
+			tp.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+func (s *fakeSMTP) next(t *testing.T) received {
+	t.Helper()
+	select {
+	case msg := <-s.messages:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a message")
+		return received{}
+	}
+}
+
+func TestSMTPMailer(t *testing.T) {
+	srv := startFakeSMTP(t, "")
+	m, err := NewSMTPMailer(srv.addr, "Chirpy <no-reply@chirpy.test>", "", "")
+	if err != nil {
+		t.Fatalf("NewSMTPMailer failed: %v", err)
+	}
+
+	err = m.Send(context.Background(), Message{To: "walt@example.com", Subject: "Hello", Body: "Line one\nLine two"})
+	if err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	got := srv.next(t)
+	if got.from != "no-reply@chirpy.test" {
+		t.Errorf("expected envelope sender without display name, got %q", got.from)
+	}
+	if len(got.to) != 1 || got.to[0] != "walt@example.com" {
+		t.Errorf("unexpected recipients %v", got.to)
+	}
+	for _, want := range []string{"Subject: Hello", "To: walt@example.com", "Line one\nLine two"} {
+		if !strings.Contains(got.data, want) {
+			t.Errorf("expected message to contain %q:\n%s", want, got.data)
+		}
+	}
+}
+
+func TestSMTPMailerAuthenticates(t *testing.T) {
+	srv := startFakeSMTP(t, "hunter2")
+
+	m, _ := NewSMTPMailer(srv.addr, "no-reply@chirpy.test", "chirpy", "hunter2")
+	if err := m.Send(context.Background(), Message{To: "walt@example.com", Subject: "Hi", Body: "Hi"}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if got := srv.next(t); got.auth != "chirpy" {
+		t.Errorf("expected to authenticate as chirpy, got %q", got.auth)
+	}
+
+	wrong, _ := NewSMTPMailer(srv.addr, "no-reply@chirpy.test", "chirpy", "wrong")
+	if err := wrong.Send(context.Background(), Message{To: "walt@example.com", Subject: "Hi", Body: "Hi"}); err == nil {
+		t.Error("expected a bad password to fail")
+	}
+}
+
+func TestRenderRejectsHeaderInjection(t *testing.T) {
+	var buf bytes.Buffer
+	m := NewWriterMailer(&buf, "no-reply@chirpy.test")
+
+	err := m.Send(context.Background(), Message{To: "walt@example.com\r\nBcc: everyone@example.com", Subject: "Hi"})
+	if !errors.Is(err, errHeaderInjection) {
+		t.Errorf("expected %v, got %v", errHeaderInjection, err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("expected nothing to be written, got %q", buf.String())
+	}
+}
+
+func TestFileMailerAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mail.log")
+	m, err := NewFileMailer(path, "no-reply@chirpy.test")
+	if err != nil {
+		t.Fatalf("NewFileMailer failed: %v", err)
+	}
+	for _, subject := range []string{"First", "Second"} {
+		if err := m.Send(context.Background(), Message{To: "walt@example.com", Subject: subject, Body: "body"}); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+	}
+
+	raw, _ := os.ReadFile(path)
+	if !strings.Contains(string(raw), "Subject: First") || !strings.Contains(string(raw), "Subject: Second") {
+		t.Errorf("expected both messages in the file, got:\n%s", raw)
+	}
+}
@@ -0,0 +1,58 @@
+package mailer
+
+import (
+	"context"
+	"net"
+	"net/smtp"
+	"time"
+)
+
+// SMTPMailer delivers messages through an SMTP relay. net/smtp upgrades to
+// TLS when the server offers STARTTLS and refuses to send credentials over
+// an unencrypted connection to anything but localhost.
+type SMTPMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+// NewSMTPMailer sends through the server at addr (host:port). Username may
+// be empty for relays that don't require authentication.
+func NewSMTPMailer(addr, from, username, password string) (*SMTPMailer, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	m := &SMTPMailer{addr: addr, from: from}
+	if username != "" {
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return m, nil
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	raw, err := render(m.from, msg, time.Now())
+	if err != nil {
+		return err
+	}
+	envelopeFrom, err := addressOf(m.from)
+	if err != nil {
+		return err
+	}
+	to, err := addressOf(msg.To)
+	if err != nil {
+		return err
+	}
+
+	// smtp.SendMail takes no context, so give up waiting on it instead.
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(m.addr, m.auth, envelopeFrom, []string{to}, raw)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,92 @@
+package memstore
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"slices"
+
+	"github.com/dev-perry/go-server/internal/database"
+	"github.com/google/uuid"
+)
+
+func (s *Store) CreateAPIKey(ctx context.Context, arg database.CreateAPIKeyParams) (database.ApiKey, error) {
+	defer s.lock()()
+
+	if _, ok := s.apiKeys[arg.ID]; ok {
+		return database.ApiKey{}, uniqueViolation("api_keys_pkey")
+	}
+	if _, ok := s.users[arg.UserID]; !ok {
+		return database.ApiKey{}, foreignKeyViolation("api_keys", "api_keys_user_id_fkey")
+	}
+	k := database.ApiKey{
+		ID:        arg.ID,
+		CreatedAt: now(),
+		UserID:    arg.UserID,
+		Name:      arg.Name,
+		KeyHash:   arg.KeyHash,
+		Scopes:    slices.Clone(arg.Scopes),
+		ExpiresAt: arg.ExpiresAt,
+	}
+	s.apiKeys[k.ID] = k
+	return k, nil
+}
+
+func (s *Store) GetAPIKey(ctx context.Context, id string) (database.ApiKey, error) {
+	defer s.rlock()()
+
+	k, ok := s.apiKeys[id]
+	if !ok {
+		return database.ApiKey{}, sql.ErrNoRows
+	}
+	k.Scopes = slices.Clone(k.Scopes)
+	return k, nil
+}
+
+func (s *Store) ListAPIKeys(ctx context.Context, userID uuid.UUID) ([]database.ApiKey, error) {
+	defer s.rlock()()
+
+	var keys []database.ApiKey
+	for _, k := range s.apiKeys {
+		if k.UserID == userID && !k.RevokedAt.Valid {
+			k.Scopes = slices.Clone(k.Scopes)
+			keys = append(keys, k)
+		}
+	}
+	slices.SortFunc(keys, func(a, b database.ApiKey) int {
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), cmp.Compare(a.ID, b.ID))
+	})
+	return keys, nil
+}
+
+func (s *Store) RehashAPIKey(ctx context.Context, arg database.RehashAPIKeyParams) error {
+	defer s.lock()()
+
+	if k, ok := s.apiKeys[arg.ID]; ok && k.KeyHash == arg.OldHash {
+		k.KeyHash = arg.NewHash
+		s.apiKeys[arg.ID] = k
+	}
+	return nil
+}
+
+func (s *Store) RevokeAPIKey(ctx context.Context, arg database.RevokeAPIKeyParams) (int64, error) {
+	defer s.lock()()
+
+	k, ok := s.apiKeys[arg.ID]
+	if !ok || k.UserID != arg.UserID || k.RevokedAt.Valid {
+		return 0, nil
+	}
+	k.RevokedAt = sql.NullTime{Time: now(), Valid: true}
+	s.apiKeys[arg.ID] = k
+	return 1, nil
+}
+
+func (s *Store) TouchAPIKey(ctx context.Context, id string) error {
+	defer s.lock()()
+
+	if k, ok := s.apiKeys[id]; ok {
+		k.LastUsedAt = sql.NullTime{Time: now(), Valid: true}
+		s.apiKeys[id] = k
+	}
+	return nil
+}
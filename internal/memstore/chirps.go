@@ -0,0 +1,129 @@
+package memstore
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"slices"
+	"time"
+
+	"github.com/dev-perry/go-server/internal/database"
+	"github.com/google/uuid"
+)
+
+func (s *Store) CreateChirp(ctx context.Context, arg database.CreateChirpParams) (database.Chirp, error) {
+	defer s.lock()()
+
+	if _, ok := s.users[arg.UserID]; !ok {
+		return database.Chirp{}, foreignKeyViolation("chirps", "chirps_user_id_fkey")
+	}
+	ts := now()
+	c := database.Chirp{
+		ID:        uuid.New(),
+		CreatedAt: ts,
+		UpdatedAt: ts,
+		Body:      arg.Body,
+		UserID:    arg.UserID,
+	}
+	s.chirps = append(s.chirps, c)
+	return c, nil
+}
+
+func (s *Store) GetChirp(ctx context.Context, id uuid.UUID) (database.Chirp, error) {
+	defer s.rlock()()
+
+	for _, c := range s.chirps {
+		if c.ID == id {
+			return c, nil
+		}
+	}
+	return database.Chirp{}, sql.ErrNoRows
+}
+
+func (s *Store) ListChirpsAsc(ctx context.Context, arg database.ListChirpsAscParams) ([]database.Chirp, error) {
+	return s.listChirps(arg, 1), nil
+}
+
+func (s *Store) ListChirpsDesc(ctx context.Context, arg database.ListChirpsDescParams) ([]database.Chirp, error) {
+	return s.listChirps(database.ListChirpsAscParams(arg), -1), nil
+}
+
+// listChirps applies the keyset filter shared by ListChirpsAsc and
+// ListChirpsDesc; direction is 1 for ascending and -1 for descending.
+func (s *Store) listChirps(arg database.ListChirpsAscParams, direction int) []database.Chirp {
+	defer s.rlock()()
+
+	var items []database.Chirp
+	for _, c := range s.chirps {
+		if arg.AuthorID.Valid && c.UserID != arg.AuthorID.UUID {
+			continue
+		}
+		if arg.AfterCreatedAt.Valid && compareChirpKey(c, arg.AfterCreatedAt.Time, arg.AfterID.UUID)*direction <= 0 {
+			continue
+		}
+		items = append(items, c)
+	}
+	slices.SortFunc(items, func(a, b database.Chirp) int {
+		return compareChirpKey(a, b.CreatedAt, b.ID) * direction
+	})
+	if len(items) > int(arg.PageSize) {
+		items = items[:arg.PageSize]
+	}
+	return items
+}
+
+// compareChirpKey orders chirps by (created_at, id) the way Postgres compares
+// the row values; uuids compare byte-wise.
+func compareChirpKey(c database.Chirp, createdAt time.Time, id uuid.UUID) int {
+	if cmp := c.CreatedAt.Compare(createdAt); cmp != 0 {
+		return cmp
+	}
+	return bytes.Compare(c.ID[:], id[:])
+}
+
+func (s *Store) DeleteAllChirps(ctx context.Context) error {
+	defer s.lock()()
+
+	s.chirps = nil
+	return nil
+}
+
+func (s *Store) IsChirpAuthor(ctx context.Context, arg database.IsChirpAuthorParams) (database.IsChirpAuthorRow, error) {
+	defer s.rlock()()
+
+	for _, c := range s.chirps {
+		if c.ID == arg.ID {
+			return database.IsChirpAuthorRow{
+				ID:       c.ID,
+				IsAuthor: c.UserID == arg.UserID,
+			}, nil
+		}
+	}
+	return database.IsChirpAuthorRow{}, sql.ErrNoRows
+}
+
+func (s *Store) DeleteChirp(ctx context.Context, arg database.DeleteChirpParams) error {
+	defer s.lock()()
+
+	kept := s.chirps[:0]
+	for _, c := range s.chirps {
+		if c.ID == arg.ID && c.UserID == arg.UserID {
+			continue
+		}
+		kept = append(kept, c)
+	}
+	s.chirps = kept
+	return nil
+}
+
+func (s *Store) RemoveChirp(ctx context.Context, id uuid.UUID) (uuid.UUID, error) {
+	defer s.lock()()
+
+	for i, c := range s.chirps {
+		if c.ID == id {
+			s.chirps = append(s.chirps[:i], s.chirps[i+1:]...)
+			return c.UserID, nil
+		}
+	}
+	return uuid.Nil, sql.ErrNoRows
+}
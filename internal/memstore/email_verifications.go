@@ -0,0 +1,65 @@
+package memstore
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/dev-perry/go-server/internal/database"
+	"github.com/google/uuid"
+)
+
+func (s *Store) CreateEmailVerificationToken(ctx context.Context, arg database.CreateEmailVerificationTokenParams) error {
+	defer s.lock()()
+
+	if _, ok := s.emailVerifications[arg.ID]; ok {
+		return uniqueViolation("email_verification_tokens_pkey")
+	}
+	if _, ok := s.users[arg.UserID]; !ok {
+		return foreignKeyViolation("email_verification_tokens", "email_verification_tokens_user_id_fkey")
+	}
+	s.emailVerifications[arg.ID] = database.EmailVerificationToken{
+		ID:        arg.ID,
+		CreatedAt: now(),
+		UserID:    arg.UserID,
+		Email:     arg.Email,
+		TokenHash: arg.TokenHash,
+		ExpiresAt: arg.ExpiresAt,
+	}
+	return nil
+}
+
+func (s *Store) GetEmailVerificationToken(ctx context.Context, id string) (database.EmailVerificationToken, error) {
+	defer s.rlock()()
+
+	t, ok := s.emailVerifications[id]
+	if !ok {
+		return database.EmailVerificationToken{}, sql.ErrNoRows
+	}
+	return t, nil
+}
+
+func (s *Store) UseEmailVerificationToken(ctx context.Context, id string) (int64, error) {
+	defer s.lock()()
+
+	t, ok := s.emailVerifications[id]
+	ts := now()
+	if !ok || t.UsedAt.Valid || !t.ExpiresAt.After(ts) {
+		return 0, nil
+	}
+	t.UsedAt = sql.NullTime{Time: ts, Valid: true}
+	s.emailVerifications[id] = t
+	return 1, nil
+}
+
+func (s *Store) InvalidateEmailVerificationTokens(ctx context.Context, userID uuid.UUID) error {
+	defer s.lock()()
+
+	ts := now()
+	for id, t := range s.emailVerifications {
+		if t.UserID == userID && !t.UsedAt.Valid {
+			t.UsedAt = sql.NullTime{Time: ts, Valid: true}
+			s.emailVerifications[id] = t
+		}
+	}
+	return nil
+}
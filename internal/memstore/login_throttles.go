@@ -0,0 +1,52 @@
+package memstore
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/dev-perry/go-server/internal/database"
+)
+
+func (s *Store) GetLoginThrottle(ctx context.Context, key string) (database.LoginThrottle, error) {
+	defer s.rlock()()
+
+	t, ok := s.loginThrottles[key]
+	if !ok {
+		return database.LoginThrottle{}, sql.ErrNoRows
+	}
+	return t, nil
+}
+
+func (s *Store) RecordLoginFailure(ctx context.Context, arg database.RecordLoginFailureParams) (int32, error) {
+	defer s.lock()()
+
+	ts := now()
+	t, ok := s.loginThrottles[arg.Key]
+	if !ok || t.LastFailureAt.Before(arg.ResetBefore) {
+		t = database.LoginThrottle{Key: arg.Key, LockedUntil: t.LockedUntil}
+	}
+	t.Failures++
+	t.LastFailureAt = ts
+	s.loginThrottles[arg.Key] = t
+	return t.Failures, nil
+}
+
+func (s *Store) LockLoginThrottle(ctx context.Context, arg database.LockLoginThrottleParams) error {
+	defer s.lock()()
+
+	if t, ok := s.loginThrottles[arg.Key]; ok {
+		t.LockedUntil = arg.LockedUntil
+		s.loginThrottles[arg.Key] = t
+	}
+	return nil
+}
+
+func (s *Store) DeleteLoginThrottle(ctx context.Context, key string) (int64, error) {
+	defer s.lock()()
+
+	if _, ok := s.loginThrottles[key]; !ok {
+		return 0, nil
+	}
+	delete(s.loginThrottles, key)
+	return 1, nil
+}
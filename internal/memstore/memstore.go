@@ -0,0 +1,182 @@
+// Package memstore is an in-process implementation of database.Querier. It
+// mirrors the constraints declared in sql/schema so the API behaves the same
+// with or without Postgres.
+package memstore
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/dev-perry/go-server/internal/database"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+type Store struct {
+	*tables
+	// inTx is set on the view of the store ExecTx passes to its callback,
+	// which already holds txMu.
+	inTx bool
+}
+
+type tables struct {
+	// txMu is held by an open transaction, and shared by queries outside
+	// one, so those wait for it to commit or roll back. mu guards the data
+	// itself.
+	txMu          sync.RWMutex
+	mu            sync.RWMutex
+	users         map[uuid.UUID]database.User
+	chirps        []database.Chirp
+	refreshTokens map[string]database.RefreshToken
+	// passwordResets is keyed by token ID.
+	passwordResets map[string]database.PasswordResetToken
+	// emailVerifications is keyed by token ID.
+	emailVerifications map[string]database.EmailVerificationToken
+	recoveryCodes      map[uuid.UUID]database.RecoveryCode
+	// mfaChallenges is keyed by challenge ID.
+	mfaChallenges map[string]database.MfaChallenge
+	// loginThrottles is keyed by throttle key and, like the table, doesn't
+	// reference users.
+	loginThrottles map[string]database.LoginThrottle
+	// apiKeys is keyed by key ID.
+	apiKeys map[string]database.ApiKey
+	// oauthClients is keyed by client ID.
+	oauthClients map[string]database.OauthClient
+	// oauthCodes is keyed by authorization code ID.
+	oauthCodes map[string]database.OauthAuthorizationCode
+	// oidcLogins is keyed by login ID and, like the table, doesn't reference
+	// users.
+	oidcLogins     map[string]database.OidcLogin
+	userIdentities map[identityKey]database.UserIdentity
+	// securityEvents is append-only, so insertion order is created_at order.
+	securityEvents []database.SecurityEvent
+}
+
+var _ database.Store = (*Store)(nil)
+
+func New() *Store {
+	return &Store{tables: &tables{
+		users:              map[uuid.UUID]database.User{},
+		refreshTokens:      map[string]database.RefreshToken{},
+		passwordResets:     map[string]database.PasswordResetToken{},
+		emailVerifications: map[string]database.EmailVerificationToken{},
+		recoveryCodes:      map[uuid.UUID]database.RecoveryCode{},
+		mfaChallenges:      map[string]database.MfaChallenge{},
+		loginThrottles:     map[string]database.LoginThrottle{},
+		apiKeys:            map[string]database.ApiKey{},
+		oauthClients:       map[string]database.OauthClient{},
+		oauthCodes:         map[string]database.OauthAuthorizationCode{},
+		oidcLogins:         map[string]database.OidcLogin{},
+		userIdentities:     map[identityKey]database.UserIdentity{},
+	}}
+}
+
+// lock takes the write lock for a query.
+func (s *Store) lock() (unlock func()) {
+	if s.inTx {
+		s.mu.Lock()
+		return s.mu.Unlock
+	}
+	s.txMu.RLock()
+	s.mu.Lock()
+	return func() {
+		s.mu.Unlock()
+		s.txMu.RUnlock()
+	}
+}
+
+// rlock takes the read lock for a query.
+func (s *Store) rlock() (unlock func()) {
+	if s.inTx {
+		s.mu.RLock()
+		return s.mu.RUnlock
+	}
+	s.txMu.RLock()
+	s.mu.RLock()
+	return func() {
+		s.mu.RUnlock()
+		s.txMu.RUnlock()
+	}
+}
+
+// ExecTx runs fn against the store and restores the previous contents if it
+// fails. Transactions run one at a time, and other queries wait until the
+// open one is over, so nothing they write is lost to a rollback and nothing
+// uncommitted is read. fn must only use the Querier it is given.
+func (s *Store) ExecTx(ctx context.Context, fn func(database.Querier) error) error {
+	s.txMu.Lock()
+	defer s.txMu.Unlock()
+
+	s.mu.RLock()
+	users := maps.Clone(s.users)
+	chirps := slices.Clone(s.chirps)
+	refreshTokens := maps.Clone(s.refreshTokens)
+	passwordResets := maps.Clone(s.passwordResets)
+	emailVerifications := maps.Clone(s.emailVerifications)
+	recoveryCodes := maps.Clone(s.recoveryCodes)
+	mfaChallenges := maps.Clone(s.mfaChallenges)
+	loginThrottles := maps.Clone(s.loginThrottles)
+	apiKeys := maps.Clone(s.apiKeys)
+	oauthClients := maps.Clone(s.oauthClients)
+	oauthCodes := maps.Clone(s.oauthCodes)
+	oidcLogins := maps.Clone(s.oidcLogins)
+	userIdentities := maps.Clone(s.userIdentities)
+	securityEvents := slices.Clone(s.securityEvents)
+	s.mu.RUnlock()
+
+	if err := fn(&Store{tables: s.tables, inTx: true}); err != nil {
+		s.mu.Lock()
+		s.users = users
+		s.chirps = chirps
+		s.refreshTokens = refreshTokens
+		s.passwordResets = passwordResets
+		s.emailVerifications = emailVerifications
+		s.recoveryCodes = recoveryCodes
+		s.mfaChallenges = mfaChallenges
+		s.loginThrottles = loginThrottles
+		s.apiKeys = apiKeys
+		s.oauthClients = oauthClients
+		s.oauthCodes = oauthCodes
+		s.oidcLogins = oidcLogins
+		s.userIdentities = userIdentities
+		s.securityEvents = securityEvents
+		s.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// now matches what lib/pq hands back for a `timestamp` column filled by now().
+func now() time.Time {
+	return time.Now().UTC()
+}
+
+func uniqueViolation(constraint string) error {
+	return &pq.Error{
+		Code:       "23505",
+		Message:    fmt.Sprintf("duplicate key value violates unique constraint \"%s\"", constraint),
+		Constraint: constraint,
+	}
+}
+
+func checkViolation(table, constraint string) error {
+	return &pq.Error{
+		Code:       "23514",
+		Message:    fmt.Sprintf("new row for relation \"%s\" violates check constraint \"%s\"", table, constraint),
+		Table:      table,
+		Constraint: constraint,
+	}
+}
+
+func foreignKeyViolation(table, constraint string) error {
+	return &pq.Error{
+		Code:       "23503",
+		Message:    fmt.Sprintf("insert or update on table \"%s\" violates foreign key constraint \"%s\"", table, constraint),
+		Table:      table,
+		Constraint: constraint,
+	}
+}
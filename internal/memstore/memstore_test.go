@@ -0,0 +1,234 @@
+package memstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dev-perry/go-server/internal/database"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+func TestCreateUserRejectsDuplicateEmail(t *testing.T) {
+	ctx := context.Background()
+	s := New()
+
+	params := database.CreateUserParams{Email: "a@example.com", HashedPassword: "x"}
+	if _, err := s.CreateUser(ctx, params); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+
+	_, err := s.CreateUser(ctx, database.CreateUserParams{Email: "A@Example.com", HashedPassword: "x"})
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) || pqErr.Code != "23505" {
+		t.Fatalf("expected unique violation, got %v", err)
+	}
+}
+
+func TestDeleteAllUsersCascades(t *testing.T) {
+	ctx := context.Background()
+	s := New()
+
+	user, _ := s.CreateUser(ctx, database.CreateUserParams{Email: "a@example.com", HashedPassword: "x"})
+	s.CreateChirp(ctx, database.CreateChirpParams{Body: "hello", UserID: user.ID})
+	s.CreateRefreshToken(ctx, database.CreateRefreshTokenParams{Token: "t", UserID: user.ID, ExpiresAt: time.Now().Add(time.Hour)})
+	s.CreateUserIdentity(ctx, database.CreateUserIdentityParams{Issuer: "idp", Subject: "sub", UserID: user.ID})
+
+	if err := s.DeleteAllUsers(ctx); err != nil {
+		t.Fatalf("DeleteAllUsers failed: %v", err)
+	}
+
+	chirps, _ := s.ListChirpsAsc(ctx, database.ListChirpsAscParams{PageSize: 10})
+	if len(chirps) != 0 {
+		t.Errorf("expected chirps to be deleted, got %d", len(chirps))
+	}
+	if _, err := s.GetRefreshToken(ctx, "t"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected refresh token to be deleted, got %v", err)
+	}
+	identity := database.GetUserByIdentityParams{Issuer: "idp", Subject: "sub"}
+	if _, err := s.GetUserByIdentity(ctx, identity); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected identity to be deleted, got %v", err)
+	}
+}
+
+func TestRevokeRefreshTokenFamily(t *testing.T) {
+	ctx := context.Background()
+	s := New()
+
+	user, _ := s.CreateUser(ctx, database.CreateUserParams{Email: "a@example.com", HashedPassword: "x"})
+	family, other := uuid.New(), uuid.New()
+	expires := time.Now().Add(time.Hour)
+	s.CreateRefreshToken(ctx, database.CreateRefreshTokenParams{Token: "a", UserID: user.ID, ExpiresAt: expires, FamilyID: family})
+	s.CreateRefreshToken(ctx, database.CreateRefreshTokenParams{Token: "b", UserID: user.ID, ExpiresAt: expires, FamilyID: family})
+	s.CreateRefreshToken(ctx, database.CreateRefreshTokenParams{Token: "c", UserID: user.ID, ExpiresAt: expires, FamilyID: other})
+
+	if _, err := s.RotateRefreshToken(ctx, "a"); err != nil {
+		t.Fatalf("RotateRefreshToken failed: %v", err)
+	}
+	if _, err := s.RotateRefreshToken(ctx, "a"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected a retired token not to rotate twice, got %v", err)
+	}
+
+	s.RevokeRefreshTokenFamily(ctx, family)
+	for token, wantRevoked := range map[string]bool{"a": true, "b": true, "c": false} {
+		got, _ := s.GetRefreshToken(ctx, token)
+		if got.RevokedAt.Valid != wantRevoked {
+			t.Errorf("token %s: expected revoked=%v, got %v", token, wantRevoked, got.RevokedAt.Valid)
+		}
+	}
+}
+
+func TestCreateChirpRequiresUser(t *testing.T) {
+	ctx := context.Background()
+	s := New()
+
+	_, err := s.CreateChirp(ctx, database.CreateChirpParams{Body: "hello"})
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) || pqErr.Code != "23503" {
+		t.Fatalf("expected foreign key violation, got %v", err)
+	}
+}
+
+func TestExecTxRollsBackOnError(t *testing.T) {
+	ctx := context.Background()
+	s := New()
+	user, _ := s.CreateUser(ctx, database.CreateUserParams{Email: "a@example.com", HashedPassword: "x"})
+	s.CreateRefreshToken(ctx, database.CreateRefreshTokenParams{Token: "t", UserID: user.ID, ExpiresAt: time.Now().Add(time.Hour)})
+
+	failure := errors.New("boom")
+	err := s.ExecTx(ctx, func(q database.Querier) error {
+		if _, err := q.BumpTokenVersion(ctx, user.ID); err != nil {
+			return err
+		}
+		if err := q.RevokeAllUserRefreshTokens(ctx, user.ID); err != nil {
+			return err
+		}
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Fatalf("expected the callback's error, got %v", err)
+	}
+
+	state, _ := s.GetUserAuthState(ctx, user.ID)
+	if state.TokenVersion != 0 {
+		t.Errorf("expected token version to be rolled back, got %d", state.TokenVersion)
+	}
+	token, _ := s.GetRefreshToken(ctx, "t")
+	if token.RevokedAt.Valid {
+		t.Error("expected refresh token revocation to be rolled back")
+	}
+}
+
+func TestExecTxRollbackKeepsConcurrentWrites(t *testing.T) {
+	ctx := context.Background()
+	s := New()
+
+	open, release := make(chan struct{}), make(chan struct{})
+	txDone := make(chan error)
+	go func() {
+		txDone <- s.ExecTx(ctx, func(q database.Querier) error {
+			if _, err := q.CreateUser(ctx, database.CreateUserParams{Email: "a@example.com", HashedPassword: "x"}); err != nil {
+				return err
+			}
+			close(open)
+			<-release
+			return errors.New("boom")
+		})
+	}()
+	<-open
+
+	created := make(chan error)
+	go func() {
+		_, err := s.CreateUser(ctx, database.CreateUserParams{Email: "b@example.com", HashedPassword: "x"})
+		created <- err
+	}()
+	select {
+	case <-created:
+		t.Fatal("expected the write to wait for the open transaction")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	<-txDone
+	if err := <-created; err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+
+	if _, err := s.GetUserCredsByEmail(ctx, "a@example.com"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected the transaction's user to be rolled back, got %v", err)
+	}
+	if _, err := s.GetUserCredsByEmail(ctx, "b@example.com"); err != nil {
+		t.Errorf("expected the concurrent write to survive the rollback, got %v", err)
+	}
+}
+
+func TestRecordLoginFailureResetsAfterWindow(t *testing.T) {
+	ctx := context.Background()
+	s := New()
+	past := time.Now().UTC().Add(-time.Hour)
+
+	for want := int32(1); want <= 3; want++ {
+		got, err := s.RecordLoginFailure(ctx, database.RecordLoginFailureParams{Key: "ip:127.0.0.1", ResetBefore: past})
+		if err != nil || got != want {
+			t.Fatalf("RecordLoginFailure = %d, %v; want %d", got, err, want)
+		}
+	}
+	got, err := s.RecordLoginFailure(ctx, database.RecordLoginFailureParams{Key: "ip:127.0.0.1", ResetBefore: time.Now().UTC().Add(time.Second)})
+	if err != nil || got != 1 {
+		t.Fatalf("expected the count to start over, got %d, %v", got, err)
+	}
+}
+
+func TestDeleteOAuthClientCascades(t *testing.T) {
+	ctx := context.Background()
+	s := New()
+
+	user, _ := s.CreateUser(ctx, database.CreateUserParams{Email: "a@example.com", HashedPassword: "x"})
+	s.CreateOAuthClient(ctx, database.CreateOAuthClientParams{ID: "client", UserID: user.ID, Name: "app"})
+	s.CreateOAuthAuthorizationCode(ctx, database.CreateOAuthAuthorizationCodeParams{ID: "code", ClientID: "client", UserID: user.ID})
+	expires := time.Now().Add(time.Hour)
+	s.CreateRefreshToken(ctx, database.CreateRefreshTokenParams{Token: "app", UserID: user.ID, ExpiresAt: expires, ClientID: sql.NullString{String: "client", Valid: true}})
+	s.CreateRefreshToken(ctx, database.CreateRefreshTokenParams{Token: "session", UserID: user.ID, ExpiresAt: expires})
+
+	_, err := s.CreateRefreshToken(ctx, database.CreateRefreshTokenParams{Token: "x", UserID: user.ID, ExpiresAt: expires, ClientID: sql.NullString{String: "nope", Valid: true}})
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) || pqErr.Code != "23503" {
+		t.Fatalf("expected foreign key violation, got %v", err)
+	}
+
+	if n, _ := s.DeleteOAuthClient(ctx, database.DeleteOAuthClientParams{ID: "client", UserID: uuid.New()}); n != 0 {
+		t.Fatalf("expected another user's delete to do nothing, got %d", n)
+	}
+	if n, _ := s.DeleteOAuthClient(ctx, database.DeleteOAuthClientParams{ID: "client", UserID: user.ID}); n != 1 {
+		t.Fatalf("expected the client to be deleted, got %d", n)
+	}
+	if _, err := s.GetOAuthAuthorizationCode(ctx, "code"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected authorization code to be deleted, got %v", err)
+	}
+	if _, err := s.GetRefreshToken(ctx, "app"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected the client's refresh token to be deleted, got %v", err)
+	}
+	if _, err := s.GetRefreshToken(ctx, "session"); err != nil {
+		t.Errorf("expected other refresh tokens to be kept, got %v", err)
+	}
+}
+
+func TestSetUserRoleChecksRole(t *testing.T) {
+	ctx := context.Background()
+	s := New()
+
+	user, _ := s.CreateUser(ctx, database.CreateUserParams{Email: "a@example.com", HashedPassword: "x"})
+	if user.Role != "user" {
+		t.Errorf("expected new users to have role user, got %q", user.Role)
+	}
+	_, err := s.SetUserRole(ctx, database.SetUserRoleParams{ID: user.ID, Role: "root"})
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) || pqErr.Code != "23514" {
+		t.Errorf("expected a check violation, got %v", err)
+	}
+	if n, _ := s.SetUserRole(ctx, database.SetUserRoleParams{ID: uuid.New(), Role: "admin"}); n != 0 {
+		t.Errorf("expected no rows for an unknown user, got %d", n)
+	}
+}
@@ -0,0 +1,172 @@
+package memstore
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/dev-perry/go-server/internal/database"
+	"github.com/google/uuid"
+)
+
+func (s *Store) GetUserTOTP(ctx context.Context, id uuid.UUID) (database.GetUserTOTPRow, error) {
+	defer s.rlock()()
+
+	u, ok := s.users[id]
+	if !ok {
+		return database.GetUserTOTPRow{}, sql.ErrNoRows
+	}
+	return database.GetUserTOTPRow{
+		Email:         u.Email,
+		TotpSecret:    u.TotpSecret,
+		TotpEnabledAt: u.TotpEnabledAt,
+		TotpLastStep:  u.TotpLastStep,
+	}, nil
+}
+
+func (s *Store) SetTOTPSecret(ctx context.Context, arg database.SetTOTPSecretParams) error {
+	defer s.lock()()
+
+	if u, ok := s.users[arg.ID]; ok {
+		u.TotpSecret = arg.TotpSecret
+		u.TotpEnabledAt = sql.NullTime{}
+		u.TotpLastStep = sql.NullInt64{}
+		u.UpdatedAt = now()
+		s.users[arg.ID] = u
+	}
+	return nil
+}
+
+func (s *Store) EnableTOTP(ctx context.Context, arg database.EnableTOTPParams) (int64, error) {
+	defer s.lock()()
+
+	u, ok := s.users[arg.ID]
+	if !ok || !u.TotpSecret.Valid || u.TotpEnabledAt.Valid {
+		return 0, nil
+	}
+	ts := now()
+	u.TotpEnabledAt = sql.NullTime{Time: ts, Valid: true}
+	u.TotpLastStep = sql.NullInt64{Int64: arg.Step, Valid: true}
+	u.UpdatedAt = ts
+	s.users[arg.ID] = u
+	return 1, nil
+}
+
+func (s *Store) DisableTOTP(ctx context.Context, id uuid.UUID) error {
+	defer s.lock()()
+
+	if u, ok := s.users[id]; ok {
+		u.TotpSecret = sql.NullString{}
+		u.TotpEnabledAt = sql.NullTime{}
+		u.TotpLastStep = sql.NullInt64{}
+		u.UpdatedAt = now()
+		s.users[id] = u
+	}
+	return nil
+}
+
+func (s *Store) UseTOTPStep(ctx context.Context, arg database.UseTOTPStepParams) (int64, error) {
+	defer s.lock()()
+
+	u, ok := s.users[arg.ID]
+	if !ok || (u.TotpLastStep.Valid && u.TotpLastStep.Int64 >= arg.Step) {
+		return 0, nil
+	}
+	u.TotpLastStep = sql.NullInt64{Int64: arg.Step, Valid: true}
+	s.users[arg.ID] = u
+	return 1, nil
+}
+
+func (s *Store) CreateRecoveryCode(ctx context.Context, arg database.CreateRecoveryCodeParams) error {
+	defer s.lock()()
+
+	if _, ok := s.users[arg.UserID]; !ok {
+		return foreignKeyViolation("recovery_codes", "recovery_codes_user_id_fkey")
+	}
+	c := database.RecoveryCode{
+		ID:        uuid.New(),
+		CreatedAt: now(),
+		UserID:    arg.UserID,
+		CodeHash:  arg.CodeHash,
+	}
+	s.recoveryCodes[c.ID] = c
+	return nil
+}
+
+func (s *Store) DeleteRecoveryCodes(ctx context.Context, userID uuid.UUID) error {
+	defer s.lock()()
+
+	for id, c := range s.recoveryCodes {
+		if c.UserID == userID {
+			delete(s.recoveryCodes, id)
+		}
+	}
+	return nil
+}
+
+func (s *Store) UseRecoveryCode(ctx context.Context, arg database.UseRecoveryCodeParams) (int64, error) {
+	defer s.lock()()
+
+	var used int64
+	for id, c := range s.recoveryCodes {
+		if c.UserID == arg.UserID && c.CodeHash == arg.CodeHash && !c.UsedAt.Valid {
+			c.UsedAt = sql.NullTime{Time: now(), Valid: true}
+			s.recoveryCodes[id] = c
+			used++
+		}
+	}
+	return used, nil
+}
+
+func (s *Store) CreateMFAChallenge(ctx context.Context, arg database.CreateMFAChallengeParams) error {
+	defer s.lock()()
+
+	if _, ok := s.mfaChallenges[arg.ID]; ok {
+		return uniqueViolation("mfa_challenges_pkey")
+	}
+	if _, ok := s.users[arg.UserID]; !ok {
+		return foreignKeyViolation("mfa_challenges", "mfa_challenges_user_id_fkey")
+	}
+	s.mfaChallenges[arg.ID] = database.MfaChallenge{
+		ID:        arg.ID,
+		CreatedAt: now(),
+		UserID:    arg.UserID,
+		TokenHash: arg.TokenHash,
+		ExpiresAt: arg.ExpiresAt,
+	}
+	return nil
+}
+
+func (s *Store) GetMFAChallenge(ctx context.Context, id string) (database.MfaChallenge, error) {
+	defer s.rlock()()
+
+	c, ok := s.mfaChallenges[id]
+	if !ok {
+		return database.MfaChallenge{}, sql.ErrNoRows
+	}
+	return c, nil
+}
+
+func (s *Store) RecordMFAChallengeAttempt(ctx context.Context, id string) (int32, error) {
+	defer s.lock()()
+
+	c, ok := s.mfaChallenges[id]
+	if !ok {
+		return 0, sql.ErrNoRows
+	}
+	c.Attempts++
+	s.mfaChallenges[id] = c
+	return c.Attempts, nil
+}
+
+func (s *Store) UseMFAChallenge(ctx context.Context, id string) (int64, error) {
+	defer s.lock()()
+
+	c, ok := s.mfaChallenges[id]
+	ts := now()
+	if !ok || c.UsedAt.Valid || !c.ExpiresAt.After(ts) {
+		return 0, nil
+	}
+	c.UsedAt = sql.NullTime{Time: ts, Valid: true}
+	s.mfaChallenges[id] = c
+	return 1, nil
+}
@@ -0,0 +1,143 @@
+package memstore
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"slices"
+
+	"github.com/dev-perry/go-server/internal/database"
+	"github.com/google/uuid"
+)
+
+func (s *Store) CreateOAuthAuthorizationCode(ctx context.Context, arg database.CreateOAuthAuthorizationCodeParams) (database.OauthAuthorizationCode, error) {
+	defer s.lock()()
+
+	if _, ok := s.oauthCodes[arg.ID]; ok {
+		return database.OauthAuthorizationCode{}, uniqueViolation("oauth_authorization_codes_pkey")
+	}
+	if _, ok := s.oauthClients[arg.ClientID]; !ok {
+		return database.OauthAuthorizationCode{}, foreignKeyViolation("oauth_authorization_codes", "oauth_authorization_codes_client_id_fkey")
+	}
+	if _, ok := s.users[arg.UserID]; !ok {
+		return database.OauthAuthorizationCode{}, foreignKeyViolation("oauth_authorization_codes", "oauth_authorization_codes_user_id_fkey")
+	}
+	c := database.OauthAuthorizationCode{
+		ID:            arg.ID,
+		CreatedAt:     now(),
+		CodeHash:      arg.CodeHash,
+		ClientID:      arg.ClientID,
+		UserID:        arg.UserID,
+		RedirectUri:   arg.RedirectUri,
+		Scopes:        slices.Clone(arg.Scopes),
+		CodeChallenge: arg.CodeChallenge,
+		FamilyID:      arg.FamilyID,
+		ExpiresAt:     arg.ExpiresAt,
+	}
+	s.oauthCodes[c.ID] = c
+	return c, nil
+}
+
+func (s *Store) CreateOAuthClient(ctx context.Context, arg database.CreateOAuthClientParams) (database.OauthClient, error) {
+	defer s.lock()()
+
+	if _, ok := s.oauthClients[arg.ID]; ok {
+		return database.OauthClient{}, uniqueViolation("oauth_clients_pkey")
+	}
+	if _, ok := s.users[arg.UserID]; !ok {
+		return database.OauthClient{}, foreignKeyViolation("oauth_clients", "oauth_clients_user_id_fkey")
+	}
+	c := database.OauthClient{
+		ID:           arg.ID,
+		CreatedAt:    now(),
+		UserID:       arg.UserID,
+		Name:         arg.Name,
+		SecretHash:   arg.SecretHash,
+		RedirectUris: slices.Clone(arg.RedirectUris),
+	}
+	s.oauthClients[c.ID] = c
+	return c, nil
+}
+
+// DeleteOAuthClient cascades to the client's authorization codes and refresh
+// tokens, as the foreign keys do.
+func (s *Store) DeleteOAuthClient(ctx context.Context, arg database.DeleteOAuthClientParams) (int64, error) {
+	defer s.lock()()
+
+	c, ok := s.oauthClients[arg.ID]
+	if !ok || c.UserID != arg.UserID {
+		return 0, nil
+	}
+	delete(s.oauthClients, arg.ID)
+	for id, code := range s.oauthCodes {
+		if code.ClientID == arg.ID {
+			delete(s.oauthCodes, id)
+		}
+	}
+	for token, t := range s.refreshTokens {
+		if t.ClientID.Valid && t.ClientID.String == arg.ID {
+			delete(s.refreshTokens, token)
+		}
+	}
+	return 1, nil
+}
+
+func (s *Store) GetOAuthAuthorizationCode(ctx context.Context, id string) (database.OauthAuthorizationCode, error) {
+	defer s.rlock()()
+
+	c, ok := s.oauthCodes[id]
+	if !ok {
+		return database.OauthAuthorizationCode{}, sql.ErrNoRows
+	}
+	c.Scopes = slices.Clone(c.Scopes)
+	return c, nil
+}
+
+func (s *Store) GetOAuthClient(ctx context.Context, id string) (database.OauthClient, error) {
+	defer s.rlock()()
+
+	c, ok := s.oauthClients[id]
+	if !ok {
+		return database.OauthClient{}, sql.ErrNoRows
+	}
+	c.RedirectUris = slices.Clone(c.RedirectUris)
+	return c, nil
+}
+
+func (s *Store) ListOAuthClients(ctx context.Context, userID uuid.UUID) ([]database.OauthClient, error) {
+	defer s.rlock()()
+
+	var clients []database.OauthClient
+	for _, c := range s.oauthClients {
+		if c.UserID == userID {
+			c.RedirectUris = slices.Clone(c.RedirectUris)
+			clients = append(clients, c)
+		}
+	}
+	slices.SortFunc(clients, func(a, b database.OauthClient) int {
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), cmp.Compare(a.ID, b.ID))
+	})
+	return clients, nil
+}
+
+func (s *Store) RehashOAuthClientSecret(ctx context.Context, arg database.RehashOAuthClientSecretParams) error {
+	defer s.lock()()
+
+	if c, ok := s.oauthClients[arg.ID]; ok && c.SecretHash.Valid && c.SecretHash.String == arg.OldHash {
+		c.SecretHash = sql.NullString{String: arg.NewHash, Valid: true}
+		s.oauthClients[arg.ID] = c
+	}
+	return nil
+}
+
+func (s *Store) UseOAuthAuthorizationCode(ctx context.Context, id string) (int64, error) {
+	defer s.lock()()
+
+	c, ok := s.oauthCodes[id]
+	if !ok || c.UsedAt.Valid {
+		return 0, nil
+	}
+	c.UsedAt = sql.NullTime{Time: now(), Valid: true}
+	s.oauthCodes[id] = c
+	return 1, nil
+}
@@ -0,0 +1,112 @@
+package memstore
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/dev-perry/go-server/internal/database"
+)
+
+// identityKey is the user_identities primary key.
+type identityKey struct {
+	issuer  string
+	subject string
+}
+
+func (s *Store) CreateOIDCLogin(ctx context.Context, arg database.CreateOIDCLoginParams) error {
+	defer s.lock()()
+
+	if _, ok := s.oidcLogins[arg.ID]; ok {
+		return uniqueViolation("oidc_logins_pkey")
+	}
+	s.oidcLogins[arg.ID] = database.OidcLogin{
+		ID:           arg.ID,
+		CreatedAt:    now(),
+		StateHash:    arg.StateHash,
+		Nonce:        arg.Nonce,
+		CodeVerifier: arg.CodeVerifier,
+		ExpiresAt:    arg.ExpiresAt,
+	}
+	return nil
+}
+
+func (s *Store) CreateUserIdentity(ctx context.Context, arg database.CreateUserIdentityParams) error {
+	defer s.lock()()
+
+	key := identityKey{issuer: arg.Issuer, subject: arg.Subject}
+	if _, ok := s.userIdentities[key]; ok {
+		return uniqueViolation("user_identities_pkey")
+	}
+	if _, ok := s.users[arg.UserID]; !ok {
+		return foreignKeyViolation("user_identities", "user_identities_user_id_fkey")
+	}
+	ts := now()
+	s.userIdentities[key] = database.UserIdentity{
+		Issuer:      arg.Issuer,
+		Subject:     arg.Subject,
+		CreatedAt:   ts,
+		LastLoginAt: ts,
+		UserID:      arg.UserID,
+		Email:       arg.Email,
+	}
+	return nil
+}
+
+func (s *Store) GetOIDCLogin(ctx context.Context, id string) (database.OidcLogin, error) {
+	defer s.rlock()()
+
+	l, ok := s.oidcLogins[id]
+	if !ok {
+		return database.OidcLogin{}, sql.ErrNoRows
+	}
+	return l, nil
+}
+
+func (s *Store) GetUserByIdentity(ctx context.Context, arg database.GetUserByIdentityParams) (database.GetUserByIdentityRow, error) {
+	defer s.rlock()()
+
+	identity, ok := s.userIdentities[identityKey{issuer: arg.Issuer, subject: arg.Subject}]
+	if !ok {
+		return database.GetUserByIdentityRow{}, sql.ErrNoRows
+	}
+	u := s.users[identity.UserID]
+	return database.GetUserByIdentityRow{
+		ID:              u.ID,
+		Email:           u.Email,
+		CreatedAt:       u.CreatedAt,
+		UpdatedAt:       u.UpdatedAt,
+		IsChirpyRed:     u.IsChirpyRed,
+		TokenVersion:    u.TokenVersion,
+		LockedAt:        u.LockedAt,
+		EmailVerifiedAt: u.EmailVerifiedAt,
+		TotpEnabledAt:   u.TotpEnabledAt,
+		Role:            u.Role,
+	}, nil
+}
+
+func (s *Store) TouchUserIdentity(ctx context.Context, arg database.TouchUserIdentityParams) error {
+	defer s.lock()()
+
+	key := identityKey{issuer: arg.Issuer, subject: arg.Subject}
+	identity, ok := s.userIdentities[key]
+	if !ok {
+		return nil
+	}
+	identity.LastLoginAt = now()
+	identity.Email = arg.Email
+	s.userIdentities[key] = identity
+	return nil
+}
+
+func (s *Store) UseOIDCLogin(ctx context.Context, id string) (int64, error) {
+	defer s.lock()()
+
+	l, ok := s.oidcLogins[id]
+	ts := now()
+	if !ok || l.UsedAt.Valid || !l.ExpiresAt.After(ts) {
+		return 0, nil
+	}
+	l.UsedAt = sql.NullTime{Time: ts, Valid: true}
+	s.oidcLogins[id] = l
+	return 1, nil
+}
@@ -0,0 +1,64 @@
+package memstore
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/dev-perry/go-server/internal/database"
+	"github.com/google/uuid"
+)
+
+func (s *Store) CreatePasswordResetToken(ctx context.Context, arg database.CreatePasswordResetTokenParams) error {
+	defer s.lock()()
+
+	if _, ok := s.passwordResets[arg.ID]; ok {
+		return uniqueViolation("password_reset_tokens_pkey")
+	}
+	if _, ok := s.users[arg.UserID]; !ok {
+		return foreignKeyViolation("password_reset_tokens", "password_reset_tokens_user_id_fkey")
+	}
+	s.passwordResets[arg.ID] = database.PasswordResetToken{
+		ID:        arg.ID,
+		CreatedAt: now(),
+		UserID:    arg.UserID,
+		TokenHash: arg.TokenHash,
+		ExpiresAt: arg.ExpiresAt,
+	}
+	return nil
+}
+
+func (s *Store) GetPasswordResetToken(ctx context.Context, id string) (database.PasswordResetToken, error) {
+	defer s.rlock()()
+
+	t, ok := s.passwordResets[id]
+	if !ok {
+		return database.PasswordResetToken{}, sql.ErrNoRows
+	}
+	return t, nil
+}
+
+func (s *Store) UsePasswordResetToken(ctx context.Context, id string) (int64, error) {
+	defer s.lock()()
+
+	t, ok := s.passwordResets[id]
+	ts := now()
+	if !ok || t.UsedAt.Valid || !t.ExpiresAt.After(ts) {
+		return 0, nil
+	}
+	t.UsedAt = sql.NullTime{Time: ts, Valid: true}
+	s.passwordResets[id] = t
+	return 1, nil
+}
+
+func (s *Store) InvalidatePasswordResetTokens(ctx context.Context, userID uuid.UUID) error {
+	defer s.lock()()
+
+	ts := now()
+	for id, t := range s.passwordResets {
+		if t.UserID == userID && !t.UsedAt.Valid {
+			t.UsedAt = sql.NullTime{Time: ts, Valid: true}
+			s.passwordResets[id] = t
+		}
+	}
+	return nil
+}
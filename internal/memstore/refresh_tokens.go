@@ -0,0 +1,196 @@
+package memstore
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"slices"
+	"time"
+
+	"github.com/dev-perry/go-server/internal/database"
+	"github.com/google/uuid"
+)
+
+func (s *Store) CreateRefreshToken(ctx context.Context, arg database.CreateRefreshTokenParams) (database.RefreshToken, error) {
+	defer s.lock()()
+
+	if _, ok := s.refreshTokens[arg.Token]; ok {
+		return database.RefreshToken{}, uniqueViolation("refresh_tokens_pkey")
+	}
+	if _, ok := s.users[arg.UserID]; !ok {
+		return database.RefreshToken{}, foreignKeyViolation("refresh_tokens", "refresh_tokens_user_id_fkey")
+	}
+	if _, ok := s.oauthClients[arg.ClientID.String]; arg.ClientID.Valid && !ok {
+		return database.RefreshToken{}, foreignKeyViolation("refresh_tokens", "refresh_tokens_client_id_fkey")
+	}
+	ts := now()
+	t := database.RefreshToken{
+		Token:      arg.Token,
+		CreatedAt:  ts,
+		UpdatedAt:  ts,
+		UserID:     arg.UserID,
+		ExpiresAt:  arg.ExpiresAt,
+		FamilyID:   arg.FamilyID,
+		TokenHash:  arg.TokenHash,
+		LastUsedAt: ts,
+		UserAgent:  arg.UserAgent,
+		IpAddress:  arg.IpAddress,
+		ClientID:   arg.ClientID,
+		Scopes:     slices.Clone(arg.Scopes),
+	}
+	s.refreshTokens[t.Token] = t
+	return t, nil
+}
+
+func (s *Store) GetRefreshToken(ctx context.Context, token string) (database.RefreshToken, error) {
+	defer s.rlock()()
+
+	t, ok := s.refreshTokens[token]
+	if !ok {
+		return database.RefreshToken{}, sql.ErrNoRows
+	}
+	t.Scopes = slices.Clone(t.Scopes)
+	return t, nil
+}
+
+func (s *Store) RevokeRefreshToken(ctx context.Context, token string) error {
+	defer s.lock()()
+
+	if t, ok := s.refreshTokens[token]; ok {
+		t.RevokedAt = sql.NullTime{Time: now(), Valid: true}
+		s.refreshTokens[token] = t
+	}
+	return nil
+}
+
+func (s *Store) RotateRefreshToken(ctx context.Context, token string) (database.RefreshToken, error) {
+	defer s.lock()()
+
+	t, ok := s.refreshTokens[token]
+	if !ok || t.RevokedAt.Valid {
+		return database.RefreshToken{}, sql.ErrNoRows
+	}
+	ts := now()
+	t.RevokedAt = sql.NullTime{Time: ts, Valid: true}
+	t.RotatedAt = sql.NullTime{Time: ts, Valid: true}
+	t.UpdatedAt = ts
+	s.refreshTokens[token] = t
+	return t, nil
+}
+
+func (s *Store) RevokeRefreshTokenFamily(ctx context.Context, familyID uuid.UUID) error {
+	defer s.lock()()
+
+	ts := now()
+	for token, t := range s.refreshTokens {
+		if t.FamilyID == familyID && !t.RevokedAt.Valid {
+			t.RevokedAt = sql.NullTime{Time: ts, Valid: true}
+			t.UpdatedAt = ts
+			s.refreshTokens[token] = t
+		}
+	}
+	return nil
+}
+
+func (s *Store) ListLegacyRefreshTokens(ctx context.Context) ([]string, error) {
+	defer s.rlock()()
+
+	var items []string
+	for token, t := range s.refreshTokens {
+		if !t.TokenHash.Valid {
+			items = append(items, token)
+		}
+	}
+	return items, nil
+}
+
+func (s *Store) HashLegacyRefreshToken(ctx context.Context, arg database.HashLegacyRefreshTokenParams) error {
+	defer s.lock()()
+
+	t, ok := s.refreshTokens[arg.LegacyToken]
+	if !ok || t.TokenHash.Valid {
+		return nil
+	}
+	if _, taken := s.refreshTokens[arg.TokenID]; taken && arg.TokenID != arg.LegacyToken {
+		return uniqueViolation("refresh_tokens_pkey")
+	}
+	delete(s.refreshTokens, arg.LegacyToken)
+	t.Token = arg.TokenID
+	t.TokenHash = arg.TokenHash
+	t.UpdatedAt = now()
+	s.refreshTokens[t.Token] = t
+	return nil
+}
+
+func (s *Store) ListActiveSessions(ctx context.Context, userID uuid.UUID) ([]database.ListActiveSessionsRow, error) {
+	defer s.rlock()()
+
+	started := map[uuid.UUID]time.Time{}
+	for _, t := range s.refreshTokens {
+		if first, ok := started[t.FamilyID]; !ok || t.CreatedAt.Before(first) {
+			started[t.FamilyID] = t.CreatedAt
+		}
+	}
+	ts := now()
+	var items []database.ListActiveSessionsRow
+	for _, t := range s.refreshTokens {
+		if t.UserID != userID || t.RevokedAt.Valid || !t.ExpiresAt.After(ts) {
+			continue
+		}
+		items = append(items, database.ListActiveSessionsRow{
+			FamilyID:   t.FamilyID,
+			LastUsedAt: t.LastUsedAt,
+			UserAgent:  t.UserAgent,
+			IpAddress:  t.IpAddress,
+			ExpiresAt:  t.ExpiresAt,
+			ClientID:   t.ClientID,
+			CreatedAt:  started[t.FamilyID],
+		})
+	}
+	slices.SortFunc(items, func(a, b database.ListActiveSessionsRow) int {
+		return cmp.Compare(b.LastUsedAt.UnixNano(), a.LastUsedAt.UnixNano())
+	})
+	return items, nil
+}
+
+func (s *Store) IsSessionActive(ctx context.Context, familyID uuid.UUID) (bool, error) {
+	defer s.rlock()()
+
+	ts := now()
+	for _, t := range s.refreshTokens {
+		if t.FamilyID == familyID && !t.RevokedAt.Valid && t.ExpiresAt.After(ts) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *Store) RevokeSession(ctx context.Context, arg database.RevokeSessionParams) (int64, error) {
+	defer s.lock()()
+
+	ts := now()
+	var n int64
+	for token, t := range s.refreshTokens {
+		if t.FamilyID == arg.FamilyID && t.UserID == arg.UserID && !t.RevokedAt.Valid {
+			t.RevokedAt = sql.NullTime{Time: ts, Valid: true}
+			t.UpdatedAt = ts
+			s.refreshTokens[token] = t
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s *Store) RevokeAllUserRefreshTokens(ctx context.Context, userID uuid.UUID) error {
+	defer s.lock()()
+
+	ts := now()
+	for token, t := range s.refreshTokens {
+		if t.UserID == userID && !t.RevokedAt.Valid {
+			t.RevokedAt = sql.NullTime{Time: ts, Valid: true}
+			t.UpdatedAt = ts
+			s.refreshTokens[token] = t
+		}
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package memstore
+
+import (
+	"context"
+
+	"github.com/dev-perry/go-server/internal/database"
+	"github.com/google/uuid"
+)
+
+func (s *Store) CreateSecurityEvent(ctx context.Context, arg database.CreateSecurityEventParams) error {
+	defer s.lock()()
+
+	if _, ok := s.users[arg.UserID]; !ok {
+		return foreignKeyViolation("security_events", "security_events_user_id_fkey")
+	}
+	s.securityEvents = append(s.securityEvents, database.SecurityEvent{
+		ID:        uuid.New(),
+		CreatedAt: now(),
+		UserID:    arg.UserID,
+		Kind:      arg.Kind,
+		Detail:    arg.Detail,
+	})
+	return nil
+}
+
+func (s *Store) ListSecurityEventsByUser(ctx context.Context, userID uuid.UUID) ([]database.SecurityEvent, error) {
+	defer s.rlock()()
+
+	var items []database.SecurityEvent
+	for _, e := range s.securityEvents {
+		if e.UserID == userID {
+			items = append(items, e)
+		}
+	}
+	return items, nil
+}
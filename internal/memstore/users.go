@@ -0,0 +1,276 @@
+package memstore
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/dev-perry/go-server/internal/database"
+	"github.com/google/uuid"
+)
+
+// emailTaken enforces the unique index on lower(email).
+func (s *Store) emailTaken(email string, except uuid.UUID) bool {
+	for id, u := range s.users {
+		if strings.EqualFold(u.Email, email) && id != except {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Store) CreateUser(ctx context.Context, arg database.CreateUserParams) (database.CreateUserRow, error) {
+	defer s.lock()()
+
+	if s.emailTaken(arg.Email, uuid.Nil) {
+		return database.CreateUserRow{}, uniqueViolation("users_email_lower_key")
+	}
+	ts := now()
+	u := database.User{
+		ID:             uuid.New(),
+		CreatedAt:      ts,
+		UpdatedAt:      ts,
+		Email:          arg.Email,
+		HashedPassword: arg.HashedPassword,
+		IsChirpyRed:    sql.NullBool{Bool: false, Valid: true},
+		Role:           "user",
+	}
+	s.users[u.ID] = u
+
+	return database.CreateUserRow{
+		ID:          u.ID,
+		CreatedAt:   u.CreatedAt,
+		UpdatedAt:   u.UpdatedAt,
+		Email:       u.Email,
+		IsChirpyRed: u.IsChirpyRed,
+		Role:        u.Role,
+	}, nil
+}
+
+func (s *Store) GetUserCredsByEmail(ctx context.Context, email string) (database.GetUserCredsByEmailRow, error) {
+	defer s.rlock()()
+
+	for _, u := range s.users {
+		if strings.EqualFold(u.Email, email) {
+			return database.GetUserCredsByEmailRow{
+				ID:              u.ID,
+				Email:           u.Email,
+				CreatedAt:       u.CreatedAt,
+				UpdatedAt:       u.UpdatedAt,
+				HashedPassword:  u.HashedPassword,
+				IsChirpyRed:     u.IsChirpyRed,
+				TokenVersion:    u.TokenVersion,
+				LockedAt:        u.LockedAt,
+				EmailVerifiedAt: u.EmailVerifiedAt,
+				TotpEnabledAt:   u.TotpEnabledAt,
+				Role:            u.Role,
+			}, nil
+		}
+	}
+	return database.GetUserCredsByEmailRow{}, sql.ErrNoRows
+}
+
+// DeleteAllUsers cascades to every table referencing users like TRUNCATE ... CASCADE.
+func (s *Store) DeleteAllUsers(ctx context.Context) error {
+	defer s.lock()()
+
+	s.users = map[uuid.UUID]database.User{}
+	s.chirps = nil
+	s.refreshTokens = map[string]database.RefreshToken{}
+	s.passwordResets = map[string]database.PasswordResetToken{}
+	s.emailVerifications = map[string]database.EmailVerificationToken{}
+	s.recoveryCodes = map[uuid.UUID]database.RecoveryCode{}
+	s.mfaChallenges = map[string]database.MfaChallenge{}
+	s.apiKeys = map[string]database.ApiKey{}
+	s.oauthClients = map[string]database.OauthClient{}
+	s.oauthCodes = map[string]database.OauthAuthorizationCode{}
+	s.userIdentities = map[identityKey]database.UserIdentity{}
+	s.securityEvents = nil
+	return nil
+}
+
+func (s *Store) UpdateUserCredentials(ctx context.Context, arg database.UpdateUserCredentialsParams) (database.UpdateUserCredentialsRow, error) {
+	defer s.lock()()
+
+	u, ok := s.users[arg.ID]
+	if !ok {
+		return database.UpdateUserCredentialsRow{}, sql.ErrNoRows
+	}
+	u.HashedPassword = arg.HashedPassword
+	u.PendingEmail = arg.PendingEmail
+	u.TokenVersion++
+	u.UpdatedAt = now()
+	s.users[u.ID] = u
+
+	return database.UpdateUserCredentialsRow{
+		ID:              u.ID,
+		UpdatedAt:       u.UpdatedAt,
+		Email:           u.Email,
+		PendingEmail:    u.PendingEmail,
+		EmailVerifiedAt: u.EmailVerifiedAt,
+		IsChirpyRed:     u.IsChirpyRed,
+		TokenVersion:    u.TokenVersion,
+		Role:            u.Role,
+	}, nil
+}
+
+// UpgradeUser is an :exec query, so an unknown id is not an error.
+func (s *Store) UpgradeUser(ctx context.Context, id uuid.UUID) error {
+	defer s.lock()()
+
+	if u, ok := s.users[id]; ok {
+		u.IsChirpyRed = sql.NullBool{Bool: true, Valid: true}
+		s.users[id] = u
+	}
+	return nil
+}
+
+func (s *Store) GetUser(ctx context.Context, id uuid.UUID) (database.GetUserRow, error) {
+	defer s.rlock()()
+
+	u, ok := s.users[id]
+	if !ok {
+		return database.GetUserRow{}, sql.ErrNoRows
+	}
+	return database.GetUserRow{
+		ID:              u.ID,
+		CreatedAt:       u.CreatedAt,
+		UpdatedAt:       u.UpdatedAt,
+		Email:           u.Email,
+		IsChirpyRed:     u.IsChirpyRed,
+		EmailVerifiedAt: u.EmailVerifiedAt,
+		PendingEmail:    u.PendingEmail,
+		Role:            u.Role,
+	}, nil
+}
+
+func (s *Store) GetUserAuthState(ctx context.Context, id uuid.UUID) (database.GetUserAuthStateRow, error) {
+	defer s.rlock()()
+
+	u, ok := s.users[id]
+	if !ok {
+		return database.GetUserAuthStateRow{}, sql.ErrNoRows
+	}
+	return database.GetUserAuthStateRow{
+		TokenVersion:    u.TokenVersion,
+		LockedAt:        u.LockedAt,
+		IsChirpyRed:     u.IsChirpyRed,
+		EmailVerifiedAt: u.EmailVerifiedAt,
+		Role:            u.Role,
+	}, nil
+}
+
+func (s *Store) BumpTokenVersion(ctx context.Context, id uuid.UUID) (int32, error) {
+	defer s.lock()()
+
+	u, ok := s.users[id]
+	if !ok {
+		return 0, sql.ErrNoRows
+	}
+	u.TokenVersion++
+	u.UpdatedAt = now()
+	s.users[id] = u
+	return u.TokenVersion, nil
+}
+
+func (s *Store) LockUser(ctx context.Context, id uuid.UUID) (int64, error) {
+	defer s.lock()()
+
+	u, ok := s.users[id]
+	if !ok {
+		return 0, nil
+	}
+	ts := now()
+	u.LockedAt = sql.NullTime{Time: ts, Valid: true}
+	u.TokenVersion++
+	u.UpdatedAt = ts
+	s.users[id] = u
+	return 1, nil
+}
+
+func (s *Store) UnlockUser(ctx context.Context, id uuid.UUID) (int64, error) {
+	defer s.lock()()
+
+	u, ok := s.users[id]
+	if !ok {
+		return 0, nil
+	}
+	u.LockedAt = sql.NullTime{}
+	u.UpdatedAt = now()
+	s.users[id] = u
+	return 1, nil
+}
+
+func (s *Store) UpdateUserPassword(ctx context.Context, arg database.UpdateUserPasswordParams) error {
+	defer s.lock()()
+
+	if u, ok := s.users[arg.ID]; ok {
+		u.HashedPassword = arg.HashedPassword
+		u.TokenVersion++
+		u.UpdatedAt = now()
+		s.users[arg.ID] = u
+	}
+	return nil
+}
+
+func (s *Store) VerifyUserEmail(ctx context.Context, arg database.VerifyUserEmailParams) (int64, error) {
+	defer s.lock()()
+
+	u, ok := s.users[arg.ID]
+	if !ok || !strings.EqualFold(u.Email, arg.Email) {
+		return 0, nil
+	}
+	ts := now()
+	u.EmailVerifiedAt = sql.NullTime{Time: ts, Valid: true}
+	u.UpdatedAt = ts
+	s.users[arg.ID] = u
+	return 1, nil
+}
+
+func (s *Store) ConfirmPendingEmail(ctx context.Context, arg database.ConfirmPendingEmailParams) (int64, error) {
+	defer s.lock()()
+
+	u, ok := s.users[arg.ID]
+	if !ok || !u.PendingEmail.Valid || u.PendingEmail.String != arg.Email {
+		return 0, nil
+	}
+	if s.emailTaken(arg.Email, arg.ID) {
+		return 0, uniqueViolation("users_email_lower_key")
+	}
+	ts := now()
+	u.Email = u.PendingEmail.String
+	u.PendingEmail = sql.NullString{}
+	u.EmailVerifiedAt = sql.NullTime{Time: ts, Valid: true}
+	u.UpdatedAt = ts
+	s.users[arg.ID] = u
+	return 1, nil
+}
+
+func (s *Store) RehashUserPassword(ctx context.Context, arg database.RehashUserPasswordParams) error {
+	defer s.lock()()
+
+	if u, ok := s.users[arg.ID]; ok && u.HashedPassword == arg.OldHash {
+		u.HashedPassword = arg.NewHash
+		s.users[arg.ID] = u
+	}
+	return nil
+}
+
+// SetUserRole enforces users_role_check like Postgres.
+func (s *Store) SetUserRole(ctx context.Context, arg database.SetUserRoleParams) (int64, error) {
+	defer s.lock()()
+
+	switch arg.Role {
+	case "user", "moderator", "admin":
+	default:
+		return 0, checkViolation("users", "users_role_check")
+	}
+	u, ok := s.users[arg.ID]
+	if !ok {
+		return 0, nil
+	}
+	u.Role = arg.Role
+	u.UpdatedAt = now()
+	s.users[arg.ID] = u
+	return 1, nil
+}
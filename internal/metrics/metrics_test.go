@@ -0,0 +1,55 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounterVec("requests_total", "Requests served.", "route", "status")
+	inflight := r.NewGauge("inflight", "Requests in flight.")
+	latency := r.NewHistogramVec("latency_seconds", "Request latency.", []float64{0.1, 1}, "route")
+
+	requests.With("GET /a", "200").Inc()
+	requests.With("GET /a", "200").Inc()
+	requests.With(`GET /"b"`, "404").Add(3)
+	inflight.Set(2)
+	inflight.Dec()
+	latency.With("GET /a").Observe(0.05)
+	latency.With("GET /a").Observe(0.5)
+	latency.With("GET /a").Observe(5)
+
+	var b strings.Builder
+	if err := r.WriteText(&b); err != nil {
+		t.Fatalf("WriteText failed: %v", err)
+	}
+
+	want := `# HELP requests_total Requests served.
+# TYPE requests_total counter
+requests_total{route="GET /\"b\"",status="404"} 3
+requests_total{route="GET /a",status="200"} 2
+# HELP inflight Requests in flight.
+# TYPE inflight gauge
+inflight 1
+# HELP latency_seconds Request latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="GET /a",le="0.1"} 1
+latency_seconds_bucket{route="GET /a",le="1"} 2
+latency_seconds_bucket{route="GET /a",le="+Inf"} 3
+latency_seconds_sum{route="GET /a"} 5.55
+latency_seconds_count{route="GET /a"} 3
+`
+	if b.String() != want {
+		t.Errorf("unexpected exposition:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
+
+func TestCounterReset(t *testing.T) {
+	c := NewRegistry().NewCounter("hits_total", "Hits.")
+	c.Add(5)
+	c.Reset()
+	if c.Value() != 0 {
+		t.Errorf("expected 0 after reset, got %v", c.Value())
+	}
+}
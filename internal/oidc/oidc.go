@@ -0,0 +1,301 @@
+// Package oidc signs users in with an external OpenID Connect provider,
+// using the authorization code flow with PKCE and verifying ID tokens
+// against the keys the provider publishes.
+package oidc
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dev-perry/go-server/internal/auth"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// maxResponseSize bounds what is read from the provider.
+	maxResponseSize = 1 << 20
+	// clockSkew is how far the provider's clock may drift from ours.
+	clockSkew = time.Minute
+	// keyRefreshInterval stops tokens with unknown key IDs from making us
+	// fetch the provider's keys on every request.
+	keyRefreshInterval = time.Minute
+)
+
+// Scopes are requested on every login.
+var Scopes = []string{"openid", "email", "profile"}
+
+var ErrInvalidIDToken = errors.New("invalid ID token")
+
+// Error is an error response from the provider's token endpoint, such as
+// invalid_grant for an expired or already used code.
+type Error struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *Error) Error() string {
+	if e.Description == "" {
+		return "oidc: " + e.Code
+	}
+	return fmt.Sprintf("oidc: %s: %s", e.Code, e.Description)
+}
+
+type Config struct {
+	// IssuerURL must match the issuer the provider reports exactly.
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	// HTTPClient defaults to one with a ten second timeout.
+	HTTPClient *http.Client
+}
+
+// Identity is what the provider asserts about a user in a verified ID token.
+type Identity struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+type metadata struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	CodeChallengeMethods  []string `json:"code_challenge_methods_supported"`
+}
+
+type idTokenClaims struct {
+	jwt.RegisteredClaims
+	Nonce           string `json:"nonce"`
+	AuthorizedParty string `json:"azp"`
+	Email           string `json:"email"`
+	EmailVerified   bool   `json:"email_verified"`
+	Name            string `json:"name"`
+}
+
+// Provider is an OpenID provider located through discovery.
+type Provider struct {
+	cfg    Config
+	meta   metadata
+	client *http.Client
+
+	mu          sync.Mutex
+	keys        map[string]*auth.Key
+	keysFetched time.Time
+}
+
+// Discover reads the provider's configuration from its well-known document.
+// Signing keys are fetched when the first ID token arrives.
+func Discover(ctx context.Context, cfg Config) (*Provider, error) {
+	p := &Provider{cfg: cfg, client: cfg.HTTPClient}
+	if p.client == nil {
+		p.client = &http.Client{Timeout: 10 * time.Second}
+	}
+	wellKnown := strings.TrimSuffix(cfg.IssuerURL, "/") + "/.well-known/openid-configuration"
+	if err := p.getJSON(ctx, wellKnown, &p.meta); err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	if p.meta.Issuer != cfg.IssuerURL {
+		return nil, fmt.Errorf("oidc discovery: provider reports issuer %q, expected %q", p.meta.Issuer, cfg.IssuerURL)
+	}
+	if p.meta.AuthorizationEndpoint == "" || p.meta.TokenEndpoint == "" || p.meta.JWKSURI == "" {
+		return nil, errors.New("oidc discovery: provider metadata is missing an endpoint")
+	}
+	if len(p.meta.CodeChallengeMethods) > 0 && !slices.Contains(p.meta.CodeChallengeMethods, "S256") {
+		return nil, errors.New("oidc discovery: provider does not support S256 PKCE")
+	}
+	return p, nil
+}
+
+func (p *Provider) Issuer() string {
+	return p.meta.Issuer
+}
+
+// AuthCodeURL is where to send the user's browser to sign in. The provider
+// returns them to the redirect URL with state and a code.
+func (p *Provider) AuthCodeURL(state, nonce, codeChallenge string) string {
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.cfg.ClientID},
+		"redirect_uri":          {p.cfg.RedirectURL},
+		"scope":                 {strings.Join(Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {codeChallenge},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(p.meta.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return p.meta.AuthorizationEndpoint + sep + query.Encode()
+}
+
+// Exchange trades an authorization code for the user's raw ID token.
+func (p *Provider) Exchange(ctx context.Context, code, codeVerifier string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.RedirectURL},
+		"code_verifier": {codeVerifier},
+	}
+	if p.cfg.ClientSecret == "" {
+		form.Set("client_id", p.cfg.ClientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.ClientSecret != "" {
+		// RFC 6749 section 2.3.1 has both halves form-encoded first.
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		IDToken string `json:"id_token"`
+		Error
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&body); err != nil {
+		return "", fmt.Errorf("oidc token endpoint returned %s: %w", resp.Status, err)
+	}
+	if body.Code != "" {
+		return "", &body.Error
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("oidc token endpoint returned %s", resp.Status)
+	}
+	if body.IDToken == "" {
+		return "", errors.New("oidc token endpoint returned no ID token")
+	}
+	return body.IDToken, nil
+}
+
+// VerifyIDToken checks an ID token's signature against the provider's keys
+// and that it was issued by the provider, for us, for the login that sent
+// nonce.
+func (p *Provider) VerifyIDToken(ctx context.Context, raw, nonce string) (Identity, error) {
+	parser := jwt.NewParser(
+		jwt.WithValidMethods([]string{"RS256", "ES256", "EdDSA"}),
+		jwt.WithIssuer(p.meta.Issuer),
+		jwt.WithAudience(p.cfg.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(clockSkew),
+	)
+	claims := &idTokenClaims{}
+	_, err := parser.ParseWithClaims(raw, claims, func(token *jwt.Token) (any, error) {
+		return p.lookupKey(ctx, token)
+	})
+	if err != nil {
+		return Identity{}, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+	if claims.Subject == "" {
+		return Identity{}, fmt.Errorf("%w: no subject", ErrInvalidIDToken)
+	}
+	if subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1 {
+		return Identity{}, fmt.Errorf("%w: nonce does not match", ErrInvalidIDToken)
+	}
+	// A token for several audiences must name us as the party it was issued
+	// to, and one that names someone else isn't ours at all.
+	if (len(claims.Audience) > 1 || claims.AuthorizedParty != "") && claims.AuthorizedParty != p.cfg.ClientID {
+		return Identity{}, fmt.Errorf("%w: authorized party is %q", ErrInvalidIDToken, claims.AuthorizedParty)
+	}
+	return Identity{
+		Issuer:        claims.Issuer,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		Name:          claims.Name,
+	}, nil
+}
+
+// lookupKey finds the key a token says it is signed with, fetching the
+// provider's keys again if it has rotated to one we haven't seen.
+func (p *Provider) lookupKey(ctx context.Context, token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := p.findKey(kid)
+	if key == nil && time.Since(p.keysFetched) > keyRefreshInterval {
+		if err := p.fetchKeys(ctx); err != nil {
+			return nil, err
+		}
+		key = p.findKey(kid)
+	}
+	if key == nil {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("key %q is for %s, not %v", kid, key.Method.Alg(), token.Header["alg"])
+	}
+	return key.Public(), nil
+}
+
+// findKey matches kid, or the only key there is when the token has none.
+func (p *Provider) findKey(kid string) *auth.Key {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key
+		}
+	}
+	return p.keys[kid]
+}
+
+func (p *Provider) fetchKeys(ctx context.Context) error {
+	var set auth.JWKS
+	if err := p.getJSON(ctx, p.meta.JWKSURI, &set); err != nil {
+		return fmt.Errorf("fetching provider keys: %w", err)
+	}
+	keys := map[string]*auth.Key{}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		// Providers may publish key types we can't use; skip them rather
+		// than refuse the ones we can.
+		if key, err := auth.ParseJWK(jwk); err == nil {
+			keys[key.ID] = key
+		}
+	}
+	p.keys = keys
+	p.keysFetched = time.Now()
+	return nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, target string, dst any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %s", target, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(dst)
+}
@@ -0,0 +1,148 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dev-perry/go-server/internal/auth"
+	"github.com/dev-perry/go-server/internal/oidc/oidctest"
+)
+
+const redirectURL = "http://chirpy.test/api/login/oidc/callback"
+
+func startProvider(t *testing.T, secret string) (*oidctest.Server, *Provider) {
+	t.Helper()
+	idp := oidctest.NewServer("chirpy", secret)
+	t.Cleanup(idp.Close)
+	provider, err := Discover(context.Background(), Config{
+		IssuerURL:    idp.URL,
+		ClientID:     "chirpy",
+		ClientSecret: secret,
+		RedirectURL:  redirectURL,
+	})
+	if err != nil {
+		t.Fatalf("Discover failed: %v", err)
+	}
+	return idp, provider
+}
+
+// login runs the browser half of the flow and returns the raw ID token.
+func login(t *testing.T, idp *oidctest.Server, provider *Provider, nonce string) string {
+	t.Helper()
+	verifier := "a-code-verifier-that-is-long-enough-for-pkce-rules"
+	back, err := idp.Login(provider.AuthCodeURL("the-state", nonce, auth.PKCEChallenge(verifier)))
+	if err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	if back.Query().Get("state") != "the-state" {
+		t.Errorf("expected state to be echoed, got %s", back)
+	}
+	raw, err := provider.Exchange(context.Background(), back.Query().Get("code"), verifier)
+	if err != nil {
+		t.Fatalf("Exchange failed: %v", err)
+	}
+	return raw
+}
+
+func TestLogin(t *testing.T) {
+	for name, secret := range map[string]string{"confidential": "s3cret/with+symbols", "public": ""} {
+		t.Run(name, func(t *testing.T) {
+			idp, provider := startProvider(t, secret)
+			idp.SetUser(oidctest.User{Subject: "u-1", Email: "ada@example.com", EmailVerified: true, Name: "Ada"})
+
+			raw := login(t, idp, provider, "n-1")
+			identity, err := provider.VerifyIDToken(context.Background(), raw, "n-1")
+			if err != nil {
+				t.Fatalf("VerifyIDToken failed: %v", err)
+			}
+			want := Identity{Issuer: idp.URL, Subject: "u-1", Email: "ada@example.com", EmailVerified: true, Name: "Ada"}
+			if identity != want {
+				t.Errorf("expected %+v, got %+v", want, identity)
+			}
+		})
+	}
+}
+
+func TestExchangeRejectsWrongVerifier(t *testing.T) {
+	idp, provider := startProvider(t, "secret")
+	back, err := idp.Login(provider.AuthCodeURL("s", "n", auth.PKCEChallenge("a-code-verifier-that-is-long-enough-for-pkce-rules")))
+	if err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	_, err = provider.Exchange(context.Background(), back.Query().Get("code"), "some-other-verifier-that-is-long-enough-for-pkce")
+	var oidcErr *Error
+	if !errors.As(err, &oidcErr) || oidcErr.Code != "invalid_grant" {
+		t.Errorf("expected invalid_grant, got %v", err)
+	}
+}
+
+func TestVerifyIDTokenRejects(t *testing.T) {
+	tests := map[string]map[string]any{
+		"wrong audience":      {"aud": "someone-else"},
+		"wrong issuer":        {"iss": "https://evil.example"},
+		"expired":             {"exp": time.Now().Add(-time.Hour).Unix()},
+		"no expiry":           {"exp": nil},
+		"no subject":          {"sub": nil},
+		"other party":         {"aud": []string{"chirpy", "other"}, "azp": "other"},
+		"several audiences":   {"aud": []string{"chirpy", "other"}},
+		"issued in future":    {"iat": time.Now().Add(time.Hour).Unix()},
+		"different nonce":     {"nonce": "n-2"},
+		"nonce left out":      {"nonce": nil},
+		"email_verified text": {"email_verified": "yes"},
+	}
+	for name, claims := range tests {
+		t.Run(name, func(t *testing.T) {
+			idp, provider := startProvider(t, "secret")
+			idp.SetUser(oidctest.User{Subject: "u-1"})
+			idp.OverrideClaims(claims)
+
+			raw := login(t, idp, provider, "n-1")
+			if _, err := provider.VerifyIDToken(context.Background(), raw, "n-1"); !errors.Is(err, ErrInvalidIDToken) {
+				t.Errorf("expected %v, got %v", ErrInvalidIDToken, err)
+			}
+		})
+	}
+}
+
+func TestVerifyIDTokenAcceptsOwnAuthorizedParty(t *testing.T) {
+	idp, provider := startProvider(t, "secret")
+	idp.SetUser(oidctest.User{Subject: "u-1"})
+	idp.OverrideClaims(map[string]any{"aud": []string{"chirpy", "other"}, "azp": "chirpy"})
+
+	raw := login(t, idp, provider, "n-1")
+	if _, err := provider.VerifyIDToken(context.Background(), raw, "n-1"); err != nil {
+		t.Errorf("expected the token to verify, got %v", err)
+	}
+}
+
+func TestVerifyIDTokenFollowsKeyRotation(t *testing.T) {
+	idp, provider := startProvider(t, "secret")
+	idp.SetUser(oidctest.User{Subject: "u-1"})
+	if _, err := provider.VerifyIDToken(context.Background(), login(t, idp, provider, "n"), "n"); err != nil {
+		t.Fatalf("VerifyIDToken failed: %v", err)
+	}
+
+	idp.RotateKey()
+	raw := login(t, idp, provider, "n")
+	// The keys were only just fetched, so an unknown kid is refused rather
+	// than refetched straight away...
+	if _, err := provider.VerifyIDToken(context.Background(), raw, "n"); !errors.Is(err, ErrInvalidIDToken) {
+		t.Fatalf("expected %v, got %v", ErrInvalidIDToken, err)
+	}
+	// ...but once the refresh interval has passed the new key is picked up.
+	provider.keysFetched = time.Time{}
+	if _, err := provider.VerifyIDToken(context.Background(), raw, "n"); err != nil {
+		t.Errorf("expected the rotated key to be fetched, got %v", err)
+	}
+}
+
+func TestDiscoverRequiresMatchingIssuer(t *testing.T) {
+	idp := oidctest.NewServer("chirpy", "secret")
+	defer idp.Close()
+	_, err := Discover(context.Background(), Config{IssuerURL: idp.URL + "/", ClientID: "chirpy"})
+	if err == nil {
+		t.Error("expected an issuer mismatch to be refused")
+	}
+}
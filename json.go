@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"regexp"
+
+	"github.com/google/uuid"
+)
+
+const (
+	errCodeBadRequest       = "bad_request"
+	errCodeValidation       = "validation_failed"
+	errCodeInvalidParameter = "invalid_parameter"
+	errCodeUnauthorized     = "unauthorized"
+	errCodeTokenExpired     = "token_expired"
+	errCodeForbidden        = "forbidden"
+	errCodeNotFound         = "not_found"
+	errCodeConflict         = "conflict"
+	errCodeRateLimited      = "rate_limited"
+	errCodeInternal         = "internal_error"
+)
+
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type apiError struct {
+	Code      string       `json:"code"`
+	Message   string       `json:"message"`
+	RequestID string       `json:"request_id,omitempty"`
+	Details   []fieldError `json:"details,omitempty"`
+}
+
+type errorResponse struct {
+	Error apiError `json:"error"`
+}
+
+func respondWithJSON(w http.ResponseWriter, status int, payload any) {
+	response, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Error marshalling JSON response: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(response)
+}
+
+func respondWithError(w http.ResponseWriter, r *http.Request, status int, code, message string, details ...fieldError) {
+	respondWithJSON(w, status, errorResponse{
+		Error: apiError{
+			Code:      code,
+			Message:   message,
+			RequestID: requestIDFromContext(r.Context()),
+			Details:   details,
+		},
+	})
+}
+
+type requestIDKey struct{}
+
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// middlewareRequestID tags every request with an ID, reusing a well-formed
+// X-Request-ID from the caller so logs can be correlated across services.
+func middlewareRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID.MatchString(id) {
+			id = uuid.NewString()
+		}
+		w.Header().Set("X-Request-ID", id)
+		ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
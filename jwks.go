@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/dev-perry/go-server/internal/auth"
+	"github.com/dev-perry/go-server/internal/config"
+)
+
+// loadJWTKeys builds the access token key set. Without a signing key file
+// tokens are signed with TOKEN_SECRET (HS256). With one, TOKEN_SECRET, if
+// still set, only verifies, so tokens issued before the switch keep working
+// until they expire.
+func loadJWTKeys(conf config.Config) (*auth.KeySet, error) {
+	if conf.JWTSigningKeyFile == "" {
+		return auth.NewHMACKeySet(conf.TokenSecret), nil
+	}
+	signing, err := auth.LoadKeyFile(conf.JWTSigningKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading JWT signing key: %w", err)
+	}
+	var verification []*auth.Key
+	for _, path := range conf.JWTVerificationKeyFiles {
+		key, err := auth.LoadKeyFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("loading JWT verification key: %w", err)
+		}
+		verification = append(verification, key)
+	}
+	if conf.TokenSecret != "" {
+		verification = append(verification, auth.NewHMACKey(conf.TokenSecret))
+	}
+	return auth.NewKeySet(signing, verification...)
+}
+
+// jwksHandler publishes the public keys access tokens can be verified with,
+// so other services don't need our signing secret.
+func (cfg *apiConfig) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	respondWithJSON(w, 200, cfg.jwtKeys.JWKS())
+}
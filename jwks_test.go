@@ -0,0 +1,84 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dev-perry/go-server/internal/auth"
+	"github.com/dev-perry/go-server/internal/config"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func writeEd25519Key(t *testing.T) string {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "signing.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+	return path
+}
+
+func TestJWKSPublishesSigningKey(t *testing.T) {
+	ts := newTestServer(t)
+	conf := config.Default()
+	conf.JWTSigningKeyFile = writeEd25519Key(t)
+	keys, err := loadJWTKeys(conf)
+	if err != nil {
+		t.Fatalf("loadJWTKeys failed: %v", err)
+	}
+	ts.cfg.jwtKeys = keys
+
+	ts.createUser(t, "walt@example.com", "heisenberg")
+	login := ts.login(t, "walt@example.com", "heisenberg")
+
+	res, body := ts.do(t, "GET", "/.well-known/jwks.json", "", nil)
+	expectStatus(t, res, body, http.StatusOK)
+	jwks := decode[auth.JWKS](t, body)
+	if len(jwks.Keys) != 1 {
+		t.Fatalf("expected one published key, got %s", body)
+	}
+	published := jwks.Keys[0]
+
+	// Verify the way another service would: with nothing but the JWKS.
+	x, err := base64.RawURLEncoding.DecodeString(published.X)
+	if err != nil {
+		t.Fatalf("decode x: %v", err)
+	}
+	token, err := jwt.Parse(login.Token, func(token *jwt.Token) (any, error) {
+		if token.Header["kid"] != published.Kid {
+			t.Errorf("expected kid %s, got %v", published.Kid, token.Header["kid"])
+		}
+		return ed25519.PublicKey(x), nil
+	}, jwt.WithValidMethods([]string{published.Alg}))
+	if err != nil || !token.Valid {
+		t.Fatalf("expected the access token to verify against the JWKS: %v", err)
+	}
+
+	res, body = ts.do(t, "GET", "/api/sessions", bearer(login.Token), nil)
+	expectStatus(t, res, body, http.StatusOK)
+}
+
+func TestJWKSOmitsHMACSecret(t *testing.T) {
+	ts := newTestServer(t)
+
+	res, body := ts.do(t, "GET", "/.well-known/jwks.json", "", nil)
+	expectStatus(t, res, body, http.StatusOK)
+	if jwks := decode[auth.JWKS](t, body); len(jwks.Keys) != 0 {
+		t.Errorf("expected no published keys with HS256, got %s", body)
+	}
+}
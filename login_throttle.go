@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dev-perry/go-server/internal/database"
+)
+
+// loginFailureWindow is how long a throttle remembers failures; the count
+// starts over after this long without one, or after a successful login.
+const loginFailureWindow = 24 * time.Hour
+
+// loginThrottle locks a key out once it has freeFailures failed logins,
+// doubling the lockout with every further failure up to maxLockout.
+type loginThrottle struct {
+	prefix       string
+	freeFailures int32
+	baseLockout  time.Duration
+	maxLockout   time.Duration
+}
+
+var (
+	// accountThrottle is keyed by the email address tried, whether or not an
+	// account has it, so lockouts don't reveal which addresses exist.
+	accountThrottle = loginThrottle{prefix: "account:", freeFailures: 5, baseLockout: 30 * time.Second, maxLockout: time.Hour}
+	// ipThrottle allows more failures, since several people can share an
+	// address, and catches one client guessing across many accounts.
+	ipThrottle = loginThrottle{prefix: "ip:", freeFailures: 20, baseLockout: 30 * time.Second, maxLockout: time.Hour}
+)
+
+func (lt loginThrottle) key(value string) string {
+	return lt.prefix + value
+}
+
+// lockout is how long to refuse logins after the given number of failures.
+func (lt loginThrottle) lockout(failures int32) time.Duration {
+	if failures < lt.freeFailures {
+		return 0
+	}
+	doublings := failures - lt.freeFailures
+	if doublings > 16 {
+		return lt.maxLockout
+	}
+	return min(lt.baseLockout<<doublings, lt.maxLockout)
+}
+
+func accountThrottleKey(email string) string {
+	return accountThrottle.key(strings.ToLower(strings.TrimSpace(email)))
+}
+
+// loginLockedFor returns how long until the account and client may try to
+// log in again, or zero if neither is locked out.
+func (cfg *apiConfig) loginLockedFor(ctx context.Context, r *http.Request, email string) (time.Duration, error) {
+	var wait time.Duration
+	for _, key := range []string{accountThrottleKey(email), ipThrottle.key(clientIP(r))} {
+		throttle, err := cfg.db.GetLoginThrottle(ctx, key)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if throttle.LockedUntil.Valid {
+			wait = max(wait, time.Until(throttle.LockedUntil.Time))
+		}
+	}
+	return wait, nil
+}
+
+// recordLoginFailure counts a failed password or second factor against the
+// account and the client, locking them out once they reach their limits.
+func (cfg *apiConfig) recordLoginFailure(ctx context.Context, r *http.Request, email string) {
+	cfg.metrics.logins.With("failure").Inc()
+	throttles := []struct {
+		loginThrottle
+		key string
+	}{
+		{accountThrottle, accountThrottleKey(email)},
+		{ipThrottle, ipThrottle.key(clientIP(r))},
+	}
+	now := time.Now().UTC()
+	for _, t := range throttles {
+		failures, err := cfg.db.RecordLoginFailure(ctx, database.RecordLoginFailureParams{
+			Key:         t.key,
+			ResetBefore: now.Add(-loginFailureWindow),
+		})
+		if err != nil {
+			log.Printf("Error recording login failure for %s: %s", t.key, err)
+			continue
+		}
+		lockout := t.lockout(failures)
+		if lockout == 0 {
+			continue
+		}
+		err = cfg.db.LockLoginThrottle(ctx, database.LockLoginThrottleParams{
+			Key:         t.key,
+			LockedUntil: sql.NullTime{Time: now.Add(lockout), Valid: true},
+		})
+		if err != nil {
+			log.Printf("Error locking out %s: %s", t.key, err)
+		}
+	}
+}
+
+// clearAccountThrottle forgets failures against an account after it logs in.
+// The client's count is left alone, or an attacker could reset it by
+// logging in to an account of their own between guesses.
+func (cfg *apiConfig) clearAccountThrottle(ctx context.Context, email string) {
+	if _, err := cfg.db.DeleteLoginThrottle(ctx, accountThrottleKey(email)); err != nil {
+		log.Printf("Error clearing login failures for %s: %s", email, err)
+	}
+}
+
+func respondWithLoginLocked(w http.ResponseWriter, r *http.Request, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	respondWithError(w, r, 429, errCodeRateLimited, "Too many failed login attempts, try again later")
+}
+
+// unlockIP lets an admin clear failed logins recorded against a client
+// address, e.g. an office behind one NAT.
+func (cfg *apiConfig) unlockIP(w http.ResponseWriter, r *http.Request) {
+	ip := net.ParseIP(r.PathValue("ip"))
+	if ip == nil {
+		respondWithParamError(w, r, &paramError{Param: "ip", Message: "must be a valid IP address"})
+		return
+	}
+
+	cleared, dbErr := cfg.db.DeleteLoginThrottle(r.Context(), ipThrottle.key(ip.String()))
+	if dbErr != nil {
+		log.Printf("Error clearing login failures for %s: %s", ip, dbErr)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to unlock address")
+		return
+	}
+	if cleared == 0 {
+		respondWithError(w, r, 404, errCodeNotFound, "No failed logins recorded for that address")
+		return
+	}
+	w.WriteHeader(204)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestLoginThrottleLockout(t *testing.T) {
+	lt := loginThrottle{freeFailures: 5, baseLockout: 30 * time.Second, maxLockout: time.Hour}
+	cases := map[int32]time.Duration{
+		1:   0,
+		4:   0,
+		5:   30 * time.Second,
+		6:   time.Minute,
+		8:   4 * time.Minute,
+		12:  time.Hour,
+		100: time.Hour,
+	}
+	for failures, want := range cases {
+		if got := lt.lockout(failures); got != want {
+			t.Errorf("lockout(%d) = %s, want %s", failures, got, want)
+		}
+	}
+}
+
+func TestLoginUnknownEmail(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser(t, "walt@example.com", "heisenberg")
+
+	res, body := ts.do(t, "POST", "/api/login", "", credsRequest{Email: "walt@example.com", Password: "wrong"})
+	wrongPassword := expectError(t, res, body, http.StatusUnauthorized, errCodeUnauthorized)
+	res, body = ts.do(t, "POST", "/api/login", "", credsRequest{Email: "nobody@example.com", Password: "wrong"})
+	unknownEmail := expectError(t, res, body, http.StatusUnauthorized, errCodeUnauthorized)
+	if wrongPassword.Message != unknownEmail.Message {
+		t.Errorf("expected the same message, got %q and %q", wrongPassword.Message, unknownEmail.Message)
+	}
+}
+
+func TestAccountLockout(t *testing.T) {
+	ts := newTestServer(t)
+	admin := bearer(ts.loginAs(t, roleAdmin).Token)
+	user := ts.createUser(t, "walt@example.com", "heisenberg")
+	ts.createUser(t, "jesse@example.com", "yeahscience")
+
+	for range accountThrottle.freeFailures {
+		res, body := ts.do(t, "POST", "/api/login", "", credsRequest{Email: "walt@example.com", Password: "wrong"})
+		expectError(t, res, body, http.StatusUnauthorized, errCodeUnauthorized)
+	}
+	// Locked out even with the right password, however the address is typed.
+	res, body := ts.do(t, "POST", "/api/login", "", credsRequest{Email: "WALT@example.com", Password: "heisenberg"})
+	expectError(t, res, body, http.StatusTooManyRequests, errCodeRateLimited)
+	if res.Header.Get("Retry-After") == "" {
+		t.Error("expected a Retry-After header")
+	}
+	ts.login(t, "jesse@example.com", "yeahscience")
+
+	res, body = ts.do(t, "POST", "/admin/users/"+user.ID.String()+"/unlock", admin, nil)
+	expectStatus(t, res, body, http.StatusNoContent)
+	ts.login(t, "walt@example.com", "heisenberg")
+}
+
+func TestUnknownAccountLockout(t *testing.T) {
+	ts := newTestServer(t)
+
+	for range accountThrottle.freeFailures {
+		res, body := ts.do(t, "POST", "/api/login", "", credsRequest{Email: "nobody@example.com", Password: "wrong"})
+		expectError(t, res, body, http.StatusUnauthorized, errCodeUnauthorized)
+	}
+	res, body := ts.do(t, "POST", "/api/login", "", credsRequest{Email: "nobody@example.com", Password: "wrong"})
+	expectError(t, res, body, http.StatusTooManyRequests, errCodeRateLimited)
+}
+
+func TestSuccessfulLoginClearsFailures(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser(t, "walt@example.com", "heisenberg")
+
+	for range 2 {
+		for range accountThrottle.freeFailures - 1 {
+			res, body := ts.do(t, "POST", "/api/login", "", credsRequest{Email: "walt@example.com", Password: "wrong"})
+			expectError(t, res, body, http.StatusUnauthorized, errCodeUnauthorized)
+		}
+		ts.login(t, "walt@example.com", "heisenberg")
+	}
+}
+
+func TestIPLockout(t *testing.T) {
+	ts := newTestServer(t)
+	// Signed in before the address is locked out.
+	admin := bearer(ts.loginAs(t, roleAdmin).Token)
+	ts.createUser(t, "walt@example.com", "heisenberg")
+
+	for i := range ipThrottle.freeFailures {
+		res, body := ts.do(t, "POST", "/api/login", "", credsRequest{Email: fmt.Sprintf("guess%d@example.com", i), Password: "wrong"})
+		expectError(t, res, body, http.StatusUnauthorized, errCodeUnauthorized)
+	}
+	res, body := ts.do(t, "POST", "/api/login", "", credsRequest{Email: "walt@example.com", Password: "heisenberg"})
+	expectError(t, res, body, http.StatusTooManyRequests, errCodeRateLimited)
+
+	res, body = ts.do(t, "POST", "/admin/ips/not-an-ip/unlock", admin, nil)
+	expectError(t, res, body, http.StatusBadRequest, errCodeInvalidParameter)
+	res, body = ts.do(t, "POST", "/admin/ips/127.0.0.1/unlock", admin, nil)
+	expectStatus(t, res, body, http.StatusNoContent)
+	ts.login(t, "walt@example.com", "heisenberg")
+
+	res, body = ts.do(t, "POST", "/admin/ips/127.0.0.1/unlock", admin, nil)
+	expectError(t, res, body, http.StatusNotFound, errCodeNotFound)
+}
+
+func TestMFAFailuresCountTowardLockout(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser(t, "walt@example.com", "heisenberg")
+	login := ts.login(t, "walt@example.com", "heisenberg")
+	ts.enableTOTP(t, login.Token)
+
+	mfaToken := ts.startMFALogin(t, "walt@example.com", "heisenberg")
+	for range accountThrottle.freeFailures {
+		res, body := ts.do(t, "POST", "/api/login/2fa", "", mfaLoginRequest{MFAToken: mfaToken, Code: "000000"})
+		expectError(t, res, body, http.StatusUnauthorized, errCodeUnauthorized)
+	}
+	res, body := ts.do(t, "POST", "/api/login", "", credsRequest{Email: "walt@example.com", Password: "heisenberg"})
+	expectError(t, res, body, http.StatusTooManyRequests, errCodeRateLimited)
+}
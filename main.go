@@ -1,34 +1,71 @@
 package main
 
 import (
+	"context"
+	"crypto/subtle"
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
 	"os"
-	"sync/atomic"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/dev-perry/go-server/internal/auth"
+	"github.com/dev-perry/go-server/internal/config"
 	"github.com/dev-perry/go-server/internal/database"
+	"github.com/dev-perry/go-server/internal/mailer"
+	"github.com/dev-perry/go-server/internal/memstore"
+	"github.com/dev-perry/go-server/internal/oidc"
 	"github.com/google/uuid"
-	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 )
 
 type apiConfig struct {
-	fileserverHits atomic.Int32
-	db             *database.Queries
-	tokenSecret    string
-	polkaKey       string
-}
+	metrics  *serverMetrics
+	db       database.Store
+	jwtKeys  *auth.KeySet
+	polkaKey string
+	platform string
+	// metricsToken is the bearer token Prometheus scrapes /metrics with.
+	// /metrics is not served without one.
+	metricsToken string
 
-type fail struct {
-	Error string `json:"error"`
-}
+	accessTokenTTL  time.Duration
+	refreshTokenTTL time.Duration
+	refreshPepper   string
+	chirpMaxLength  int
+
+	mailer           mailer.Mailer
+	publicURL        string
+	passwordResetTTL time.Duration
+	resetLimiter     *rateLimiter
+
+	emailVerificationTTL time.Duration
+	requireVerifiedEmail bool
+	verifyLimiter        *rateLimiter
+
+	// totpCipher encrypts TOTP secrets at rest. It is nil, and 2FA enrollment
+	// disabled, when no MFA_ENCRYPTION_KEY is configured.
+	totpCipher *auth.Cipher
+
+	// oidcProvider is nil, and single sign-on disabled, unless
+	// OIDC_ISSUER_URL is configured.
+	oidcProvider *oidc.Provider
 
-type RefreshTokenResponse struct {
-	Token string `json:"token"`
+	passwordParams auth.PasswordParams
+	passwordPolicy *auth.PasswordPolicy
+	// dummyPasswordHash is checked against for logins with an unknown email,
+	// so they take as long as ones with a wrong password.
+	dummyPasswordHash string
+
+	// background tracks work that outlives the request that started it, so
+	// shutdown can wait for it.
+	background sync.WaitGroup
 }
 
 type polkaRequest struct {
@@ -40,9 +77,7 @@ type polkaRequest struct {
 
 func (cfg *apiConfig) middlewareMetricsInc(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		cfg.fileserverHits.Add(1)
-		resHeader := w.Header()
-		resHeader.Set("Cache-Control", "no-cache")
+		cfg.metrics.fileserverHits.Inc()
 		next.ServeHTTP(w, r)
 	})
 }
@@ -56,7 +91,7 @@ func readyHandler(r http.ResponseWriter, _ *http.Request) {
 }
 
 func (cfg *apiConfig) metricsHandler(w http.ResponseWriter, _ *http.Request) {
-	hits := cfg.fileserverHits.Load()
+	hits := int(cfg.metrics.fileserverHits.Value())
 	w.Header().Set("Content-Type", "text/html")
 
 	response := fmt.Sprintf(`
@@ -71,88 +106,58 @@ func (cfg *apiConfig) metricsHandler(w http.ResponseWriter, _ *http.Request) {
 }
 
 func (cfg *apiConfig) reset(w http.ResponseWriter, r *http.Request) {
-	platform := os.Getenv("PLATFORM")
-	if platform != "dev" {
-		w.WriteHeader(403)
-		return
-	}
-	cfg.fileserverHits.Swap(0)
-	cfg.db.DeleteAllUsers(r.Context())
-	w.WriteHeader(200)
-	message := "OK"
-	w.Write([]byte(message))
-}
-
-func (cfg *apiConfig) refreshToken(w http.ResponseWriter, r *http.Request) {
-	bearer, headErr := auth.GetBearerToken(r.Header)
-	if headErr != nil {
-		w.WriteHeader(403)
-		w.Write([]byte("Unathorized"))
-		return
-	}
-
-	uid, refreshErr := cfg.db.GetUserFromRefreshToken(r.Context(), bearer)
-	if refreshErr != nil {
-		w.WriteHeader(401)
+	if cfg.platform != "dev" {
+		respondWithError(w, r, 403, errCodeForbidden, "Reset is only allowed in dev environment")
 		return
 	}
-	expires, _ := time.ParseDuration("1h")
-	token, tokenErr := auth.MakeJWT(uid, cfg.tokenSecret, expires)
-
-	if tokenErr != nil {
-		w.WriteHeader(500)
-		w.Write([]byte("Something went wrong"))
+	cfg.metrics.fileserverHits.Reset()
+	if dbErr := cfg.db.DeleteAllUsers(r.Context()); dbErr != nil {
+		log.Printf("Error resetting users: %s", dbErr)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to reset database")
 		return
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	response := RefreshTokenResponse{
-		token,
-	}
-
-	resBuff, _ := json.Marshal(response)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	w.WriteHeader(200)
-	w.Write(resBuff)
+	message := "OK"
+	w.Write([]byte(message))
 }
 
-func (cfg *apiConfig) revokeToken(w http.ResponseWriter, r *http.Request) {
-	bearer, headErr := auth.GetBearerToken(r.Header)
-	if headErr != nil {
-		w.WriteHeader(403)
-		w.Write([]byte("Unathorized"))
-		return
-	}
-	revokeErr := cfg.db.RevokeRefreshToken(r.Context(), bearer)
-	if revokeErr != nil {
-		w.WriteHeader(500)
-		w.Write([]byte("Something went wrong"))
-		return
-	}
-	w.WriteHeader(204)
-
+// prometheusHandler serves the metrics registry to scrapers that present
+// METRICS_TOKEN as a bearer token.
+func (cfg *apiConfig) prometheusHandler() http.Handler {
+	registry := cfg.metrics.registry.Handler()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, tokenErr := auth.GetBearerToken(r.Header)
+		if tokenErr != nil || subtle.ConstantTimeCompare([]byte(token), []byte(cfg.metricsToken)) != 1 {
+			respondWithError(w, r, 401, errCodeUnauthorized, "Invalid metrics token")
+			return
+		}
+		registry.ServeHTTP(w, r)
+	})
 }
 
 func (cfg *apiConfig) polkaHandler(w http.ResponseWriter, r *http.Request) {
 	apiKey, keyErr := auth.GetAPIKey(r.Header)
 	if keyErr != nil || apiKey != cfg.polkaKey {
-		w.WriteHeader(401)
+		respondWithError(w, r, 401, errCodeUnauthorized, "Invalid API key")
 		return
 	}
 	polka := polkaRequest{}
 	decoder := json.NewDecoder(r.Body)
 	if jsonErr := decoder.Decode(&polka); jsonErr != nil {
-		w.WriteHeader(500)
+		respondWithError(w, r, 400, errCodeBadRequest, "Unable to decode request body")
 		return
 	}
 
 	event := polka.Event
 	userId := polka.Data.UserId
+	cfg.metrics.webhookEvents.With(event).Inc()
 
 	if event == "user.upgraded" {
 		upgradeErr := cfg.db.UpgradeUser(r.Context(), userId)
 
 		if upgradeErr != nil {
-			w.WriteHeader(404)
+			respondWithError(w, r, 404, errCodeNotFound, "User not found")
 			return
 		}
 		w.WriteHeader(204)
@@ -161,44 +166,258 @@ func (cfg *apiConfig) polkaHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(204)
 }
 
-func main() {
-	godotenv.Load()
-	dbURL := os.Getenv("DB_URL")
-	tokenSecret := os.Getenv("TOKEN_SECRET")
-	polkaKey := os.Getenv("POLKA_KEY")
+func newRouter(cfg *apiConfig, static http.Handler) http.Handler {
+	mux := http.NewServeMux()
 
-	db, _ := sql.Open("postgres", dbURL)
-	dbQueries := database.New(db)
+	fileHandler := http.StripPrefix("/app", static)
+
+	mux.Handle("/app/", cfg.middlewareMetricsInc(fileHandler))
+	mux.HandleFunc("GET /api/healthz", readyHandler)
+	mux.Handle("GET /admin/metrics", cfg.requirePermission(permViewMetrics, cfg.metricsHandler))
+	if cfg.metricsToken != "" {
+		mux.Handle("GET /metrics", cfg.prometheusHandler())
+	}
+	mux.Handle("POST /admin/reset", cfg.requirePermission(permResetDatabase, cfg.reset))
+	mux.Handle("POST /admin/users/{userID}/lock", cfg.requirePermission(permManageUsers, cfg.lockUser))
+	mux.Handle("POST /admin/users/{userID}/unlock", cfg.requirePermission(permManageUsers, cfg.unlockUser))
+	mux.Handle("PUT /admin/users/{userID}/role", cfg.requirePermission(permManageUsers, cfg.setUserRole))
+	mux.Handle("POST /admin/ips/{ip}/unlock", cfg.requirePermission(permManageUsers, cfg.unlockIP))
+	mux.Handle("DELETE /admin/chirps/{chirpID}", cfg.requirePermission(permModerateChirps, cfg.removeChirp))
+	mux.HandleFunc("POST /api/login", cfg.loginUser)
+	mux.HandleFunc("POST /api/login/2fa", cfg.loginSecondFactor)
+	mux.HandleFunc("GET /api/login/oidc", cfg.startOIDCLogin)
+	mux.HandleFunc("GET /api/login/oidc/callback", cfg.finishOIDCLogin)
+	mux.HandleFunc("POST /api/users", cfg.createUser)
+	mux.HandleFunc("POST /api/password/forgot", cfg.forgotPassword)
+	mux.HandleFunc("POST /api/password/reset", cfg.resetPassword)
+	mux.HandleFunc("GET /api/verify-email", showVerifyEmailPage)
+	mux.HandleFunc("POST /api/verify-email", cfg.verifyEmail)
+	mux.Handle("POST /api/verify-email/resend", cfg.requireAuth(cfg.resendEmailVerification))
+	mux.Handle("POST /api/chirps", cfg.requireScope(scopeChirpsWrite, cfg.createChirp))
+	mux.Handle("DELETE /api/chirps/{chirpID}", cfg.requireScope(scopeChirpsWrite, cfg.deleteChirp))
+	mux.Handle("GET /api/chirps", cfg.optionalScope(scopeChirpsRead, cfg.getChirps))
+	mux.Handle("GET /api/chirps/{chirpID}", cfg.optionalScope(scopeChirpsRead, cfg.getChirp))
+	mux.HandleFunc("POST /api/refresh", cfg.refreshToken)
+	mux.HandleFunc("POST /api/revoke", cfg.revokeToken)
+	mux.HandleFunc("GET /.well-known/jwks.json", cfg.jwksHandler)
+	mux.Handle("GET /api/sessions", cfg.requireAuth(cfg.listSessions))
+	mux.Handle("DELETE /api/sessions/{sessionID}", cfg.requireAuth(cfg.revokeSession))
+	mux.Handle("POST /api/sessions/revoke-all", cfg.requireAuth(cfg.revokeAllSessions))
+	mux.Handle("POST /api/keys", cfg.requireAuth(cfg.createAPIKey))
+	mux.Handle("GET /api/keys", cfg.requireAuth(cfg.listAPIKeys))
+	mux.Handle("DELETE /api/keys/{keyID}", cfg.requireAuth(cfg.revokeAPIKey))
+	mux.Handle("POST /api/oauth/clients", cfg.requireAuth(cfg.registerOAuthClient))
+	mux.Handle("GET /api/oauth/clients", cfg.requireAuth(cfg.listOAuthClients))
+	mux.Handle("DELETE /api/oauth/clients/{clientID}", cfg.requireAuth(cfg.deleteOAuthClient))
+	mux.HandleFunc("GET /oauth/authorize", cfg.authorize)
+	mux.Handle("POST /oauth/authorize", cfg.requireAuth(cfg.decideAuthorization))
+	mux.HandleFunc("POST /oauth/token", cfg.oauthToken)
+	mux.HandleFunc("POST /oauth/introspect", cfg.introspectOAuthToken)
+	mux.HandleFunc("POST /oauth/revoke", cfg.revokeOAuthToken)
+	mux.HandleFunc("GET /.well-known/oauth-authorization-server", cfg.authorizationServerMetadata)
+	mux.Handle("PUT /api/users", cfg.requireScope(scopeProfileWrite, cfg.updateUser))
+	mux.Handle("PUT /api/users/2fa", cfg.requireAuth(cfg.enrollTOTP))
+	mux.Handle("POST /api/users/2fa/confirm", cfg.requireAuth(cfg.confirmTOTP))
+	mux.Handle("DELETE /api/users/2fa", cfg.requireAuth(cfg.disableTOTP))
+	mux.HandleFunc("POST /api/polka/webhooks", cfg.polkaHandler)
+
+	return middlewareRequestID(cfg.metrics.middlewareRequestMetrics(mux))
+}
+
+// serve runs server until ctx is cancelled, then stops accepting connections
+// and waits up to shutdownTimeout for in-flight requests to finish.
+func serve(ctx context.Context, server *http.Server, shutdownTimeout time.Duration) error {
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Printf("Shutting down, waiting up to %s for in-flight requests", shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("graceful shutdown: %w", err)
+	}
+	return nil
+}
+
+func newMailer(conf config.Mail) (mailer.Mailer, error) {
+	switch conf.Driver {
+	case "file":
+		return mailer.NewFileMailer(conf.File, conf.From)
+	case "smtp":
+		return mailer.NewSMTPMailer(conf.SMTPAddr, conf.From, conf.SMTPUsername, conf.SMTPPassword)
+	default:
+		return mailer.NewWriterMailer(os.Stdout, conf.From), nil
+	}
+}
+
+func openDB(conf config.Config) (*sql.DB, error) {
+	db, err := sql.Open("postgres", conf.DBURL)
+	if err != nil {
+		return nil, fmt.Errorf("opening database: %w", err)
+	}
+	db.SetMaxOpenConns(conf.DB.MaxOpenConns)
+	db.SetMaxIdleConns(conf.DB.MaxIdleConns)
+	db.SetConnMaxLifetime(conf.DB.ConnMaxLifetime)
+
+	pingCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := db.PingContext(pingCtx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("connecting to database: %w", err)
+	}
+	return db, nil
+}
+
+func run() error {
+	conf, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	log.Printf("Effective configuration:\n%s", conf.Redacted())
+
+	serverMetrics := newServerMetrics()
+
+	var dbQueries database.Store
+	if conf.DBURL == "" {
+		log.Println("DB_URL is not set, using the in-memory store; nothing will survive a restart")
+		dbQueries = memstore.New()
+	} else {
+		db, err := openDB(conf)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		dbQueries = database.NewStore(db, serverMetrics.instrumentDB)
+	}
+
+	jwtKeys, err := loadJWTKeys(conf)
+	if err != nil {
+		return err
+	}
+
+	mail, err := newMailer(conf.Mail)
+	if err != nil {
+		return fmt.Errorf("setting up mail: %w", err)
+	}
+
+	var totpCipher *auth.Cipher
+	if conf.MFAEncryptionKey != "" {
+		totpCipher, err = auth.NewCipher(conf.MFAEncryptionKey, "totp")
+		if err != nil {
+			return fmt.Errorf("setting up MFA encryption: %w", err)
+		}
+	}
+
+	var oidcProvider *oidc.Provider
+	if conf.OIDC.IssuerURL != "" {
+		redirectURL := conf.OIDC.RedirectURL
+		if redirectURL == "" {
+			redirectURL = oidcRedirectURL(strings.TrimSuffix(conf.PublicURL, "/"))
+		}
+		discoverCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		oidcProvider, err = oidc.Discover(discoverCtx, oidc.Config{
+			IssuerURL:    conf.OIDC.IssuerURL,
+			ClientID:     conf.OIDC.ClientID,
+			ClientSecret: conf.OIDC.ClientSecret,
+			RedirectURL:  redirectURL,
+		})
+		if err != nil {
+			return fmt.Errorf("setting up single sign-on: %w", err)
+		}
+	}
+
+	passwordParams := auth.PasswordParams{
+		Memory:      uint32(conf.Password.Argon2Memory),
+		Iterations:  uint32(conf.Password.Argon2Iterations),
+		Parallelism: uint8(conf.Password.Argon2Parallelism),
+	}
+	passwordPolicy := &auth.PasswordPolicy{MinLength: conf.Password.MinLength}
+	if conf.Password.BreachedListFile != "" {
+		if err := passwordPolicy.LoadBreachedPasswords(conf.Password.BreachedListFile); err != nil {
+			return fmt.Errorf("loading breached passwords: %w", err)
+		}
+	}
+	dummyHash, err := auth.HashPassword("chirpy-dummy-password", passwordParams)
+	if err != nil {
+		return fmt.Errorf("hashing dummy password: %w", err)
+	}
 
 	apiCfg := apiConfig{
-		fileserverHits: atomic.Int32{},
-		db:             dbQueries,
-		tokenSecret:    tokenSecret,
-		polkaKey:       polkaKey,
+		metrics:         serverMetrics,
+		db:              dbQueries,
+		jwtKeys:         jwtKeys,
+		polkaKey:        conf.PolkaKey,
+		platform:        conf.Platform,
+		metricsToken:    conf.MetricsToken,
+		accessTokenTTL:  conf.AccessTokenTTL,
+		refreshTokenTTL: conf.RefreshTokenTTL,
+		refreshPepper:   conf.RefreshPepper,
+		chirpMaxLength:  conf.ChirpMaxLength,
+
+		mailer:           mail,
+		publicURL:        strings.TrimSuffix(conf.PublicURL, "/"),
+		passwordResetTTL: conf.PasswordResetTTL,
+		resetLimiter:     newRateLimiter(passwordResetLimit, passwordResetWindow),
+
+		emailVerificationTTL: conf.EmailVerificationTTL,
+		requireVerifiedEmail: conf.RequireVerifiedEmail,
+		verifyLimiter:        newRateLimiter(verificationEmailLimit, verificationEmailWindow),
+
+		totpCipher:   totpCipher,
+		oidcProvider: oidcProvider,
+
+		passwordParams:    passwordParams,
+		passwordPolicy:    passwordPolicy,
+		dummyPasswordHash: dummyHash,
+	}
+
+	if err := migrateLegacyRefreshTokens(context.Background(), dbQueries, conf.RefreshPepper); err != nil {
+		return fmt.Errorf("hashing legacy refresh tokens: %w", err)
+	}
+
+	static, err := newStaticHandler(conf.StaticDir)
+	if err != nil {
+		return fmt.Errorf("loading static site: %w", err)
 	}
 
-	mux := http.NewServeMux()
 	server := &http.Server{
-		Handler: mux,
-		Addr:    ":8080",
+		Handler:           newRouter(&apiCfg, static),
+		Addr:              conf.ListenAddr,
+		ReadHeaderTimeout: conf.Server.ReadHeaderTimeout,
+		ReadTimeout:       conf.Server.ReadTimeout,
+		WriteTimeout:      conf.Server.WriteTimeout,
+		IdleTimeout:       conf.Server.IdleTimeout,
+		ConnState:         serverMetrics.trackConnState,
 	}
 
-	fileHandler := http.StripPrefix("/app", http.FileServer(http.Dir(".")))
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	mux.Handle("/app/", apiCfg.middlewareMetricsInc(fileHandler))
-	mux.HandleFunc("GET /api/healthz", readyHandler)
-	mux.HandleFunc("GET /admin/metrics", apiCfg.metricsHandler)
-	mux.HandleFunc("POST /admin/reset", apiCfg.reset)
-	mux.HandleFunc("POST /api/login", apiCfg.loginUser)
-	mux.HandleFunc("POST /api/users", apiCfg.createUser)
-	mux.HandleFunc("POST /api/chirps", apiCfg.createChirp)
-	mux.HandleFunc("DELETE /api/chirps/{chirpID}", apiCfg.deleteChirp)
-	mux.HandleFunc("GET /api/chirps", apiCfg.getChirps)
-	mux.HandleFunc("GET /api/chirps/{chirpID}", apiCfg.getChirp)
-	mux.HandleFunc("POST /api/refresh", apiCfg.refreshToken)
-	mux.HandleFunc("POST /api/revoke", apiCfg.revokeToken)
-	mux.HandleFunc("PUT /api/users", apiCfg.updateUser)
-	mux.HandleFunc("POST /api/polka/webhooks", apiCfg.polkaHandler)
-
-	server.ListenAndServe()
+	log.Printf("Serving on %s", server.Addr)
+	err = serve(ctx, server, conf.Server.ShutdownTimeout)
+	apiCfg.background.Wait()
+	return err
+}
+
+func main() {
+	if len(os.Args) > 1 {
+		if err := runCommand(context.Background(), os.Args[1:], os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "chirpy: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if err := run(); err != nil {
+		log.Printf("Server error: %s", err)
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dev-perry/go-server/internal/auth"
+	"github.com/dev-perry/go-server/internal/mailer"
+	"github.com/dev-perry/go-server/internal/memstore"
+)
+
+const (
+	testTokenSecret = "test-secret-key"
+	testPolkaKey    = "test-polka-key"
+	testPepper      = "test-refresh-token-pepper"
+	testMFAKey      = "test-mfa-encryption-key-0123456789"
+	testMetricsKey  = "test-metrics-token-0123456789abcdef"
+)
+
+type testServer struct {
+	*httptest.Server
+	cfg  *apiConfig
+	mail *captureMailer
+}
+
+// captureMailer records messages instead of sending them.
+type captureMailer struct {
+	mu   sync.Mutex
+	sent []mailer.Message
+}
+
+func (m *captureMailer) Send(_ context.Context, msg mailer.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+func (m *captureMailer) messages() []mailer.Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]mailer.Message(nil), m.sent...)
+}
+
+var testPasswordParams = auth.PasswordParams{Memory: 8 * 1024, Iterations: 1, Parallelism: 1}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	mail := &captureMailer{}
+	totpCipher, err := auth.NewCipher(testMFAKey, "totp")
+	if err != nil {
+		t.Fatalf("NewCipher: %v", err)
+	}
+	dummyHash, err := auth.HashPassword("chirpy-dummy-password", testPasswordParams)
+	if err != nil {
+		t.Fatalf("HashPassword: %v", err)
+	}
+	cfg := &apiConfig{
+		metrics:  newServerMetrics(),
+		db:       memstore.New(),
+		jwtKeys:  auth.NewHMACKeySet(testTokenSecret),
+		polkaKey: testPolkaKey,
+		platform: "dev",
+
+		metricsToken: testMetricsKey,
+
+		accessTokenTTL:  time.Hour,
+		refreshTokenTTL: 24 * time.Hour,
+		refreshPepper:   testPepper,
+		chirpMaxLength:  140,
+
+		mailer:           mail,
+		publicURL:        "http://chirpy.test",
+		passwordResetTTL: time.Hour,
+		resetLimiter:     newRateLimiter(passwordResetLimit, passwordResetWindow),
+
+		emailVerificationTTL: 24 * time.Hour,
+		verifyLimiter:        newRateLimiter(verificationEmailLimit, verificationEmailWindow),
+
+		totpCipher: totpCipher,
+
+		// Cheap hashing keeps the suite fast, and a lax policy lets tests use
+		// short passwords.
+		passwordParams:    testPasswordParams,
+		passwordPolicy:    &auth.PasswordPolicy{MinLength: 1},
+		dummyPasswordHash: dummyHash,
+	}
+	static, err := newStaticHandler("")
+	if err != nil {
+		t.Fatalf("newStaticHandler: %v", err)
+	}
+	srv := httptest.NewServer(newRouter(cfg, static))
+	t.Cleanup(srv.Close)
+	return &testServer{Server: srv, cfg: cfg, mail: mail}
+}
+
+// do sends body as JSON and returns the response with its body already read.
+func (ts *testServer) do(t *testing.T, method, path, authorization string, body any) (*http.Response, []byte) {
+	t.Helper()
+	var reqBody io.Reader
+	if body != nil {
+		buf, err := json.Marshal(body)
+		if err != nil {
+			t.Fatalf("marshal request: %v", err)
+		}
+		reqBody = bytes.NewReader(buf)
+	}
+	req, err := http.NewRequest(method, ts.URL+path, reqBody)
+	if err != nil {
+		t.Fatalf("build request: %v", err)
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	res, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer res.Body.Close()
+	resBody, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("read response: %v", err)
+	}
+	return res, resBody
+}
+
+func bearer(token string) string {
+	return "Bearer " + token
+}
+
+func expectStatus(t *testing.T, res *http.Response, body []byte, want int) {
+	t.Helper()
+	if res.StatusCode != want {
+		t.Fatalf("%s %s: expected status %d, got %d: %s", res.Request.Method, res.Request.URL.Path, want, res.StatusCode, body)
+	}
+}
+
+// expectError asserts the status and the JSON error envelope every handler uses.
+func expectError(t *testing.T, res *http.Response, body []byte, status int, code string) apiError {
+	t.Helper()
+	expectStatus(t, res, body, status)
+	if ct := res.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected JSON error, got Content-Type %q", ct)
+	}
+	apiErr := decode[errorResponse](t, body).Error
+	if apiErr.Code != code {
+		t.Errorf("expected error code %q, got %q", code, apiErr.Code)
+	}
+	if apiErr.Message == "" {
+		t.Error("expected an error message")
+	}
+	if apiErr.RequestID == "" || apiErr.RequestID != res.Header.Get("X-Request-ID") {
+		t.Errorf("expected request ID %q in error body, got %q", res.Header.Get("X-Request-ID"), apiErr.RequestID)
+	}
+	return apiErr
+}
+
+func decode[T any](t *testing.T, body []byte) T {
+	t.Helper()
+	var v T
+	if err := json.Unmarshal(body, &v); err != nil {
+		t.Fatalf("decode %q: %v", body, err)
+	}
+	return v
+}
+
+func (ts *testServer) createUser(t *testing.T, email, password string) User {
+	t.Helper()
+	res, body := ts.do(t, "POST", "/api/users", "", credsRequest{Email: email, Password: password})
+	expectStatus(t, res, body, http.StatusCreated)
+	return decode[User](t, body)
+}
+
+func (ts *testServer) login(t *testing.T, email, password string) AuthSuccessResponse {
+	t.Helper()
+	res, body := ts.do(t, "POST", "/api/login", "", credsRequest{Email: email, Password: password})
+	expectStatus(t, res, body, http.StatusOK)
+	return decode[AuthSuccessResponse](t, body)
+}
+
+// loginAs creates a user with role, named after it, and logs them in.
+func (ts *testServer) loginAs(t *testing.T, role string) AuthSuccessResponse {
+	t.Helper()
+	email := role + "@chirpy.test"
+	ts.createUser(t, email, "correct horse")
+	if err := setRoleByEmail(context.Background(), ts.cfg.db, email, role, io.Discard); err != nil {
+		t.Fatalf("setRoleByEmail: %v", err)
+	}
+	return ts.login(t, email, "correct horse")
+}
+
+func TestHealthz(t *testing.T) {
+	ts := newTestServer(t)
+
+	res, body := ts.do(t, "GET", "/api/healthz", "", nil)
+	expectStatus(t, res, body, http.StatusOK)
+	if string(body) != "OK" {
+		t.Errorf("expected OK, got %q", body)
+	}
+}
+
+func TestAdminMetricsCountsAppHits(t *testing.T) {
+	ts := newTestServer(t)
+
+	ts.do(t, "GET", "/app/", "", nil)
+	ts.do(t, "GET", "/app/", "", nil)
+
+	res, body := ts.do(t, "GET", "/admin/metrics", bearer(ts.loginAs(t, roleAdmin).Token), nil)
+	expectStatus(t, res, body, http.StatusOK)
+	if !strings.Contains(string(body), "visited 2 times") {
+		t.Errorf("expected 2 visits in metrics page, got %s", body)
+	}
+}
+
+func TestAdminReset(t *testing.T) {
+	ts := newTestServer(t)
+	admin := bearer(ts.loginAs(t, roleAdmin).Token)
+	ts.createUser(t, "walt@example.com", "heisenberg")
+
+	res, body := ts.do(t, "POST", "/admin/reset", admin, nil)
+	expectStatus(t, res, body, http.StatusOK)
+
+	res, body = ts.do(t, "POST", "/api/login", "", credsRequest{Email: "walt@example.com", Password: "heisenberg"})
+	if res.StatusCode == http.StatusOK {
+		t.Fatalf("expected login to fail after reset, got %d: %s", res.StatusCode, body)
+	}
+}
+
+func TestAdminResetOutsideDev(t *testing.T) {
+	ts := newTestServer(t)
+	ts.cfg.platform = "production"
+
+	res, body := ts.do(t, "POST", "/admin/reset", bearer(ts.loginAs(t, roleAdmin).Token), nil)
+	expectError(t, res, body, http.StatusForbidden, errCodeForbidden)
+}
+
+func TestPolkaWebhook(t *testing.T) {
+	ts := newTestServer(t)
+	user := ts.createUser(t, "walt@example.com", "heisenberg")
+
+	event := polkaRequest{Event: "user.upgraded"}
+	event.Data.UserId = user.ID
+
+	res, body := ts.do(t, "POST", "/api/polka/webhooks", "ApiKey wrong-key", event)
+	expectError(t, res, body, http.StatusUnauthorized, errCodeUnauthorized)
+
+	res, body = ts.do(t, "POST", "/api/polka/webhooks", "ApiKey "+testPolkaKey, event)
+	expectStatus(t, res, body, http.StatusNoContent)
+
+	auth := ts.login(t, "walt@example.com", "heisenberg")
+	if !auth.IsChirpyRed {
+		t.Error("expected user to be upgraded to Chirpy Red")
+	}
+}
+
+func TestPolkaWebhookIgnoresOtherEvents(t *testing.T) {
+	ts := newTestServer(t)
+	user := ts.createUser(t, "walt@example.com", "heisenberg")
+
+	event := polkaRequest{Event: "user.payment_failed"}
+	event.Data.UserId = user.ID
+
+	res, body := ts.do(t, "POST", "/api/polka/webhooks", "ApiKey "+testPolkaKey, event)
+	expectStatus(t, res, body, http.StatusNoContent)
+
+	auth := ts.login(t, "walt@example.com", "heisenberg")
+	if auth.IsChirpyRed {
+		t.Error("expected user not to be upgraded")
+	}
+}
+
+func TestRequestIDIsPropagated(t *testing.T) {
+	ts := newTestServer(t)
+
+	req, _ := http.NewRequest("POST", ts.URL+"/api/refresh", nil)
+	req.Header.Set("X-Request-ID", "abc-123")
+	res, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatalf("POST /api/refresh: %v", err)
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+
+	apiErr := expectError(t, res, body, http.StatusUnauthorized, errCodeUnauthorized)
+	if apiErr.RequestID != "abc-123" {
+		t.Errorf("expected caller's request ID, got %q", apiErr.RequestID)
+	}
+}
+
+func TestServeDrainsInFlightRequests(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	started := make(chan struct{})
+	server := &http.Server{
+		Addr: addr,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			time.Sleep(200 * time.Millisecond)
+			w.Write([]byte("done"))
+		}),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- serve(ctx, server, 5*time.Second)
+	}()
+
+	var res *http.Response
+	var reqErr error
+	requested := make(chan struct{})
+	go func() {
+		defer close(requested)
+		for range 50 {
+			res, reqErr = http.Get("http://" + addr)
+			if reqErr == nil {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+
+	<-started
+	cancel()
+	<-requested
+	if reqErr != nil {
+		t.Fatalf("in-flight request failed: %v", reqErr)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if string(body) != "done" {
+		t.Errorf("expected in-flight request to complete, got %q", body)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("expected clean shutdown, got %v", err)
+	}
+}
+
+func TestServeReturnsStartupErrors(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer listener.Close()
+
+	server := &http.Server{Addr: listener.Addr().String()}
+	if err := serve(context.Background(), server, time.Second); err == nil {
+		t.Fatal("expected an error when the address is already in use")
+	}
+}
+
+func TestPrometheusMetrics(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser(t, "walt@example.com", "heisenberg")
+	ts.login(t, "walt@example.com", "heisenberg")
+	ts.do(t, "POST", "/api/login", "", credsRequest{Email: "walt@example.com", Password: "pinkman"})
+	ts.do(t, "GET", "/api/chirps/not-a-uuid", "", nil)
+	ts.do(t, "GET", "/app/", "", nil)
+
+	res, body := ts.do(t, "GET", "/metrics", bearer(testMetricsKey), nil)
+	expectStatus(t, res, body, http.StatusOK)
+	if ct := res.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("expected Prometheus text format, got %q", ct)
+	}
+
+	for _, want := range []string{
+		`chirpy_http_requests_total{route="POST /api/users",status="201"} 1`,
+		`chirpy_http_requests_total{route="POST /api/login",status="401"} 1`,
+		`chirpy_http_requests_total{route="GET /api/chirps/{chirpID}",status="400"} 1`,
+		`chirpy_http_request_duration_seconds_count{route="POST /api/login",status="200"} 1`,
+		`chirpy_http_response_size_bytes_count{route="POST /api/users",status="201"} 1`,
+		`chirpy_logins_total{result="success"} 1`,
+		`chirpy_logins_total{result="failure"} 1`,
+		`chirpy_fileserver_hits_total 1`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("expected metrics to contain %s\n%s", want, body)
+		}
+	}
+}
+
+func TestPrometheusMetricsRequireToken(t *testing.T) {
+	ts := newTestServer(t)
+	admin := ts.loginAs(t, roleAdmin)
+
+	for _, authorization := range []string{"", bearer("wrong-token"), bearer(admin.Token)} {
+		res, body := ts.do(t, "GET", "/metrics", authorization, nil)
+		expectError(t, res, body, http.StatusUnauthorized, errCodeUnauthorized)
+	}
+
+	ts.cfg.metricsToken = ""
+	disabled := httptest.NewServer(newRouter(ts.cfg, http.NotFoundHandler()))
+	defer disabled.Close()
+	res, err := http.Get(disabled.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("GET /metrics: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("expected /metrics to be off without a token, got %d", res.StatusCode)
+	}
+}
+
+func TestQueryName(t *testing.T) {
+	if got := queryName("-- name: CreateChirp :one\nINSERT INTO chirps"); got != "CreateChirp" {
+		t.Errorf("expected CreateChirp, got %q", got)
+	}
+	if got := queryName("SELECT 1"); got != "unknown" {
+		t.Errorf("expected unknown, got %q", got)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dev-perry/go-server/internal/database"
+	"github.com/dev-perry/go-server/internal/metrics"
+)
+
+type serverMetrics struct {
+	registry *metrics.Registry
+
+	fileserverHits    *metrics.Counter
+	requests          *metrics.CounterVec
+	requestDuration   *metrics.HistogramVec
+	responseSize      *metrics.HistogramVec
+	activeConnections *metrics.Gauge
+	dbQueryDuration   *metrics.HistogramVec
+	logins            *metrics.CounterVec
+	webhookEvents     *metrics.CounterVec
+}
+
+func newServerMetrics() *serverMetrics {
+	r := metrics.NewRegistry()
+	return &serverMetrics{
+		registry:          r,
+		fileserverHits:    r.NewCounter("chirpy_fileserver_hits_total", "Requests served under /app/."),
+		requests:          r.NewCounterVec("chirpy_http_requests_total", "HTTP requests by route pattern and status.", "route", "status"),
+		requestDuration:   r.NewHistogramVec("chirpy_http_request_duration_seconds", "HTTP request latency by route pattern and status.", metrics.DefBuckets, "route", "status"),
+		responseSize:      r.NewHistogramVec("chirpy_http_response_size_bytes", "HTTP response body size by route pattern and status.", metrics.SizeBuckets, "route", "status"),
+		activeConnections: r.NewGauge("chirpy_http_active_connections", "Open client connections."),
+		dbQueryDuration:   r.NewHistogramVec("chirpy_db_query_duration_seconds", "Database query latency by sqlc query name.", metrics.DefBuckets, "query"),
+		logins:            r.NewCounterVec("chirpy_logins_total", "Login attempts by result.", "result"),
+		webhookEvents:     r.NewCounterVec("chirpy_webhook_events_total", "Polka webhook events received by type.", "event"),
+	}
+}
+
+// statusRecorder captures what a handler wrote so it can be reported after
+// the fact.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.size += n
+	return n, err
+}
+
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// middlewareRequestMetrics must wrap the ServeMux directly: the mux records
+// the matched pattern on the request it is given, which is what we label by.
+func (m *serverMetrics) middlewareRequestMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		labels := []string{route, strconv.Itoa(status)}
+		m.requests.With(labels...).Inc()
+		m.requestDuration.With(labels...).Observe(time.Since(start).Seconds())
+		m.responseSize.With(labels...).Observe(float64(rec.size))
+	})
+}
+
+// trackConnState is installed as http.Server.ConnState.
+func (m *serverMetrics) trackConnState(_ net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		m.activeConnections.Inc()
+	case http.StateClosed, http.StateHijacked:
+		m.activeConnections.Dec()
+	}
+}
+
+// instrumentedDB times every statement sqlc sends, labelled by the query
+// name sqlc embeds as a leading "-- name: X" comment.
+type instrumentedDB struct {
+	db       database.DBTX
+	duration *metrics.HistogramVec
+}
+
+func (m *serverMetrics) instrumentDB(db database.DBTX) database.DBTX {
+	return instrumentedDB{db: db, duration: m.dbQueryDuration}
+}
+
+func (i instrumentedDB) observe(query string, start time.Time) {
+	i.duration.With(queryName(query)).Observe(time.Since(start).Seconds())
+}
+
+func (i instrumentedDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	defer i.observe(query, time.Now())
+	return i.db.ExecContext(ctx, query, args...)
+}
+
+func (i instrumentedDB) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return i.db.PrepareContext(ctx, query)
+}
+
+func (i instrumentedDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	defer i.observe(query, time.Now())
+	return i.db.QueryContext(ctx, query, args...)
+}
+
+func (i instrumentedDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	defer i.observe(query, time.Now())
+	return i.db.QueryRowContext(ctx, query, args...)
+}
+
+func queryName(query string) string {
+	rest, ok := strings.CutPrefix(query, "-- name: ")
+	if !ok {
+		return "unknown"
+	}
+	name, _, _ := strings.Cut(rest, " ")
+	return name
+}
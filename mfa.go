@@ -0,0 +1,441 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/dev-perry/go-server/internal/auth"
+	"github.com/dev-perry/go-server/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	totpIssuer        = "Chirpy"
+	recoveryCodeCount = 10
+	mfaChallengeTTL   = 5 * time.Minute
+	// maxMFAAttempts bounds guesses per challenge; with a one-in-a-million
+	// chance per guess, a fresh password login is needed for every few tries.
+	maxMFAAttempts = 5
+)
+
+var (
+	errInvalidMFAChallenge = errors.New("MFA challenge is invalid")
+	errInvalidSecondFactor = errors.New("authentication code is invalid")
+)
+
+type MFAChallengeResponse struct {
+	MFARequired bool      `json:"mfa_required"`
+	MFAToken    string    `json:"mfa_token"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+type TOTPEnrollment struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+	// RecoveryCodes are only ever shown here; the server keeps hashes.
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// secondFactorRequest carries either a code from the authenticator app or
+// one of the recovery codes.
+type secondFactorRequest struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// startMFAChallenge answers a correct password for an account with 2FA
+// enabled. The token it returns is exchanged for real tokens at
+// /api/login/2fa together with a code.
+func (cfg *apiConfig) startMFAChallenge(w http.ResponseWriter, r *http.Request, userID uuid.UUID) {
+	token, err := auth.MakeOpaqueToken()
+	if err != nil {
+		log.Printf("Error generating MFA challenge: %s", err)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to start two-factor login")
+		return
+	}
+	expiresAt := time.Now().Add(mfaChallengeTTL)
+	err = cfg.db.CreateMFAChallenge(r.Context(), database.CreateMFAChallengeParams{
+		ID:        token.ID,
+		UserID:    userID,
+		TokenHash: auth.HashOpaqueToken(token.Secret, cfg.refreshPepper, auth.PurposeMFAChallenge),
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		log.Printf("Error storing MFA challenge: %s", err)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to start two-factor login")
+		return
+	}
+	cfg.metrics.logins.With("mfa_required").Inc()
+	respondWithJSON(w, 200, MFAChallengeResponse{
+		MFARequired: true,
+		MFAToken:    token.String(),
+		ExpiresAt:   expiresAt,
+	})
+}
+
+// loginSecondFactor completes a login started by startMFAChallenge.
+func (cfg *apiConfig) loginSecondFactor(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		MFAToken string `json:"mfa_token"`
+		secondFactorRequest
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, r, 400, errCodeBadRequest, "Unable to decode request body")
+		return
+	}
+
+	challenge, lookupErr := cfg.lookupMFAChallenge(r.Context(), req.MFAToken)
+	if errors.Is(lookupErr, errInvalidMFAChallenge) {
+		cfg.metrics.logins.With("failure").Inc()
+		respondWithError(w, r, 401, errCodeUnauthorized, "Two-factor login has expired, log in again")
+		return
+	}
+	if lookupErr != nil {
+		log.Printf("Error looking up MFA challenge: %s", lookupErr)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to verify code")
+		return
+	}
+	// Counted before checking, and outside the transaction below, so failed
+	// guesses can't be rolled back.
+	attempts, dbErr := cfg.db.RecordMFAChallengeAttempt(r.Context(), challenge.ID)
+	if dbErr != nil {
+		log.Printf("Error recording MFA attempt: %s", dbErr)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to verify code")
+		return
+	}
+	if attempts > maxMFAAttempts {
+		cfg.metrics.logins.With("failure").Inc()
+		respondWithError(w, r, 401, errCodeUnauthorized, "Too many attempts, log in again")
+		return
+	}
+
+	totp, dbErr := cfg.db.GetUserTOTP(r.Context(), challenge.UserID)
+	if dbErr != nil {
+		log.Printf("Error loading 2FA settings for user %s: %s", challenge.UserID, dbErr)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to verify code")
+		return
+	}
+	wait, throttleErr := cfg.loginLockedFor(r.Context(), r, totp.Email)
+	if throttleErr != nil {
+		log.Printf("Error checking login throttle: %s", throttleErr)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to verify code")
+		return
+	}
+	if wait > 0 {
+		cfg.metrics.logins.With("failure").Inc()
+		respondWithLoginLocked(w, r, wait)
+		return
+	}
+	txErr := cfg.db.ExecTx(r.Context(), func(q database.Querier) error {
+		if err := cfg.useSecondFactor(r.Context(), q, challenge.UserID, totp, req.secondFactorRequest); err != nil {
+			return err
+		}
+		used, err := q.UseMFAChallenge(r.Context(), challenge.ID)
+		if err != nil {
+			return err
+		}
+		if used == 0 {
+			return errInvalidMFAChallenge
+		}
+		return nil
+	})
+	if errors.Is(txErr, errInvalidSecondFactor) {
+		// Counts toward the same limits as wrong passwords, so knowing the
+		// password doesn't buy unlimited guesses across fresh challenges.
+		cfg.recordLoginFailure(r.Context(), r, totp.Email)
+		respondWithInvalidSecondFactor(w, r)
+		return
+	}
+	if errors.Is(txErr, errInvalidMFAChallenge) {
+		cfg.metrics.logins.With("failure").Inc()
+		respondWithError(w, r, 401, errCodeUnauthorized, "Two-factor login has expired, log in again")
+		return
+	}
+	if txErr != nil {
+		log.Printf("Error verifying second factor for user %s: %s", challenge.UserID, txErr)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to verify code")
+		return
+	}
+
+	user, dbErr := cfg.db.GetUser(r.Context(), challenge.UserID)
+	if dbErr != nil {
+		log.Printf("Error loading user %s: %s", challenge.UserID, dbErr)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to generate user tokens")
+		return
+	}
+	// The account may have been locked since the password was checked.
+	state, dbErr := cfg.db.GetUserAuthState(r.Context(), challenge.UserID)
+	if dbErr != nil {
+		log.Printf("Error loading user %s: %s", challenge.UserID, dbErr)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to generate user tokens")
+		return
+	}
+	if state.LockedAt.Valid {
+		cfg.metrics.logins.With("failure").Inc()
+		respondWithError(w, r, 403, errCodeForbidden, "Account is locked")
+		return
+	}
+	cfg.clearAccountThrottle(r.Context(), user.Email)
+	cfg.completeLogin(w, r, User{
+		ID:            user.ID,
+		Email:         user.Email,
+		CreatedAt:     user.CreatedAt,
+		UpdatedAt:     user.UpdatedAt,
+		IsChirpyRed:   user.IsChirpyRed.Bool,
+		EmailVerified: user.EmailVerifiedAt.Valid,
+		Role:          state.Role,
+	}, state.TokenVersion)
+}
+
+// enrollTOTP generates a new TOTP secret and recovery codes for the caller.
+// 2FA isn't enforced until confirmTOTP sees a code made from the secret.
+func (cfg *apiConfig) enrollTOTP(w http.ResponseWriter, r *http.Request) {
+	if cfg.totpCipher == nil {
+		respondWithError(w, r, 403, errCodeForbidden, "Two-factor authentication is disabled")
+		return
+	}
+	caller, _ := principalFromContext(r.Context())
+	totp, dbErr := cfg.db.GetUserTOTP(r.Context(), caller.UserID)
+	if dbErr != nil {
+		log.Printf("Error loading 2FA settings for user %s: %s", caller.UserID, dbErr)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to set up two-factor authentication")
+		return
+	}
+	if totp.TotpEnabledAt.Valid {
+		respondWithError(w, r, 409, errCodeConflict, "Two-factor authentication is already enabled")
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		log.Printf("Error generating TOTP secret: %s", err)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to set up two-factor authentication")
+		return
+	}
+	sealed, err := cfg.totpCipher.Seal([]byte(secret), caller.UserID[:])
+	if err != nil {
+		log.Printf("Error encrypting TOTP secret: %s", err)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to set up two-factor authentication")
+		return
+	}
+	codes, err := auth.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		log.Printf("Error generating recovery codes: %s", err)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to set up two-factor authentication")
+		return
+	}
+
+	txErr := cfg.db.ExecTx(r.Context(), func(q database.Querier) error {
+		err := q.SetTOTPSecret(r.Context(), database.SetTOTPSecretParams{
+			ID:         caller.UserID,
+			TotpSecret: sql.NullString{String: sealed, Valid: true},
+		})
+		if err != nil {
+			return err
+		}
+		if err := q.DeleteRecoveryCodes(r.Context(), caller.UserID); err != nil {
+			return err
+		}
+		for _, code := range codes {
+			err := q.CreateRecoveryCode(r.Context(), database.CreateRecoveryCodeParams{
+				UserID:   caller.UserID,
+				CodeHash: auth.HashOpaqueToken(code, cfg.refreshPepper, auth.PurposeRecoveryCode),
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if txErr != nil {
+		log.Printf("Error storing 2FA enrollment for user %s: %s", caller.UserID, txErr)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to set up two-factor authentication")
+		return
+	}
+	respondWithJSON(w, 200, TOTPEnrollment{
+		Secret:        secret,
+		OTPAuthURI:    auth.TOTPURI(totpIssuer, totp.Email, secret),
+		RecoveryCodes: codes,
+	})
+}
+
+// confirmTOTP turns on 2FA once the caller proves their authenticator app
+// has the secret from enrollTOTP.
+func (cfg *apiConfig) confirmTOTP(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+	var req secondFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, r, 400, errCodeBadRequest, "Unable to decode request body")
+		return
+	}
+	totp, dbErr := cfg.db.GetUserTOTP(r.Context(), caller.UserID)
+	if dbErr != nil {
+		log.Printf("Error loading 2FA settings for user %s: %s", caller.UserID, dbErr)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to enable two-factor authentication")
+		return
+	}
+	if totp.TotpEnabledAt.Valid {
+		respondWithError(w, r, 409, errCodeConflict, "Two-factor authentication is already enabled")
+		return
+	}
+	if !totp.TotpSecret.Valid {
+		respondWithError(w, r, 409, errCodeConflict, "Set up two-factor authentication first")
+		return
+	}
+
+	step, checkErr := cfg.checkTOTPCode(caller.UserID, totp, req.Code)
+	if errors.Is(checkErr, errInvalidSecondFactor) {
+		respondWithInvalidSecondFactor(w, r)
+		return
+	}
+	if checkErr != nil {
+		log.Printf("Error checking TOTP code for user %s: %s", caller.UserID, checkErr)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to enable two-factor authentication")
+		return
+	}
+	enabled, dbErr := cfg.db.EnableTOTP(r.Context(), database.EnableTOTPParams{ID: caller.UserID, Step: step})
+	if dbErr != nil {
+		log.Printf("Error enabling 2FA for user %s: %s", caller.UserID, dbErr)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to enable two-factor authentication")
+		return
+	}
+	if enabled == 0 {
+		respondWithError(w, r, 409, errCodeConflict, "Two-factor authentication is already enabled")
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// disableTOTP turns 2FA off. It takes a current code, so a stolen access
+// token alone can't remove the second factor.
+func (cfg *apiConfig) disableTOTP(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+	var req secondFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, r, 400, errCodeBadRequest, "Unable to decode request body")
+		return
+	}
+	totp, dbErr := cfg.db.GetUserTOTP(r.Context(), caller.UserID)
+	if dbErr != nil {
+		log.Printf("Error loading 2FA settings for user %s: %s", caller.UserID, dbErr)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to disable two-factor authentication")
+		return
+	}
+	if !totp.TotpEnabledAt.Valid {
+		respondWithError(w, r, 409, errCodeConflict, "Two-factor authentication is not enabled")
+		return
+	}
+
+	txErr := cfg.db.ExecTx(r.Context(), func(q database.Querier) error {
+		if err := cfg.useSecondFactor(r.Context(), q, caller.UserID, totp, req); err != nil {
+			return err
+		}
+		if err := q.DisableTOTP(r.Context(), caller.UserID); err != nil {
+			return err
+		}
+		return q.DeleteRecoveryCodes(r.Context(), caller.UserID)
+	})
+	if errors.Is(txErr, errInvalidSecondFactor) {
+		respondWithInvalidSecondFactor(w, r)
+		return
+	}
+	if txErr != nil {
+		log.Printf("Error disabling 2FA for user %s: %s", caller.UserID, txErr)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to disable two-factor authentication")
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// useSecondFactor checks a TOTP or recovery code against an enrolled user
+// and spends it: a TOTP code's time step can't be used again, and a recovery
+// code works once. Run it inside ExecTx with whatever the code authorizes.
+func (cfg *apiConfig) useSecondFactor(ctx context.Context, q database.Querier, userID uuid.UUID, totp database.GetUserTOTPRow, req secondFactorRequest) error {
+	if !totp.TotpEnabledAt.Valid {
+		return errInvalidSecondFactor
+	}
+	if req.RecoveryCode != "" {
+		code := auth.NormalizeRecoveryCode(req.RecoveryCode)
+		// Codes handed out before each kind of token had its own key are
+		// stored under the legacy hash until they are spent.
+		for _, hash := range []string{
+			auth.HashOpaqueToken(code, cfg.refreshPepper, auth.PurposeRecoveryCode),
+			auth.LegacyOpaqueTokenHash(code, cfg.refreshPepper),
+		} {
+			used, err := q.UseRecoveryCode(ctx, database.UseRecoveryCodeParams{UserID: userID, CodeHash: hash})
+			if err != nil {
+				return err
+			}
+			if used > 0 {
+				return nil
+			}
+		}
+		return errInvalidSecondFactor
+	}
+
+	step, err := cfg.checkTOTPCode(userID, totp, req.Code)
+	if err != nil {
+		return err
+	}
+	used, err := q.UseTOTPStep(ctx, database.UseTOTPStepParams{ID: userID, Step: step})
+	if err != nil {
+		return err
+	}
+	if used == 0 {
+		return errInvalidSecondFactor
+	}
+	return nil
+}
+
+// checkTOTPCode decrypts the user's secret and returns the time step code
+// belongs to, without recording it as used.
+func (cfg *apiConfig) checkTOTPCode(userID uuid.UUID, totp database.GetUserTOTPRow, code string) (int64, error) {
+	if cfg.totpCipher == nil {
+		return 0, errors.New("MFA_ENCRYPTION_KEY is not set but a user has a TOTP secret")
+	}
+	secret, err := cfg.totpCipher.Open(totp.TotpSecret.String, userID[:])
+	if err != nil {
+		return 0, err
+	}
+	step, ok := auth.ValidateTOTP(string(secret), code, time.Now())
+	if !ok {
+		return 0, errInvalidSecondFactor
+	}
+	return step, nil
+}
+
+// lookupMFAChallenge finds the stored row for a presented challenge token
+// and verifies its secret, returning errInvalidMFAChallenge if it is
+// unknown, wrong, spent or expired.
+func (cfg *apiConfig) lookupMFAChallenge(ctx context.Context, raw string) (database.MfaChallenge, error) {
+	presented, parseErr := auth.ParseOpaqueToken(raw)
+	if parseErr != nil {
+		return database.MfaChallenge{}, errInvalidMFAChallenge
+	}
+	stored, dbErr := cfg.db.GetMFAChallenge(ctx, presented.ID)
+	if errors.Is(dbErr, sql.ErrNoRows) {
+		return database.MfaChallenge{}, errInvalidMFAChallenge
+	}
+	if dbErr != nil {
+		return database.MfaChallenge{}, dbErr
+	}
+	if !auth.CheckOpaqueTokenHash(presented.Secret, cfg.refreshPepper, auth.PurposeMFAChallenge, stored.TokenHash) {
+		return database.MfaChallenge{}, errInvalidMFAChallenge
+	}
+	if stored.UsedAt.Valid || !time.Now().Before(stored.ExpiresAt) {
+		return database.MfaChallenge{}, errInvalidMFAChallenge
+	}
+	return stored, nil
+}
+
+func respondWithInvalidSecondFactor(w http.ResponseWriter, r *http.Request) {
+	respondWithError(w, r, 401, errCodeUnauthorized, "Authentication code is invalid", fieldError{
+		Field:   "code",
+		Message: "is invalid or has already been used",
+	})
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dev-perry/go-server/internal/auth"
+)
+
+type mfaLoginRequest struct {
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code,omitempty"`
+	RecoveryCode string `json:"recovery_code,omitempty"`
+}
+
+// totpCode returns the code for secret offset steps from now. Tests use
+// different steps for each code they expect to succeed, since a step can
+// only be used once.
+func totpCode(t *testing.T, secret string, offset int) string {
+	t.Helper()
+	code, err := auth.TOTPCode(secret, time.Now().Add(time.Duration(offset)*30*time.Second))
+	if err != nil {
+		t.Fatalf("TOTPCode: %v", err)
+	}
+	return code
+}
+
+// enableTOTP enrolls the user behind token and confirms it with the code for
+// the previous time step.
+func (ts *testServer) enableTOTP(t *testing.T, token string) TOTPEnrollment {
+	t.Helper()
+	res, body := ts.do(t, "PUT", "/api/users/2fa", bearer(token), nil)
+	expectStatus(t, res, body, http.StatusOK)
+	enrollment := decode[TOTPEnrollment](t, body)
+	res, body = ts.do(t, "POST", "/api/users/2fa/confirm", bearer(token), secondFactorRequest{Code: totpCode(t, enrollment.Secret, -1)})
+	expectStatus(t, res, body, http.StatusNoContent)
+	return enrollment
+}
+
+// startMFALogin logs in with a password and returns the challenge token.
+func (ts *testServer) startMFALogin(t *testing.T, email, password string) string {
+	t.Helper()
+	res, body := ts.do(t, "POST", "/api/login", "", credsRequest{Email: email, Password: password})
+	expectStatus(t, res, body, http.StatusOK)
+	challenge := decode[MFAChallengeResponse](t, body)
+	if !challenge.MFARequired || challenge.MFAToken == "" {
+		t.Fatalf("expected an MFA challenge, got %s", body)
+	}
+	return challenge.MFAToken
+}
+
+func TestTOTPLogin(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser(t, "walt@example.com", "heisenberg")
+	login := ts.login(t, "walt@example.com", "heisenberg")
+
+	enrollment := ts.enableTOTP(t, login.Token)
+	if !strings.HasPrefix(enrollment.OTPAuthURI, "otpauth://totp/Chirpy:walt@example.com?") {
+		t.Errorf("unexpected otpauth URI %q", enrollment.OTPAuthURI)
+	}
+	if len(enrollment.RecoveryCodes) != recoveryCodeCount {
+		t.Errorf("expected %d recovery codes, got %d", recoveryCodeCount, len(enrollment.RecoveryCodes))
+	}
+	totp, err := ts.cfg.db.GetUserTOTP(t.Context(), login.User.ID)
+	if err != nil {
+		t.Fatalf("GetUserTOTP: %v", err)
+	}
+	if strings.Contains(totp.TotpSecret.String, enrollment.Secret) {
+		t.Error("expected the TOTP secret to be encrypted at rest")
+	}
+
+	mfaToken := ts.startMFALogin(t, "walt@example.com", "heisenberg")
+	code := totpCode(t, enrollment.Secret, 0)
+	res, body := ts.do(t, "POST", "/api/login/2fa", "", mfaLoginRequest{MFAToken: mfaToken, Code: code})
+	expectStatus(t, res, body, http.StatusOK)
+	tokens := decode[AuthSuccessResponse](t, body)
+	if tokens.Token == "" || tokens.RefreshToken == "" || tokens.User.Email != "walt@example.com" {
+		t.Errorf("expected a token pair, got %s", body)
+	}
+
+	// The challenge is spent, and the code can't be replayed on a new one.
+	res, body = ts.do(t, "POST", "/api/login/2fa", "", mfaLoginRequest{MFAToken: mfaToken, Code: totpCode(t, enrollment.Secret, 1)})
+	expectError(t, res, body, http.StatusUnauthorized, errCodeUnauthorized)
+	mfaToken = ts.startMFALogin(t, "walt@example.com", "heisenberg")
+	res, body = ts.do(t, "POST", "/api/login/2fa", "", mfaLoginRequest{MFAToken: mfaToken, Code: code})
+	expectError(t, res, body, http.StatusUnauthorized, errCodeUnauthorized)
+	res, body = ts.do(t, "POST", "/api/login/2fa", "", mfaLoginRequest{MFAToken: mfaToken, Code: totpCode(t, enrollment.Secret, 1)})
+	expectStatus(t, res, body, http.StatusOK)
+}
+
+func TestRecoveryCodeLogin(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser(t, "walt@example.com", "heisenberg")
+	login := ts.login(t, "walt@example.com", "heisenberg")
+	enrollment := ts.enableTOTP(t, login.Token)
+	recovery := strings.ToUpper(strings.ReplaceAll(enrollment.RecoveryCodes[0], "-", ""))
+
+	mfaToken := ts.startMFALogin(t, "walt@example.com", "heisenberg")
+	res, body := ts.do(t, "POST", "/api/login/2fa", "", mfaLoginRequest{MFAToken: mfaToken, RecoveryCode: recovery})
+	expectStatus(t, res, body, http.StatusOK)
+
+	mfaToken = ts.startMFALogin(t, "walt@example.com", "heisenberg")
+	res, body = ts.do(t, "POST", "/api/login/2fa", "", mfaLoginRequest{MFAToken: mfaToken, RecoveryCode: recovery})
+	expectError(t, res, body, http.StatusUnauthorized, errCodeUnauthorized)
+	res, body = ts.do(t, "POST", "/api/login/2fa", "", mfaLoginRequest{MFAToken: mfaToken, RecoveryCode: enrollment.RecoveryCodes[1]})
+	expectStatus(t, res, body, http.StatusOK)
+}
+
+func TestMFAChallengeAttemptLimit(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser(t, "walt@example.com", "heisenberg")
+	login := ts.login(t, "walt@example.com", "heisenberg")
+	enrollment := ts.enableTOTP(t, login.Token)
+
+	mfaToken := ts.startMFALogin(t, "walt@example.com", "heisenberg")
+	for range maxMFAAttempts {
+		res, body := ts.do(t, "POST", "/api/login/2fa", "", mfaLoginRequest{MFAToken: mfaToken, Code: "000000"})
+		expectError(t, res, body, http.StatusUnauthorized, errCodeUnauthorized)
+	}
+	res, body := ts.do(t, "POST", "/api/login/2fa", "", mfaLoginRequest{MFAToken: mfaToken, Code: totpCode(t, enrollment.Secret, 0)})
+	expectError(t, res, body, http.StatusUnauthorized, errCodeUnauthorized)
+
+	res, body = ts.do(t, "POST", "/api/login/2fa", "", mfaLoginRequest{MFAToken: "not-a-token", Code: "000000"})
+	expectError(t, res, body, http.StatusUnauthorized, errCodeUnauthorized)
+}
+
+func TestTOTPEnrollment(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser(t, "walt@example.com", "heisenberg")
+	login := ts.login(t, "walt@example.com", "heisenberg")
+
+	res, body := ts.do(t, "PUT", "/api/users/2fa", bearer(login.Token), nil)
+	expectStatus(t, res, body, http.StatusOK)
+	enrollment := decode[TOTPEnrollment](t, body)
+	res, body = ts.do(t, "POST", "/api/users/2fa/confirm", bearer(login.Token), secondFactorRequest{Code: "000000"})
+	expectError(t, res, body, http.StatusUnauthorized, errCodeUnauthorized)
+
+	// Until confirmed, the password alone still logs in.
+	ts.login(t, "walt@example.com", "heisenberg")
+
+	res, body = ts.do(t, "POST", "/api/users/2fa/confirm", bearer(login.Token), secondFactorRequest{Code: totpCode(t, enrollment.Secret, -1)})
+	expectStatus(t, res, body, http.StatusNoContent)
+	res, body = ts.do(t, "PUT", "/api/users/2fa", bearer(login.Token), nil)
+	expectError(t, res, body, http.StatusConflict, errCodeConflict)
+
+	res, body = ts.do(t, "DELETE", "/api/users/2fa", bearer(login.Token), secondFactorRequest{Code: "000000"})
+	expectError(t, res, body, http.StatusUnauthorized, errCodeUnauthorized)
+	res, body = ts.do(t, "DELETE", "/api/users/2fa", bearer(login.Token), secondFactorRequest{Code: totpCode(t, enrollment.Secret, 0)})
+	expectStatus(t, res, body, http.StatusNoContent)
+	ts.login(t, "walt@example.com", "heisenberg")
+}
+
+func TestTOTPDisabledWithoutKey(t *testing.T) {
+	ts := newTestServer(t)
+	ts.cfg.totpCipher = nil
+	ts.createUser(t, "walt@example.com", "heisenberg")
+	login := ts.login(t, "walt@example.com", "heisenberg")
+
+	res, body := ts.do(t, "PUT", "/api/users/2fa", bearer(login.Token), nil)
+	expectError(t, res, body, http.StatusForbidden, errCodeForbidden)
+}
@@ -0,0 +1,763 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dev-perry/go-server/internal/auth"
+	"github.com/dev-perry/go-server/internal/database"
+	"github.com/google/uuid"
+)
+
+// oauthCodeTTL is how long an authorization code can be exchanged for.
+const oauthCodeTTL = time.Minute
+
+// scopeDescriptions is what the consent page says each scope allows.
+var scopeDescriptions = map[string]string{
+	scopeChirpsRead:   "Read chirps",
+	scopeChirpsWrite:  "Post and delete chirps as you",
+	scopeProfileWrite: "Change your email address and password",
+}
+
+// oauthError is reported to a client in the RFC 6749 format rather than the
+// usual error envelope, either from the token endpoints or in a redirect
+// back from the authorization endpoint.
+type oauthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (e *oauthError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+var (
+	errInvalidOAuthClient = &oauthError{Code: "invalid_client", Description: "Client authentication failed"}
+	errInvalidOAuthGrant  = &oauthError{Code: "invalid_grant", Description: "The grant is invalid, expired or revoked"}
+	errOAuthServer        = &oauthError{Code: "server_error", Description: "The request could not be completed"}
+
+	// Errors about the client or its redirect URI can't be sent back to the
+	// redirect URI, since it may belong to an attacker.
+	errUnknownOAuthClient   = errors.New("unknown client")
+	errUnregisteredRedirect = errors.New("redirect_uri is not registered for the client")
+
+	errAuthorizationCodeUsed = errors.New("authorization code was already used")
+)
+
+func respondWithOAuthError(w http.ResponseWriter, r *http.Request, e *oauthError) {
+	status := 400
+	switch e.Code {
+	case "invalid_client":
+		status = 401
+		if _, _, basic := r.BasicAuth(); basic {
+			w.Header().Set("WWW-Authenticate", `Basic realm="chirpy"`)
+		}
+	case "server_error":
+		status = 500
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, status, e)
+}
+
+// respondWithOAuthFailure sends err if it is an *oauthError, and otherwise
+// logs it and reports a server error.
+func respondWithOAuthFailure(w http.ResponseWriter, r *http.Request, err error, action string) {
+	var oauthErr *oauthError
+	if errors.As(err, &oauthErr) {
+		respondWithOAuthError(w, r, oauthErr)
+		return
+	}
+	log.Printf("Error %s: %s", action, err)
+	respondWithOAuthError(w, r, errOAuthServer)
+}
+
+// parseScopes splits a space-separated scope parameter, refusing scopes that
+// can't be granted. The result is sorted and has no duplicates.
+func parseScopes(raw string) ([]string, error) {
+	var scopes []string
+	for _, scope := range strings.Fields(raw) {
+		if !slices.Contains(grantableScopes, scope) {
+			return nil, &oauthError{Code: "invalid_scope", Description: "Unknown scope " + scope}
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	if len(scopes) == 0 {
+		return nil, &oauthError{Code: "invalid_scope", Description: "At least one scope is required"}
+	}
+	slices.Sort(scopes)
+	return scopes, nil
+}
+
+// authorizeParams are the parameters of an authorization request, taken from
+// the query string and echoed back by the consent page.
+type authorizeParams struct {
+	ResponseType        string `json:"response_type"`
+	ClientID            string `json:"client_id"`
+	RedirectURI         string `json:"redirect_uri"`
+	Scope               string `json:"scope"`
+	State               string `json:"state"`
+	CodeChallenge       string `json:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method"`
+}
+
+type authorizationRequest struct {
+	authorizeParams
+	Client database.OauthClient
+	Scopes []string
+}
+
+// validateAuthorization checks an authorization request. errUnknownOAuthClient
+// and errUnregisteredRedirect must be shown to the user; an *oauthError can
+// be sent to the client at the redirect URI.
+func (cfg *apiConfig) validateAuthorization(ctx context.Context, params authorizeParams) (authorizationRequest, error) {
+	client, dbErr := cfg.db.GetOAuthClient(ctx, params.ClientID)
+	if errors.Is(dbErr, sql.ErrNoRows) {
+		return authorizationRequest{}, errUnknownOAuthClient
+	}
+	if dbErr != nil {
+		return authorizationRequest{}, dbErr
+	}
+	if !slices.Contains(client.RedirectUris, params.RedirectURI) {
+		return authorizationRequest{}, errUnregisteredRedirect
+	}
+
+	if params.ResponseType != "code" {
+		return authorizationRequest{}, &oauthError{Code: "unsupported_response_type", Description: "Only the code response type is supported"}
+	}
+	// PKCE is required of every client, and only with SHA-256, whose
+	// challenges are always 43 characters.
+	if params.CodeChallengeMethod != "S256" || len(params.CodeChallenge) != 43 {
+		return authorizationRequest{}, &oauthError{Code: "invalid_request", Description: "A code_challenge with code_challenge_method S256 is required"}
+	}
+	scopes, scopeErr := parseScopes(params.Scope)
+	if scopeErr != nil {
+		return authorizationRequest{}, scopeErr
+	}
+	return authorizationRequest{authorizeParams: params, Client: client, Scopes: scopes}, nil
+}
+
+// authorizationRedirect is where to send the user back to the client with
+// the outcome of params, along with its state and our issuer identifier.
+func (cfg *apiConfig) authorizationRedirect(params authorizeParams, result url.Values) string {
+	// The URI matched one the client registered, which were checked then.
+	u, _ := url.Parse(params.RedirectURI)
+	q := u.Query()
+	for key, values := range result {
+		q[key] = values
+	}
+	if params.State != "" {
+		q.Set("state", params.State)
+	}
+	q.Set("iss", cfg.publicURL)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+func (cfg *apiConfig) authorizationErrorRedirect(params authorizeParams, e *oauthError) string {
+	return cfg.authorizationRedirect(params, url.Values{
+		"error":             {e.Code},
+		"error_description": {e.Description},
+	})
+}
+
+type consentPageData struct {
+	ClientName string
+	Scopes     []string
+	Request    authorizeParams
+	Nonce      string
+	Error      string
+}
+
+// consentPage asks the signed-in user whether to let a client in. It reads
+// the access token the front-end's login page stores, sending the browser
+// there first if there is none or it has expired, and posts the decision to
+// /oauth/authorize, which answers with where to send the browser next.
+var consentPage = template.Must(template.New("consent").Parse(`<!DOCTYPE html>
+<html>
+    <head>
+        <meta charset="utf-8">
+        <title>Authorize {{.ClientName}} - Chirpy</title>
+    </head>
+    <body>
+        {{if .Error}}
+        <h1>Unable to authorize</h1>
+        <p>{{.Error}}</p>
+        {{else}}
+        <h1>{{.ClientName}} wants to use your Chirpy account</h1>
+        <p>If you allow it, {{.ClientName}} will be able to:</p>
+        <ul>
+            {{range .Scopes}}<li>{{.}}</li>{{end}}
+        </ul>
+        <p id="error"></p>
+        <button id="allow">Allow</button>
+        <button id="deny">Deny</button>
+        <script nonce="{{.Nonce}}">
+            const request = {{.Request}};
+            const token = localStorage.getItem("chirpy_access_token");
+            const login = "/app/login/?next=" + encodeURIComponent(location.pathname + location.search);
+            if (!token) {
+                location.assign(login);
+            }
+            async function decide(approve) {
+                const res = await fetch("/oauth/authorize", {
+                    method: "POST",
+                    headers: {"Authorization": "Bearer " + token, "Content-Type": "application/json"},
+                    body: JSON.stringify({...request, approve}),
+                });
+                if (res.status === 401) {
+                    localStorage.removeItem("chirpy_access_token");
+                    location.assign(login);
+                    return;
+                }
+                const body = await res.json();
+                if (res.ok) {
+                    location.assign(body.redirect_to);
+                } else {
+                    document.getElementById("error").textContent = body.error.message;
+                }
+            }
+            document.getElementById("allow").addEventListener("click", () => decide(true));
+            document.getElementById("deny").addEventListener("click", () => decide(false));
+        </script>
+        {{end}}
+    </body>
+</html>
+`))
+
+func renderConsentPage(w http.ResponseWriter, status int, data consentPageData) {
+	nonce, err := scriptNonce()
+	if err != nil {
+		log.Printf("Error generating script nonce: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	data.Nonce = nonce
+	setPageHeaders(w.Header(), nonce)
+	w.WriteHeader(status)
+	if err := consentPage.Execute(w, data); err != nil {
+		log.Printf("Error rendering consent page: %s", err)
+	}
+}
+
+// authorize serves the consent page for an authorization request.
+func (cfg *apiConfig) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	params := authorizeParams{
+		ResponseType:        q.Get("response_type"),
+		ClientID:            q.Get("client_id"),
+		RedirectURI:         q.Get("redirect_uri"),
+		Scope:               q.Get("scope"),
+		State:               q.Get("state"),
+		CodeChallenge:       q.Get("code_challenge"),
+		CodeChallengeMethod: q.Get("code_challenge_method"),
+	}
+	req, err := cfg.validateAuthorization(r.Context(), params)
+	var oauthErr *oauthError
+	switch {
+	case errors.Is(err, errUnknownOAuthClient):
+		renderConsentPage(w, 400, consentPageData{Error: "The app asking for access is not registered with Chirpy."})
+		return
+	case errors.Is(err, errUnregisteredRedirect):
+		renderConsentPage(w, 400, consentPageData{Error: "The app asked to return to an address it has not registered."})
+		return
+	case errors.As(err, &oauthErr):
+		http.Redirect(w, r, cfg.authorizationErrorRedirect(params, oauthErr), http.StatusFound)
+		return
+	case err != nil:
+		log.Printf("Error checking authorization request: %s", err)
+		renderConsentPage(w, 500, consentPageData{Error: "Something went wrong, try again later."})
+		return
+	}
+
+	descriptions := make([]string, 0, len(req.Scopes))
+	for _, scope := range req.Scopes {
+		descriptions = append(descriptions, scopeDescriptions[scope])
+	}
+	renderConsentPage(w, 200, consentPageData{
+		ClientName: req.Client.Name,
+		Scopes:     descriptions,
+		Request:    params,
+	})
+}
+
+type authorizationDecision struct {
+	RedirectTo string `json:"redirect_to"`
+}
+
+// decideAuthorization records the signed-in user's answer on the consent
+// page, issuing an authorization code if they allowed the client in.
+func (cfg *apiConfig) decideAuthorization(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+	var req struct {
+		authorizeParams
+		Approve bool `json:"approve"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, r, 400, errCodeBadRequest, "Unable to decode request body")
+		return
+	}
+
+	authz, err := cfg.validateAuthorization(r.Context(), req.authorizeParams)
+	var oauthErr *oauthError
+	switch {
+	case errors.Is(err, errUnknownOAuthClient):
+		respondWithError(w, r, 400, errCodeValidation, "Invalid authorization request", fieldError{Field: "client_id", Message: "is not a registered client"})
+		return
+	case errors.Is(err, errUnregisteredRedirect):
+		respondWithError(w, r, 400, errCodeValidation, "Invalid authorization request", fieldError{Field: "redirect_uri", Message: "is not registered for the client"})
+		return
+	case errors.As(err, &oauthErr):
+		respondWithJSON(w, 200, authorizationDecision{RedirectTo: cfg.authorizationErrorRedirect(req.authorizeParams, oauthErr)})
+		return
+	case err != nil:
+		log.Printf("Error checking authorization request: %s", err)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to authorize client")
+		return
+	}
+	if !req.Approve {
+		denied := &oauthError{Code: "access_denied", Description: "The user denied the request"}
+		respondWithJSON(w, 200, authorizationDecision{RedirectTo: cfg.authorizationErrorRedirect(req.authorizeParams, denied)})
+		return
+	}
+
+	code, err := auth.MakeOpaqueToken()
+	if err != nil {
+		log.Printf("Error generating authorization code: %s", err)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to authorize client")
+		return
+	}
+	_, dbErr := cfg.db.CreateOAuthAuthorizationCode(r.Context(), database.CreateOAuthAuthorizationCodeParams{
+		ID:            code.ID,
+		CodeHash:      auth.HashOpaqueToken(code.Secret, cfg.refreshPepper, auth.PurposeOAuthCode),
+		ClientID:      authz.Client.ID,
+		UserID:        caller.UserID,
+		RedirectUri:   authz.RedirectURI,
+		Scopes:        authz.Scopes,
+		CodeChallenge: authz.CodeChallenge,
+		FamilyID:      uuid.New(),
+		ExpiresAt:     time.Now().Add(oauthCodeTTL),
+	})
+	if dbErr != nil {
+		log.Printf("Error storing authorization code: %s", dbErr)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to authorize client")
+		return
+	}
+	respondWithJSON(w, 200, authorizationDecision{
+		RedirectTo: cfg.authorizationRedirect(req.authorizeParams, url.Values{"code": {code.String()}}),
+	})
+}
+
+// authenticateOAuthClient checks the credentials a client sent to a token
+// endpoint, either with HTTP Basic or as client_id and client_secret form
+// fields. Public clients send only their client_id.
+func (cfg *apiConfig) authenticateOAuthClient(r *http.Request) (database.OauthClient, error) {
+	clientID, secret, basic := r.BasicAuth()
+	if basic {
+		if r.PostForm.Has("client_secret") {
+			return database.OauthClient{}, &oauthError{Code: "invalid_request", Description: "Use only one client authentication method"}
+		}
+		var idErr, secretErr error
+		clientID, idErr = url.QueryUnescape(clientID)
+		secret, secretErr = url.QueryUnescape(secret)
+		if idErr != nil || secretErr != nil {
+			return database.OauthClient{}, errInvalidOAuthClient
+		}
+	} else {
+		clientID = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+	if clientID == "" {
+		return database.OauthClient{}, errInvalidOAuthClient
+	}
+
+	client, dbErr := cfg.db.GetOAuthClient(r.Context(), clientID)
+	if errors.Is(dbErr, sql.ErrNoRows) {
+		return database.OauthClient{}, errInvalidOAuthClient
+	}
+	if dbErr != nil {
+		return database.OauthClient{}, dbErr
+	}
+	if client.SecretHash.Valid {
+		rehash := func(ctx context.Context, newHash string) error {
+			return cfg.db.RehashOAuthClientSecret(ctx, database.RehashOAuthClientSecretParams{
+				ID:      client.ID,
+				NewHash: newHash,
+				OldHash: client.SecretHash.String,
+			})
+		}
+		if secret == "" || !cfg.checkOpaqueTokenHash(r.Context(), secret, auth.PurposeOAuthClientSecret, client.SecretHash.String, rehash) {
+			return database.OauthClient{}, errInvalidOAuthClient
+		}
+	} else if secret != "" {
+		return database.OauthClient{}, errInvalidOAuthClient
+	}
+	return client, nil
+}
+
+// parseOAuthForm reads a token endpoint request and authenticates its
+// client. Parameters are only taken from the form body.
+func (cfg *apiConfig) parseOAuthForm(r *http.Request) (database.OauthClient, error) {
+	if err := r.ParseForm(); err != nil {
+		return database.OauthClient{}, &oauthError{Code: "invalid_request", Description: "Unable to parse the request body"}
+	}
+	return cfg.authenticateOAuthClient(r)
+}
+
+type oauthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	Scope        string `json:"scope"`
+}
+
+// oauthToken is the token endpoint: it exchanges authorization codes and
+// refresh tokens for access tokens limited to what the user granted.
+func (cfg *apiConfig) oauthToken(w http.ResponseWriter, r *http.Request) {
+	client, err := cfg.parseOAuthForm(r)
+	if err != nil {
+		respondWithOAuthFailure(w, r, err, "authenticating OAuth client")
+		return
+	}
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		cfg.exchangeAuthorizationCode(w, r, client)
+	case "refresh_token":
+		cfg.refreshOAuthToken(w, r, client)
+	case "":
+		respondWithOAuthError(w, r, &oauthError{Code: "invalid_request", Description: "grant_type is required"})
+	default:
+		respondWithOAuthError(w, r, &oauthError{Code: "unsupported_grant_type", Description: "Only authorization_code and refresh_token grants are supported"})
+	}
+}
+
+func (cfg *apiConfig) exchangeAuthorizationCode(w http.ResponseWriter, r *http.Request, client database.OauthClient) {
+	ctx := r.Context()
+	presented, parseErr := auth.ParseOpaqueToken(r.PostForm.Get("code"))
+	if parseErr != nil {
+		respondWithOAuthError(w, r, errInvalidOAuthGrant)
+		return
+	}
+	code, dbErr := cfg.db.GetOAuthAuthorizationCode(ctx, presented.ID)
+	if errors.Is(dbErr, sql.ErrNoRows) {
+		respondWithOAuthError(w, r, errInvalidOAuthGrant)
+		return
+	}
+	if dbErr != nil {
+		respondWithOAuthFailure(w, r, dbErr, "looking up authorization code")
+		return
+	}
+	if !auth.CheckOpaqueTokenHash(presented.Secret, cfg.refreshPepper, auth.PurposeOAuthCode, code.CodeHash) || code.ClientID != client.ID {
+		respondWithOAuthError(w, r, errInvalidOAuthGrant)
+		return
+	}
+	if code.UsedAt.Valid {
+		cfg.handleAuthorizationCodeReuse(ctx, code)
+		respondWithOAuthError(w, r, errInvalidOAuthGrant)
+		return
+	}
+	if !code.ExpiresAt.After(time.Now()) {
+		respondWithOAuthError(w, r, errInvalidOAuthGrant)
+		return
+	}
+	if r.PostForm.Get("redirect_uri") != code.RedirectUri {
+		respondWithOAuthError(w, r, &oauthError{Code: "invalid_grant", Description: "redirect_uri does not match the authorization request"})
+		return
+	}
+	if !auth.CheckPKCE(r.PostForm.Get("code_verifier"), code.CodeChallenge) {
+		respondWithOAuthError(w, r, &oauthError{Code: "invalid_grant", Description: "code_verifier does not match the code_challenge"})
+		return
+	}
+
+	tokens, issueErr := cfg.issueOAuthTokens(r, client, code.UserID, code.FamilyID, code.Scopes, code.Scopes, func(q database.Querier) error {
+		used, err := q.UseOAuthAuthorizationCode(ctx, code.ID)
+		if err != nil {
+			return fmt.Errorf("using authorization code: %w", err)
+		}
+		if used == 0 {
+			return errAuthorizationCodeUsed
+		}
+		return nil
+	})
+	if errors.Is(issueErr, errAuthorizationCodeUsed) {
+		// Another request exchanged it first.
+		cfg.handleAuthorizationCodeReuse(ctx, code)
+		respondWithOAuthError(w, r, errInvalidOAuthGrant)
+		return
+	}
+	if issueErr != nil {
+		respondWithOAuthFailure(w, r, issueErr, "issuing OAuth tokens")
+		return
+	}
+	respondWithOAuthTokens(w, tokens)
+}
+
+// handleAuthorizationCodeReuse revokes whatever a code was exchanged for
+// when it is presented again, since one of the two parties stole it.
+func (cfg *apiConfig) handleAuthorizationCodeReuse(ctx context.Context, code database.OauthAuthorizationCode) {
+	log.Printf("Authorization code reuse detected for client %s, revoking family %s", code.ClientID, code.FamilyID)
+	if err := cfg.db.RevokeRefreshTokenFamily(ctx, code.FamilyID); err != nil {
+		log.Printf("Error revoking refresh token family %s: %s", code.FamilyID, err)
+	}
+}
+
+func (cfg *apiConfig) refreshOAuthToken(w http.ResponseWriter, r *http.Request, client database.OauthClient) {
+	ctx := r.Context()
+	current, lookupErr := cfg.lookupRefreshToken(ctx, r.PostForm.Get("refresh_token"))
+	if errors.Is(lookupErr, errInvalidRefreshToken) {
+		respondWithOAuthError(w, r, errInvalidOAuthGrant)
+		return
+	}
+	if lookupErr != nil {
+		respondWithOAuthFailure(w, r, lookupErr, "looking up refresh token")
+		return
+	}
+	if !current.ClientID.Valid || current.ClientID.String != client.ID {
+		respondWithOAuthError(w, r, errInvalidOAuthGrant)
+		return
+	}
+	if current.RotatedAt.Valid {
+		cfg.handleRefreshTokenReuse(ctx, current)
+		respondWithOAuthError(w, r, errInvalidOAuthGrant)
+		return
+	}
+	if current.RevokedAt.Valid || !current.ExpiresAt.After(time.Now()) {
+		respondWithOAuthError(w, r, errInvalidOAuthGrant)
+		return
+	}
+	// The client may ask for fewer scopes than it was granted, but the grant
+	// itself, and so the next refresh token, keeps them all.
+	tokenScopes := current.Scopes
+	if raw := r.PostForm.Get("scope"); raw != "" {
+		requested, scopeErr := parseScopes(raw)
+		if scopeErr != nil {
+			respondWithOAuthFailure(w, r, scopeErr, "parsing scopes")
+			return
+		}
+		for _, scope := range requested {
+			if !slices.Contains(current.Scopes, scope) {
+				respondWithOAuthError(w, r, &oauthError{Code: "invalid_scope", Description: "The " + scope + " scope was not granted"})
+				return
+			}
+		}
+		tokenScopes = requested
+	}
+
+	tokens, issueErr := cfg.issueOAuthTokens(r, client, current.UserID, current.FamilyID, current.Scopes, tokenScopes, func(q database.Querier) error {
+		_, err := q.RotateRefreshToken(ctx, current.Token)
+		if errors.Is(err, sql.ErrNoRows) {
+			return errRefreshTokenRotated
+		}
+		return err
+	})
+	if errors.Is(issueErr, errRefreshTokenRotated) {
+		cfg.handleRefreshTokenReuse(ctx, current)
+		respondWithOAuthError(w, r, errInvalidOAuthGrant)
+		return
+	}
+	if issueErr != nil {
+		respondWithOAuthFailure(w, r, issueErr, "refreshing OAuth token")
+		return
+	}
+	respondWithOAuthTokens(w, tokens)
+}
+
+// issueOAuthTokens returns an access token for tokenScopes and a refresh
+// token in familyID that keeps grantScopes. spend retires whatever the
+// client presented for them in the same transaction as the refresh token is
+// stored, so if storing fails the client can try again with it.
+func (cfg *apiConfig) issueOAuthTokens(r *http.Request, client database.OauthClient, userID, familyID uuid.UUID, grantScopes, tokenScopes []string, spend func(database.Querier) error) (oauthTokenResponse, error) {
+	var tokens oauthTokenResponse
+	err := cfg.db.ExecTx(r.Context(), func(q database.Querier) error {
+		if err := spend(q); err != nil {
+			return err
+		}
+		state, err := q.GetUserAuthState(r.Context(), userID)
+		if err != nil {
+			return fmt.Errorf("loading user: %w", err)
+		}
+		if state.LockedAt.Valid {
+			return errInvalidOAuthGrant
+		}
+
+		accessToken, err := auth.MakeClientJWT(userID, familyID, state.TokenVersion, state.Role, client.ID, tokenScopes, cfg.jwtKeys, cfg.accessTokenTTL)
+		if err != nil {
+			return fmt.Errorf("creating access token: %w", err)
+		}
+		refreshToken, err := cfg.storeRefreshToken(r, q, database.CreateRefreshTokenParams{
+			UserID:   userID,
+			FamilyID: familyID,
+			ClientID: sql.NullString{String: client.ID, Valid: true},
+			Scopes:   grantScopes,
+		})
+		if err != nil {
+			return fmt.Errorf("storing refresh token: %w", err)
+		}
+		tokens = oauthTokenResponse{
+			AccessToken:  accessToken,
+			TokenType:    "Bearer",
+			ExpiresIn:    int(cfg.accessTokenTTL.Seconds()),
+			RefreshToken: refreshToken,
+			Scope:        strings.Join(tokenScopes, " "),
+		}
+		return nil
+	})
+	return tokens, err
+}
+
+func respondWithOAuthTokens(w http.ResponseWriter, tokens oauthTokenResponse) {
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, 200, tokens)
+}
+
+// introspectionResponse describes a token per RFC 7662. Everything but
+// Active is left out for tokens that aren't.
+type introspectionResponse struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+}
+
+// introspectOAuthToken tells a client whether a token it holds is still
+// good. Clients can only see their own tokens; any other is reported
+// inactive.
+func (cfg *apiConfig) introspectOAuthToken(w http.ResponseWriter, r *http.Request) {
+	client, err := cfg.parseOAuthForm(r)
+	if err != nil {
+		respondWithOAuthFailure(w, r, err, "authenticating OAuth client")
+		return
+	}
+	token := r.PostForm.Get("token")
+	if token == "" {
+		respondWithOAuthError(w, r, &oauthError{Code: "invalid_request", Description: "token is required"})
+		return
+	}
+	res, err := cfg.introspect(r.Context(), client.ID, token)
+	if err != nil {
+		respondWithOAuthFailure(w, r, err, "introspecting token")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, 200, res)
+}
+
+func (cfg *apiConfig) introspect(ctx context.Context, clientID, token string) (introspectionResponse, error) {
+	var inactive introspectionResponse
+	if claims, err := auth.ValidateJWT(token, cfg.jwtKeys); err == nil {
+		if claims.ClientID != clientID {
+			return inactive, nil
+		}
+		_, authErr := cfg.principalForClaims(ctx, claims)
+		if errors.Is(authErr, auth.ErrRevokedToken) || errors.Is(authErr, auth.ErrInvalidToken) {
+			return inactive, nil
+		}
+		if authErr != nil {
+			return inactive, authErr
+		}
+		return introspectionResponse{
+			Active:    true,
+			Scope:     claims.Scope,
+			ClientID:  claims.ClientID,
+			Subject:   claims.Subject,
+			TokenType: "Bearer",
+			ExpiresAt: claims.ExpiresAt.Unix(),
+			IssuedAt:  claims.IssuedAt.Unix(),
+		}, nil
+	}
+
+	stored, lookupErr := cfg.lookupRefreshToken(ctx, token)
+	if errors.Is(lookupErr, errInvalidRefreshToken) {
+		return inactive, nil
+	}
+	if lookupErr != nil {
+		return inactive, lookupErr
+	}
+	if !stored.ClientID.Valid || stored.ClientID.String != clientID || stored.RevokedAt.Valid || !stored.ExpiresAt.After(time.Now()) {
+		return inactive, nil
+	}
+	state, dbErr := cfg.db.GetUserAuthState(ctx, stored.UserID)
+	if dbErr != nil {
+		return inactive, dbErr
+	}
+	if state.LockedAt.Valid {
+		return inactive, nil
+	}
+	return introspectionResponse{
+		Active:    true,
+		Scope:     strings.Join(stored.Scopes, " "),
+		ClientID:  clientID,
+		Subject:   stored.UserID.String(),
+		ExpiresAt: stored.ExpiresAt.Unix(),
+		IssuedAt:  stored.CreatedAt.Unix(),
+	}, nil
+}
+
+// revokeOAuthToken ends a client's grant given one of its refresh tokens,
+// per RFC 7009. Access tokens can't be revoked one at a time; they expire
+// on their own.
+func (cfg *apiConfig) revokeOAuthToken(w http.ResponseWriter, r *http.Request) {
+	client, err := cfg.parseOAuthForm(r)
+	if err != nil {
+		respondWithOAuthFailure(w, r, err, "authenticating OAuth client")
+		return
+	}
+	token := r.PostForm.Get("token")
+	if token == "" {
+		respondWithOAuthError(w, r, &oauthError{Code: "invalid_request", Description: "token is required"})
+		return
+	}
+	if claims, jwtErr := auth.ValidateJWT(token, cfg.jwtKeys); jwtErr == nil && claims.ClientID == client.ID {
+		respondWithOAuthError(w, r, &oauthError{Code: "unsupported_token_type", Description: "Access tokens cannot be revoked; revoke the refresh token instead"})
+		return
+	}
+
+	stored, lookupErr := cfg.lookupRefreshToken(r.Context(), token)
+	if lookupErr != nil && !errors.Is(lookupErr, errInvalidRefreshToken) {
+		respondWithOAuthFailure(w, r, lookupErr, "looking up refresh token")
+		return
+	}
+	// Unknown tokens and other clients' tokens get the same answer, so
+	// tokens can't be probed.
+	if lookupErr == nil && stored.ClientID.Valid && stored.ClientID.String == client.ID {
+		if err := cfg.db.RevokeRefreshTokenFamily(r.Context(), stored.FamilyID); err != nil {
+			respondWithOAuthFailure(w, r, err, "revoking OAuth grant")
+			return
+		}
+	}
+	w.WriteHeader(200)
+}
+
+// authorizationServerMetadata lets clients discover the endpoints, per
+// RFC 8414.
+func (cfg *apiConfig) authorizationServerMetadata(w http.ResponseWriter, _ *http.Request) {
+	respondWithJSON(w, 200, map[string]any{
+		"issuer":                                         cfg.publicURL,
+		"authorization_endpoint":                         cfg.publicURL + "/oauth/authorize",
+		"token_endpoint":                                 cfg.publicURL + "/oauth/token",
+		"introspection_endpoint":                         cfg.publicURL + "/oauth/introspect",
+		"revocation_endpoint":                            cfg.publicURL + "/oauth/revoke",
+		"jwks_uri":                                       cfg.publicURL + "/.well-known/jwks.json",
+		"scopes_supported":                               grantableScopes,
+		"response_types_supported":                       []string{"code"},
+		"grant_types_supported":                          []string{"authorization_code", "refresh_token"},
+		"code_challenge_methods_supported":               []string{"S256"},
+		"token_endpoint_auth_methods_supported":          []string{"client_secret_basic", "client_secret_post", "none"},
+		"authorization_response_iss_parameter_supported": true,
+	})
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dev-perry/go-server/internal/auth"
+	"github.com/dev-perry/go-server/internal/database"
+)
+
+const (
+	maxOAuthClientNameLength = 100
+	maxOAuthRedirectURIs     = 10
+)
+
+// OAuthClient is a third-party app registered to ask users for access.
+type OAuthClient struct {
+	ID           string    `json:"client_id"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Confidential bool      `json:"confidential"`
+	CreatedAt    time.Time `json:"created_at"`
+	// Secret is only returned when a confidential client is registered; the
+	// server keeps a hash.
+	Secret string `json:"client_secret,omitempty"`
+}
+
+func newOAuthClient(c database.OauthClient) OAuthClient {
+	return OAuthClient{
+		ID:           c.ID,
+		Name:         c.Name,
+		RedirectURIs: c.RedirectUris,
+		Confidential: c.SecretHash.Valid,
+		CreatedAt:    c.CreatedAt,
+	}
+}
+
+// checkRedirectURI returns why raw can't be registered as a redirect URI, or
+// "" if it can. Codes may only be sent over HTTPS, or plain HTTP to the
+// user's own machine for native apps.
+func checkRedirectURI(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || !u.IsAbs() || u.Host == "" {
+		return "must be absolute URLs"
+	}
+	if u.Fragment != "" || strings.Contains(raw, "#") {
+		return "must not contain a fragment"
+	}
+	switch u.Scheme {
+	case "https":
+		return ""
+	case "http":
+		host := u.Hostname()
+		if ip := net.ParseIP(host); host == "localhost" || (ip != nil && ip.IsLoopback()) {
+			return ""
+		}
+	}
+	return "must use https, or http on a loopback address"
+}
+
+func (cfg *apiConfig) registerOAuthClient(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+	var req struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Confidential bool     `json:"confidential"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, r, 400, errCodeBadRequest, "Unable to decode request body")
+		return
+	}
+
+	var problems []fieldError
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		problems = append(problems, fieldError{Field: "name", Message: "is required"})
+	} else if utf8.RuneCountInString(name) > maxOAuthClientNameLength {
+		problems = append(problems, fieldError{Field: "name", Message: "is too long"})
+	}
+	switch {
+	case len(req.RedirectURIs) == 0:
+		problems = append(problems, fieldError{Field: "redirect_uris", Message: "is required"})
+	case len(req.RedirectURIs) > maxOAuthRedirectURIs:
+		problems = append(problems, fieldError{
+			Field:   "redirect_uris",
+			Message: fmt.Sprintf("must have at most %d entries", maxOAuthRedirectURIs),
+		})
+	}
+	var redirectURIs []string
+	for _, uri := range req.RedirectURIs {
+		if problem := checkRedirectURI(uri); problem != "" {
+			problems = append(problems, fieldError{Field: "redirect_uris", Message: problem})
+			break
+		}
+		if !slices.Contains(redirectURIs, uri) {
+			redirectURIs = append(redirectURIs, uri)
+		}
+	}
+	if len(problems) > 0 {
+		respondWithError(w, r, 400, errCodeValidation, "Invalid client registration", problems...)
+		return
+	}
+
+	// The ID half is public; the secret half is only used by confidential
+	// clients.
+	creds, err := auth.MakeOpaqueToken()
+	if err != nil {
+		log.Printf("Error generating OAuth client credentials: %s", err)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to register client")
+		return
+	}
+	var secretHash sql.NullString
+	if req.Confidential {
+		secretHash = sql.NullString{String: auth.HashOpaqueToken(creds.Secret, cfg.refreshPepper, auth.PurposeOAuthClientSecret), Valid: true}
+	}
+	stored, dbErr := cfg.db.CreateOAuthClient(r.Context(), database.CreateOAuthClientParams{
+		ID:           creds.ID,
+		UserID:       caller.UserID,
+		Name:         name,
+		SecretHash:   secretHash,
+		RedirectUris: redirectURIs,
+	})
+	if dbErr != nil {
+		log.Printf("Error storing OAuth client for user %s: %s", caller.UserID, dbErr)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to register client")
+		return
+	}
+	created := newOAuthClient(stored)
+	if req.Confidential {
+		created.Secret = creds.Secret
+	}
+	respondWithJSON(w, 201, created)
+}
+
+func (cfg *apiConfig) listOAuthClients(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+	rows, dbErr := cfg.db.ListOAuthClients(r.Context(), caller.UserID)
+	if dbErr != nil {
+		log.Printf("Error listing OAuth clients: %s", dbErr)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to list clients")
+		return
+	}
+	clients := make([]OAuthClient, 0, len(rows))
+	for _, row := range rows {
+		clients = append(clients, newOAuthClient(row))
+	}
+	respondWithJSON(w, 200, clients)
+}
+
+// deleteOAuthClient removes a client along with every grant users gave it.
+func (cfg *apiConfig) deleteOAuthClient(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+	clientID := r.PathValue("clientID")
+
+	deleted, dbErr := cfg.db.DeleteOAuthClient(r.Context(), database.DeleteOAuthClientParams{
+		ID:     clientID,
+		UserID: caller.UserID,
+	})
+	if dbErr != nil {
+		log.Printf("Error deleting OAuth client %s: %s", clientID, dbErr)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to delete client")
+		return
+	}
+	if deleted == 0 {
+		respondWithError(w, r, 404, errCodeNotFound, "Client not found")
+		return
+	}
+	w.WriteHeader(204)
+}
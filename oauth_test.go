@@ -0,0 +1,476 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dev-perry/go-server/internal/auth"
+)
+
+const (
+	testRedirectURI  = "https://app.example/callback"
+	testCodeVerifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+)
+
+type registerClientRequest struct {
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirect_uris"`
+	Confidential bool     `json:"confidential"`
+}
+
+type decideRequest struct {
+	authorizeParams
+	Approve bool `json:"approve"`
+}
+
+func (ts *testServer) registerClient(t *testing.T, token string, confidential bool) OAuthClient {
+	t.Helper()
+	res, body := ts.do(t, "POST", "/api/oauth/clients", bearer(token), registerClientRequest{
+		Name:         "Birdwatch",
+		RedirectURIs: []string{testRedirectURI},
+		Confidential: confidential,
+	})
+	expectStatus(t, res, body, http.StatusCreated)
+	return decode[OAuthClient](t, body)
+}
+
+func authorizeRequest(clientID, scope string) authorizeParams {
+	return authorizeParams{
+		ResponseType:        "code",
+		ClientID:            clientID,
+		RedirectURI:         testRedirectURI,
+		Scope:               scope,
+		State:               "xyz",
+		CodeChallenge:       auth.PKCEChallenge(testCodeVerifier),
+		CodeChallengeMethod: "S256",
+	}
+}
+
+// decide answers the consent page and returns the query the user would be
+// sent back to the client with.
+func (ts *testServer) decide(t *testing.T, token string, params authorizeParams, approve bool) url.Values {
+	t.Helper()
+	res, body := ts.do(t, "POST", "/oauth/authorize", bearer(token), decideRequest{authorizeParams: params, Approve: approve})
+	expectStatus(t, res, body, http.StatusOK)
+	redirect, err := url.Parse(decode[authorizationDecision](t, body).RedirectTo)
+	if err != nil {
+		t.Fatalf("parse redirect: %v", err)
+	}
+	if !strings.HasPrefix(redirect.String(), testRedirectURI+"?") {
+		t.Fatalf("expected a redirect to the client, got %s", redirect)
+	}
+	q := redirect.Query()
+	if q.Get("state") != params.State || q.Get("iss") != ts.cfg.publicURL {
+		t.Errorf("expected state and iss in the redirect, got %s", redirect)
+	}
+	return q
+}
+
+func (ts *testServer) authorizationCode(t *testing.T, token, clientID, scope string) string {
+	t.Helper()
+	q := ts.decide(t, token, authorizeRequest(clientID, scope), true)
+	if q.Get("code") == "" {
+		t.Fatalf("expected a code, got %v", q)
+	}
+	return q.Get("code")
+}
+
+// postForm calls a token endpoint, authenticating with HTTP Basic when
+// secret is set.
+func (ts *testServer) postForm(t *testing.T, path, clientID, secret string, form url.Values) (*http.Response, []byte) {
+	t.Helper()
+	if secret == "" && clientID != "" {
+		form.Set("client_id", clientID)
+	}
+	req, err := http.NewRequest("POST", ts.URL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatalf("build request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if secret != "" {
+		req.SetBasicAuth(url.QueryEscape(clientID), url.QueryEscape(secret))
+	}
+	res, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatalf("POST %s: %v", path, err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("read response: %v", err)
+	}
+	return res, body
+}
+
+func exchangeForm(code string) url.Values {
+	return url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {testRedirectURI},
+		"code_verifier": {testCodeVerifier},
+	}
+}
+
+func (ts *testServer) exchangeCode(t *testing.T, clientID, secret, code string) oauthTokenResponse {
+	t.Helper()
+	res, body := ts.postForm(t, "/oauth/token", clientID, secret, exchangeForm(code))
+	expectStatus(t, res, body, http.StatusOK)
+	return decode[oauthTokenResponse](t, body)
+}
+
+func expectOAuthError(t *testing.T, res *http.Response, body []byte, status int, code string) {
+	t.Helper()
+	expectStatus(t, res, body, status)
+	if got := decode[oauthError](t, body).Code; got != code {
+		t.Errorf("expected OAuth error %q, got %q", code, got)
+	}
+	if res.Header.Get("Cache-Control") != "no-store" {
+		t.Error("expected Cache-Control: no-store")
+	}
+}
+
+func TestOAuthClientRegistration(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser(t, "walt@example.com", "heisenberg")
+	ts.createUser(t, "jesse@example.com", "yeahscience")
+	walt := ts.login(t, "walt@example.com", "heisenberg")
+	jesse := ts.login(t, "jesse@example.com", "yeahscience")
+
+	for _, uri := range []string{"http://app.example/callback", "https://app.example/callback#frag", "/callback"} {
+		res, body := ts.do(t, "POST", "/api/oauth/clients", bearer(walt.Token), registerClientRequest{Name: "Birdwatch", RedirectURIs: []string{uri}})
+		apiErr := expectError(t, res, body, http.StatusBadRequest, errCodeValidation)
+		if len(apiErr.Details) != 1 || apiErr.Details[0].Field != "redirect_uris" {
+			t.Errorf("%s: expected a redirect_uris detail, got %v", uri, apiErr.Details)
+		}
+	}
+	res, body := ts.do(t, "POST", "/api/oauth/clients", bearer(walt.Token), registerClientRequest{
+		Name:         "Desktop",
+		RedirectURIs: []string{"http://127.0.0.1:8123/callback"},
+	})
+	expectStatus(t, res, body, http.StatusCreated)
+	if public := decode[OAuthClient](t, body); public.Confidential || public.Secret != "" {
+		t.Errorf("expected a public client without a secret, got %s", body)
+	}
+	confidential := ts.registerClient(t, walt.Token, true)
+	if !confidential.Confidential || confidential.Secret == "" {
+		t.Errorf("expected a confidential client with a secret, got %+v", confidential)
+	}
+
+	res, body = ts.do(t, "GET", "/api/oauth/clients", bearer(walt.Token), nil)
+	expectStatus(t, res, body, http.StatusOK)
+	if clients := decode[[]OAuthClient](t, body); len(clients) != 2 || clients[1].Secret != "" {
+		t.Errorf("expected both clients without secrets, got %s", body)
+	}
+	res, body = ts.do(t, "DELETE", "/api/oauth/clients/"+confidential.ID, bearer(jesse.Token), nil)
+	expectError(t, res, body, http.StatusNotFound, errCodeNotFound)
+	res, body = ts.do(t, "DELETE", "/api/oauth/clients/"+confidential.ID, bearer(walt.Token), nil)
+	expectStatus(t, res, body, http.StatusNoContent)
+}
+
+func TestOAuthAuthorizationCodeFlow(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser(t, "walt@example.com", "heisenberg")
+	login := ts.login(t, "walt@example.com", "heisenberg")
+	client := ts.registerClient(t, login.Token, true)
+
+	params := authorizeRequest(client.ID, scopeChirpsRead)
+	query := url.Values{
+		"response_type":         {params.ResponseType},
+		"client_id":             {params.ClientID},
+		"redirect_uri":          {params.RedirectURI},
+		"scope":                 {params.Scope},
+		"state":                 {params.State},
+		"code_challenge":        {params.CodeChallenge},
+		"code_challenge_method": {params.CodeChallengeMethod},
+	}
+	res, body := ts.do(t, "GET", "/oauth/authorize?"+query.Encode(), "", nil)
+	expectStatus(t, res, body, http.StatusOK)
+	if !strings.Contains(string(body), "Birdwatch wants to use your Chirpy account") || !strings.Contains(string(body), "Read chirps") {
+		t.Errorf("expected a consent page, got %s", body)
+	}
+	if csp := res.Header.Get("Content-Security-Policy"); !strings.Contains(csp, "frame-ancestors 'none'") {
+		t.Errorf("expected the page to refuse framing, got %q", csp)
+	}
+
+	code := ts.authorizationCode(t, login.Token, client.ID, scopeChirpsRead)
+	wrongVerifier := exchangeForm(code)
+	wrongVerifier.Set("code_verifier", strings.Repeat("a", 43))
+	res, body = ts.postForm(t, "/oauth/token", client.ID, client.Secret, wrongVerifier)
+	expectOAuthError(t, res, body, http.StatusBadRequest, "invalid_grant")
+
+	tokens := ts.exchangeCode(t, client.ID, client.Secret, code)
+	if tokens.TokenType != "Bearer" || tokens.Scope != scopeChirpsRead || tokens.RefreshToken == "" {
+		t.Errorf("unexpected token response %+v", tokens)
+	}
+	res, body = ts.do(t, "GET", "/api/chirps", bearer(tokens.AccessToken), nil)
+	expectStatus(t, res, body, http.StatusOK)
+	res, body = ts.do(t, "POST", "/api/chirps", bearer(tokens.AccessToken), createChirpRequest{Body: "Say my name"})
+	expectError(t, res, body, http.StatusForbidden, errCodeForbidden)
+	res, body = ts.do(t, "GET", "/api/sessions", bearer(tokens.AccessToken), nil)
+	expectError(t, res, body, http.StatusForbidden, errCodeForbidden)
+	// Client tokens can't be refreshed into a first-party session.
+	res, body = ts.do(t, "POST", "/api/refresh", bearer(tokens.RefreshToken), nil)
+	expectError(t, res, body, http.StatusUnauthorized, errCodeUnauthorized)
+
+	res, body = ts.do(t, "GET", "/api/sessions", bearer(login.Token), nil)
+	expectStatus(t, res, body, http.StatusOK)
+	if sessions := decode[[]Session](t, body); len(sessions) != 2 {
+		t.Errorf("expected the grant to be listed as a session, got %s", body)
+	}
+
+	// A code used twice revokes what it was exchanged for.
+	res, body = ts.postForm(t, "/oauth/token", client.ID, client.Secret, exchangeForm(code))
+	expectOAuthError(t, res, body, http.StatusBadRequest, "invalid_grant")
+	res, body = ts.postForm(t, "/oauth/token", client.ID, client.Secret, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {tokens.RefreshToken},
+	})
+	expectOAuthError(t, res, body, http.StatusBadRequest, "invalid_grant")
+}
+
+func TestConsentPageSendsUserToLogin(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser(t, "walt@example.com", "heisenberg")
+	login := ts.login(t, "walt@example.com", "heisenberg")
+	client := ts.registerClient(t, login.Token, true)
+	params := authorizeRequest(client.ID, scopeChirpsRead)
+	consentPath := "/oauth/authorize?" + url.Values{
+		"response_type":         {params.ResponseType},
+		"client_id":             {params.ClientID},
+		"redirect_uri":          {params.RedirectURI},
+		"scope":                 {params.Scope},
+		"state":                 {params.State},
+		"code_challenge":        {params.CodeChallenge},
+		"code_challenge_method": {params.CodeChallengeMethod},
+	}.Encode()
+
+	res, body := ts.do(t, "GET", consentPath, "", nil)
+	expectStatus(t, res, body, http.StatusOK)
+	if !strings.Contains(string(body), `"/app/login/?next="`) {
+		t.Fatalf("expected the consent page to send the user to log in, got %s", body)
+	}
+
+	// The login page stores the token the consent page reads, then goes back.
+	res, body = ts.do(t, "GET", "/app/login/?next="+url.QueryEscape(consentPath), "", nil)
+	expectStatus(t, res, body, http.StatusOK)
+	for _, want := range []string{`"/api/login"`, `"/api/login/2fa"`, `localStorage.setItem("chirpy_access_token"`, `get("next")`} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("expected the login page to contain %s", want)
+		}
+	}
+}
+
+func TestOAuthAuthorizeErrors(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser(t, "walt@example.com", "heisenberg")
+	login := ts.login(t, "walt@example.com", "heisenberg")
+	client := ts.registerClient(t, login.Token, false)
+
+	res, body := ts.do(t, "GET", "/oauth/authorize?client_id=nope&redirect_uri="+url.QueryEscape(testRedirectURI), "", nil)
+	expectStatus(t, res, body, http.StatusBadRequest)
+	res, body = ts.do(t, "GET", "/oauth/authorize?client_id="+client.ID+"&redirect_uri="+url.QueryEscape("https://evil.example/"), "", nil)
+	expectStatus(t, res, body, http.StatusBadRequest)
+
+	// Other problems are reported to the client.
+	noRedirects := *ts.Client()
+	noRedirects.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	res, err := noRedirects.Get(ts.URL + "/oauth/authorize?response_type=code&state=xyz&client_id=" + client.ID + "&redirect_uri=" + url.QueryEscape(testRedirectURI))
+	if err != nil {
+		t.Fatalf("GET /oauth/authorize: %v", err)
+	}
+	res.Body.Close()
+	location, _ := url.Parse(res.Header.Get("Location"))
+	if res.StatusCode != http.StatusFound || location.Query().Get("error") != "invalid_request" || location.Query().Get("state") != "xyz" {
+		t.Errorf("expected a redirect reporting the missing PKCE challenge, got %d to %s", res.StatusCode, location)
+	}
+
+	q := ts.decide(t, login.Token, authorizeRequest(client.ID, "admin"), true)
+	if q.Get("error") != "invalid_scope" {
+		t.Errorf("expected invalid_scope, got %v", q)
+	}
+	q = ts.decide(t, login.Token, authorizeRequest(client.ID, scopeChirpsRead), false)
+	if q.Get("error") != "access_denied" || q.Get("code") != "" {
+		t.Errorf("expected access_denied, got %v", q)
+	}
+
+	// Only the user themselves, not an API key, can approve a client.
+	key := ts.createAPIKey(t, login.Token, scopeChirpsRead)
+	res, body = ts.do(t, "POST", "/oauth/authorize", apiKeyAuth(key.Key), decideRequest{authorizeParams: authorizeRequest(client.ID, scopeChirpsRead), Approve: true})
+	expectError(t, res, body, http.StatusForbidden, errCodeForbidden)
+}
+
+func TestOAuthClientAuthentication(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser(t, "walt@example.com", "heisenberg")
+	login := ts.login(t, "walt@example.com", "heisenberg")
+	confidential := ts.registerClient(t, login.Token, true)
+	public := ts.registerClient(t, login.Token, false)
+
+	code := ts.authorizationCode(t, login.Token, confidential.ID, scopeChirpsRead)
+	res, body := ts.postForm(t, "/oauth/token", confidential.ID, "", exchangeForm(code))
+	expectOAuthError(t, res, body, http.StatusUnauthorized, "invalid_client")
+	res, body = ts.postForm(t, "/oauth/token", confidential.ID, "wrong", exchangeForm(code))
+	expectOAuthError(t, res, body, http.StatusUnauthorized, "invalid_client")
+	if res.Header.Get("WWW-Authenticate") == "" {
+		t.Error("expected a Basic challenge")
+	}
+	// The secret can also be sent in the form.
+	form := exchangeForm(code)
+	form.Set("client_secret", confidential.Secret)
+	res, body = ts.postForm(t, "/oauth/token", confidential.ID, "", form)
+	expectStatus(t, res, body, http.StatusOK)
+
+	// A code only works for the client it was issued to.
+	code = ts.authorizationCode(t, login.Token, confidential.ID, scopeChirpsRead)
+	res, body = ts.postForm(t, "/oauth/token", public.ID, "", exchangeForm(code))
+	expectOAuthError(t, res, body, http.StatusBadRequest, "invalid_grant")
+
+	res, body = ts.postForm(t, "/oauth/token", public.ID, "", url.Values{"grant_type": {"password"}})
+	expectOAuthError(t, res, body, http.StatusBadRequest, "unsupported_grant_type")
+}
+
+func TestOAuthRefresh(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser(t, "walt@example.com", "heisenberg")
+	login := ts.login(t, "walt@example.com", "heisenberg")
+	client := ts.registerClient(t, login.Token, false)
+	other := ts.registerClient(t, login.Token, false)
+
+	code := ts.authorizationCode(t, login.Token, client.ID, scopeChirpsRead+" "+scopeChirpsWrite)
+	tokens := ts.exchangeCode(t, client.ID, "", code)
+	refresh := func(clientID, refreshToken, scope string) (*http.Response, []byte) {
+		form := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {refreshToken}}
+		if scope != "" {
+			form.Set("scope", scope)
+		}
+		return ts.postForm(t, "/oauth/token", clientID, "", form)
+	}
+
+	res, body := refresh(other.ID, tokens.RefreshToken, "")
+	expectOAuthError(t, res, body, http.StatusBadRequest, "invalid_grant")
+	res, body = refresh(client.ID, tokens.RefreshToken, scopeProfileWrite)
+	expectOAuthError(t, res, body, http.StatusBadRequest, "invalid_scope")
+
+	res, body = refresh(client.ID, tokens.RefreshToken, scopeChirpsRead)
+	expectStatus(t, res, body, http.StatusOK)
+	narrowed := decode[oauthTokenResponse](t, body)
+	if narrowed.Scope != scopeChirpsRead {
+		t.Errorf("expected a narrowed scope, got %q", narrowed.Scope)
+	}
+	res, body = ts.do(t, "POST", "/api/chirps", bearer(narrowed.AccessToken), createChirpRequest{Body: "Say my name"})
+	expectError(t, res, body, http.StatusForbidden, errCodeForbidden)
+
+	// The grant keeps both scopes.
+	res, body = refresh(client.ID, narrowed.RefreshToken, "")
+	expectStatus(t, res, body, http.StatusOK)
+	full := decode[oauthTokenResponse](t, body)
+	if full.Scope != scopeChirpsRead+" "+scopeChirpsWrite {
+		t.Errorf("expected the granted scopes, got %q", full.Scope)
+	}
+	res, body = ts.do(t, "POST", "/api/chirps", bearer(full.AccessToken), createChirpRequest{Body: "Say my name"})
+	expectStatus(t, res, body, http.StatusCreated)
+
+	// Replaying a rotated token revokes the grant.
+	res, body = refresh(client.ID, tokens.RefreshToken, "")
+	expectOAuthError(t, res, body, http.StatusBadRequest, "invalid_grant")
+	res, body = refresh(client.ID, full.RefreshToken, "")
+	expectOAuthError(t, res, body, http.StatusBadRequest, "invalid_grant")
+}
+
+func TestOAuthRefreshSurvivesFailedInsert(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser(t, "walt@example.com", "heisenberg")
+	login := ts.login(t, "walt@example.com", "heisenberg")
+	client := ts.registerClient(t, login.Token, false)
+	tokens := ts.exchangeCode(t, client.ID, "", ts.authorizationCode(t, login.Token, client.ID, scopeChirpsRead))
+	store := newFailingInsertStore(ts.cfg.db)
+	ts.cfg.db = store
+	form := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {tokens.RefreshToken}}
+
+	store.fail.Store(true)
+	res, body := ts.postForm(t, "/oauth/token", client.ID, "", form)
+	expectOAuthError(t, res, body, http.StatusInternalServerError, "server_error")
+
+	// The failed attempt left the grant intact, so retrying isn't reuse.
+	res, body = ts.postForm(t, "/oauth/token", client.ID, "", form)
+	expectStatus(t, res, body, http.StatusOK)
+}
+
+func TestOAuthCodeSurvivesFailedInsert(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser(t, "walt@example.com", "heisenberg")
+	login := ts.login(t, "walt@example.com", "heisenberg")
+	client := ts.registerClient(t, login.Token, false)
+	code := ts.authorizationCode(t, login.Token, client.ID, scopeChirpsRead)
+	store := newFailingInsertStore(ts.cfg.db)
+	ts.cfg.db = store
+
+	store.fail.Store(true)
+	res, body := ts.postForm(t, "/oauth/token", client.ID, "", exchangeForm(code))
+	expectOAuthError(t, res, body, http.StatusInternalServerError, "server_error")
+
+	// The code wasn't spent, so the client can exchange it again.
+	ts.exchangeCode(t, client.ID, "", code)
+}
+
+func TestOAuthIntrospectAndRevoke(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser(t, "walt@example.com", "heisenberg")
+	login := ts.login(t, "walt@example.com", "heisenberg")
+	client := ts.registerClient(t, login.Token, true)
+	other := ts.registerClient(t, login.Token, true)
+	tokens := ts.exchangeCode(t, client.ID, client.Secret, ts.authorizationCode(t, login.Token, client.ID, scopeChirpsRead))
+
+	introspect := func(c OAuthClient, token string) introspectionResponse {
+		t.Helper()
+		res, body := ts.postForm(t, "/oauth/introspect", c.ID, c.Secret, url.Values{"token": {token}})
+		expectStatus(t, res, body, http.StatusOK)
+		return decode[introspectionResponse](t, body)
+	}
+	access := introspect(client, tokens.AccessToken)
+	if !access.Active || access.Scope != scopeChirpsRead || access.ClientID != client.ID || access.ExpiresAt <= time.Now().Unix() {
+		t.Errorf("expected an active access token, got %+v", access)
+	}
+	if refresh := introspect(client, tokens.RefreshToken); !refresh.Active || refresh.Scope != scopeChirpsRead {
+		t.Errorf("expected an active refresh token, got %+v", refresh)
+	}
+	for _, token := range []string{tokens.AccessToken, tokens.RefreshToken, login.Token, "garbage"} {
+		if introspect(other, token).Active {
+			t.Errorf("expected %q to be inactive for another client", token)
+		}
+	}
+
+	res, body := ts.postForm(t, "/oauth/revoke", client.ID, client.Secret, url.Values{"token": {tokens.AccessToken}})
+	expectOAuthError(t, res, body, http.StatusBadRequest, "unsupported_token_type")
+	res, body = ts.postForm(t, "/oauth/revoke", other.ID, other.Secret, url.Values{"token": {tokens.RefreshToken}})
+	expectStatus(t, res, body, http.StatusOK)
+	if !introspect(client, tokens.RefreshToken).Active {
+		t.Error("expected another client's revocation to be ignored")
+	}
+	res, body = ts.postForm(t, "/oauth/revoke", client.ID, client.Secret, url.Values{"token": {tokens.RefreshToken}})
+	expectStatus(t, res, body, http.StatusOK)
+	if introspect(client, tokens.RefreshToken).Active {
+		t.Error("expected the refresh token to be revoked")
+	}
+
+	// Revoking every session also ends the client's access.
+	res, body = ts.do(t, "POST", "/api/sessions/revoke-all", bearer(login.Token), nil)
+	expectStatus(t, res, body, http.StatusNoContent)
+	if introspect(client, tokens.AccessToken).Active {
+		t.Error("expected the access token to be inactive after revoke-all")
+	}
+}
+
+func TestOAuthMetadata(t *testing.T) {
+	ts := newTestServer(t)
+	res, body := ts.do(t, "GET", "/.well-known/oauth-authorization-server", "", nil)
+	expectStatus(t, res, body, http.StatusOK)
+	metadata := decode[map[string]any](t, body)
+	if metadata["issuer"] != ts.cfg.publicURL || metadata["token_endpoint"] != ts.cfg.publicURL+"/oauth/token" {
+		t.Errorf("unexpected metadata %s", body)
+	}
+}
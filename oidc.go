@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dev-perry/go-server/internal/auth"
+	"github.com/dev-perry/go-server/internal/database"
+	"github.com/dev-perry/go-server/internal/oidc"
+	"github.com/google/uuid"
+)
+
+const (
+	// oidcLoginTTL is how long the user has to sign in at the provider.
+	oidcLoginTTL    = 10 * time.Minute
+	oidcStateCookie = "chirpy_oidc_state"
+	oidcCookiePath  = "/api/login/oidc"
+
+	securityEventIdentityLinked = "identity_linked"
+)
+
+var (
+	errInvalidOIDCLogin = errors.New("oidc login is invalid or expired")
+	errOIDCNoEmail      = errors.New("identity provider did not share a valid email address")
+	errOIDCEmailTaken   = errors.New("email belongs to an account the identity can't be linked to")
+)
+
+// oidcRedirectURL is where the provider sends users back to unless
+// OIDC_REDIRECT_URL says otherwise.
+func oidcRedirectURL(publicURL string) string {
+	return publicURL + oidcCookiePath + "/callback"
+}
+
+// setOIDCStateCookie ties a login to the browser that started it. A maxAge
+// below zero clears the cookie.
+func (cfg *apiConfig) setOIDCStateCookie(w http.ResponseWriter, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    value,
+		Path:     oidcCookiePath,
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(cfg.publicURL, "https://"),
+		// Lax, so the cookie comes back on the provider's top-level redirect.
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// startOIDCLogin sends the browser to the identity provider to sign in.
+func (cfg *apiConfig) startOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if cfg.oidcProvider == nil {
+		respondWithError(w, r, 404, errCodeNotFound, "Single sign-on is not configured")
+		return
+	}
+	state, err := auth.MakeOpaqueToken()
+	if err != nil {
+		log.Printf("Error generating OIDC state: %s", err)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to start single sign-on")
+		return
+	}
+	nonce := rand.Text()
+	// PKCE wants at least 43 characters.
+	verifier := rand.Text() + rand.Text()
+	err = cfg.db.CreateOIDCLogin(r.Context(), database.CreateOIDCLoginParams{
+		ID:           state.ID,
+		StateHash:    auth.HashOpaqueToken(state.Secret, cfg.refreshPepper, auth.PurposeOIDCState),
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ExpiresAt:    time.Now().Add(oidcLoginTTL),
+	})
+	if err != nil {
+		log.Printf("Error storing OIDC login: %s", err)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to start single sign-on")
+		return
+	}
+	cfg.setOIDCStateCookie(w, state.String(), int(oidcLoginTTL.Seconds()))
+	http.Redirect(w, r, cfg.oidcProvider.AuthCodeURL(state.String(), nonce, auth.PKCEChallenge(verifier)), 302)
+}
+
+// claimOIDCLogin looks up the login a presented state belongs to and marks
+// it used, so each provider response is only accepted once.
+func (cfg *apiConfig) claimOIDCLogin(ctx context.Context, rawState string) (database.OidcLogin, error) {
+	state, parseErr := auth.ParseOpaqueToken(rawState)
+	if parseErr != nil {
+		return database.OidcLogin{}, errInvalidOIDCLogin
+	}
+	login, dbErr := cfg.db.GetOIDCLogin(ctx, state.ID)
+	if errors.Is(dbErr, sql.ErrNoRows) {
+		return database.OidcLogin{}, errInvalidOIDCLogin
+	}
+	if dbErr != nil {
+		return database.OidcLogin{}, dbErr
+	}
+	if !auth.CheckOpaqueTokenHash(state.Secret, cfg.refreshPepper, auth.PurposeOIDCState, login.StateHash) {
+		return database.OidcLogin{}, errInvalidOIDCLogin
+	}
+	used, useErr := cfg.db.UseOIDCLogin(ctx, login.ID)
+	if useErr != nil {
+		return database.OidcLogin{}, useErr
+	}
+	if used == 0 {
+		return database.OidcLogin{}, errInvalidOIDCLogin
+	}
+	return login, nil
+}
+
+// finishOIDCLogin completes a login the provider sent the browser back
+// from, answering like /api/login does.
+func (cfg *apiConfig) finishOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if cfg.oidcProvider == nil {
+		respondWithError(w, r, 404, errCodeNotFound, "Single sign-on is not configured")
+		return
+	}
+	query := r.URL.Query()
+	cookie, _ := r.Cookie(oidcStateCookie)
+	// Each login gets one attempt at coming back.
+	cfg.setOIDCStateCookie(w, "", -1)
+	// Without the cookie check, someone could finish a login they started
+	// in a victim's browser and sign the victim in to the wrong account.
+	if cookie == nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(query.Get("state"))) != 1 {
+		cfg.metrics.logins.With("failure").Inc()
+		respondWithError(w, r, 400, errCodeBadRequest, "Sign-in was not started from this browser; start again")
+		return
+	}
+	login, claimErr := cfg.claimOIDCLogin(r.Context(), query.Get("state"))
+	if errors.Is(claimErr, errInvalidOIDCLogin) {
+		cfg.metrics.logins.With("failure").Inc()
+		respondWithError(w, r, 400, errCodeBadRequest, "Sign-in has expired; start again")
+		return
+	}
+	if claimErr != nil {
+		log.Printf("Error looking up OIDC login: %s", claimErr)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to complete single sign-on")
+		return
+	}
+	if providerErr := query.Get("error"); providerErr != "" {
+		cfg.metrics.logins.With("failure").Inc()
+		respondWithError(w, r, 401, errCodeUnauthorized, fmt.Sprintf("The identity provider did not sign you in (%s)", providerErr))
+		return
+	}
+	if query.Get("code") == "" {
+		respondWithError(w, r, 400, errCodeBadRequest, "Missing authorization code")
+		return
+	}
+
+	rawIDToken, exchangeErr := cfg.oidcProvider.Exchange(r.Context(), query.Get("code"), login.CodeVerifier)
+	var providerErr *oidc.Error
+	if errors.As(exchangeErr, &providerErr) {
+		log.Printf("OIDC code exchange refused: %s", providerErr)
+		cfg.metrics.logins.With("failure").Inc()
+		respondWithError(w, r, 401, errCodeUnauthorized, "The identity provider did not accept the sign-in; start again")
+		return
+	}
+	if exchangeErr != nil {
+		log.Printf("Error exchanging OIDC code: %s", exchangeErr)
+		respondWithError(w, r, 502, errCodeInternal, "Unable to reach the identity provider")
+		return
+	}
+	identity, verifyErr := cfg.oidcProvider.VerifyIDToken(r.Context(), rawIDToken, login.Nonce)
+	if errors.Is(verifyErr, oidc.ErrInvalidIDToken) {
+		log.Printf("Rejected OIDC ID token: %s", verifyErr)
+		cfg.metrics.logins.With("failure").Inc()
+		respondWithError(w, r, 401, errCodeUnauthorized, "The identity provider's response could not be verified")
+		return
+	}
+	if verifyErr != nil {
+		log.Printf("Error verifying OIDC ID token: %s", verifyErr)
+		respondWithError(w, r, 502, errCodeInternal, "Unable to reach the identity provider")
+		return
+	}
+
+	user, userErr := cfg.oidcUser(r.Context(), identity)
+	switch {
+	case errors.Is(userErr, errOIDCNoEmail):
+		cfg.metrics.logins.With("failure").Inc()
+		respondWithError(w, r, 403, errCodeForbidden, "The identity provider did not share a valid email address")
+		return
+	case errors.Is(userErr, errOIDCEmailTaken):
+		cfg.metrics.logins.With("failure").Inc()
+		respondWithError(w, r, 409, errCodeConflict, "An account with this email already exists; sign in with its password instead")
+		return
+	case userErr != nil:
+		log.Printf("Error finding user for OIDC identity: %s", userErr)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to complete single sign-on")
+		return
+	}
+	if user.LockedAt.Valid {
+		cfg.metrics.logins.With("failure").Inc()
+		respondWithError(w, r, 403, errCodeForbidden, "Account is locked")
+		return
+	}
+	if user.TotpEnabledAt.Valid {
+		cfg.startMFAChallenge(w, r, user.ID)
+		return
+	}
+	cfg.completeLogin(w, r, User{
+		ID:            user.ID,
+		Email:         user.Email,
+		CreatedAt:     user.CreatedAt,
+		UpdatedAt:     user.UpdatedAt,
+		IsChirpyRed:   user.IsChirpyRed.Bool,
+		EmailVerified: user.EmailVerifiedAt.Valid,
+		Role:          user.Role,
+	}, user.TokenVersion)
+}
+
+// oidcUser finds the user an identity is linked to. On its first login the
+// identity is linked to the account with the same email address, or to a
+// new account if there is none.
+func (cfg *apiConfig) oidcUser(ctx context.Context, identity oidc.Identity) (database.GetUserByIdentityRow, error) {
+	key := database.GetUserByIdentityParams{Issuer: identity.Issuer, Subject: identity.Subject}
+	user, err := cfg.db.GetUserByIdentity(ctx, key)
+	if err == nil {
+		touchErr := cfg.db.TouchUserIdentity(ctx, database.TouchUserIdentityParams{
+			Issuer:  identity.Issuer,
+			Subject: identity.Subject,
+			Email:   identity.Email,
+		})
+		if touchErr != nil {
+			log.Printf("Error recording OIDC login for user %s: %s", user.ID, touchErr)
+		}
+		return user, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return database.GetUserByIdentityRow{}, err
+	}
+
+	email, emailErr := normalizeEmail(identity.Email)
+	if emailErr != nil {
+		return database.GetUserByIdentityRow{}, errOIDCNoEmail
+	}
+	// Hashing is slow, so it is done before the transaction whether or not
+	// the account turns out to exist.
+	unusablePassword, err := auth.HashPassword(rand.Text(), cfg.passwordParams)
+	if err != nil {
+		return database.GetUserByIdentityRow{}, err
+	}
+	var created database.CreateUserRow
+	err = cfg.db.ExecTx(ctx, func(q database.Querier) error {
+		existing, err := q.GetUserCredsByEmail(ctx, email)
+		if errors.Is(err, sql.ErrNoRows) {
+			// The new account's password is never revealed, so until the user
+			// resets it they can only sign in through the provider.
+			created, err = q.CreateUser(ctx, database.CreateUserParams{Email: email, HashedPassword: unusablePassword})
+			if err != nil {
+				return err
+			}
+			if identity.EmailVerified {
+				if _, err := q.VerifyUserEmail(ctx, database.VerifyUserEmailParams{ID: created.ID, Email: email}); err != nil {
+					return err
+				}
+			}
+			return q.CreateUserIdentity(ctx, database.CreateUserIdentityParams{
+				Issuer:  identity.Issuer,
+				Subject: identity.Subject,
+				UserID:  created.ID,
+				Email:   email,
+			})
+		}
+		if err != nil {
+			return err
+		}
+		// Only link when both sides have confirmed the address, or anyone who
+		// can get an account at the provider with someone's email could take
+		// over their Chirpy account.
+		if !identity.EmailVerified || !existing.EmailVerifiedAt.Valid {
+			return errOIDCEmailTaken
+		}
+		err = q.CreateUserIdentity(ctx, database.CreateUserIdentityParams{
+			Issuer:  identity.Issuer,
+			Subject: identity.Subject,
+			UserID:  existing.ID,
+			Email:   email,
+		})
+		if err != nil {
+			return err
+		}
+		return q.CreateSecurityEvent(ctx, database.CreateSecurityEventParams{
+			UserID: existing.ID,
+			Kind:   securityEventIdentityLinked,
+			Detail: fmt.Sprintf("signed in with %s as %s for the first time; linked to this account", identity.Issuer, identity.Subject),
+		})
+	})
+	if isUniqueViolation(err) {
+		// A concurrent login linked the identity first, or someone signed up
+		// with the address in the meantime.
+		user, err := cfg.db.GetUserByIdentity(ctx, key)
+		if errors.Is(err, sql.ErrNoRows) {
+			return database.GetUserByIdentityRow{}, errOIDCEmailTaken
+		}
+		return user, err
+	}
+	if err != nil {
+		return database.GetUserByIdentityRow{}, err
+	}
+	if created.ID != uuid.Nil && !identity.EmailVerified {
+		if err := cfg.sendEmailVerification(ctx, created.ID, created.Email); err != nil {
+			log.Printf("Error sending verification email to user %s: %s", created.ID, err)
+		}
+	}
+	return cfg.db.GetUserByIdentity(ctx, key)
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/dev-perry/go-server/internal/database"
+	"github.com/dev-perry/go-server/internal/oidc"
+	"github.com/dev-perry/go-server/internal/oidc/oidctest"
+)
+
+// enableOIDC points the server at a fake identity provider.
+func (ts *testServer) enableOIDC(t *testing.T) *oidctest.Server {
+	t.Helper()
+	idp := oidctest.NewServer("chirpy", "idp-secret")
+	t.Cleanup(idp.Close)
+	provider, err := oidc.Discover(context.Background(), oidc.Config{
+		IssuerURL:    idp.URL,
+		ClientID:     idp.ClientID,
+		ClientSecret: idp.ClientSecret,
+		RedirectURL:  oidcRedirectURL(ts.URL),
+	})
+	if err != nil {
+		t.Fatalf("Discover: %v", err)
+	}
+	ts.cfg.oidcProvider = provider
+	return idp
+}
+
+// browser is an HTTP client that keeps cookies but, like the tests, looks
+// at redirects rather than following them.
+func browser(t *testing.T) *http.Client {
+	t.Helper()
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("cookiejar: %v", err)
+	}
+	return &http.Client{Jar: jar, CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+}
+
+func get(t *testing.T, client *http.Client, target string) (*http.Response, []byte) {
+	t.Helper()
+	res, err := client.Get(target)
+	if err != nil {
+		t.Fatalf("GET %s: %v", target, err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("read response: %v", err)
+	}
+	return res, body
+}
+
+// startOIDCLogin begins a login in client and returns the URL the provider
+// sends the browser back to.
+func (ts *testServer) startOIDCLogin(t *testing.T, client *http.Client, idp *oidctest.Server) string {
+	t.Helper()
+	res, body := get(t, client, ts.URL+"/api/login/oidc")
+	expectStatus(t, res, body, http.StatusFound)
+	back, err := idp.Login(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("provider login: %v", err)
+	}
+	if !strings.HasPrefix(back.String(), ts.URL+"/api/login/oidc/callback?") {
+		t.Fatalf("expected to be sent back to the callback, got %s", back)
+	}
+	return back.String()
+}
+
+func (ts *testServer) oidcLogin(t *testing.T, idp *oidctest.Server, user oidctest.User) (*http.Response, []byte) {
+	t.Helper()
+	idp.SetUser(user)
+	client := browser(t)
+	return get(t, client, ts.startOIDCLogin(t, client, idp))
+}
+
+func TestOIDCLoginCreatesAccount(t *testing.T) {
+	ts := newTestServer(t)
+	idp := ts.enableOIDC(t)
+
+	res, body := ts.oidcLogin(t, idp, oidctest.User{Subject: "staff-1", Email: "Gus@Example.com", EmailVerified: true})
+	expectStatus(t, res, body, http.StatusOK)
+	first := decode[AuthSuccessResponse](t, body)
+	if first.Email != "Gus@example.com" || !first.EmailVerified || first.Token == "" || first.RefreshToken == "" {
+		t.Fatalf("expected a verified account and tokens, got %+v", first)
+	}
+	res, body = ts.do(t, "GET", "/api/sessions", bearer(first.Token), nil)
+	expectStatus(t, res, body, http.StatusOK)
+
+	// The link is by subject, so it survives the address changing at the
+	// provider.
+	res, body = ts.oidcLogin(t, idp, oidctest.User{Subject: "staff-1", Email: "gustavo@example.com", EmailVerified: true})
+	expectStatus(t, res, body, http.StatusOK)
+	if again := decode[AuthSuccessResponse](t, body); again.ID != first.ID {
+		t.Errorf("expected the same account, got %s and %s", first.ID, again.ID)
+	}
+
+	// A provider that hasn't verified the address leaves that to us.
+	res, body = ts.oidcLogin(t, idp, oidctest.User{Subject: "staff-9", Email: "lydia@example.com"})
+	expectStatus(t, res, body, http.StatusOK)
+	if got := decode[AuthSuccessResponse](t, body); got.EmailVerified {
+		t.Errorf("expected the address to be unverified, got %+v", got)
+	}
+	ts.verificationToken(t, "lydia@example.com")
+
+	// The account has no password anyone knows.
+	res, body = ts.do(t, "POST", "/api/login", "", credsRequest{Email: "Gus@example.com", Password: ""})
+	expectError(t, res, body, http.StatusUnauthorized, errCodeUnauthorized)
+}
+
+func TestOIDCLoginLinksVerifiedAccount(t *testing.T) {
+	ts := newTestServer(t)
+	idp := ts.enableOIDC(t)
+	user := ts.createUser(t, "walt@example.com", "heisenberg")
+
+	// Until the account's address is verified, it can't be claimed.
+	res, body := ts.oidcLogin(t, idp, oidctest.User{Subject: "staff-2", Email: "walt@example.com", EmailVerified: true})
+	expectError(t, res, body, http.StatusConflict, errCodeConflict)
+
+	res, body = ts.do(t, "POST", "/api/verify-email", "", tokenRequest{Token: ts.verificationToken(t, "walt@example.com")})
+	expectStatus(t, res, body, http.StatusOK)
+
+	// Nor by a provider that hasn't verified it.
+	res, body = ts.oidcLogin(t, idp, oidctest.User{Subject: "staff-2", Email: "walt@example.com"})
+	expectError(t, res, body, http.StatusConflict, errCodeConflict)
+
+	res, body = ts.oidcLogin(t, idp, oidctest.User{Subject: "staff-2", Email: "walt@example.com", EmailVerified: true})
+	expectStatus(t, res, body, http.StatusOK)
+	if got := decode[AuthSuccessResponse](t, body); got.ID != user.ID {
+		t.Errorf("expected the existing account %s, got %s", user.ID, got.ID)
+	}
+	events, _ := ts.cfg.db.ListSecurityEventsByUser(context.Background(), user.ID)
+	if len(events) != 1 || events[0].Kind != securityEventIdentityLinked {
+		t.Errorf("expected the link to be recorded, got %+v", events)
+	}
+	ts.login(t, "walt@example.com", "heisenberg")
+}
+
+func TestOIDCLoginKeepsAccountChecks(t *testing.T) {
+	ts := newTestServer(t)
+	admin := bearer(ts.loginAs(t, roleAdmin).Token)
+	idp := ts.enableOIDC(t)
+	staff := oidctest.User{Subject: "staff-3", Email: "mike@example.com", EmailVerified: true}
+
+	res, body := ts.oidcLogin(t, idp, staff)
+	expectStatus(t, res, body, http.StatusOK)
+	login := decode[AuthSuccessResponse](t, body)
+
+	ts.enableTOTP(t, login.Token)
+	res, body = ts.oidcLogin(t, idp, staff)
+	expectStatus(t, res, body, http.StatusOK)
+	if challenge := decode[MFAChallengeResponse](t, body); !challenge.MFARequired {
+		t.Errorf("expected a second factor to be required, got %s", body)
+	}
+
+	res, body = ts.do(t, "POST", "/admin/users/"+login.ID.String()+"/lock", admin, nil)
+	expectStatus(t, res, body, http.StatusNoContent)
+	res, body = ts.oidcLogin(t, idp, staff)
+	expectError(t, res, body, http.StatusForbidden, errCodeForbidden)
+}
+
+func TestOIDCCallbackChecks(t *testing.T) {
+	ts := newTestServer(t)
+	idp := ts.enableOIDC(t)
+	idp.SetUser(oidctest.User{Subject: "staff-4", Email: "jesse@example.com", EmailVerified: true})
+
+	// A login started in one browser can't be finished in another.
+	callback := ts.startOIDCLogin(t, browser(t), idp)
+	res, body := get(t, browser(t), callback)
+	expectError(t, res, body, http.StatusBadRequest, errCodeBadRequest)
+
+	// Nor can the provider's response be replayed.
+	client := browser(t)
+	callback = ts.startOIDCLogin(t, client, idp)
+	state, _ := url.Parse(callback)
+	res, body = get(t, client, callback)
+	expectStatus(t, res, body, http.StatusOK)
+	client.Jar.SetCookies(state, []*http.Cookie{{Name: oidcStateCookie, Value: state.Query().Get("state"), Path: oidcCookiePath}})
+	res, body = get(t, client, callback)
+	expectError(t, res, body, http.StatusBadRequest, errCodeBadRequest)
+
+	// None of the failures below may leave an account behind.
+	idp.SetUser(oidctest.User{Subject: "staff-5", Email: "skyler@example.com", EmailVerified: true})
+
+	// The user cancelling at the provider is reported.
+	client = browser(t)
+	callback = ts.startOIDCLogin(t, client, idp)
+	denied, _ := url.Parse(callback)
+	query := denied.Query()
+	query.Del("code")
+	query.Set("error", "access_denied")
+	denied.RawQuery = query.Encode()
+	res, body = get(t, client, denied.String())
+	expectError(t, res, body, http.StatusUnauthorized, errCodeUnauthorized)
+
+	// An ID token that doesn't verify is refused.
+	idp.OverrideClaims(map[string]any{"aud": "someone-else"})
+	client = browser(t)
+	res, body = get(t, client, ts.startOIDCLogin(t, client, idp))
+	expectError(t, res, body, http.StatusUnauthorized, errCodeUnauthorized)
+
+	idp.OverrideClaims(map[string]any{"email": nil})
+	client = browser(t)
+	res, body = get(t, client, ts.startOIDCLogin(t, client, idp))
+	expectError(t, res, body, http.StatusForbidden, errCodeForbidden)
+
+	if _, err := ts.cfg.db.GetUserCredsByEmail(context.Background(), "skyler@example.com"); err == nil {
+		t.Error("expected no account to have been created")
+	}
+	_, err := ts.cfg.db.GetUserByIdentity(context.Background(), database.GetUserByIdentityParams{Issuer: idp.URL, Subject: "staff-5"})
+	if err == nil {
+		t.Error("expected no identity to have been linked")
+	}
+}
+
+func TestOIDCDisabled(t *testing.T) {
+	ts := newTestServer(t)
+	res, body := ts.do(t, "GET", "/api/login/oidc", "", nil)
+	expectError(t, res, body, http.StatusNotFound, errCodeNotFound)
+	res, body = ts.do(t, "GET", "/api/login/oidc/callback?code=x&state=y", "", nil)
+	expectError(t, res, body, http.StatusNotFound, errCodeNotFound)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// paramError describes a path value or query parameter that failed to parse.
+type paramError struct {
+	Param   string
+	Message string
+}
+
+func (e *paramError) Error() string {
+	return fmt.Sprintf("invalid parameter %s: %s", e.Param, e.Message)
+}
+
+func pathUUID(r *http.Request, name string) (uuid.UUID, error) {
+	raw := r.PathValue(name)
+	if raw == "" {
+		return uuid.UUID{}, &paramError{Param: name, Message: "is required"}
+	}
+	id, err := uuid.Parse(raw)
+	if err != nil {
+		return uuid.UUID{}, &paramError{Param: name, Message: "must be a valid UUID"}
+	}
+	return id, nil
+}
+
+// queryUUID parses an optional UUID query parameter; Valid is false when it is absent.
+func queryUUID(r *http.Request, name string) (uuid.NullUUID, error) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return uuid.NullUUID{}, nil
+	}
+	id, err := uuid.Parse(raw)
+	if err != nil {
+		return uuid.NullUUID{}, &paramError{Param: name, Message: "must be a valid UUID"}
+	}
+	return uuid.NullUUID{UUID: id, Valid: true}, nil
+}
+
+// queryOneOf parses an optional query parameter restricted to allowed values.
+func queryOneOf(r *http.Request, name string, allowed ...string) (string, error) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" || slices.Contains(allowed, raw) {
+		return raw, nil
+	}
+	return "", &paramError{Param: name, Message: "must be one of " + strings.Join(allowed, ", ")}
+}
+
+// queryInt parses an optional integer query parameter within [min, max],
+// returning def when it is absent.
+func queryInt(r *http.Request, name string, def, min, max int) (int, error) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < min || n > max {
+		return 0, &paramError{Param: name, Message: fmt.Sprintf("must be an integer between %d and %d", min, max)}
+	}
+	return n, nil
+}
+
+func respondWithParamError(w http.ResponseWriter, r *http.Request, err error) {
+	pErr, ok := err.(*paramError)
+	if !ok {
+		respondWithError(w, r, 400, errCodeInvalidParameter, err.Error())
+		return
+	}
+	respondWithError(w, r, 400, errCodeInvalidParameter, pErr.Error(), fieldError{
+		Field:   pErr.Param,
+		Message: pErr.Message,
+	})
+}
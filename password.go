@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dev-perry/go-server/internal/auth"
+	"github.com/dev-perry/go-server/internal/database"
+	"github.com/dev-perry/go-server/internal/mailer"
+	"github.com/google/uuid"
+)
+
+const (
+	passwordResetLimit  = 3
+	passwordResetWindow = time.Hour
+	// passwordResetMailTimeout bounds the work forgotPassword leaves running
+	// after it responds.
+	passwordResetMailTimeout = time.Minute
+)
+
+var errInvalidResetToken = errors.New("password reset token is invalid")
+
+// forgotPassword mails a reset link to the address if it belongs to an
+// account. The response is the same either way so the endpoint can't be used
+// to find out who has signed up.
+func (cfg *apiConfig) forgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, r, 400, errCodeBadRequest, "Unable to decode request body")
+		return
+	}
+	if req.Email == "" {
+		respondWithError(w, r, 400, errCodeValidation, "Email is required", fieldError{
+			Field:   "email",
+			Message: "is required",
+		})
+		return
+	}
+
+	allowed, retryAfter := cfg.resetLimiter.allow(strings.ToLower(strings.TrimSpace(req.Email)), time.Now())
+	if !allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		respondWithError(w, r, 429, errCodeRateLimited, "Too many password reset requests, try again later")
+		return
+	}
+
+	// Looking up the account and mailing it happen after responding, so how
+	// long the request takes doesn't give away whether the account exists.
+	ctx := context.WithoutCancel(r.Context())
+	cfg.background.Go(func() {
+		ctx, cancel := context.WithTimeout(ctx, passwordResetMailTimeout)
+		defer cancel()
+		cfg.mailPasswordReset(ctx, req.Email)
+	})
+	w.WriteHeader(202)
+}
+
+// mailPasswordReset is the part of forgotPassword done in the background.
+// Nobody is waiting on it, so errors are logged.
+func (cfg *apiConfig) mailPasswordReset(ctx context.Context, email string) {
+	user, err := cfg.db.GetUserCredsByEmail(ctx, email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return
+	}
+	if err != nil {
+		log.Printf("Error looking up user for password reset: %s", err)
+		return
+	}
+
+	token, err := auth.MakeOpaqueToken()
+	if err != nil {
+		log.Printf("Error generating password reset token: %s", err)
+		return
+	}
+	err = cfg.db.CreatePasswordResetToken(ctx, database.CreatePasswordResetTokenParams{
+		ID:        token.ID,
+		UserID:    user.ID,
+		TokenHash: auth.HashOpaqueToken(token.Secret, cfg.refreshPepper, auth.PurposePasswordReset),
+		ExpiresAt: time.Now().Add(cfg.passwordResetTTL),
+	})
+	if err != nil {
+		log.Printf("Error storing password reset token for user %s: %s", user.ID, err)
+		return
+	}
+
+	link := cfg.publicURL + "/app/reset-password/?token=" + url.QueryEscape(token.String())
+	err = cfg.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your Chirpy password",
+		Body: fmt.Sprintf("Someone asked to reset the password for your Chirpy account.\n\n"+
+			"To choose a new password, open this link within %s:\n\n%s\n\n"+
+			"If this wasn't you, you can ignore this email.\n", cfg.passwordResetTTL, link),
+	})
+	if err != nil {
+		log.Printf("Error sending password reset email to user %s: %s", user.ID, err)
+	}
+}
+
+// resetPassword sets a new password using a token from forgotPassword. The
+// token is spent, and every token the user held is revoked, in one
+// transaction.
+func (cfg *apiConfig) resetPassword(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, r, 400, errCodeBadRequest, "Unable to decode request body")
+		return
+	}
+	if req.Password == "" {
+		respondWithError(w, r, 400, errCodeValidation, "Password is required", fieldError{
+			Field:   "password",
+			Message: "is required",
+		})
+		return
+	}
+
+	stored, lookupErr := cfg.lookupResetToken(r, req.Token)
+	if errors.Is(lookupErr, errInvalidResetToken) {
+		respondWithInvalidResetToken(w, r)
+		return
+	}
+	if lookupErr != nil {
+		log.Printf("Error looking up password reset token: %s", lookupErr)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to reset password")
+		return
+	}
+
+	user, dbErr := cfg.db.GetUser(r.Context(), stored.UserID)
+	if dbErr != nil {
+		log.Printf("Error loading user %s: %s", stored.UserID, dbErr)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to reset password")
+		return
+	}
+	if !cfg.checkPasswordPolicy(w, r, req.Password, user.Email) {
+		return
+	}
+	hashed, hashErr := auth.HashPassword(req.Password, cfg.passwordParams)
+	if hashErr != nil {
+		log.Printf("Error hashing password: %s", hashErr)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to reset password")
+		return
+	}
+
+	txErr := cfg.db.ExecTx(r.Context(), func(q database.Querier) error {
+		// Claiming the token here, not just checking it above, stops two
+		// concurrent requests from both using it.
+		used, err := q.UsePasswordResetToken(r.Context(), stored.ID)
+		if err != nil {
+			return err
+		}
+		if used == 0 {
+			return errInvalidResetToken
+		}
+		// Bumps the token version, so outstanding access tokens stop working.
+		err = q.UpdateUserPassword(r.Context(), database.UpdateUserPasswordParams{
+			ID:             stored.UserID,
+			HashedPassword: hashed,
+		})
+		if err != nil {
+			return err
+		}
+		if err := q.RevokeAllUserRefreshTokens(r.Context(), stored.UserID); err != nil {
+			return err
+		}
+		return q.InvalidatePasswordResetTokens(r.Context(), stored.UserID)
+	})
+	if errors.Is(txErr, errInvalidResetToken) {
+		respondWithInvalidResetToken(w, r)
+		return
+	}
+	if txErr != nil {
+		log.Printf("Error resetting password for user %s: %s", stored.UserID, txErr)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to reset password")
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// lookupResetToken finds the stored row for a presented reset token and
+// verifies its secret, returning errInvalidResetToken if it is unknown,
+// wrong, spent or expired.
+func (cfg *apiConfig) lookupResetToken(r *http.Request, raw string) (database.PasswordResetToken, error) {
+	presented, parseErr := auth.ParseOpaqueToken(raw)
+	if parseErr != nil {
+		return database.PasswordResetToken{}, errInvalidResetToken
+	}
+	stored, dbErr := cfg.db.GetPasswordResetToken(r.Context(), presented.ID)
+	if errors.Is(dbErr, sql.ErrNoRows) {
+		return database.PasswordResetToken{}, errInvalidResetToken
+	}
+	if dbErr != nil {
+		return database.PasswordResetToken{}, dbErr
+	}
+	if !auth.CheckOpaqueTokenHash(presented.Secret, cfg.refreshPepper, auth.PurposePasswordReset, stored.TokenHash) {
+		return database.PasswordResetToken{}, errInvalidResetToken
+	}
+	if stored.UsedAt.Valid || !time.Now().Before(stored.ExpiresAt) {
+		return database.PasswordResetToken{}, errInvalidResetToken
+	}
+	return stored, nil
+}
+
+func respondWithInvalidResetToken(w http.ResponseWriter, r *http.Request) {
+	respondWithError(w, r, 400, errCodeValidation, "Password reset link is invalid or has expired", fieldError{
+		Field:   "token",
+		Message: "is invalid or has expired",
+	})
+}
+
+// checkPasswordPolicy responds with every reason password can't be used and
+// returns false, or returns true if the account with email may use it.
+func (cfg *apiConfig) checkPasswordPolicy(w http.ResponseWriter, r *http.Request, password, email string) bool {
+	problems := cfg.passwordPolicy.Check(password, email)
+	if len(problems) == 0 {
+		return true
+	}
+	details := make([]fieldError, len(problems))
+	for i, problem := range problems {
+		details[i] = fieldError{Field: "password", Message: problem}
+	}
+	respondWithError(w, r, 400, errCodeValidation, "Password is not allowed", details...)
+	return false
+}
+
+// rehashPasswordIfWeak replaces hash, which password has just been checked
+// against, if it was made with lower costs than are now configured. Failing
+// only costs a rehash at a later login, so errors are logged.
+func (cfg *apiConfig) rehashPasswordIfWeak(ctx context.Context, userID uuid.UUID, password, hash string) {
+	if !auth.NeedsRehash(hash, cfg.passwordParams) {
+		return
+	}
+	rehashed, err := auth.HashPassword(password, cfg.passwordParams)
+	if err != nil {
+		log.Printf("Error rehashing password for user %s: %s", userID, err)
+		return
+	}
+	err = cfg.db.RehashUserPassword(ctx, database.RehashUserPasswordParams{
+		ID:      userID,
+		NewHash: rehashed,
+		OldHash: hash,
+	})
+	if err != nil {
+		log.Printf("Error storing rehashed password for user %s: %s", userID, err)
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dev-perry/go-server/internal/mailer"
+)
+
+type forgotRequest struct {
+	Email string `json:"email"`
+}
+
+type resetRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// resetToken requests a reset for email and pulls the token out of the link
+// in the message that was sent.
+func (ts *testServer) resetToken(t *testing.T, email string) string {
+	t.Helper()
+	ts.cfg.background.Wait()
+	before := len(ts.mail.messages())
+	res, body := ts.do(t, "POST", "/api/password/forgot", "", forgotRequest{Email: email})
+	expectStatus(t, res, body, http.StatusAccepted)
+	ts.cfg.background.Wait()
+
+	sent := ts.mail.messages()
+	if len(sent) != before+1 {
+		t.Fatalf("expected one more message, got %d", len(sent)-before)
+	}
+	msg := sent[len(sent)-1]
+	if msg.To != email {
+		t.Errorf("expected mail to %s, got %s", email, msg.To)
+	}
+	return linkToken(t, msg, "http://chirpy.test/app/reset-password/")
+}
+
+// linkToken returns the token parameter of the link to base in msg.
+func linkToken(t *testing.T, msg mailer.Message, base string) string {
+	t.Helper()
+	_, rest, found := strings.Cut(msg.Body, base+"?")
+	if !found {
+		t.Fatalf("expected a link to %s in:\n%s", base, msg.Body)
+	}
+	link, _, _ := strings.Cut(rest, "\n")
+	query, err := url.ParseQuery(link)
+	if err != nil {
+		t.Fatalf("parse link: %v", err)
+	}
+	return query.Get("token")
+}
+
+func TestResetLinkOpensPage(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser(t, "walt@example.com", "heisenberg")
+	token := ts.resetToken(t, "walt@example.com")
+	sent := ts.mail.messages()
+
+	_, rest, _ := strings.Cut(sent[len(sent)-1].Body, "http://chirpy.test")
+	link, _, _ := strings.Cut(rest, "\n")
+	res, body := ts.do(t, "GET", link, "", nil)
+	expectStatus(t, res, body, http.StatusOK)
+	if !strings.Contains(string(body), `fetch("/api/password/reset"`) {
+		t.Errorf("expected the reset page, got:\n%s", body)
+	}
+
+	// Links without the trailing slash keep their token through the redirect.
+	res, body = ts.do(t, "GET", "/app/reset-password?token="+url.QueryEscape(token), "", nil)
+	expectStatus(t, res, body, http.StatusOK)
+	if got := res.Request.URL.Query().Get("token"); got != token {
+		t.Errorf("expected the token to survive the redirect, got %q", got)
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser(t, "walt@example.com", "heisenberg")
+	login := ts.login(t, "walt@example.com", "heisenberg")
+
+	token := ts.resetToken(t, "walt@example.com")
+	res, body := ts.do(t, "POST", "/api/password/reset", "", resetRequest{Token: token, Password: "blue"})
+	expectStatus(t, res, body, http.StatusNoContent)
+
+	ts.login(t, "walt@example.com", "blue")
+	res, body = ts.do(t, "POST", "/api/login", "", credsRequest{Email: "walt@example.com", Password: "heisenberg"})
+	expectStatus(t, res, body, http.StatusUnauthorized)
+
+	res, body = ts.do(t, "GET", "/api/sessions", bearer(login.Token), nil)
+	expectError(t, res, body, http.StatusUnauthorized, errCodeUnauthorized)
+	res, body = ts.do(t, "POST", "/api/refresh", bearer(login.RefreshToken), nil)
+	expectError(t, res, body, http.StatusUnauthorized, errCodeUnauthorized)
+
+	res, body = ts.do(t, "POST", "/api/password/reset", "", resetRequest{Token: token, Password: "again"})
+	apiErr := expectError(t, res, body, http.StatusBadRequest, errCodeValidation)
+	if len(apiErr.Details) != 1 || apiErr.Details[0].Field != "token" {
+		t.Errorf("expected token field detail, got %v", apiErr.Details)
+	}
+}
+
+func TestPasswordResetInvalidatesOtherTokens(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser(t, "walt@example.com", "heisenberg")
+	first := ts.resetToken(t, "walt@example.com")
+	second := ts.resetToken(t, "walt@example.com")
+
+	res, body := ts.do(t, "POST", "/api/password/reset", "", resetRequest{Token: second, Password: "blue"})
+	expectStatus(t, res, body, http.StatusNoContent)
+
+	res, body = ts.do(t, "POST", "/api/password/reset", "", resetRequest{Token: first, Password: "again"})
+	expectError(t, res, body, http.StatusBadRequest, errCodeValidation)
+}
+
+func TestPasswordResetRejectsBadTokens(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser(t, "walt@example.com", "heisenberg")
+
+	ts.cfg.passwordResetTTL = -time.Minute
+	expired := ts.resetToken(t, "walt@example.com")
+	ts.cfg.passwordResetTTL = time.Hour
+	valid := ts.resetToken(t, "walt@example.com")
+	id, _, _ := strings.Cut(valid, ".")
+
+	for name, token := range map[string]string{
+		"expired":      expired,
+		"wrong secret": id + ".deadbeef",
+		"unknown":      "abc.def",
+		"malformed":    "not-a-token",
+	} {
+		t.Run(name, func(t *testing.T) {
+			res, body := ts.do(t, "POST", "/api/password/reset", "", resetRequest{Token: token, Password: "blue"})
+			expectError(t, res, body, http.StatusBadRequest, errCodeValidation)
+		})
+	}
+	ts.login(t, "walt@example.com", "heisenberg")
+}
+
+func TestForgotPasswordUnknownEmail(t *testing.T) {
+	ts := newTestServer(t)
+
+	res, body := ts.do(t, "POST", "/api/password/forgot", "", forgotRequest{Email: "nobody@example.com"})
+	expectStatus(t, res, body, http.StatusAccepted)
+	ts.cfg.background.Wait()
+	if sent := ts.mail.messages(); len(sent) != 0 {
+		t.Errorf("expected no mail for an unknown address, got %v", sent)
+	}
+
+	res, body = ts.do(t, "POST", "/api/password/forgot", "", forgotRequest{})
+	expectError(t, res, body, http.StatusBadRequest, errCodeValidation)
+}
+
+// blockingMailer holds every message until release is closed.
+type blockingMailer struct {
+	captureMailer
+	release chan struct{}
+}
+
+func (m *blockingMailer) Send(ctx context.Context, msg mailer.Message) error {
+	<-m.release
+	return m.captureMailer.Send(ctx, msg)
+}
+
+func TestForgotPasswordDoesNotWaitForMail(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser(t, "walt@example.com", "heisenberg")
+	mail := &blockingMailer{release: make(chan struct{})}
+	ts.cfg.mailer = mail
+
+	// Known and unknown addresses both answer before any mail is sent.
+	for _, email := range []string{"walt@example.com", "nobody@example.com"} {
+		res, body := ts.do(t, "POST", "/api/password/forgot", "", forgotRequest{Email: email})
+		expectStatus(t, res, body, http.StatusAccepted)
+	}
+	close(mail.release)
+	ts.cfg.background.Wait()
+	if sent := mail.messages(); len(sent) != 1 || sent[0].To != "walt@example.com" {
+		t.Errorf("expected one message to walt@example.com, got %v", sent)
+	}
+}
+
+func TestForgotPasswordRateLimit(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser(t, "walt@example.com", "heisenberg")
+	before := len(ts.mail.messages())
+
+	for range passwordResetLimit {
+		ts.resetToken(t, "walt@example.com")
+	}
+	res, body := ts.do(t, "POST", "/api/password/forgot", "", forgotRequest{Email: "WALT@example.com"})
+	expectError(t, res, body, http.StatusTooManyRequests, errCodeRateLimited)
+	if res.Header.Get("Retry-After") == "" {
+		t.Error("expected a Retry-After header")
+	}
+	if sent := ts.mail.messages(); len(sent)-before != passwordResetLimit {
+		t.Errorf("expected %d messages, got %d", passwordResetLimit, len(sent)-before)
+	}
+
+	// Unknown addresses are limited the same way, so the 429 reveals nothing.
+	for range passwordResetLimit {
+		ts.do(t, "POST", "/api/password/forgot", "", forgotRequest{Email: "nobody@example.com"})
+	}
+	res, body = ts.do(t, "POST", "/api/password/forgot", "", forgotRequest{Email: "nobody@example.com"})
+	expectError(t, res, body, http.StatusTooManyRequests, errCodeRateLimited)
+}
+
+func TestRateLimiterWindow(t *testing.T) {
+	limiter := newRateLimiter(2, time.Minute)
+	start := time.Now()
+
+	limiter.allow("a", start)
+	limiter.allow("a", start.Add(10*time.Second))
+	allowed, retryAfter := limiter.allow("a", start.Add(20*time.Second))
+	if allowed || retryAfter != 40*time.Second {
+		t.Errorf("expected to be limited for 40s, got %v %s", allowed, retryAfter)
+	}
+	if allowed, _ := limiter.allow("b", start.Add(20*time.Second)); !allowed {
+		t.Error("expected other keys to be unaffected")
+	}
+	if allowed, _ := limiter.allow("a", start.Add(61*time.Second)); !allowed {
+		t.Error("expected the oldest event to have left the window")
+	}
+}
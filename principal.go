@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/dev-perry/go-server/internal/auth"
+	"github.com/dev-perry/go-server/internal/database"
+	"github.com/google/uuid"
+)
+
+type membershipTier string
+
+const (
+	tierFree      membershipTier = "free"
+	tierChirpyRed membershipTier = "chirpy_red"
+)
+
+// principal is who an authenticated request is acting for.
+type principal struct {
+	UserID uuid.UUID
+	// Scopes limits what the credential may do; nil means unrestricted, as
+	// for access tokens issued at login.
+	Scopes []string
+	// TokenID is the access token's jti.
+	TokenID string
+	// ClientID is set when the access token was issued to an OAuth client.
+	ClientID string
+	// APIKeyID is set instead when the request used a personal API key.
+	APIKeyID      string
+	Tier          membershipTier
+	EmailVerified bool
+	// Role decides what requirePermission lets the user do.
+	Role string
+}
+
+// hasScope reports whether the credential may be used where scope is
+// required. An empty scope means only unrestricted credentials are allowed.
+func (p principal) hasScope(scope string) bool {
+	if p.Scopes == nil {
+		return true
+	}
+	return scope != "" && slices.Contains(p.Scopes, scope)
+}
+
+type principalKey struct{}
+
+func principalFromContext(ctx context.Context) (principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(principal)
+	return p, ok
+}
+
+// authenticate verifies an access token and checks it has not been revoked
+// since it was issued: its version must still be the user's, the account
+// must not be locked and its session must not have been signed out.
+func (cfg *apiConfig) authenticate(ctx context.Context, token string) (principal, error) {
+	claims, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		return principal{}, err
+	}
+	return cfg.principalForClaims(ctx, claims)
+}
+
+// principalForClaims is authenticate for an access token whose signature
+// and expiry have already been checked.
+func (cfg *apiConfig) principalForClaims(ctx context.Context, claims *auth.ChirpyClaims) (principal, error) {
+	state, dbErr := cfg.db.GetUserAuthState(ctx, claims.UserID)
+	if errors.Is(dbErr, sql.ErrNoRows) {
+		return principal{}, fmt.Errorf("%w: user no longer exists", auth.ErrInvalidToken)
+	}
+	if dbErr != nil {
+		return principal{}, dbErr
+	}
+	if state.LockedAt.Valid || state.TokenVersion != claims.TokenVersion {
+		return principal{}, auth.ErrRevokedToken
+	}
+	// A token naming a role the user no longer has must be refreshed. Ones
+	// from before roles existed name none, and were all issued to users.
+	if claims.Role != state.Role && (claims.Role != "" || state.Role != roleUser) {
+		return principal{}, auth.ErrRevokedToken
+	}
+	// Signing out of a session revokes its refresh tokens, and with them
+	// every access token they issued. Tokens from before sessions were
+	// recorded name none.
+	if claims.SessionID != "" {
+		sessionID, parseErr := uuid.Parse(claims.SessionID)
+		if parseErr != nil {
+			return principal{}, fmt.Errorf("%w: session is not a valid ID", auth.ErrInvalidToken)
+		}
+		active, dbErr := cfg.db.IsSessionActive(ctx, sessionID)
+		if dbErr != nil {
+			return principal{}, dbErr
+		}
+		if !active {
+			return principal{}, auth.ErrRevokedToken
+		}
+	}
+	p := newPrincipal(claims.UserID, state)
+	p.TokenID = claims.ID
+	if claims.ClientID != "" {
+		p.ClientID = claims.ClientID
+		// Never nil, so a token without scopes is still restricted.
+		p.Scopes = append([]string{}, strings.Fields(claims.Scope)...)
+	}
+	return p, nil
+}
+
+func newPrincipal(userID uuid.UUID, state database.GetUserAuthStateRow) principal {
+	p := principal{
+		UserID:        userID,
+		Tier:          tierFree,
+		EmailVerified: state.EmailVerifiedAt.Valid,
+		Role:          state.Role,
+	}
+	if state.IsChirpyRed.Bool {
+		p.Tier = tierChirpyRed
+	}
+	return p
+}
+
+// requireAuth only calls next for requests with a valid access token, which
+// it makes available through principalFromContext. Personal API keys and
+// tokens issued to OAuth clients are refused.
+func (cfg *apiConfig) requireAuth(next http.HandlerFunc) http.Handler {
+	return cfg.middlewareAuth(next, true, "")
+}
+
+// requireScope is requireAuth that also accepts API keys and OAuth client
+// tokens granted scope.
+func (cfg *apiConfig) requireScope(scope string, next http.HandlerFunc) http.Handler {
+	return cfg.middlewareAuth(next, true, scope)
+}
+
+// optionalScope lets anonymous requests through, but a credential that is
+// present must still be valid and, if it is restricted, granted scope.
+func (cfg *apiConfig) optionalScope(scope string, next http.HandlerFunc) http.Handler {
+	return cfg.middlewareAuth(next, false, scope)
+}
+
+func (cfg *apiConfig) middlewareAuth(next http.Handler, required bool, scope string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p principal
+		var authErr error
+		if strings.HasPrefix(r.Header.Get("Authorization"), "ApiKey ") {
+			key, _ := auth.GetAPIKey(r.Header)
+			p, authErr = cfg.authenticateAPIKey(r.Context(), key)
+		} else {
+			token, headErr := auth.GetBearerToken(r.Header)
+			if errors.Is(headErr, auth.ErrMissingToken) && !required {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if headErr != nil {
+				respondWithAuthError(w, r, headErr)
+				return
+			}
+			p, authErr = cfg.authenticate(r.Context(), token)
+		}
+		if authErr != nil {
+			respondWithAuthError(w, r, authErr)
+			return
+		}
+		if !p.hasScope(scope) {
+			respondWithInsufficientScope(w, r, scope)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+	})
+}
+
+// setBearerChallenge adds the WWW-Authenticate header RFC 6750 asks for on a
+// 401. errCode is empty when no credentials were sent at all.
+func setBearerChallenge(w http.ResponseWriter, errCode, description string) {
+	challenge := `Bearer realm="chirpy"`
+	if errCode != "" {
+		challenge += fmt.Sprintf(`, error="%s", error_description="%s"`, errCode, description)
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+}
+
+// respondWithAuthError maps a GetBearerToken or authenticate failure to a
+// 401, or a 500 if the token could not be checked at all.
+func respondWithAuthError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, auth.ErrMissingToken):
+		setBearerChallenge(w, "", "")
+		respondWithError(w, r, 401, errCodeUnauthorized, "Authentication required")
+	case errors.Is(err, auth.ErrExpiredToken):
+		setBearerChallenge(w, "invalid_token", "The access token expired")
+		respondWithError(w, r, 401, errCodeTokenExpired, "Access token has expired")
+	case errors.Is(err, auth.ErrRevokedToken):
+		setBearerChallenge(w, "invalid_token", "The access token has been revoked")
+		respondWithError(w, r, 401, errCodeUnauthorized, "Access token has been revoked")
+	case errors.Is(err, auth.ErrInvalidToken), errors.Is(err, auth.ErrWrongSigningMethod):
+		setBearerChallenge(w, "invalid_token", "The access token is invalid")
+		respondWithError(w, r, 401, errCodeUnauthorized, "Invalid access token")
+	case errors.Is(err, errInvalidAPIKey):
+		setBearerChallenge(w, "invalid_token", "The API key is invalid")
+		respondWithError(w, r, 401, errCodeUnauthorized, "Invalid API key")
+	case errors.Is(err, errExpiredAPIKey):
+		setBearerChallenge(w, "invalid_token", "The API key expired")
+		respondWithError(w, r, 401, errCodeTokenExpired, "API key has expired")
+	case errors.Is(err, auth.ErrMalformedAuthorization):
+		setBearerChallenge(w, "invalid_request", "The Authorization header must use the Bearer or ApiKey scheme")
+		respondWithError(w, r, 401, errCodeUnauthorized, "Authorization header is not in the correct format")
+	default:
+		log.Printf("Error checking access token: %s", err)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to check access token")
+	}
+}
+
+// respondWithInsufficientScope refuses a valid credential that may not be
+// used for this request.
+func respondWithInsufficientScope(w http.ResponseWriter, r *http.Request, scope string) {
+	if scope == "" {
+		setBearerChallenge(w, "insufficient_scope", "Only session tokens can be used here")
+		respondWithError(w, r, 403, errCodeForbidden, "API keys and app tokens cannot be used for this request")
+		return
+	}
+	setBearerChallenge(w, "insufficient_scope", "The "+scope+" scope is required")
+	respondWithError(w, r, 403, errCodeForbidden, fmt.Sprintf("This request requires the %s scope", scope))
+}
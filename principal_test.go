@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dev-perry/go-server/internal/auth"
+	"github.com/google/uuid"
+)
+
+func TestRequireAuthChallenges(t *testing.T) {
+	ts := newTestServer(t)
+	user := ts.createUser(t, "walt@example.com", "heisenberg")
+	expired, _ := auth.MakeJWT(user.ID, uuid.New(), 0, user.Role, ts.cfg.jwtKeys, -time.Minute)
+
+	tests := []struct {
+		name          string
+		authorization string
+		code          string
+		challenge     string
+	}{
+		{"missing", "", errCodeUnauthorized, `Bearer realm="chirpy"`},
+		{"wrong scheme", "Basic d2FsdDpoZWlzZW5iZXJn", errCodeUnauthorized, `error="invalid_request"`},
+		{"garbage", bearer("not.a.token"), errCodeUnauthorized, `error="invalid_token"`},
+		{"expired", bearer(expired), errCodeTokenExpired, `error="invalid_token"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, body := ts.do(t, "POST", "/api/chirps", tt.authorization, createChirpRequest{Body: "hello"})
+			expectError(t, res, body, http.StatusUnauthorized, tt.code)
+			if got := res.Header.Get("WWW-Authenticate"); !strings.Contains(got, tt.challenge) {
+				t.Errorf("expected WWW-Authenticate to contain %s, got %q", tt.challenge, got)
+			}
+		})
+	}
+}
+
+func TestRequireAuthSetsPrincipal(t *testing.T) {
+	ts := newTestServer(t)
+	user := ts.createUser(t, "walt@example.com", "heisenberg")
+	login := ts.login(t, "walt@example.com", "heisenberg")
+	ts.cfg.db.UpgradeUser(context.Background(), user.ID)
+
+	var got principal
+	handler := ts.cfg.requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = principalFromContext(r.Context())
+	})
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", bearer(login.Token))
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if got.UserID != user.ID {
+		t.Errorf("expected principal for %s, got %s", user.ID, got.UserID)
+	}
+	if got.TokenID == "" {
+		t.Error("expected the token ID to be set")
+	}
+	if got.Tier != tierChirpyRed {
+		t.Errorf("expected tier %s, got %s", tierChirpyRed, got.Tier)
+	}
+}
+
+func TestOptionalAuth(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser(t, "walt@example.com", "heisenberg")
+	login := ts.login(t, "walt@example.com", "heisenberg")
+
+	res, body := ts.do(t, "GET", "/api/chirps", "", nil)
+	expectStatus(t, res, body, http.StatusOK)
+
+	res, body = ts.do(t, "GET", "/api/chirps", bearer(login.Token), nil)
+	expectStatus(t, res, body, http.StatusOK)
+
+	res, body = ts.do(t, "GET", "/api/chirps", bearer("not.a.token"), nil)
+	expectError(t, res, body, http.StatusUnauthorized, errCodeUnauthorized)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter allows at most limit events per key within a sliding window.
+// State is kept in memory, so each replica enforces its own limit.
+type rateLimiter struct {
+	mu     sync.Mutex
+	limit  int
+	window time.Duration
+	events map[string][]time.Time
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{limit: limit, window: window, events: map[string][]time.Time{}}
+}
+
+// allow records an event for key at now unless the limit has been reached,
+// in which case it reports how long until the oldest event expires.
+func (l *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	cutoff := now.Add(-l.window)
+	recent := l.events[key][:0]
+	for _, at := range l.events[key] {
+		if at.After(cutoff) {
+			recent = append(recent, at)
+		}
+	}
+	if len(recent) >= l.limit {
+		l.events[key] = recent
+		return false, recent[0].Sub(cutoff)
+	}
+	l.events[key] = append(recent, now)
+	l.sweep(cutoff)
+	return true, 0
+}
+
+// sweep drops keys with no events left in the window so the map doesn't grow
+// with every address ever submitted. It runs only once the map is large.
+func (l *rateLimiter) sweep(cutoff time.Time) {
+	if len(l.events) < 1024 {
+		return
+	}
+	for key, events := range l.events {
+		if !events[len(events)-1].After(cutoff) {
+			delete(l.events, key)
+		}
+	}
+}
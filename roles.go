@@ -0,0 +1,151 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/dev-perry/go-server/internal/database"
+)
+
+// Roles, as stored in users.role and carried in access tokens.
+const (
+	roleUser      = "user"
+	roleModerator = "moderator"
+	roleAdmin     = "admin"
+)
+
+var roles = []string{roleUser, roleModerator, roleAdmin}
+
+const (
+	securityEventRoleChanged  = "role_changed"
+	securityEventChirpRemoved = "chirp_removed"
+)
+
+// permission is something only some roles may do.
+type permission string
+
+const (
+	permModerateChirps permission = "chirps:moderate"
+	permManageUsers    permission = "users:manage"
+	permViewMetrics    permission = "metrics:read"
+	permResetDatabase  permission = "database:reset"
+)
+
+// rolePermissions lists what each role may do beyond what every user can.
+var rolePermissions = map[string][]permission{
+	roleModerator: {permModerateChirps},
+	roleAdmin:     {permModerateChirps, permManageUsers, permViewMetrics, permResetDatabase},
+}
+
+func (p principal) can(perm permission) bool {
+	return slices.Contains(rolePermissions[p.Role], perm)
+}
+
+// requirePermission is requireAuth for requests whose user's role grants
+// perm. Like requireAuth it refuses API keys and app tokens, so nothing but
+// a session can act with a role's authority.
+func (cfg *apiConfig) requirePermission(perm permission, next http.HandlerFunc) http.Handler {
+	return cfg.requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		caller, _ := principalFromContext(r.Context())
+		if !caller.can(perm) {
+			respondWithError(w, r, 403, errCodeForbidden, "You do not have permission to do that")
+			return
+		}
+		next(w, r)
+	})
+}
+
+// setUserRole changes another user's role. Their access tokens name the old
+// role and stop working, but their sessions survive: refreshing picks up the
+// new one.
+func (cfg *apiConfig) setUserRole(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+	userID, paramErr := pathUUID(r, "userID")
+	if paramErr != nil {
+		respondWithParamError(w, r, paramErr)
+		return
+	}
+	var req struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, r, 400, errCodeBadRequest, "Unable to decode request body")
+		return
+	}
+	if !slices.Contains(roles, req.Role) {
+		respondWithError(w, r, 400, errCodeValidation, "Unknown role", fieldError{
+			Field:   "role",
+			Message: "must be one of " + strings.Join(roles, ", "),
+		})
+		return
+	}
+	// Otherwise the last admin could demote themselves and leave nobody
+	// able to manage users short of the command line.
+	if userID == caller.UserID {
+		respondWithError(w, r, 403, errCodeForbidden, "You cannot change your own role")
+		return
+	}
+
+	var updated int64
+	dbErr := cfg.db.ExecTx(r.Context(), func(q database.Querier) error {
+		var err error
+		updated, err = q.SetUserRole(r.Context(), database.SetUserRoleParams{ID: userID, Role: req.Role})
+		if err != nil || updated == 0 {
+			return err
+		}
+		return q.CreateSecurityEvent(r.Context(), database.CreateSecurityEventParams{
+			UserID: userID,
+			Kind:   securityEventRoleChanged,
+			Detail: fmt.Sprintf("role set to %s by %s", req.Role, caller.UserID),
+		})
+	})
+	if dbErr != nil {
+		log.Printf("Error setting role of user %s: %s", userID, dbErr)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to change role")
+		return
+	}
+	if updated == 0 {
+		respondWithError(w, r, 404, errCodeNotFound, "User not found")
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// removeChirp lets a moderator delete anyone's chirp. The author is told
+// through their security events.
+func (cfg *apiConfig) removeChirp(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+	chirpID, paramErr := pathUUID(r, "chirpID")
+	if paramErr != nil {
+		respondWithParamError(w, r, paramErr)
+		return
+	}
+
+	dbErr := cfg.db.ExecTx(r.Context(), func(q database.Querier) error {
+		authorID, err := q.RemoveChirp(r.Context(), chirpID)
+		if err != nil {
+			return err
+		}
+		return q.CreateSecurityEvent(r.Context(), database.CreateSecurityEventParams{
+			UserID: authorID,
+			Kind:   securityEventChirpRemoved,
+			Detail: fmt.Sprintf("chirp %s removed by moderator %s", chirpID, caller.UserID),
+		})
+	})
+	if errors.Is(dbErr, sql.ErrNoRows) {
+		respondWithError(w, r, 404, errCodeNotFound, "Chirp not found")
+		return
+	}
+	if dbErr != nil {
+		log.Printf("Error removing chirp %s: %s", chirpID, dbErr)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to remove chirp")
+		return
+	}
+	w.WriteHeader(204)
+}
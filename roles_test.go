@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/dev-perry/go-server/internal/auth"
+	"github.com/google/uuid"
+)
+
+func TestAdminEndpointsRequirePermission(t *testing.T) {
+	ts := newTestServer(t)
+	user := bearer(ts.loginAs(t, roleUser).Token)
+	moderator := bearer(ts.loginAs(t, roleModerator).Token)
+	admin := ts.loginAs(t, roleAdmin)
+	apiKey := ts.createAPIKey(t, admin.Token, scopeChirpsRead)
+
+	res, body := ts.do(t, "GET", "/admin/metrics", "", nil)
+	expectError(t, res, body, http.StatusUnauthorized, errCodeUnauthorized)
+	for _, authorization := range []string{user, moderator, apiKeyAuth(apiKey.Key)} {
+		res, body = ts.do(t, "GET", "/admin/metrics", authorization, nil)
+		expectError(t, res, body, http.StatusForbidden, errCodeForbidden)
+	}
+	res, body = ts.do(t, "GET", "/admin/metrics", bearer(admin.Token), nil)
+	expectStatus(t, res, body, http.StatusOK)
+
+	res, body = ts.do(t, "POST", "/admin/reset", moderator, nil)
+	expectError(t, res, body, http.StatusForbidden, errCodeForbidden)
+	res, body = ts.do(t, "POST", "/admin/ips/127.0.0.1/unlock", moderator, nil)
+	expectError(t, res, body, http.StatusForbidden, errCodeForbidden)
+}
+
+func TestLoginCarriesRole(t *testing.T) {
+	ts := newTestServer(t)
+	login := ts.loginAs(t, roleModerator)
+	if login.Role != roleModerator {
+		t.Errorf("expected role %s in the response, got %q", roleModerator, login.Role)
+	}
+	claims, err := auth.ValidateJWT(login.Token, ts.cfg.jwtKeys)
+	if err != nil {
+		t.Fatalf("ValidateJWT: %v", err)
+	}
+	if claims.Role != roleModerator {
+		t.Errorf("expected role %s in the token, got %q", roleModerator, claims.Role)
+	}
+}
+
+func TestSetUserRole(t *testing.T) {
+	ts := newTestServer(t)
+	admin := ts.loginAs(t, roleAdmin)
+	user := ts.createUser(t, "walt@example.com", "heisenberg")
+	login := ts.login(t, "walt@example.com", "heisenberg")
+	rolePath := "/admin/users/" + user.ID.String() + "/role"
+
+	res, body := ts.do(t, "PUT", rolePath, bearer(admin.Token), map[string]string{"role": "owner"})
+	expectError(t, res, body, http.StatusBadRequest, errCodeValidation)
+	res, body = ts.do(t, "PUT", "/admin/users/"+uuid.NewString()+"/role", bearer(admin.Token), map[string]string{"role": roleModerator})
+	expectError(t, res, body, http.StatusNotFound, errCodeNotFound)
+
+	res, body = ts.do(t, "PUT", rolePath, bearer(admin.Token), map[string]string{"role": roleModerator})
+	expectStatus(t, res, body, http.StatusNoContent)
+	events, _ := ts.cfg.db.ListSecurityEventsByUser(context.Background(), user.ID)
+	if len(events) != 1 || events[0].Kind != securityEventRoleChanged {
+		t.Errorf("expected the change to be recorded, got %+v", events)
+	}
+
+	// The old token names the old role, but the session carries on.
+	res, body = ts.do(t, "GET", "/api/sessions", bearer(login.Token), nil)
+	expectError(t, res, body, http.StatusUnauthorized, errCodeUnauthorized)
+	res, body = ts.do(t, "POST", "/api/refresh", bearer(login.RefreshToken), nil)
+	expectStatus(t, res, body, http.StatusOK)
+	refreshed := decode[RefreshTokenResponse](t, body)
+	chirp := ts.createChirp(t, admin.Token, "Moderate me")
+	res, body = ts.do(t, "DELETE", "/admin/chirps/"+chirp.ID.String(), bearer(refreshed.Token), nil)
+	expectStatus(t, res, body, http.StatusNoContent)
+
+	// An admin can't demote themselves, so there is always one left.
+	res, body = ts.do(t, "PUT", "/admin/users/"+admin.ID.String()+"/role", bearer(admin.Token), map[string]string{"role": roleUser})
+	expectError(t, res, body, http.StatusForbidden, errCodeForbidden)
+}
+
+func TestRemoveChirp(t *testing.T) {
+	ts := newTestServer(t)
+	moderator := ts.loginAs(t, roleModerator)
+	author := ts.createUser(t, "walt@example.com", "heisenberg")
+	login := ts.login(t, "walt@example.com", "heisenberg")
+	chirp := ts.createChirp(t, login.Token, "Say my name")
+
+	// Moderation has its own endpoint, which authors can't use either.
+	res, body := ts.do(t, "DELETE", "/api/chirps/"+chirp.ID.String(), bearer(moderator.Token), nil)
+	expectError(t, res, body, http.StatusForbidden, errCodeForbidden)
+	res, body = ts.do(t, "DELETE", "/admin/chirps/"+chirp.ID.String(), bearer(login.Token), nil)
+	expectError(t, res, body, http.StatusForbidden, errCodeForbidden)
+
+	res, body = ts.do(t, "DELETE", "/admin/chirps/"+chirp.ID.String(), bearer(moderator.Token), nil)
+	expectStatus(t, res, body, http.StatusNoContent)
+	res, body = ts.do(t, "GET", "/api/chirps/"+chirp.ID.String(), "", nil)
+	expectError(t, res, body, http.StatusNotFound, errCodeNotFound)
+	events, _ := ts.cfg.db.ListSecurityEventsByUser(context.Background(), author.ID)
+	if len(events) != 1 || events[0].Kind != securityEventChirpRemoved {
+		t.Errorf("expected the author to be told, got %+v", events)
+	}
+
+	res, body = ts.do(t, "DELETE", "/admin/chirps/"+chirp.ID.String(), bearer(moderator.Token), nil)
+	expectError(t, res, body, http.StatusNotFound, errCodeNotFound)
+}
+
+func TestSetRoleByEmail(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser(t, "walt@example.com", "heisenberg")
+	ctx := context.Background()
+
+	if err := setRoleByEmail(ctx, ts.cfg.db, "walt@example.com", "root", &bytes.Buffer{}); err == nil {
+		t.Error("expected an unknown role to be refused")
+	}
+	if err := setRoleByEmail(ctx, ts.cfg.db, "jesse@example.com", roleAdmin, &bytes.Buffer{}); err == nil {
+		t.Error("expected an unknown email to be refused")
+	}
+
+	var out bytes.Buffer
+	if err := setRoleByEmail(ctx, ts.cfg.db, "WALT@example.com", roleAdmin, &out); err != nil {
+		t.Fatalf("setRoleByEmail: %v", err)
+	}
+	if !strings.Contains(out.String(), "is now admin") {
+		t.Errorf("expected confirmation, got %q", out.String())
+	}
+	if login := ts.login(t, "walt@example.com", "heisenberg"); login.Role != roleAdmin {
+		t.Errorf("expected role %s, got %q", roleAdmin, login.Role)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/dev-perry/go-server/internal/database"
+	"github.com/google/uuid"
+)
+
+// Session is a refresh token family: it starts at login and survives every
+// rotation, so its ID is the family ID rather than any single token.
+type Session struct {
+	ID         uuid.UUID `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+	// ClientID is set when the session is an OAuth client's grant.
+	ClientID string `json:"client_id,omitempty"`
+}
+
+// clientIP is the address the request arrived from, without the port.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func (cfg *apiConfig) listSessions(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+	uid := caller.UserID
+
+	rows, dbErr := cfg.db.ListActiveSessions(r.Context(), uid)
+	if dbErr != nil {
+		log.Printf("Error listing sessions: %s", dbErr)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to list sessions")
+		return
+	}
+	sessions := make([]Session, 0, len(rows))
+	for _, row := range rows {
+		sessions = append(sessions, Session{
+			ID:         row.FamilyID,
+			CreatedAt:  row.CreatedAt,
+			LastUsedAt: row.LastUsedAt,
+			ExpiresAt:  row.ExpiresAt,
+			UserAgent:  row.UserAgent,
+			IPAddress:  row.IpAddress,
+			ClientID:   row.ClientID.String,
+		})
+	}
+	respondWithJSON(w, 200, sessions)
+}
+
+// revokeSession signs out of one session. Access tokens carry the session
+// they were issued with, so they stop working along with its refresh token.
+func (cfg *apiConfig) revokeSession(w http.ResponseWriter, r *http.Request) {
+	sessionID, paramErr := pathUUID(r, "sessionID")
+	if paramErr != nil {
+		respondWithParamError(w, r, paramErr)
+		return
+	}
+	caller, _ := principalFromContext(r.Context())
+	uid := caller.UserID
+
+	revoked, dbErr := cfg.db.RevokeSession(r.Context(), database.RevokeSessionParams{
+		FamilyID: sessionID,
+		UserID:   uid,
+	})
+	if dbErr != nil {
+		log.Printf("Error revoking session %s: %s", sessionID, dbErr)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to revoke session")
+		return
+	}
+	if revoked == 0 {
+		// Someone else's session looks the same as one that doesn't exist.
+		respondWithError(w, r, 404, errCodeNotFound, "Session not found")
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// revokeAllSessions logs the user out everywhere, including the access
+// token used to make the request.
+func (cfg *apiConfig) revokeAllSessions(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+	uid := caller.UserID
+
+	dbErr := cfg.db.ExecTx(r.Context(), func(q database.Querier) error {
+		return revokeUserTokens(r.Context(), q, uid)
+	})
+	if dbErr != nil {
+		log.Printf("Error revoking sessions for user %s: %s", uid, dbErr)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to revoke sessions")
+		return
+	}
+	w.WriteHeader(204)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestListSessions(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser(t, "walt@example.com", "heisenberg")
+	first := ts.login(t, "walt@example.com", "heisenberg")
+	ts.login(t, "walt@example.com", "heisenberg")
+
+	res, body := ts.do(t, "POST", "/api/refresh", bearer(first.RefreshToken), nil)
+	expectStatus(t, res, body, http.StatusOK)
+
+	res, body = ts.do(t, "GET", "/api/sessions", bearer(first.Token), nil)
+	expectStatus(t, res, body, http.StatusOK)
+	sessions := decode[[]Session](t, body)
+	if len(sessions) != 2 {
+		t.Fatalf("expected one session per login, got %d: %s", len(sessions), body)
+	}
+	refreshed := sessions[0]
+	if refreshed.IPAddress != "127.0.0.1" {
+		t.Errorf("expected the client IP to be recorded, got %q", refreshed.IPAddress)
+	}
+	if refreshed.UserAgent == "" {
+		t.Error("expected the user agent to be recorded")
+	}
+	if refreshed.CreatedAt.After(refreshed.LastUsedAt) {
+		t.Errorf("expected created_at %s to be the login time, before last_used_at %s", refreshed.CreatedAt, refreshed.LastUsedAt)
+	}
+}
+
+func TestListSessionsRequiresToken(t *testing.T) {
+	ts := newTestServer(t)
+
+	res, body := ts.do(t, "GET", "/api/sessions", "", nil)
+	expectError(t, res, body, http.StatusUnauthorized, errCodeUnauthorized)
+}
+
+func TestRevokeSession(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser(t, "walt@example.com", "heisenberg")
+	ts.createUser(t, "jesse@example.com", "yeahscience")
+	walt := ts.login(t, "walt@example.com", "heisenberg")
+	jesse := ts.login(t, "jesse@example.com", "yeahscience")
+
+	res, body := ts.do(t, "GET", "/api/sessions", bearer(walt.Token), nil)
+	expectStatus(t, res, body, http.StatusOK)
+	sessions := decode[[]Session](t, body)
+	if len(sessions) != 1 {
+		t.Fatalf("expected one session, got %d", len(sessions))
+	}
+	path := "/api/sessions/" + sessions[0].ID.String()
+	other := ts.login(t, "walt@example.com", "heisenberg")
+
+	res, body = ts.do(t, "DELETE", path, bearer(jesse.Token), nil)
+	expectError(t, res, body, http.StatusNotFound, errCodeNotFound)
+
+	res, body = ts.do(t, "DELETE", path, bearer(other.Token), nil)
+	expectStatus(t, res, body, http.StatusNoContent)
+
+	res, body = ts.do(t, "POST", "/api/refresh", bearer(walt.RefreshToken), nil)
+	expectError(t, res, body, http.StatusUnauthorized, errCodeUnauthorized)
+
+	// The session's access token goes with it; other sessions are untouched.
+	res, body = ts.do(t, "GET", "/api/sessions", bearer(walt.Token), nil)
+	expectError(t, res, body, http.StatusUnauthorized, errCodeUnauthorized)
+
+	res, body = ts.do(t, "DELETE", path, bearer(other.Token), nil)
+	expectError(t, res, body, http.StatusNotFound, errCodeNotFound)
+
+	res, body = ts.do(t, "DELETE", "/api/sessions/"+uuid.NewString(), bearer(other.Token), nil)
+	expectError(t, res, body, http.StatusNotFound, errCodeNotFound)
+
+	res, body = ts.do(t, "DELETE", "/api/sessions/not-a-uuid", bearer(other.Token), nil)
+	expectError(t, res, body, http.StatusBadRequest, errCodeInvalidParameter)
+}
+
+func TestRevokeAllSessions(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser(t, "walt@example.com", "heisenberg")
+	ts.createUser(t, "jesse@example.com", "yeahscience")
+	first := ts.login(t, "walt@example.com", "heisenberg")
+	second := ts.login(t, "walt@example.com", "heisenberg")
+	jesse := ts.login(t, "jesse@example.com", "yeahscience")
+
+	res, body := ts.do(t, "POST", "/api/sessions/revoke-all", bearer(first.Token), nil)
+	expectStatus(t, res, body, http.StatusNoContent)
+
+	for _, token := range []string{first.RefreshToken, second.RefreshToken} {
+		res, body = ts.do(t, "POST", "/api/refresh", bearer(token), nil)
+		expectError(t, res, body, http.StatusUnauthorized, errCodeUnauthorized)
+	}
+
+	res, body = ts.do(t, "GET", "/api/sessions", bearer(first.Token), nil)
+	expectError(t, res, body, http.StatusUnauthorized, errCodeUnauthorized)
+
+	again := ts.login(t, "walt@example.com", "heisenberg")
+	res, body = ts.do(t, "GET", "/api/sessions", bearer(again.Token), nil)
+	expectStatus(t, res, body, http.StatusOK)
+	if sessions := decode[[]Session](t, body); len(sessions) != 1 {
+		t.Errorf("expected only the new session, got %d", len(sessions))
+	}
+
+	res, body = ts.do(t, "POST", "/api/refresh", bearer(jesse.RefreshToken), nil)
+	expectStatus(t, res, body, http.StatusOK)
+}
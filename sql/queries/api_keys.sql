@@ -0,0 +1,27 @@
+-- name: CreateAPIKey :one
+INSERT INTO api_keys (id, created_at, user_id, name, key_hash, scopes, expires_at)
+VALUES ($1, now(), $2, $3, $4, $5, $6)
+RETURNING *;
+
+-- name: GetAPIKey :one
+SELECT * FROM api_keys WHERE id=$1;
+
+-- name: ListAPIKeys :many
+SELECT * FROM api_keys
+WHERE user_id=$1
+and revoked_at is null
+ORDER BY created_at;
+
+-- Only replaces the hash it was computed from, so a concurrent revocation
+-- or rehash wins.
+-- name: RehashAPIKey :exec
+UPDATE api_keys SET key_hash=sqlc.arg(new_hash) WHERE id=$1 and key_hash=sqlc.arg(old_hash);
+
+-- name: RevokeAPIKey :execrows
+UPDATE api_keys SET revoked_at=now()
+WHERE id=$1
+and user_id=$2
+and revoked_at is null;
+
+-- name: TouchAPIKey :exec
+UPDATE api_keys SET last_used_at=now() WHERE id=$1;
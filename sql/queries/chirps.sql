@@ -7,11 +7,19 @@ VALUES
 -- name: GetChirp :one
 SELECT * FROM chirps WHERE id = $1;
 
--- name: GetAllChirps :many
-SELECT * FROM chirps;
+-- name: ListChirpsAsc :many
+SELECT * FROM chirps
+WHERE (sqlc.narg('author_id')::uuid IS NULL OR user_id = sqlc.narg('author_id')::uuid)
+AND (sqlc.narg('after_created_at')::timestamp IS NULL OR (created_at, id) > (sqlc.narg('after_created_at')::timestamp, sqlc.narg('after_id')::uuid))
+ORDER BY created_at ASC, id ASC
+LIMIT sqlc.arg('page_size');
 
--- name: GetAllChirpsByAuthor :many
-SELECT * FROM chirps WHERE user_id=$1;
+-- name: ListChirpsDesc :many
+SELECT * FROM chirps
+WHERE (sqlc.narg('author_id')::uuid IS NULL OR user_id = sqlc.narg('author_id')::uuid)
+AND (sqlc.narg('after_created_at')::timestamp IS NULL OR (created_at, id) < (sqlc.narg('after_created_at')::timestamp, sqlc.narg('after_id')::uuid))
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg('page_size');
 
 -- name: DeleteAllChirps :exec
 TRUNCATE chirps;
@@ -25,4 +33,7 @@ FROM chirps
 WHERE id=$2;
 
 -- name: DeleteChirp :exec
-DELETE FROM chirps WHERE id=$1 AND user_id=$2;
\ No newline at end of file
+DELETE FROM chirps WHERE id=$1 AND user_id=$2;
+
+-- name: RemoveChirp :one
+DELETE FROM chirps WHERE id=$1 RETURNING user_id;
@@ -0,0 +1,17 @@
+-- name: CreateEmailVerificationToken :exec
+INSERT INTO email_verification_tokens (id, created_at, user_id, email, token_hash, expires_at)
+VALUES ($1, now(), $2, $3, $4, $5);
+
+-- name: GetEmailVerificationToken :one
+SELECT * FROM email_verification_tokens WHERE id=$1;
+
+-- name: UseEmailVerificationToken :execrows
+UPDATE email_verification_tokens SET used_at=now()
+WHERE id=$1
+and used_at is null
+and expires_at > now();
+
+-- name: InvalidateEmailVerificationTokens :exec
+UPDATE email_verification_tokens SET used_at=now()
+WHERE user_id=$1
+and used_at is null;
@@ -0,0 +1,16 @@
+-- name: GetLoginThrottle :one
+SELECT * FROM login_throttles WHERE key=$1;
+
+-- name: RecordLoginFailure :one
+INSERT INTO login_throttles (key, failures, last_failure_at)
+VALUES ($1, 1, now())
+ON CONFLICT (key) DO UPDATE SET
+failures = CASE WHEN login_throttles.last_failure_at < sqlc.arg(reset_before)::timestamp THEN 1 ELSE login_throttles.failures + 1 END,
+last_failure_at = now()
+RETURNING failures;
+
+-- name: LockLoginThrottle :exec
+UPDATE login_throttles SET locked_until=$2 WHERE key=$1;
+
+-- name: DeleteLoginThrottle :execrows
+DELETE FROM login_throttles WHERE key=$1;
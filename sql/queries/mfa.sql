@@ -0,0 +1,48 @@
+-- name: GetUserTOTP :one
+SELECT email, totp_secret, totp_enabled_at, totp_last_step FROM users WHERE id=$1;
+
+-- name: SetTOTPSecret :exec
+UPDATE users SET totp_secret=$2, totp_enabled_at=NULL, totp_last_step=NULL, updated_at=now() WHERE id=$1;
+
+-- name: EnableTOTP :execrows
+UPDATE users SET totp_enabled_at=now(), totp_last_step=sqlc.arg(step)::bigint, updated_at=now()
+WHERE id=$1
+and totp_secret is not null
+and totp_enabled_at is null;
+
+-- name: DisableTOTP :exec
+UPDATE users SET totp_secret=NULL, totp_enabled_at=NULL, totp_last_step=NULL, updated_at=now() WHERE id=$1;
+
+-- name: UseTOTPStep :execrows
+UPDATE users SET totp_last_step=sqlc.arg(step)::bigint
+WHERE id=$1
+and (totp_last_step is null or totp_last_step < sqlc.arg(step)::bigint);
+
+-- name: CreateRecoveryCode :exec
+INSERT INTO recovery_codes (id, created_at, user_id, code_hash)
+VALUES (gen_random_uuid(), now(), $1, $2);
+
+-- name: DeleteRecoveryCodes :exec
+DELETE FROM recovery_codes WHERE user_id=$1;
+
+-- name: UseRecoveryCode :execrows
+UPDATE recovery_codes SET used_at=now()
+WHERE user_id=$1
+and code_hash=$2
+and used_at is null;
+
+-- name: CreateMFAChallenge :exec
+INSERT INTO mfa_challenges (id, created_at, user_id, token_hash, expires_at)
+VALUES ($1, now(), $2, $3, $4);
+
+-- name: GetMFAChallenge :one
+SELECT * FROM mfa_challenges WHERE id=$1;
+
+-- name: RecordMFAChallengeAttempt :one
+UPDATE mfa_challenges SET attempts=attempts+1 WHERE id=$1 RETURNING attempts;
+
+-- name: UseMFAChallenge :execrows
+UPDATE mfa_challenges SET used_at=now()
+WHERE id=$1
+and used_at is null
+and expires_at > now();
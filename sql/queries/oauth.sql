@@ -0,0 +1,37 @@
+-- name: CreateOAuthClient :one
+INSERT INTO oauth_clients (id, created_at, user_id, name, secret_hash, redirect_uris)
+VALUES ($1, now(), $2, $3, $4, $5)
+RETURNING *;
+
+-- name: GetOAuthClient :one
+SELECT * FROM oauth_clients WHERE id=$1;
+
+-- name: ListOAuthClients :many
+SELECT * FROM oauth_clients
+WHERE user_id=$1
+ORDER BY created_at;
+
+-- name: DeleteOAuthClient :execrows
+DELETE FROM oauth_clients
+WHERE id=$1
+and user_id=$2;
+
+-- Only replaces the hash it was computed from.
+-- name: RehashOAuthClientSecret :exec
+UPDATE oauth_clients SET secret_hash=sqlc.arg(new_hash)::text
+WHERE id=$1
+and secret_hash=sqlc.arg(old_hash)::text;
+
+-- name: CreateOAuthAuthorizationCode :one
+INSERT INTO oauth_authorization_codes (
+    id, created_at, code_hash, client_id, user_id, redirect_uri, scopes, code_challenge, family_id, expires_at
+) VALUES ($1, now(), $2, $3, $4, $5, $6, $7, $8, $9)
+RETURNING *;
+
+-- name: GetOAuthAuthorizationCode :one
+SELECT * FROM oauth_authorization_codes WHERE id=$1;
+
+-- name: UseOAuthAuthorizationCode :execrows
+UPDATE oauth_authorization_codes SET used_at=now()
+WHERE id=$1
+and used_at is null;
@@ -0,0 +1,28 @@
+-- name: CreateOIDCLogin :exec
+INSERT INTO oidc_logins (id, created_at, state_hash, nonce, code_verifier, expires_at)
+VALUES ($1, now(), $2, $3, $4, $5);
+
+-- name: GetOIDCLogin :one
+SELECT * FROM oidc_logins WHERE id=$1;
+
+-- name: UseOIDCLogin :execrows
+UPDATE oidc_logins SET used_at=now()
+WHERE id=$1
+and used_at is null
+and expires_at > now();
+
+-- name: CreateUserIdentity :exec
+INSERT INTO user_identities (issuer, subject, created_at, last_login_at, user_id, email)
+VALUES ($1, $2, now(), now(), $3, $4);
+
+-- name: GetUserByIdentity :one
+SELECT u.id, u.email, u.created_at, u.updated_at, u.is_chirpy_red, u.token_version, u.locked_at, u.email_verified_at, u.totp_enabled_at, u.role
+FROM user_identities i
+JOIN users u ON u.id = i.user_id
+WHERE i.issuer=$1
+and i.subject=$2;
+
+-- name: TouchUserIdentity :exec
+UPDATE user_identities SET last_login_at=now(), email=$3
+WHERE issuer=$1
+and subject=$2;
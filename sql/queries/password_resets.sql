@@ -0,0 +1,17 @@
+-- name: CreatePasswordResetToken :exec
+INSERT INTO password_reset_tokens (id, created_at, user_id, token_hash, expires_at)
+VALUES ($1, now(), $2, $3, $4);
+
+-- name: GetPasswordResetToken :one
+SELECT * FROM password_reset_tokens WHERE id=$1;
+
+-- name: UsePasswordResetToken :execrows
+UPDATE password_reset_tokens SET used_at=now()
+WHERE id=$1
+and used_at is null
+and expires_at > now();
+
+-- name: InvalidatePasswordResetTokens :exec
+UPDATE password_reset_tokens SET used_at=now()
+WHERE user_id=$1
+and used_at is null;
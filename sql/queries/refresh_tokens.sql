@@ -1,16 +1,57 @@
 -- name: CreateRefreshToken :one
 INSERT INTO refresh_tokens(
-    created_at, updated_at,  token, user_id, expires_at
-) VALUES (now(), now(), $1, $2, $3 ) RETURNING *;
+    created_at, updated_at, last_used_at, token, user_id, expires_at, family_id, token_hash, user_agent, ip_address, client_id, scopes
+) VALUES (now(), now(), now(), $1, $2, $3, $4, $5, $6, $7, $8, $9 ) RETURNING *;
 
 -- name: GetRefreshToken :one
+SELECT * from refresh_tokens where token=$1;
 
-SELECT token from refresh_tokens where expires is null and token=$1;
+-- name: RevokeRefreshToken :exec
+UPDATE refresh_tokens SET revoked_at=now() where token=$1;
 
--- name: GetUserFromRefreshToken :one
-SELECT user_id from refresh_tokens 
+-- name: RotateRefreshToken :one
+UPDATE refresh_tokens SET revoked_at=now(), rotated_at=now(), updated_at=now()
 where token=$1
+and revoked_at is null
+RETURNING *;
+
+-- name: RevokeRefreshTokenFamily :exec
+UPDATE refresh_tokens SET revoked_at=now(), updated_at=now()
+where family_id=$1
 and revoked_at is null;
 
--- name: RevokeRefreshToken :exec
-UPDATE refresh_tokens SET revoked_at=now() where token=$1;
\ No newline at end of file
+-- name: ListLegacyRefreshTokens :many
+SELECT token from refresh_tokens where token_hash is null;
+
+-- name: HashLegacyRefreshToken :exec
+UPDATE refresh_tokens SET token=sqlc.arg('token_id'), token_hash=sqlc.arg('token_hash'), updated_at=now()
+where token=sqlc.arg('legacy_token')
+and token_hash is null;
+
+-- name: ListActiveSessions :many
+SELECT t.family_id, t.last_used_at, t.user_agent, t.ip_address, t.expires_at, t.client_id,
+    (SELECT min(f.created_at) FROM refresh_tokens f WHERE f.family_id = t.family_id)::timestamp AS created_at
+FROM refresh_tokens t
+where t.user_id=$1
+and t.revoked_at is null
+and t.expires_at > now()
+ORDER BY t.last_used_at DESC;
+
+-- name: IsSessionActive :one
+SELECT EXISTS(
+    SELECT 1 FROM refresh_tokens
+    where family_id=$1
+    and revoked_at is null
+    and expires_at > now()
+);
+
+-- name: RevokeSession :execrows
+UPDATE refresh_tokens SET revoked_at=now(), updated_at=now()
+where family_id=$1
+and user_id=$2
+and revoked_at is null;
+
+-- name: RevokeAllUserRefreshTokens :exec
+UPDATE refresh_tokens SET revoked_at=now(), updated_at=now()
+where user_id=$1
+and revoked_at is null;
\ No newline at end of file
@@ -0,0 +1,6 @@
+-- name: CreateSecurityEvent :exec
+INSERT INTO security_events (id, created_at, user_id, kind, detail)
+VALUES (gen_random_uuid(), now(), $1, $2, $3);
+
+-- name: ListSecurityEventsByUser :many
+SELECT * FROM security_events WHERE user_id=$1 ORDER BY created_at;
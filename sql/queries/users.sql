@@ -2,16 +2,52 @@
 INSERT INTO
     users (id, created_at, updated_at, email, hashed_password)
 VALUES
-    (gen_random_uuid(), now(), now(), $1, $2) RETURNING id, created_at, updated_at, email, is_chirpy_red;
+    (gen_random_uuid(), now(), now(), $1, $2) RETURNING id, created_at, updated_at, email, is_chirpy_red, email_verified_at, role;
 
 -- name: GetUserCredsByEmail :one
-SELECT id, email, created_at, updated_at, hashed_password, is_chirpy_red FROM users where email=$1;
+SELECT id, email, created_at, updated_at, hashed_password, is_chirpy_red, token_version, locked_at, email_verified_at, totp_enabled_at, role FROM users where lower(email)=lower(sqlc.arg(email));
 
 -- name: DeleteAllUsers :exec
 TRUNCATE users CASCADE;
 
 -- name: UpdateUserCredentials :one
-UPDATE users SET hashed_password=$1, email=$2, updated_at=now() WHERE id=$3 RETURNING id, updated_at, email, is_chirpy_red;
+UPDATE users SET hashed_password=$1, pending_email=$2, token_version=token_version+1, updated_at=now() WHERE id=$3 RETURNING id, updated_at, email, pending_email, email_verified_at, is_chirpy_red, token_version, role;
 
 -- name: UpgradeUser :exec
-UPDATE users SET is_chirpy_red=true WHERE id=$1 RETURNING id, is_chirpy_red;
\ No newline at end of file
+UPDATE users SET is_chirpy_red=true WHERE id=$1 RETURNING id, is_chirpy_red;
+
+-- name: GetUserAuthState :one
+SELECT token_version, locked_at, is_chirpy_red, email_verified_at, role FROM users WHERE id=$1;
+
+-- name: BumpTokenVersion :one
+UPDATE users SET token_version=token_version+1, updated_at=now() WHERE id=$1 RETURNING token_version;
+
+-- name: LockUser :execrows
+UPDATE users SET locked_at=now(), token_version=token_version+1, updated_at=now() WHERE id=$1;
+
+-- name: UnlockUser :execrows
+UPDATE users SET locked_at=NULL, updated_at=now() WHERE id=$1;
+
+-- name: UpdateUserPassword :exec
+UPDATE users SET hashed_password=$1, token_version=token_version+1, updated_at=now() WHERE id=$2;
+
+-- name: GetUser :one
+SELECT id, created_at, updated_at, email, is_chirpy_red, email_verified_at, pending_email, role FROM users WHERE id=$1;
+
+-- name: VerifyUserEmail :execrows
+UPDATE users SET email_verified_at=now(), updated_at=now()
+WHERE id=$1
+and lower(email)=lower(sqlc.arg(email));
+
+-- name: ConfirmPendingEmail :execrows
+UPDATE users SET email=pending_email, pending_email=NULL, email_verified_at=now(), updated_at=now()
+WHERE id=$1
+and pending_email=sqlc.arg(email)::text;
+
+-- Only replaces the hash it was computed from, so a concurrent password
+-- change wins.
+-- name: RehashUserPassword :exec
+UPDATE users SET hashed_password=sqlc.arg(new_hash) WHERE id=$1 and hashed_password=sqlc.arg(old_hash);
+
+-- name: SetUserRole :execrows
+UPDATE users SET role=$2, updated_at=now() WHERE id=$1;
@@ -0,0 +1,23 @@
+-- +goose Up
+alter table refresh_tokens
+add column family_id uuid not null default gen_random_uuid(),
+add column rotated_at timestamp;
+
+create index refresh_tokens_family_id_idx on refresh_tokens (family_id);
+
+create table security_events (
+    id uuid primary key,
+    created_at timestamp not null,
+    user_id uuid not null references users(id) on delete cascade,
+    kind text not null,
+    detail text not null
+);
+
+-- +goose Down
+drop table security_events;
+
+drop index refresh_tokens_family_id_idx;
+
+alter table refresh_tokens
+drop column rotated_at,
+drop column family_id;
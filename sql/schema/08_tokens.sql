@@ -0,0 +1,10 @@
+-- +goose Up
+-- token now holds only the lookup ID of a refresh token; the secret half is
+-- stored as an HMAC in token_hash. Rows created before this migration still
+-- hold the raw token and a null hash until the server re-keys them at startup.
+alter table refresh_tokens
+add column token_hash text;
+
+-- +goose Down
+alter table refresh_tokens
+drop column token_hash;
@@ -0,0 +1,15 @@
+-- +goose Up
+alter table refresh_tokens
+add column last_used_at timestamp not null default now(),
+add column user_agent text not null default '',
+add column ip_address text not null default '';
+
+create index refresh_tokens_user_id_idx on refresh_tokens (user_id);
+
+-- +goose Down
+drop index refresh_tokens_user_id_idx;
+
+alter table refresh_tokens
+drop column ip_address,
+drop column user_agent,
+drop column last_used_at;
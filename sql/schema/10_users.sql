@@ -0,0 +1,9 @@
+-- +goose Up
+alter table users
+add column token_version integer not null default 0,
+add column locked_at timestamp;
+
+-- +goose Down
+alter table users
+drop column locked_at,
+drop column token_version;
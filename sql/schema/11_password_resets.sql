@@ -0,0 +1,14 @@
+-- +goose Up
+create table password_reset_tokens (
+    id text primary key,
+    created_at timestamp not null,
+    user_id uuid not null references users(id) on delete cascade,
+    token_hash text not null,
+    expires_at timestamp not null,
+    used_at timestamp
+);
+
+create index password_reset_tokens_user_id_idx on password_reset_tokens (user_id);
+
+-- +goose Down
+drop table password_reset_tokens;
@@ -0,0 +1,32 @@
+-- +goose Up
+alter table users
+add column email_verified_at timestamp,
+add column pending_email text;
+
+-- Accounts created before verification existed are trusted as they are.
+update users set email_verified_at = created_at;
+
+alter table users drop constraint users_email_key;
+create unique index users_email_lower_key on users (lower(email));
+
+create table email_verification_tokens (
+    id text primary key,
+    created_at timestamp not null,
+    user_id uuid not null references users(id) on delete cascade,
+    email text not null,
+    token_hash text not null,
+    expires_at timestamp not null,
+    used_at timestamp
+);
+
+create index email_verification_tokens_user_id_idx on email_verification_tokens (user_id);
+
+-- +goose Down
+drop table email_verification_tokens;
+
+drop index users_email_lower_key;
+alter table users add constraint users_email_key unique (email);
+
+alter table users
+drop column pending_email,
+drop column email_verified_at;
@@ -0,0 +1,34 @@
+-- +goose Up
+alter table users
+add column totp_secret text,
+add column totp_enabled_at timestamp,
+add column totp_last_step bigint;
+
+create table recovery_codes (
+    id uuid primary key,
+    created_at timestamp not null,
+    user_id uuid not null references users(id) on delete cascade,
+    code_hash text not null,
+    used_at timestamp
+);
+
+create index recovery_codes_user_id_idx on recovery_codes (user_id);
+
+create table mfa_challenges (
+    id text primary key,
+    created_at timestamp not null,
+    user_id uuid not null references users(id) on delete cascade,
+    token_hash text not null,
+    expires_at timestamp not null,
+    attempts integer not null default 0,
+    used_at timestamp
+);
+
+-- +goose Down
+drop table mfa_challenges;
+drop table recovery_codes;
+
+alter table users
+drop column totp_last_step,
+drop column totp_enabled_at,
+drop column totp_secret;
@@ -0,0 +1,12 @@
+-- +goose Up
+-- Failed login counters, keyed by "account:<email>" or "ip:<address>", shared
+-- by every server instance.
+create table login_throttles (
+    key text primary key,
+    failures integer not null,
+    last_failure_at timestamp not null,
+    locked_until timestamp
+);
+
+-- +goose Down
+drop table login_throttles;
@@ -0,0 +1,17 @@
+-- +goose Up
+create table api_keys (
+    id text primary key,
+    created_at timestamp not null,
+    user_id uuid not null references users(id) on delete cascade,
+    name text not null,
+    key_hash text not null,
+    scopes text[] not null,
+    expires_at timestamp,
+    last_used_at timestamp,
+    revoked_at timestamp
+);
+
+create index api_keys_user_id_idx on api_keys (user_id);
+
+-- +goose Down
+drop table api_keys;
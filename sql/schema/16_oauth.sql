@@ -0,0 +1,43 @@
+-- +goose Up
+create table oauth_clients (
+    id text primary key,
+    created_at timestamp not null,
+    user_id uuid not null references users(id) on delete cascade,
+    name text not null,
+    -- secret_hash is null for public clients, which can't keep a secret.
+    secret_hash text,
+    redirect_uris text[] not null
+);
+
+create index oauth_clients_user_id_idx on oauth_clients (user_id);
+
+create table oauth_authorization_codes (
+    id text primary key,
+    created_at timestamp not null,
+    code_hash text not null,
+    client_id text not null references oauth_clients(id) on delete cascade,
+    user_id uuid not null references users(id) on delete cascade,
+    redirect_uri text not null,
+    scopes text[] not null,
+    code_challenge text not null,
+    -- family_id is the refresh token family the code is exchanged for, so
+    -- the family can be revoked if the code is presented twice.
+    family_id uuid not null,
+    expires_at timestamp not null,
+    used_at timestamp
+);
+
+-- Refresh tokens issued to a third-party client carry the client and the
+-- scopes the user granted it; first-party ones have neither.
+alter table refresh_tokens
+add column client_id text references oauth_clients(id) on delete cascade,
+add column scopes text[];
+
+-- +goose Down
+alter table refresh_tokens
+drop column scopes,
+drop column client_id;
+
+drop table oauth_authorization_codes;
+
+drop table oauth_clients;
@@ -0,0 +1,32 @@
+-- +goose Up
+-- user_identities links accounts at an external OpenID provider to users.
+-- The issuer and subject together are the provider's stable ID for a user;
+-- email is only what it last reported.
+create table user_identities (
+    issuer text not null,
+    subject text not null,
+    created_at timestamp not null,
+    last_login_at timestamp not null,
+    user_id uuid not null references users(id) on delete cascade,
+    email text not null,
+    primary key (issuer, subject)
+);
+
+create index user_identities_user_id_idx on user_identities (user_id);
+
+-- oidc_logins holds what a login sent to the provider must come back with.
+-- Nobody is signed in yet, so it doesn't reference users.
+create table oidc_logins (
+    id text primary key,
+    created_at timestamp not null,
+    state_hash text not null,
+    nonce text not null,
+    code_verifier text not null,
+    expires_at timestamp not null,
+    used_at timestamp
+);
+
+-- +goose Down
+drop table oidc_logins;
+
+drop table user_identities;
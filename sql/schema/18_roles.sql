@@ -0,0 +1,8 @@
+-- +goose Up
+-- role decides which admin and moderation endpoints a user may call.
+alter table users
+    add column role text not null default 'user'
+    constraint users_role_check check (role in ('user', 'moderator', 'admin'));
+
+-- +goose Down
+alter table users drop column role;
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Only the front-end is embedded, so nothing else in the module can be
+// reached through /app/.
+//
+//go:embed index.html assets login reset-password
+var embeddedSite embed.FS
+
+// hashedAsset matches fingerprinted file names such as app.3f9a2c1d.js.
+var hashedAsset = regexp.MustCompile(`\.[0-9a-f]{8,}\.[A-Za-z0-9]+$`)
+
+type staticHandler struct {
+	fsys fs.FS
+	// modTime stands in for file modification times, which embed.FS lacks.
+	// It is zero when serving from disk.
+	modTime time.Time
+	// etags is precomputed for immutable filesystems; nil means hash on
+	// every request.
+	etags map[string]string
+}
+
+// newStaticHandler serves the embedded site, or dir from disk when set so
+// front-end changes show up without a rebuild.
+func newStaticHandler(dir string) (http.Handler, error) {
+	if dir != "" {
+		return &staticHandler{fsys: os.DirFS(dir)}, nil
+	}
+	return newImmutableStaticHandler(embeddedSite, time.Now().UTC().Truncate(time.Second))
+}
+
+func newImmutableStaticHandler(fsys fs.FS, modTime time.Time) (*staticHandler, error) {
+	h := &staticHandler{fsys: fsys, modTime: modTime, etags: map[string]string{}}
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		content, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		h.etags[name] = etagFor(content)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func etagFor(content []byte) string {
+	sum := sha256.Sum256(content)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+func (h *staticHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if name == "" {
+		name = "."
+	}
+	for _, segment := range strings.Split(name, "/") {
+		if strings.HasPrefix(segment, ".") && segment != "." {
+			http.NotFound(w, r)
+			return
+		}
+	}
+
+	info, err := fs.Stat(h.fsys, name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if info.IsDir() {
+		// Relative links in an index page need the trailing slash. The query
+		// is kept for pages that read it, like the reset link's token.
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			location := path.Base(r.URL.Path) + "/"
+			if r.URL.RawQuery != "" {
+				location += "?" + r.URL.RawQuery
+			}
+			w.Header().Set("Location", location)
+			w.WriteHeader(http.StatusMovedPermanently)
+			return
+		}
+		// Only explicit index pages are served; never a generated listing.
+		name = path.Join(name, "index.html")
+		if info, err = fs.Stat(h.fsys, name); err != nil || info.IsDir() {
+			http.NotFound(w, r)
+			return
+		}
+	}
+
+	content, err := fs.ReadFile(h.fsys, name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	modTime := h.modTime
+	etag, ok := h.etags[name]
+	if h.etags == nil {
+		modTime = info.ModTime()
+	}
+	if !ok {
+		etag = etagFor(content)
+	}
+
+	w.Header().Set("ETag", etag)
+	if h.etags != nil && hashedAsset.MatchString(name) {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+	http.ServeContent(w, r, name, modTime, bytes.NewReader(content))
+}
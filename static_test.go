@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func serveStatic(t *testing.T, h http.Handler, path string, header http.Header) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest("GET", path, nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAppServesOnlyEmbeddedSite(t *testing.T) {
+	ts := newTestServer(t)
+
+	res, body := ts.do(t, "GET", "/app/", "", nil)
+	expectStatus(t, res, body, http.StatusOK)
+
+	res, body = ts.do(t, "GET", "/app/assets/logo.png", "", nil)
+	expectStatus(t, res, body, http.StatusOK)
+	if ct := res.Header.Get("Content-Type"); ct != "image/png" {
+		t.Errorf("expected image/png, got %q", ct)
+	}
+
+	for _, path := range []string{"/app/go.mod", "/app/main.go", "/app/.env", "/app/sql/schema/01_users.sql", "/app/../go.mod"} {
+		res, body = ts.do(t, "GET", path, "", nil)
+		if res.StatusCode != http.StatusNotFound {
+			t.Errorf("GET %s: expected 404, got %d", path, res.StatusCode)
+		}
+	}
+}
+
+func TestStaticHandler(t *testing.T) {
+	modTime := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	h, err := newImmutableStaticHandler(fstest.MapFS{
+		"index.html":            {Data: []byte("<h1>hi</h1>")},
+		"app.3f9a2c1d.js":       {Data: []byte("console.log(1)")},
+		"empty/.keep":           {Data: []byte("")},
+		".env":                  {Data: []byte("TOKEN_SECRET=oops")},
+		"docs/.secret/file.txt": {Data: []byte("nope")},
+	}, modTime)
+	if err != nil {
+		t.Fatalf("newImmutableStaticHandler: %v", err)
+	}
+
+	rec := serveStatic(t, h, "/", nil)
+	if rec.Code != http.StatusOK || rec.Body.String() != "<h1>hi</h1>" {
+		t.Fatalf("expected index page, got %d %q", rec.Code, rec.Body.String())
+	}
+	etag := rec.Header().Get("ETag")
+	if etag == "" {
+		t.Error("expected an ETag")
+	}
+	if rec.Header().Get("Last-Modified") != modTime.Format(http.TimeFormat) {
+		t.Errorf("expected Last-Modified %s, got %q", modTime.Format(http.TimeFormat), rec.Header().Get("Last-Modified"))
+	}
+	if rec.Header().Get("Cache-Control") != "no-cache" {
+		t.Errorf("expected unhashed files to be revalidated, got %q", rec.Header().Get("Cache-Control"))
+	}
+
+	rec = serveStatic(t, h, "/", http.Header{"If-None-Match": {etag}})
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("expected 304 for matching ETag, got %d", rec.Code)
+	}
+
+	rec = serveStatic(t, h, "/app.3f9a2c1d.js", nil)
+	if cc := rec.Header().Get("Cache-Control"); cc != "public, max-age=31536000, immutable" {
+		t.Errorf("expected long-lived caching for hashed asset, got %q", cc)
+	}
+
+	rec = serveStatic(t, h, "/empty", nil)
+	if rec.Code != http.StatusMovedPermanently || rec.Header().Get("Location") != "empty/" {
+		t.Errorf("expected redirect to empty/, got %d %q", rec.Code, rec.Header().Get("Location"))
+	}
+
+	for _, path := range []string{"/empty/", "/.env", "/docs/.secret/file.txt", "/empty/.keep"} {
+		if rec := serveStatic(t, h, path, nil); rec.Code != http.StatusNotFound {
+			t.Errorf("GET %s: expected 404, got %d", path, rec.Code)
+		}
+	}
+}
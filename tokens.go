@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/dev-perry/go-server/internal/auth"
+	"github.com/dev-perry/go-server/internal/database"
+	"github.com/google/uuid"
+)
+
+type RefreshTokenResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+const securityEventRefreshTokenReuse = "refresh_token_reuse"
+
+var (
+	errInvalidRefreshToken = errors.New("refresh token is invalid")
+	errRefreshTokenRotated = errors.New("refresh token was already rotated")
+)
+
+// revokeUserTokens invalidates every access and refresh token userID holds.
+// Run it inside ExecTx so neither half can happen without the other.
+func revokeUserTokens(ctx context.Context, q database.Querier, userID uuid.UUID) error {
+	if _, err := q.BumpTokenVersion(ctx, userID); err != nil {
+		return err
+	}
+	return q.RevokeAllUserRefreshTokens(ctx, userID)
+}
+
+// issueRefreshToken stores a new refresh token in familyID with q and
+// returns the value to hand to the client. Only its hash is persisted, along
+// with the client details r was sent from so the session can be listed later.
+func (cfg *apiConfig) issueRefreshToken(r *http.Request, q database.Querier, userID, familyID uuid.UUID) (string, error) {
+	return cfg.storeRefreshToken(r, q, database.CreateRefreshTokenParams{
+		UserID:   userID,
+		FamilyID: familyID,
+	})
+}
+
+// storeRefreshToken is issueRefreshToken for any token, such as one issued to
+// an OAuth client. It fills in the token, its expiry and the client details.
+func (cfg *apiConfig) storeRefreshToken(r *http.Request, q database.Querier, params database.CreateRefreshTokenParams) (string, error) {
+	token, err := auth.MakeOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+	params.Token = token.ID
+	params.ExpiresAt = time.Now().Add(cfg.refreshTokenTTL)
+	params.TokenHash = sql.NullString{String: auth.HashOpaqueToken(token.Secret, cfg.refreshPepper, auth.PurposeRefreshToken), Valid: true}
+	params.UserAgent = r.UserAgent()
+	params.IpAddress = clientIP(r)
+	if _, err := q.CreateRefreshToken(r.Context(), params); err != nil {
+		return "", err
+	}
+	return token.String(), nil
+}
+
+// lookupRefreshToken finds the stored row for a presented refresh token and
+// verifies its secret, returning errInvalidRefreshToken if either fails.
+func (cfg *apiConfig) lookupRefreshToken(ctx context.Context, raw string) (database.RefreshToken, error) {
+	presented, parseErr := auth.ParseRefreshToken(raw)
+	if parseErr != nil {
+		return database.RefreshToken{}, errInvalidRefreshToken
+	}
+	stored, dbErr := cfg.db.GetRefreshToken(ctx, presented.ID)
+	if errors.Is(dbErr, sql.ErrNoRows) {
+		return database.RefreshToken{}, errInvalidRefreshToken
+	}
+	if dbErr != nil {
+		return database.RefreshToken{}, dbErr
+	}
+	// Rotation replaces a token stored under the legacy hash, so there is
+	// nothing to rehash.
+	if !stored.TokenHash.Valid || !cfg.checkOpaqueTokenHash(ctx, presented.Secret, auth.PurposeRefreshToken, stored.TokenHash.String, nil) {
+		return database.RefreshToken{}, errInvalidRefreshToken
+	}
+	return stored, nil
+}
+
+// checkOpaqueTokenHash checks secret against a stored hash. Long-lived
+// credentials issued before each purpose had its own key are stored under
+// the legacy hash; when one of those matches, rehash is given the new hash
+// to replace it with. Failing only means rehashing at the next use, so
+// errors are logged.
+func (cfg *apiConfig) checkOpaqueTokenHash(ctx context.Context, secret string, purpose auth.TokenPurpose, hash string, rehash func(ctx context.Context, newHash string) error) bool {
+	if auth.CheckOpaqueTokenHash(secret, cfg.refreshPepper, purpose, hash) {
+		return true
+	}
+	if !auth.CheckLegacyOpaqueTokenHash(secret, cfg.refreshPepper, hash) {
+		return false
+	}
+	if rehash != nil {
+		if err := rehash(ctx, auth.HashOpaqueToken(secret, cfg.refreshPepper, purpose)); err != nil {
+			log.Printf("Error rehashing %s: %s", purpose, err)
+		}
+	}
+	return true
+}
+
+// refreshToken exchanges a refresh token for a new access token and a new
+// refresh token from the same family, retiring the one presented.
+func (cfg *apiConfig) refreshToken(w http.ResponseWriter, r *http.Request) {
+	bearer, headErr := auth.GetBearerToken(r.Header)
+	if headErr != nil {
+		respondWithAuthError(w, r, headErr)
+		return
+	}
+
+	current, lookupErr := cfg.lookupRefreshToken(r.Context(), bearer)
+	if errors.Is(lookupErr, errInvalidRefreshToken) {
+		respondWithInvalidRefreshToken(w, r)
+		return
+	}
+	if lookupErr != nil {
+		log.Printf("Error looking up refresh token: %s", lookupErr)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to refresh token")
+		return
+	}
+	// Tokens issued to an OAuth client can only be refreshed by that client
+	// at /oauth/token, or they could be traded for an unrestricted session.
+	if current.ClientID.Valid {
+		respondWithInvalidRefreshToken(w, r)
+		return
+	}
+	if current.RotatedAt.Valid {
+		cfg.handleRefreshTokenReuse(r.Context(), current)
+		respondWithInvalidRefreshToken(w, r)
+		return
+	}
+	if current.RevokedAt.Valid || !current.ExpiresAt.After(time.Now()) {
+		respondWithInvalidRefreshToken(w, r)
+		return
+	}
+
+	// Retiring the old token and storing its replacement happen together, so
+	// if storing fails the client can retry with the old one rather than
+	// having it look reused.
+	var token, newRefreshToken string
+	txErr := cfg.db.ExecTx(r.Context(), func(q database.Querier) error {
+		retired, err := q.RotateRefreshToken(r.Context(), current.Token)
+		if errors.Is(err, sql.ErrNoRows) {
+			return errRefreshTokenRotated
+		}
+		if err != nil {
+			return fmt.Errorf("rotating refresh token: %w", err)
+		}
+		state, err := q.GetUserAuthState(r.Context(), retired.UserID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return errInvalidRefreshToken
+		}
+		if err != nil {
+			return fmt.Errorf("loading user: %w", err)
+		}
+		if state.LockedAt.Valid {
+			return errInvalidRefreshToken
+		}
+		token, err = auth.MakeJWT(retired.UserID, retired.FamilyID, state.TokenVersion, state.Role, cfg.jwtKeys, cfg.accessTokenTTL)
+		if err != nil {
+			return fmt.Errorf("creating access token: %w", err)
+		}
+		newRefreshToken, err = cfg.issueRefreshToken(r, q, retired.UserID, retired.FamilyID)
+		if err != nil {
+			return fmt.Errorf("storing refresh token: %w", err)
+		}
+		return nil
+	})
+	if errors.Is(txErr, errRefreshTokenRotated) {
+		// Another request rotated this token first, so it has been used twice.
+		cfg.handleRefreshTokenReuse(r.Context(), current)
+		respondWithInvalidRefreshToken(w, r)
+		return
+	}
+	if errors.Is(txErr, errInvalidRefreshToken) {
+		respondWithInvalidRefreshToken(w, r)
+		return
+	}
+	if txErr != nil {
+		log.Printf("Error refreshing token: %s", txErr)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to refresh token")
+		return
+	}
+
+	respondWithJSON(w, 200, RefreshTokenResponse{
+		Token:        token,
+		RefreshToken: newRefreshToken,
+	})
+}
+
+func respondWithInvalidRefreshToken(w http.ResponseWriter, r *http.Request) {
+	setBearerChallenge(w, "invalid_token", "The refresh token is invalid or revoked")
+	respondWithError(w, r, 401, errCodeUnauthorized, "Refresh token is invalid or revoked")
+}
+
+// handleRefreshTokenReuse is called when a retired refresh token is
+// presented again. Only one party can hold the live token, so the whole
+// family is revoked and the user has to log in again.
+func (cfg *apiConfig) handleRefreshTokenReuse(ctx context.Context, token database.RefreshToken) {
+	log.Printf("Refresh token reuse detected for user %s, revoking family %s", token.UserID, token.FamilyID)
+	if err := cfg.db.RevokeRefreshTokenFamily(ctx, token.FamilyID); err != nil {
+		log.Printf("Error revoking refresh token family %s: %s", token.FamilyID, err)
+	}
+	eventErr := cfg.db.CreateSecurityEvent(ctx, database.CreateSecurityEventParams{
+		UserID: token.UserID,
+		Kind:   securityEventRefreshTokenReuse,
+		Detail: fmt.Sprintf("retired refresh token from family %s was presented again; family revoked", token.FamilyID),
+	})
+	if eventErr != nil {
+		log.Printf("Error recording security event: %s", eventErr)
+	}
+}
+
+func (cfg *apiConfig) revokeToken(w http.ResponseWriter, r *http.Request) {
+	bearer, headErr := auth.GetBearerToken(r.Header)
+	if headErr != nil {
+		respondWithAuthError(w, r, headErr)
+		return
+	}
+	current, lookupErr := cfg.lookupRefreshToken(r.Context(), bearer)
+	if errors.Is(lookupErr, errInvalidRefreshToken) {
+		// Nothing to revoke; answer the same way so tokens can't be probed.
+		w.WriteHeader(204)
+		return
+	}
+	if lookupErr != nil {
+		log.Printf("Error looking up refresh token: %s", lookupErr)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to revoke refresh token")
+		return
+	}
+	revokeErr := cfg.db.RevokeRefreshToken(r.Context(), current.Token)
+	if revokeErr != nil {
+		log.Printf("Error revoking refresh token: %s", revokeErr)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to revoke refresh token")
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// migrateLegacyRefreshTokens re-keys refresh tokens stored in clear before
+// hashing was introduced, so clients holding them can keep refreshing.
+func migrateLegacyRefreshTokens(ctx context.Context, db database.Querier, pepper string) error {
+	legacy, err := db.ListLegacyRefreshTokens(ctx)
+	if err != nil {
+		return err
+	}
+	for _, raw := range legacy {
+		parsed, parseErr := auth.ParseRefreshToken(raw)
+		if parseErr != nil {
+			// Not something we ever issued; it can never verify, so drop the secret.
+			parsed = auth.OpaqueToken{ID: uuid.NewString(), Secret: raw}
+		}
+		err := db.HashLegacyRefreshToken(ctx, database.HashLegacyRefreshTokenParams{
+			TokenID:     parsed.ID,
+			TokenHash:   sql.NullString{String: auth.HashOpaqueToken(parsed.Secret, pepper, auth.PurposeRefreshToken), Valid: true},
+			LegacyToken: raw,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if len(legacy) > 0 {
+		log.Printf("Hashed %d legacy refresh tokens", len(legacy))
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dev-perry/go-server/internal/auth"
+	"github.com/dev-perry/go-server/internal/database"
+	"github.com/google/uuid"
+)
+
+func TestRefreshTokenIsHashedAtRest(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser(t, "walt@example.com", "heisenberg")
+	login := ts.login(t, "walt@example.com", "heisenberg")
+
+	presented, err := auth.ParseRefreshToken(login.RefreshToken)
+	if err != nil {
+		t.Fatalf("unexpected refresh token format %q: %v", login.RefreshToken, err)
+	}
+	stored, err := ts.cfg.db.GetRefreshToken(context.Background(), presented.ID)
+	if err != nil {
+		t.Fatalf("GetRefreshToken failed: %v", err)
+	}
+	if strings.Contains(stored.Token+stored.TokenHash.String, presented.Secret) {
+		t.Error("expected the refresh token secret not to be stored")
+	}
+
+	tampered := presented.ID + "." + strings.Repeat("0", len(presented.Secret))
+	res, body := ts.do(t, "POST", "/api/refresh", bearer(tampered), nil)
+	expectError(t, res, body, http.StatusUnauthorized, errCodeUnauthorized)
+}
+
+func TestMigrateLegacyRefreshTokens(t *testing.T) {
+	ts := newTestServer(t)
+	user := ts.createUser(t, "walt@example.com", "heisenberg")
+	ctx := context.Background()
+
+	legacy := strings.Repeat("a1", 32)
+	_, err := ts.cfg.db.CreateRefreshToken(ctx, database.CreateRefreshTokenParams{
+		Token:     legacy,
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(time.Hour),
+		FamilyID:  uuid.New(),
+	})
+	if err != nil {
+		t.Fatalf("CreateRefreshToken failed: %v", err)
+	}
+
+	if err := migrateLegacyRefreshTokens(ctx, ts.cfg.db, testPepper); err != nil {
+		t.Fatalf("migrateLegacyRefreshTokens failed: %v", err)
+	}
+	if remaining, _ := ts.cfg.db.ListLegacyRefreshTokens(ctx); len(remaining) != 0 {
+		t.Errorf("expected no legacy tokens left, got %v", remaining)
+	}
+
+	res, body := ts.do(t, "POST", "/api/refresh", bearer(legacy), nil)
+	expectStatus(t, res, body, http.StatusOK)
+}
+
+func TestRefreshAndRevoke(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser(t, "walt@example.com", "heisenberg")
+	auth := ts.login(t, "walt@example.com", "heisenberg")
+
+	res, body := ts.do(t, "POST", "/api/refresh", bearer(auth.RefreshToken), nil)
+	expectStatus(t, res, body, http.StatusOK)
+	refreshed := decode[RefreshTokenResponse](t, body)
+	if refreshed.Token == "" {
+		t.Fatal("expected a new access token")
+	}
+	if refreshed.RefreshToken == "" || refreshed.RefreshToken == auth.RefreshToken {
+		t.Fatal("expected the refresh token to be rotated")
+	}
+
+	res, body = ts.do(t, "POST", "/api/revoke", bearer(refreshed.RefreshToken), nil)
+	expectStatus(t, res, body, http.StatusNoContent)
+
+	res, body = ts.do(t, "POST", "/api/refresh", bearer(refreshed.RefreshToken), nil)
+	expectError(t, res, body, http.StatusUnauthorized, errCodeUnauthorized)
+}
+
+// failingInsertStore is the in-memory store with the next refresh token
+// insert failing, whether or not it is made in a transaction.
+type failingInsertStore struct {
+	database.Store
+	fail *atomic.Bool
+}
+
+func newFailingInsertStore(store database.Store) *failingInsertStore {
+	return &failingInsertStore{Store: store, fail: &atomic.Bool{}}
+}
+
+func (s *failingInsertStore) CreateRefreshToken(ctx context.Context, arg database.CreateRefreshTokenParams) (database.RefreshToken, error) {
+	return failingInsertTx{Querier: s.Store, fail: s.fail}.CreateRefreshToken(ctx, arg)
+}
+
+func (s *failingInsertStore) ExecTx(ctx context.Context, fn func(database.Querier) error) error {
+	return s.Store.ExecTx(ctx, func(q database.Querier) error {
+		return fn(failingInsertTx{Querier: q, fail: s.fail})
+	})
+}
+
+type failingInsertTx struct {
+	database.Querier
+	fail *atomic.Bool
+}
+
+func (q failingInsertTx) CreateRefreshToken(ctx context.Context, arg database.CreateRefreshTokenParams) (database.RefreshToken, error) {
+	if q.fail.CompareAndSwap(true, false) {
+		return database.RefreshToken{}, errors.New("injected insert failure")
+	}
+	return q.Querier.CreateRefreshToken(ctx, arg)
+}
+
+func TestRefreshSurvivesFailedInsert(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser(t, "walt@example.com", "heisenberg")
+	login := ts.login(t, "walt@example.com", "heisenberg")
+	store := newFailingInsertStore(ts.cfg.db)
+	ts.cfg.db = store
+
+	store.fail.Store(true)
+	res, body := ts.do(t, "POST", "/api/refresh", bearer(login.RefreshToken), nil)
+	expectError(t, res, body, http.StatusInternalServerError, errCodeInternal)
+
+	// The failed attempt left the token live, so retrying isn't reuse.
+	res, body = ts.do(t, "POST", "/api/refresh", bearer(login.RefreshToken), nil)
+	expectStatus(t, res, body, http.StatusOK)
+	refreshed := decode[RefreshTokenResponse](t, body)
+	res, body = ts.do(t, "POST", "/api/refresh", bearer(refreshed.RefreshToken), nil)
+	expectStatus(t, res, body, http.StatusOK)
+}
+
+func TestRefreshTokenReuseRevokesFamily(t *testing.T) {
+	ts := newTestServer(t)
+	user := ts.createUser(t, "walt@example.com", "heisenberg")
+	original := ts.login(t, "walt@example.com", "heisenberg")
+	otherSession := ts.login(t, "walt@example.com", "heisenberg")
+
+	res, body := ts.do(t, "POST", "/api/refresh", bearer(original.RefreshToken), nil)
+	expectStatus(t, res, body, http.StatusOK)
+	rotated := decode[RefreshTokenResponse](t, body)
+
+	// Presenting the retired token again signals that it was stolen.
+	res, body = ts.do(t, "POST", "/api/refresh", bearer(original.RefreshToken), nil)
+	expectError(t, res, body, http.StatusUnauthorized, errCodeUnauthorized)
+
+	res, body = ts.do(t, "POST", "/api/refresh", bearer(rotated.RefreshToken), nil)
+	expectError(t, res, body, http.StatusUnauthorized, errCodeUnauthorized)
+
+	res, body = ts.do(t, "POST", "/api/refresh", bearer(otherSession.RefreshToken), nil)
+	expectStatus(t, res, body, http.StatusOK)
+
+	events, _ := ts.cfg.db.ListSecurityEventsByUser(context.Background(), user.ID)
+	if len(events) != 1 || events[0].Kind != securityEventRefreshTokenReuse {
+		t.Errorf("expected one refresh token reuse event, got %v", events)
+	}
+}
+
+func TestRefreshExpiredToken(t *testing.T) {
+	ts := newTestServer(t)
+	ts.cfg.refreshTokenTTL = -time.Minute
+	ts.createUser(t, "walt@example.com", "heisenberg")
+	auth := ts.login(t, "walt@example.com", "heisenberg")
+
+	res, body := ts.do(t, "POST", "/api/refresh", bearer(auth.RefreshToken), nil)
+	expectError(t, res, body, http.StatusUnauthorized, errCodeUnauthorized)
+}
+
+func TestRefreshUnknownToken(t *testing.T) {
+	ts := newTestServer(t)
+
+	res, body := ts.do(t, "POST", "/api/refresh", bearer("not-a-token"), nil)
+	expectStatus(t, res, body, http.StatusUnauthorized)
+}
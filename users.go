@@ -1,14 +1,18 @@
 package main
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/dev-perry/go-server/internal/auth"
 	"github.com/dev-perry/go-server/internal/database"
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 type credsRequest struct {
@@ -17,17 +21,30 @@ type credsRequest struct {
 }
 
 type User struct {
-	ID          uuid.UUID `json:"id"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
-	Email       string    `json:"email"`
-	IsChirpyRed bool      `json:"is_chirpy_red"`
+	ID            uuid.UUID `json:"id"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	Email         string    `json:"email"`
+	IsChirpyRed   bool      `json:"is_chirpy_red"`
+	EmailVerified bool      `json:"email_verified"`
+	Role          string    `json:"role"`
+	// PendingEmail is an address the user asked to change to, which takes
+	// effect once verified.
+	PendingEmail string `json:"pending_email,omitempty"`
 }
 
+// UpdatedUserRes carries a fresh token pair, since changing the password
+// revokes every token issued before, including the caller's.
 type UpdatedUserRes struct {
-	ID        uuid.UUID `json:"id"`
-	UpdatedAt time.Time `json:"updated_at"`
-	Email     string    `json:"email"`
+	ID            uuid.UUID `json:"id"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	Email         string    `json:"email"`
+	EmailVerified bool      `json:"email_verified"`
+	PendingEmail  string    `json:"pending_email,omitempty"`
+	// Token and RefreshToken replace the caller's revoked session. They are
+	// left out for API key callers, whose key keeps working.
+	Token        string `json:"token,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
 }
 
 type AuthSuccessResponse struct {
@@ -36,51 +53,76 @@ type AuthSuccessResponse struct {
 	RefreshToken string `json:"refresh_token"`
 }
 
+// isUniqueViolation reports whether err is Postgres' unique_violation, which
+// both the database and the in-memory store return for a taken email.
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
 func (cfg *apiConfig) createUser(w http.ResponseWriter, r *http.Request) {
 	user := credsRequest{}
 	decoder := json.NewDecoder(r.Body)
 	err := decoder.Decode(&user)
 	if err != nil {
-		w.WriteHeader(500)
-		w.Write([]byte("Something went wrong"))
+		respondWithError(w, r, 400, errCodeBadRequest, "Unable to decode request body")
 		return
 	}
 	if user.Email == "" {
-		w.WriteHeader(400)
-		w.Write([]byte("User email is required"))
+		respondWithError(w, r, 400, errCodeValidation, "User email is required", fieldError{
+			Field:   "email",
+			Message: "is required",
+		})
+		return
+	}
+	email, emailErr := normalizeEmail(user.Email)
+	if emailErr != nil {
+		respondWithInvalidEmail(w, r)
+		return
+	}
+	if !cfg.checkPasswordPolicy(w, r, user.Password, email) {
 		return
 	}
-	hashPass, passErr := auth.HashPassword(user.Password)
+	hashPass, passErr := auth.HashPassword(user.Password, cfg.passwordParams)
 	if passErr != nil {
-		log.Fatal(passErr)
-		w.WriteHeader(500)
-		w.Write([]byte("Something went wrong"))
+		log.Printf("Error hashing password: %s", passErr)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to create new user")
 		return
 	}
 	createUser := database.CreateUserParams{
-		Email:          user.Email,
+		Email:          email,
 		HashedPassword: hashPass,
 	}
 	newUser, dbErr := cfg.db.CreateUser(r.Context(), createUser)
 
+	if isUniqueViolation(dbErr) {
+		respondWithError(w, r, 409, errCodeConflict, "A user with that email already exists", fieldError{
+			Field:   "email",
+			Message: "is already taken",
+		})
+		return
+	}
 	if dbErr != nil {
 		log.Printf("Error %v", dbErr)
-		w.WriteHeader(500)
-		w.Write([]byte("Unable to create new user"))
+		respondWithError(w, r, 500, errCodeInternal, "Unable to create new user")
 		return
 	}
 
+	if err := cfg.sendEmailVerification(r.Context(), newUser.ID, newUser.Email); err != nil {
+		// The account is usable regardless; the user can ask for another link.
+		log.Printf("Error sending verification email to user %s: %s", newUser.ID, err)
+	}
+
 	finalUser := User{
-		ID:        newUser.ID,
-		CreatedAt: newUser.CreatedAt,
-		UpdatedAt: newUser.UpdatedAt,
-		Email:     newUser.Email,
+		ID:            newUser.ID,
+		CreatedAt:     newUser.CreatedAt,
+		UpdatedAt:     newUser.UpdatedAt,
+		Email:         newUser.Email,
+		EmailVerified: newUser.EmailVerifiedAt.Valid,
+		Role:          newUser.Role,
 	}
 
-	userResponse, _ := json.Marshal(finalUser)
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(201)
-	w.Write(userResponse)
+	respondWithJSON(w, 201, finalUser)
 }
 
 func (cfg *apiConfig) loginUser(w http.ResponseWriter, r *http.Request) {
@@ -91,120 +133,267 @@ func (cfg *apiConfig) loginUser(w http.ResponseWriter, r *http.Request) {
 	decoder := json.NewDecoder(r.Body)
 	err := decoder.Decode(&loginRequest)
 	if err != nil {
-		w.WriteHeader(400)
-		w.Write([]byte("Unable to decode request"))
+		respondWithError(w, r, 400, errCodeBadRequest, "Unable to decode request body")
+		return
+	}
+	wait, throttleErr := cfg.loginLockedFor(r.Context(), r, loginRequest.Email)
+	if throttleErr != nil {
+		log.Printf("Error checking login throttle: %s", throttleErr)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to verify credentials")
 		return
 	}
+	if wait > 0 {
+		cfg.metrics.logins.With("failure").Inc()
+		respondWithLoginLocked(w, r, wait)
+		return
+	}
+
 	dbUser, dbErr := cfg.db.GetUserCredsByEmail(r.Context(), loginRequest.Email)
+	if errors.Is(dbErr, sql.ErrNoRows) {
+		// Spend as long as a real check would before giving the same answer
+		// as a wrong password.
+		auth.CheckPasswordHash(loginRequest.Password, cfg.dummyPasswordHash)
+		cfg.recordLoginFailure(r.Context(), r, loginRequest.Email)
+		respondWithError(w, r, 401, errCodeUnauthorized, "Incorrect email or password")
+		return
+	}
 	if dbErr != nil {
-		w.WriteHeader(500)
-		w.Write([]byte("Something went wrong: Unable to find user."))
+		log.Printf("Error looking up user: %s", dbErr)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to verify credentials")
 		return
 	}
 	matchPass, passErr := auth.CheckPasswordHash(loginRequest.Password, dbUser.HashedPassword)
 	if passErr != nil {
-		w.WriteHeader(401)
-		w.Write([]byte("Incorrect email or password"))
+		log.Printf("Unable to verify password for user %s: %s", dbUser.ID, passErr)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to verify credentials")
 		return
 	}
-	if matchPass {
-		tokenDur, _ := time.ParseDuration("1h")
-		refDur, _ := time.ParseDuration("1440h")
-
-		duration := tokenDur
-		token, tokenErr := auth.MakeJWT(dbUser.ID, cfg.tokenSecret, duration)
-		refreshToken, refTokenErr := auth.MakeRefreshToken()
-		if tokenErr != nil || refTokenErr != nil {
-			w.WriteHeader(500)
-			w.Write([]byte("Unable to generate user tokens"))
-			return
-		}
-
-		refreshParams := database.CreateRefreshTokenParams{
-			Token:     refreshToken,
-			UserID:    dbUser.ID,
-			ExpiresAt: time.Now().Add(refDur),
-		}
-
-		rToken, rTokeErr := cfg.db.CreateRefreshToken(r.Context(), refreshParams)
-
-		if rTokeErr != nil {
-			w.WriteHeader(500)
-			w.Write([]byte("Unable to generate user tokens"))
-			return
-		}
-
-		authResponse := AuthSuccessResponse{
-			RefreshToken: rToken.Token,
-			Token:        token,
-			User: User{
-				ID:          dbUser.ID,
-				Email:       dbUser.Email,
-				CreatedAt:   dbUser.CreatedAt,
-				UpdatedAt:   dbUser.UpdatedAt,
-				IsChirpyRed: dbUser.IsChirpyRed.Bool,
-			},
-		}
-
-		response, _ := json.Marshal(authResponse)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(200)
-		w.Write(response)
+	if !matchPass {
+		cfg.recordLoginFailure(r.Context(), r, loginRequest.Email)
+		respondWithError(w, r, 401, errCodeUnauthorized, "Incorrect email or password")
 		return
-	} else {
-		w.WriteHeader(401)
-		w.Write([]byte("Incorrect email or password"))
+	}
+	if dbUser.LockedAt.Valid {
+		cfg.metrics.logins.With("failure").Inc()
+		respondWithError(w, r, 403, errCodeForbidden, "Account is locked")
+		return
+	}
+	cfg.rehashPasswordIfWeak(r.Context(), dbUser.ID, loginRequest.Password, dbUser.HashedPassword)
+	if dbUser.TotpEnabledAt.Valid {
+		cfg.startMFAChallenge(w, r, dbUser.ID)
 		return
 	}
+	cfg.clearAccountThrottle(r.Context(), loginRequest.Email)
+	cfg.completeLogin(w, r, User{
+		ID:            dbUser.ID,
+		Email:         dbUser.Email,
+		CreatedAt:     dbUser.CreatedAt,
+		UpdatedAt:     dbUser.UpdatedAt,
+		IsChirpyRed:   dbUser.IsChirpyRed.Bool,
+		EmailVerified: dbUser.EmailVerifiedAt.Valid,
+		Role:          dbUser.Role,
+	}, dbUser.TokenVersion)
 }
 
-func (cfg *apiConfig) updateUser(w http.ResponseWriter, r *http.Request) {
-	token, tokenErr := auth.GetBearerToken(r.Header)
+// completeLogin issues an access and refresh token pair for a user who has
+// passed every login check.
+func (cfg *apiConfig) completeLogin(w http.ResponseWriter, r *http.Request, user User, tokenVersion int32) {
+	familyID := uuid.New()
+	token, tokenErr := auth.MakeJWT(user.ID, familyID, tokenVersion, user.Role, cfg.jwtKeys, cfg.accessTokenTTL)
 	if tokenErr != nil {
-		w.WriteHeader(401)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to generate user tokens")
 		return
 	}
-	uid, authErr := auth.ValidateJWT(token, cfg.tokenSecret)
-	if authErr != nil {
-		w.WriteHeader(401)
-		w.Write([]byte("Unauthorized"))
+
+	refreshToken, refTokenErr := cfg.issueRefreshToken(r, cfg.db, user.ID, familyID)
+	if refTokenErr != nil {
+		log.Printf("Error storing refresh token: %s", refTokenErr)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to generate user tokens")
 		return
 	}
+
+	cfg.metrics.logins.With("success").Inc()
+	respondWithJSON(w, 200, AuthSuccessResponse{
+		RefreshToken: refreshToken,
+		Token:        token,
+		User:         user,
+	})
+}
+
+func (cfg *apiConfig) updateUser(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+	uid := caller.UserID
 	decoder := json.NewDecoder(r.Body)
 	req := credsRequest{}
 	jsonErr := decoder.Decode(&req)
 	if jsonErr != nil {
-		w.WriteHeader(500)
 		log.Printf("Encountered error in JSON decoding: %s", jsonErr)
+		respondWithError(w, r, 400, errCodeBadRequest, "Unable to decode request body")
 		return
 	}
 
-	newPass, hashErr := auth.HashPassword(req.Password)
+	email, emailErr := normalizeEmail(req.Email)
+	if emailErr != nil {
+		respondWithInvalidEmail(w, r)
+		return
+	}
+	current, dbErr := cfg.db.GetUser(r.Context(), uid)
+	if dbErr != nil {
+		log.Printf("Error loading user %s: %s", uid, dbErr)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to update user")
+		return
+	}
+	// A new address only replaces the current one once it is verified.
+	// Sending the current address again cancels a pending change.
+	var pendingEmail sql.NullString
+	if !strings.EqualFold(email, current.Email) {
+		owner, lookupErr := cfg.db.GetUserCredsByEmail(r.Context(), email)
+		if lookupErr == nil && owner.ID != uid {
+			respondWithError(w, r, 409, errCodeConflict, "A user with that email already exists", fieldError{
+				Field:   "email",
+				Message: "is already taken",
+			})
+			return
+		}
+		if lookupErr != nil && !errors.Is(lookupErr, sql.ErrNoRows) {
+			log.Printf("Error looking up user by email: %s", lookupErr)
+			respondWithError(w, r, 500, errCodeInternal, "Unable to update user")
+			return
+		}
+		pendingEmail = sql.NullString{String: email, Valid: true}
+	}
+
+	if !cfg.checkPasswordPolicy(w, r, req.Password, email) {
+		return
+	}
+	newPass, hashErr := auth.HashPassword(req.Password, cfg.passwordParams)
 	if hashErr != nil {
-		w.WriteHeader(500)
 		log.Printf("Encountered error when creating has for updated password: %s", hashErr)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to update user")
 		return
 	}
 
 	updateParams := database.UpdateUserCredentialsParams{
 		ID:             uid,
-		Email:          req.Email,
+		PendingEmail:   pendingEmail,
 		HashedPassword: newPass,
 	}
 
-	res, dbErr := cfg.db.UpdateUserCredentials(r.Context(), updateParams)
+	var res database.UpdateUserCredentialsRow
+	dbErr = cfg.db.ExecTx(r.Context(), func(q database.Querier) error {
+		var err error
+		// Bumps the token version, so outstanding access tokens stop working.
+		res, err = q.UpdateUserCredentials(r.Context(), updateParams)
+		if err != nil {
+			return err
+		}
+		return q.RevokeAllUserRefreshTokens(r.Context(), uid)
+	})
 	if dbErr != nil {
-		w.WriteHeader(500)
 		log.Printf("Encountered error when updating user record: %s", dbErr)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to update user")
 		return
 	}
+
 	response := UpdatedUserRes{
-		ID:        res.ID,
-		UpdatedAt: res.UpdatedAt,
-		Email:     res.Email,
-	}
-	json, _ := json.Marshal(response)
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(200)
-	w.Write(json)
+		ID:            res.ID,
+		UpdatedAt:     res.UpdatedAt,
+		Email:         res.Email,
+		EmailVerified: res.EmailVerifiedAt.Valid,
+		PendingEmail:  res.PendingEmail.String,
+	}
+	// An API key or app token must not be traded for an unrestricted session.
+	if caller.Scopes == nil {
+		familyID := uuid.New()
+		accessToken, jwtErr := auth.MakeJWT(res.ID, familyID, res.TokenVersion, res.Role, cfg.jwtKeys, cfg.accessTokenTTL)
+		if jwtErr != nil {
+			log.Printf("Error creating access token: %s", jwtErr)
+			respondWithError(w, r, 500, errCodeInternal, "Unable to generate user tokens")
+			return
+		}
+		refreshToken, refTokenErr := cfg.issueRefreshToken(r, cfg.db, res.ID, familyID)
+		if refTokenErr != nil {
+			log.Printf("Error storing refresh token: %s", refTokenErr)
+			respondWithError(w, r, 500, errCodeInternal, "Unable to generate user tokens")
+			return
+		}
+		response.Token = accessToken
+		response.RefreshToken = refreshToken
+	}
+
+	if res.PendingEmail.Valid {
+		if err := cfg.sendEmailVerification(r.Context(), res.ID, res.PendingEmail.String); err != nil {
+			log.Printf("Error sending verification email to user %s: %s", res.ID, err)
+		}
+	}
+
+	respondWithJSON(w, 200, response)
+}
+
+// lockUser suspends an account: it can no longer log in, and every token it
+// holds is revoked in the same transaction.
+func (cfg *apiConfig) lockUser(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+	userID, paramErr := pathUUID(r, "userID")
+	if paramErr != nil {
+		respondWithParamError(w, r, paramErr)
+		return
+	}
+	// Like setUserRole, so the last admin can't lock everyone out.
+	if userID == caller.UserID {
+		respondWithError(w, r, 403, errCodeForbidden, "You cannot lock your own account")
+		return
+	}
+
+	var locked int64
+	dbErr := cfg.db.ExecTx(r.Context(), func(q database.Querier) error {
+		var err error
+		// Bumps the token version along with setting locked_at.
+		locked, err = q.LockUser(r.Context(), userID)
+		if err != nil || locked == 0 {
+			return err
+		}
+		return q.RevokeAllUserRefreshTokens(r.Context(), userID)
+	})
+	if dbErr != nil {
+		log.Printf("Error locking user %s: %s", userID, dbErr)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to lock user")
+		return
+	}
+	if locked == 0 {
+		respondWithError(w, r, 404, errCodeNotFound, "User not found")
+		return
+	}
+	w.WriteHeader(204)
+}
+
+func (cfg *apiConfig) unlockUser(w http.ResponseWriter, r *http.Request) {
+	userID, paramErr := pathUUID(r, "userID")
+	if paramErr != nil {
+		respondWithParamError(w, r, paramErr)
+		return
+	}
+
+	user, dbErr := cfg.db.GetUser(r.Context(), userID)
+	if errors.Is(dbErr, sql.ErrNoRows) {
+		respondWithError(w, r, 404, errCodeNotFound, "User not found")
+		return
+	}
+	if dbErr != nil {
+		log.Printf("Error loading user %s: %s", userID, dbErr)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to unlock user")
+		return
+	}
+	// Lifts an admin lock and any lockout from failed logins alike.
+	if _, dbErr := cfg.db.UnlockUser(r.Context(), userID); dbErr != nil {
+		log.Printf("Error unlocking user %s: %s", userID, dbErr)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to unlock user")
+		return
+	}
+	if _, dbErr := cfg.db.DeleteLoginThrottle(r.Context(), accountThrottleKey(user.Email)); dbErr != nil {
+		log.Printf("Error clearing login failures for user %s: %s", userID, dbErr)
+		respondWithError(w, r, 500, errCodeInternal, "Unable to unlock user")
+		return
+	}
+	w.WriteHeader(204)
 }
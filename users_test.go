@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/dev-perry/go-server/internal/auth"
+	"github.com/dev-perry/go-server/internal/database"
+	"github.com/google/uuid"
+)
+
+func TestCreateUser(t *testing.T) {
+	ts := newTestServer(t)
+
+	user := ts.createUser(t, "walt@example.com", "heisenberg")
+	if user.Email != "walt@example.com" {
+		t.Errorf("expected email walt@example.com, got %s", user.Email)
+	}
+	if user.IsChirpyRed {
+		t.Error("expected new user not to be Chirpy Red")
+	}
+
+	res, body := ts.do(t, "POST", "/api/users", "", credsRequest{Password: "heisenberg"})
+	expectError(t, res, body, http.StatusBadRequest, errCodeValidation)
+
+	res, body = ts.do(t, "POST", "/api/users", "", credsRequest{Email: "walt@example.com", Password: "again"})
+	apiErr := expectError(t, res, body, http.StatusConflict, errCodeConflict)
+	if len(apiErr.Details) != 1 || apiErr.Details[0].Field != "email" {
+		t.Errorf("expected email field detail, got %v", apiErr.Details)
+	}
+}
+
+func TestLogin(t *testing.T) {
+	ts := newTestServer(t)
+	user := ts.createUser(t, "walt@example.com", "heisenberg")
+
+	res := ts.login(t, "walt@example.com", "heisenberg")
+	if res.ID != user.ID {
+		t.Errorf("expected user %s, got %s", user.ID, res.ID)
+	}
+	if res.RefreshToken == "" {
+		t.Error("expected a refresh token")
+	}
+	caller, err := ts.cfg.authenticate(context.Background(), res.Token)
+	if err != nil || caller.UserID != user.ID {
+		t.Errorf("expected access token for %s, got %s (%v)", user.ID, caller.UserID, err)
+	}
+}
+
+func TestLoginWrongPassword(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser(t, "walt@example.com", "heisenberg")
+
+	res, body := ts.do(t, "POST", "/api/login", "", credsRequest{Email: "walt@example.com", Password: "pinkman"})
+	expectStatus(t, res, body, http.StatusUnauthorized)
+}
+
+func TestUpdateUser(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser(t, "walt@example.com", "heisenberg")
+	login := ts.login(t, "walt@example.com", "heisenberg")
+
+	update := credsRequest{Email: "heisenberg@example.com", Password: "blue"}
+
+	res, body := ts.do(t, "PUT", "/api/users", "", update)
+	expectStatus(t, res, body, http.StatusUnauthorized)
+
+	res, body = ts.do(t, "PUT", "/api/users", bearer(login.Token), update)
+	expectStatus(t, res, body, http.StatusOK)
+	updated := decode[UpdatedUserRes](t, body)
+	if updated.Email != "walt@example.com" || updated.PendingEmail != "heisenberg@example.com" {
+		t.Errorf("expected the new email to be pending, got %+v", updated)
+	}
+
+	ts.login(t, "walt@example.com", "blue")
+}
+
+func TestPasswordChangeRevokesTokens(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser(t, "walt@example.com", "heisenberg")
+	phone := ts.login(t, "walt@example.com", "heisenberg")
+	laptop := ts.login(t, "walt@example.com", "heisenberg")
+
+	update := credsRequest{Email: "walt@example.com", Password: "blue"}
+	res, body := ts.do(t, "PUT", "/api/users", bearer(laptop.Token), update)
+	expectStatus(t, res, body, http.StatusOK)
+	updated := decode[UpdatedUserRes](t, body)
+
+	for _, token := range []string{phone.Token, laptop.Token} {
+		res, body = ts.do(t, "GET", "/api/sessions", bearer(token), nil)
+		expectError(t, res, body, http.StatusUnauthorized, errCodeUnauthorized)
+	}
+	for _, token := range []string{phone.RefreshToken, laptop.RefreshToken} {
+		res, body = ts.do(t, "POST", "/api/refresh", bearer(token), nil)
+		expectError(t, res, body, http.StatusUnauthorized, errCodeUnauthorized)
+	}
+
+	res, body = ts.do(t, "GET", "/api/sessions", bearer(updated.Token), nil)
+	expectStatus(t, res, body, http.StatusOK)
+	res, body = ts.do(t, "POST", "/api/refresh", bearer(updated.RefreshToken), nil)
+	expectStatus(t, res, body, http.StatusOK)
+}
+
+func TestFailedPasswordChangeKeepsTokens(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser(t, "walt@example.com", "heisenberg")
+	ts.createUser(t, "jesse@example.com", "yeahscience")
+	login := ts.login(t, "walt@example.com", "heisenberg")
+
+	update := credsRequest{Email: "jesse@example.com", Password: "blue"}
+	res, body := ts.do(t, "PUT", "/api/users", bearer(login.Token), update)
+	expectError(t, res, body, http.StatusConflict, errCodeConflict)
+
+	res, body = ts.do(t, "POST", "/api/refresh", bearer(login.RefreshToken), nil)
+	expectStatus(t, res, body, http.StatusOK)
+}
+
+func TestAdminLockUser(t *testing.T) {
+	ts := newTestServer(t)
+	admin := bearer(ts.loginAs(t, roleAdmin).Token)
+	user := ts.createUser(t, "walt@example.com", "heisenberg")
+	login := ts.login(t, "walt@example.com", "heisenberg")
+	lockPath := "/admin/users/" + user.ID.String() + "/lock"
+
+	res, body := ts.do(t, "POST", lockPath, bearer(login.Token), nil)
+	expectError(t, res, body, http.StatusForbidden, errCodeForbidden)
+
+	res, body = ts.do(t, "POST", lockPath, admin, nil)
+	expectStatus(t, res, body, http.StatusNoContent)
+
+	res, body = ts.do(t, "GET", "/api/sessions", bearer(login.Token), nil)
+	expectError(t, res, body, http.StatusUnauthorized, errCodeUnauthorized)
+	res, body = ts.do(t, "POST", "/api/refresh", bearer(login.RefreshToken), nil)
+	expectError(t, res, body, http.StatusUnauthorized, errCodeUnauthorized)
+	res, body = ts.do(t, "POST", "/api/login", "", credsRequest{Email: "walt@example.com", Password: "heisenberg"})
+	expectError(t, res, body, http.StatusForbidden, errCodeForbidden)
+
+	res, body = ts.do(t, "POST", "/admin/users/"+user.ID.String()+"/unlock", admin, nil)
+	expectStatus(t, res, body, http.StatusNoContent)
+	ts.login(t, "walt@example.com", "heisenberg")
+
+	res, body = ts.do(t, "POST", "/admin/users/"+uuid.NewString()+"/lock", admin, nil)
+	expectError(t, res, body, http.StatusNotFound, errCodeNotFound)
+}
+
+func TestAdminCannotLockThemselves(t *testing.T) {
+	ts := newTestServer(t)
+	admin := ts.loginAs(t, roleAdmin)
+
+	res, body := ts.do(t, "POST", "/admin/users/"+admin.ID.String()+"/lock", bearer(admin.Token), nil)
+	expectError(t, res, body, http.StatusForbidden, errCodeForbidden)
+	res, body = ts.do(t, "GET", "/admin/metrics", bearer(admin.Token), nil)
+	expectStatus(t, res, body, http.StatusOK)
+}
+
+func TestLoginWithMalformedStoredHash(t *testing.T) {
+	ts := newTestServer(t)
+	user := ts.createUser(t, "walt@example.com", "heisenberg")
+	ts.cfg.db.UpdateUserCredentials(context.Background(), database.UpdateUserCredentialsParams{
+		ID:             user.ID,
+		HashedPassword: "corrupted",
+	})
+
+	res, body := ts.do(t, "POST", "/api/login", "", credsRequest{Email: "walt@example.com", Password: "heisenberg"})
+	expectError(t, res, body, http.StatusInternalServerError, errCodeInternal)
+}
+
+func TestExpiredAccessToken(t *testing.T) {
+	ts := newTestServer(t)
+	user := ts.createUser(t, "walt@example.com", "heisenberg")
+	token, _ := auth.MakeJWT(user.ID, uuid.New(), 0, user.Role, ts.cfg.jwtKeys, -time.Minute)
+
+	res, body := ts.do(t, "PUT", "/api/users", bearer(token), credsRequest{Email: "a@example.com", Password: "b"})
+	expectError(t, res, body, http.StatusUnauthorized, errCodeTokenExpired)
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	ts := newTestServer(t)
+	ts.cfg.passwordPolicy = &auth.PasswordPolicy{MinLength: 8}
+
+	res, body := ts.do(t, "POST", "/api/users", "", credsRequest{Email: "walt@example.com", Password: "blue"})
+	apiErr := expectError(t, res, body, http.StatusBadRequest, errCodeValidation)
+	if len(apiErr.Details) != 1 || apiErr.Details[0].Field != "password" {
+		t.Errorf("expected a password field detail, got %v", apiErr.Details)
+	}
+	res, body = ts.do(t, "POST", "/api/users", "", credsRequest{Email: "walt@example.com", Password: "Walt-2008"})
+	expectError(t, res, body, http.StatusBadRequest, errCodeValidation)
+
+	ts.createUser(t, "walt@example.com", "heisenberg")
+	login := ts.login(t, "walt@example.com", "heisenberg")
+	res, body = ts.do(t, "PUT", "/api/users", bearer(login.Token), credsRequest{Email: "walt@example.com", Password: "short"})
+	expectError(t, res, body, http.StatusBadRequest, errCodeValidation)
+
+	res, body = ts.do(t, "POST", "/api/password/forgot", "", forgotRequest{Email: "walt@example.com"})
+	expectStatus(t, res, body, http.StatusAccepted)
+	token := ts.resetToken(t, "walt@example.com")
+	res, body = ts.do(t, "POST", "/api/password/reset", "", resetRequest{Token: token, Password: "walt"})
+	apiErr = expectError(t, res, body, http.StatusBadRequest, errCodeValidation)
+	if len(apiErr.Details) != 2 {
+		t.Errorf("expected length and email problems, got %v", apiErr.Details)
+	}
+	// The token survives a refused password.
+	res, body = ts.do(t, "POST", "/api/password/reset", "", resetRequest{Token: token, Password: "blue sky 99"})
+	expectStatus(t, res, body, http.StatusNoContent)
+}
+
+func TestLoginRehashesWeakPassword(t *testing.T) {
+	ts := newTestServer(t)
+	user := ts.createUser(t, "walt@example.com", "heisenberg")
+	stored := func() string {
+		creds, err := ts.cfg.db.GetUserCredsByEmail(context.Background(), "walt@example.com")
+		if err != nil {
+			t.Fatalf("GetUserCredsByEmail: %v", err)
+		}
+		return creds.HashedPassword
+	}
+	weak := stored()
+
+	ts.login(t, "walt@example.com", "heisenberg")
+	if stored() != weak {
+		t.Fatal("expected a hash with the current params to be kept")
+	}
+
+	ts.cfg.passwordParams.Iterations = 2
+	res, body := ts.do(t, "POST", "/api/login", "", credsRequest{Email: "walt@example.com", Password: "wrong"})
+	expectError(t, res, body, http.StatusUnauthorized, errCodeUnauthorized)
+	if stored() != weak {
+		t.Fatal("expected a failed login to leave the hash alone")
+	}
+	ts.login(t, "walt@example.com", "heisenberg")
+	rehashed := stored()
+	if rehashed == weak || auth.NeedsRehash(rehashed, ts.cfg.passwordParams) {
+		t.Errorf("expected the hash to be upgraded for user %s, got %q", user.ID, rehashed)
+	}
+	ts.login(t, "walt@example.com", "heisenberg")
+}